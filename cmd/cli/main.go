@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/bootstrap"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+// Runs the same sync as the Lambda function from a laptop or a CI job. The bootstrap subcommand sets up the
+// lifecycle hooks of new AutoScaling Groups.
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bootstrap" {
+		runBootstrap(os.Args[2:])
+		return
+	}
+
+	// The defaults are the function's own settings
+	cfg := config.FromEnv()
+	asgName := flag.String("asg", "", "Name of the AutoScaling Group, or eb:<environment> for the AutoScaling Group of an Elastic Beanstalk environment")
+	sgID := flag.String("sg", cfg.SecurityGroupID, "ID of the Security Group")
+	port := flag.Int64("port", target.HTTPSPort, "TCP port of the managed rules")
+	rulesSpec := flag.String("rules", os.Getenv("rules"), `Rule matrix of the managed rules, e.g. [{"proto":"tcp","ports":[443,8443]}]. Overrides --port`)
+	region := flag.String("region", awsclient.DefaultRegion(), "AWS region")
+	dryRun := flag.Bool("dry-run", false, "Only print the IPs that would be added and removed")
+	gc := flag.Bool("gc", false, "Also remove the managed rules whose instances no longer exist")
+	endpoint := flag.String("endpoint", cfg.EndpointURL, "Endpoint of every AWS service, e.g. http://localhost:4566 for LocalStack")
+	namespace := flag.String("namespace", cfg.OwnershipNamespace, "Ownership namespace of the deployment whose rules are synced")
+	mask := flag.Int("mask", cfg.RuleCIDRMask, "Mask the instances' IPv4 addresses are widened to, between 16 and 32")
+	flag.Parse()
+
+	if *asgName == "" || *sgID == "" || *region == "" {
+		fmt.Fprintln(os.Stderr, "--asg, --sg and --region are required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *namespace != "" && !target.ValidNamespace(*namespace) {
+		fmt.Fprintln(os.Stderr, "invalid --namespace: at most 32 letters, digits, dots, dashes and underscores")
+		os.Exit(2)
+	}
+	target.Namespace = *namespace
+
+	if *mask < 16 || *mask > 32 {
+		fmt.Fprintln(os.Stderr, "invalid --mask: between 16 and 32")
+		os.Exit(2)
+	}
+
+	rules := []target.Rule{{Protocol: target.TCPProtocol, Port: *port}}
+	if *rulesSpec != "" {
+		var err error
+		if rules, err = target.ParseRules(*rulesSpec); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid --rules:", err)
+			os.Exit(2)
+		}
+	}
+
+	logger, _ := zap.NewDevelopment()
+	defer logger.Sync()
+
+	clients, err := awsclient.NewSessionFactory(awsclient.Options{Endpoint: *endpoint})(*region)
+	if err != nil {
+		logger.Fatal("Failed to create session", zap.Error(err))
+	}
+	if *asgName, err = source.ResolveGroupName(*asgName, clients.ElasticBeanstalk); err != nil {
+		logger.Fatal("Failed to resolve the AutoScaling Group", zap.Error(err))
+	}
+
+	result, err := syncer.Sync(syncer.Input{
+		AutoScalingGroupName: *asgName,
+		SecurityGroupID:      *sgID,
+		Rules:                rules,
+		DryRun:               *dryRun,
+		CollectOrphans:       *gc,
+		RuleCIDRMask:         *mask,
+	}, clients.AutoScaling, clients.EC2, logger)
+	if err != nil {
+		logger.Fatal("Sync failed", zap.Error(err))
+	}
+
+	out, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(out))
+}
+
+// Creates, or updates, the launch and terminate lifecycle hooks of the AutoScaling Groups and, given the function's
+// ARN, the EventBridge rule that forwards their events to it
+func runBootstrap(args []string) {
+	flags := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	asgNames := flags.String("asg", "", "Comma separated names of the AutoScaling Groups")
+	region := flags.String("region", awsclient.DefaultRegion(), "AWS region")
+	heartbeat := flags.Duration("heartbeat", bootstrap.DefaultHeartbeatTimeout, "Heartbeat timeout of the lifecycle hooks")
+	defaultResult := flags.String("default-result", "ABANDON", "Result of the lifecycle hooks that time out, ABANDON or CONTINUE")
+	functionARN := flags.String("function-arn", "", "ARN of the sync function. When set, the EventBridge rule and its permission to invoke the function are set up too")
+	flags.Parse(args)
+
+	if *asgNames == "" || *region == "" {
+		fmt.Fprintln(os.Stderr, "--asg and --region are required")
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	logger, _ := zap.NewDevelopment()
+	defer logger.Sync()
+
+	clients, err := awsclient.NewSessionFactory(awsclient.Options{Lambda: true, EventBridge: true})(*region)
+	if err != nil {
+		logger.Fatal("Failed to create session", zap.Error(err))
+	}
+
+	var names []string
+	for _, asgName := range strings.Split(*asgNames, ",") {
+		asgName = strings.TrimSpace(asgName)
+		names = append(names, asgName)
+		if err := bootstrap.LifecycleHooks(asgName, *heartbeat, *defaultResult, clients.AutoScaling); err != nil {
+			logger.Fatal("Failed to put the lifecycle hooks", zap.String("asgName", asgName), zap.Error(err))
+		}
+		logger.Info("Lifecycle hooks are in place", zap.String("asgName", asgName))
+	}
+
+	if *functionARN == "" {
+		return
+	}
+	if err := bootstrap.EventRule(*functionARN, names, clients.EventBridge, clients.Lambda); err != nil {
+		logger.Fatal("Failed to set up the EventBridge rule", zap.Error(err))
+	}
+	logger.Info("EventBridge rule is in place", zap.String("rule", bootstrap.RuleName), zap.String("functionARN", *functionARN))
+}
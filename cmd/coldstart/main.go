@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"go.uber.org/zap"
+)
+
+// Measures the initialization steps that run on a Lambda cold start. No AWS call is made, the clients
+// are only constructed. Run it before and after touching the initialization path and compare the numbers.
+func main() {
+	runs := flag.Int("runs", 1000, "Number of runs per step")
+	flag.Parse()
+
+	if os.Getenv("AWS_REGION") == "" {
+		os.Setenv("AWS_REGION", "us-east-1")
+	}
+	cfg := config.FromEnv()
+	region := os.Getenv("AWS_REGION")
+	cached := awsclient.Cached(awsclient.ForConfig(cfg))
+
+	steps := []struct {
+		name string
+		fn   func()
+	}{
+		{"config.FromEnv", func() { config.FromEnv() }},
+		{"zap.NewProduction", func() { zap.NewProduction() }},
+		{"logging.Build", func() { logging.Build() }},
+		{"clients (all features)", func() {
+			awsclient.NewSessionFactory(awsclient.Options{SNS: true, SQS: true, Lambda: true, EventBridge: true, ELBv2: true, Route53: true, CloudWatch: true, ServiceQuotas: true, DynamoDB: true, ConfigService: true, CloudTrail: true, S3: true, SSM: true, SSMIncidents: true})(region)
+		}},
+		{"clients (core only)", func() { awsclient.NewSessionFactory(awsclient.Options{})(region) }},
+		{"clients (cached, warm)", func() { cached(region) }},
+	}
+
+	fmt.Printf("%-25s %15s\n", "step", "avg")
+	for _, step := range steps {
+		start := time.Now()
+		for i := 0; i < *runs; i++ {
+			step.fn()
+		}
+		fmt.Printf("%-25s %15s\n", step.name, time.Since(start)/time.Duration(*runs))
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/handler"
+)
+
+func main() {
+	cfg := handler.LoadConfig()
+	lambda.Start(cfn.LambdaWrap(handler.NewCustomResource(cfg, awsclient.Cached(awsclient.ForConfig(cfg))).Handle))
+}
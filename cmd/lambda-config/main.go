@@ -0,0 +1,14 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/handler"
+)
+
+func main() {
+	cfg := handler.LoadConfig()
+	opts := awsclient.OptionsFor(cfg)
+	opts.ConfigService = true
+	lambda.Start(handler.NewConfigRule(cfg, awsclient.Cached(awsclient.NewSessionFactory(opts))).Handle)
+}
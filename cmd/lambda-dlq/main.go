@@ -0,0 +1,12 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/handler"
+)
+
+func main() {
+	cfg := handler.LoadConfig()
+	lambda.Start(handler.NewDLQ(cfg, awsclient.Cached(awsclient.ForConfig(cfg))).Handle)
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/handler"
+)
+
+func main() {
+	cfg := handler.LoadConfig()
+	// HANDLER_MODE selects the role of the function, the lifecycle hooks' handler by default
+	h, err := handler.ForMode(cfg)
+	if err != nil {
+		// Fails the init, an unknown mode would fail every invocation anyway
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	lambda.Start(h)
+}
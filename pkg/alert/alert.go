@@ -0,0 +1,53 @@
+package alert
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/notify"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// Alert is published when the sync carried on past stage failures that the policy tolerated, or when it found that
+// other actors changed the managed rule sets
+type Alert struct {
+	AutoScalingGroupName string           `json:"asgName"`
+	SecurityGroupID      string           `json:"sgID"`
+	Rules                []target.Rule    `json:"rules"`
+	Failures             []syncer.Failure `json:"failures,omitempty"`
+	// CIDRFailures are the CIDRs of the failures, for a targeted remediation
+	CIDRFailures []target.CIDRFailure `json:"cidrFailures,omitempty"`
+	Drift        []syncer.Drift       `json:"drift,omitempty"`
+	CreatedAt    time.Time            `json:"createdAt"`
+}
+
+// Kind is the kind of the alerts' messages
+const Kind = "alert"
+
+// Message builds the notification of the alert
+func (a Alert) Message() notify.Message {
+	subject := "Security Group sync partially failed"
+	if len(a.Failures) == 0 {
+		subject = "Security Group changed outside of the sync"
+	}
+	return notify.Message{Kind: Kind, Subject: subject, Details: a, Key: a.key()}
+}
+
+// Gets the key of the alert: its Security Group, AutoScaling Group and the categories of its failures, or drift
+func (a Alert) key() string {
+	seen := make(map[string]bool)
+	var categories []string
+	for _, failure := range a.Failures {
+		if category := string(failure.Category); !seen[category] {
+			seen[category] = true
+			categories = append(categories, category)
+		}
+	}
+	sort.Strings(categories)
+	if len(categories) == 0 {
+		categories = []string{"drift"}
+	}
+	return strings.Join([]string{Kind, a.SecurityGroupID, a.AutoScalingGroupName, strings.Join(categories, "+")}, "/")
+}
@@ -0,0 +1,54 @@
+package approval
+
+import (
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/notify"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// Request is published when the removals of a sync exceed the approval threshold.
+// The removals are applied only once a follow-up approval invocation lists them in ApprovedRemovals.
+type Request struct {
+	AutoScalingGroupName string        `json:"asgName"`
+	SecurityGroupID      string        `json:"sgID"`
+	Rules                []target.Rule `json:"rules"`
+	PendingRemovals      []string      `json:"pendingRemovals"`
+	CreatedAt            time.Time     `json:"createdAt"`
+}
+
+// Kind is the kind of the approval requests' messages
+const Kind = "approval"
+
+// Message builds the notification of the approval request
+func (r Request) Message() notify.Message {
+	return notify.Message{Kind: Kind, Subject: "Security Group removals awaiting approval", Details: r}
+}
+
+// Filter keeps the IPs that have been approved for removal
+func Filter(ipsToRemove []string, approved []string) (kept []string) {
+	approvedSet := make(map[string]struct{}, len(approved))
+	for _, ip := range approved {
+		approvedSet[ip] = struct{}{}
+	}
+	for _, ip := range ipsToRemove {
+		if _, ok := approvedSet[ip]; ok {
+			kept = append(kept, ip)
+		}
+	}
+	return kept
+}
+
+// Exclude drops the IPs that are in excluded
+func Exclude(ips []string, excluded []string) (kept []string) {
+	excludedSet := make(map[string]struct{}, len(excluded))
+	for _, ip := range excluded {
+		excludedSet[ip] = struct{}{}
+	}
+	for _, ip := range ips {
+		if _, ok := excludedSet[ip]; !ok {
+			kept = append(kept, ip)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,116 @@
+package attribution
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/cloudtrail/cloudtrailiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// The API calls that change the inbound rules of a Security Group
+const (
+	authorizeEvent = "AuthorizeSecurityGroupIngress"
+	revokeEvent    = "RevokeSecurityGroupIngress"
+)
+
+// The part of a CloudTrail record of an ingress change that attributes it
+type record struct {
+	UserIdentity struct {
+		ARN string `json:"arn"`
+	} `json:"userIdentity"`
+	RequestParameters struct {
+		IPPermissions struct {
+			Items []struct {
+				IPProtocol string `json:"ipProtocol"`
+				FromPort   int64  `json:"fromPort"`
+				ToPort     int64  `json:"toPort"`
+				IPRanges   struct {
+					Items []struct {
+						CIDRIP string `json:"cidrIp"`
+					} `json:"items"`
+				} `json:"ipRanges"`
+			} `json:"items"`
+		} `json:"ipPermissions"`
+	} `json:"requestParameters"`
+}
+
+// A CIDR changed by an ingress call
+type change struct {
+	principal string
+	eventName string
+	eventTime time.Time
+}
+
+// Attribute looks up the ingress calls made on the Security Group in CloudTrail since the earliest drift and fills in
+// who made every change. Changes without a matching call, e.g. still being delivered to CloudTrail, are left as they
+// are.
+func Attribute(ctSvc cloudtrailiface.CloudTrailAPI, sgID string, drift []syncer.Drift) ([]syncer.Drift, error) {
+	if len(drift) == 0 {
+		return drift, nil
+	}
+	since := drift[0].Since
+	for _, d := range drift {
+		if d.Since.Before(since) {
+			since = d.Since
+		}
+	}
+
+	changes := make(map[string]change)
+	err := ctSvc.LookupEventsPages(&cloudtrail.LookupEventsInput{
+		LookupAttributes: []*cloudtrail.LookupAttribute{{
+			AttributeKey:   aws.String(cloudtrail.LookupAttributeKeyResourceName),
+			AttributeValue: aws.String(sgID),
+		}},
+		StartTime: aws.Time(since),
+	}, func(page *cloudtrail.LookupEventsOutput, lastPage bool) bool {
+		for _, event := range page.Events {
+			name := aws.StringValue(event.EventName)
+			if name != authorizeEvent && name != revokeEvent {
+				continue
+			}
+			var rec record
+			if err := json.Unmarshal([]byte(aws.StringValue(event.CloudTrailEvent)), &rec); err != nil {
+				continue
+			}
+			eventTime := aws.TimeValue(event.EventTime)
+			for _, perm := range rec.RequestParameters.IPPermissions.Items {
+				rule := target.PermissionRule(perm.IPProtocol, perm.FromPort, perm.ToPort).String()
+				for _, ipRange := range perm.IPRanges.Items {
+					k := key(name, rule, ipRange.CIDRIP)
+					// Events come newest first, the latest call is the one that stuck
+					if _, ok := changes[k]; !ok {
+						changes[k] = change{principal: rec.UserIdentity.ARN, eventName: name, eventTime: eventTime}
+					}
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return drift, errs.Wrap(errs.Target, "lookup events", err)
+	}
+
+	attributed := make([]syncer.Drift, len(drift))
+	for i, d := range drift {
+		name := authorizeEvent
+		if d.Change == syncer.DriftRemoved {
+			name = revokeEvent
+		}
+		if c, ok := changes[key(name, d.Rule, d.CIDR)]; ok {
+			eventTime := c.eventTime
+			d.Principal, d.EventName, d.EventTime = c.principal, c.eventName, &eventTime
+		}
+		attributed[i] = d
+	}
+	return attributed, nil
+}
+
+// Builds the key of a change
+func key(eventName string, rule string, cidr string) string {
+	return eventName + " " + rule + " " + cidr
+}
@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+)
+
+// SchemaVersion is the version of the records' shape. It is bumped when a field is removed or changes meaning.
+const SchemaVersion = 1
+
+// Actions of the records
+const (
+	ActionAuthorize = "authorize"
+	ActionRevoke    = "revoke"
+)
+
+// Reasons of the revocations
+const (
+	// ReasonSync is a change of the sync: the CIDR of an instance was added or is no longer desired
+	ReasonSync = "sync"
+	// ReasonOrphan is a managed rule whose instance no longer exists
+	ReasonOrphan = "orphan"
+	// ReasonExpired is a managed rule older than the max rule age
+	ReasonExpired = "expired"
+)
+
+// Record is a single change of a Security Group's rules
+type Record struct {
+	SchemaVersion int `json:"schema_version"`
+	// ID identifies the record, the same change of the same sync always has the same ID so that the consumers can
+	// drop the duplicates of a retried put
+	ID                   string    `json:"id"`
+	Time                 time.Time `json:"time"`
+	Region               string    `json:"region"`
+	AutoScalingGroupName string    `json:"asg_name"`
+	SecurityGroupID      string    `json:"sg_id"`
+	Action               string    `json:"action"`
+	Reason               string    `json:"reason"`
+	CIDR                 string    `json:"cidr"`
+	// Protocol, Port and Code are the rule of the change, see target.Rule
+	Protocol string `json:"proto"`
+	Port     int64  `json:"port"`
+	Code     int64  `json:"code,omitempty"`
+	// InstanceID is the instance of the CIDR, when known
+	InstanceID string `json:"instance_id,omitempty"`
+	// Function is the function that made the change
+	Function string `json:"function,omitempty"`
+}
+
+// Sync identifies the sync whose changes are recorded
+type Sync struct {
+	Region               string
+	AutoScalingGroupName string
+	SecurityGroupID      string
+	Function             string
+	Time                 time.Time
+}
+
+// Records builds the records of the changes the sync made, one per CIDR and rule. A dry run made none.
+func Records(sync Sync, result syncer.Result) []Record {
+	if result.DryRun {
+		return nil
+	}
+	reasons := make(map[string]string)
+	for _, c := range result.CollectedOrphans {
+		reasons[c] = ReasonOrphan
+	}
+	for _, c := range result.ExpiredRules {
+		reasons[c] = ReasonExpired
+	}
+	removedBy := make(map[string]string, len(result.RemovedByInstance))
+	for instanceID, cidrs := range result.RemovedByInstance {
+		for _, c := range cidrs {
+			removedBy[c] = instanceID
+		}
+	}
+
+	var records []Record
+	for _, rr := range result.Rules {
+		record := Record{
+			SchemaVersion:        SchemaVersion,
+			Time:                 sync.Time.UTC(),
+			Region:               sync.Region,
+			AutoScalingGroupName: sync.AutoScalingGroupName,
+			SecurityGroupID:      sync.SecurityGroupID,
+			Protocol:             rr.Protocol,
+			Port:                 rr.Port,
+			Code:                 rr.Code,
+			Function:             sync.Function,
+		}
+		for _, c := range rr.AddedIPs {
+			record.Action, record.Reason, record.CIDR, record.InstanceID = ActionAuthorize, ReasonSync, c, result.Owners[c]
+			records = append(records, record.withID())
+		}
+		for _, c := range rr.Revoked {
+			reason, ok := reasons[c]
+			if !ok {
+				reason = ReasonSync
+			}
+			record.Action, record.Reason, record.CIDR, record.InstanceID = ActionRevoke, reason, c, removedBy[c]
+			records = append(records, record.withID())
+		}
+	}
+	return records
+}
+
+// Sets the ID of the record, a hash of the change and of its time
+func (r Record) withID() Record {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%s|%s/%d:%d", r.Time.Format(time.RFC3339Nano), r.SecurityGroupID, r.Action, r.CIDR, r.Protocol, r.Port, r.Code)))
+	r.ID = hex.EncodeToString(hash[:16])
+	return r
+}
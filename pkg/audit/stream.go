@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/firehose/firehoseiface"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// maxBatch is the most records a PutRecords or a PutRecordBatch call takes
+const maxBatch = 500
+
+// Stream receives the change records, e.g. a Kinesis Data Stream feeding a SIEM
+type Stream interface {
+	// Name identifies the stream in the logs, e.g. kinesis or firehose
+	Name() string
+	Put(records []Record) error
+}
+
+// Kinesis puts the records to a Kinesis Data Stream, partitioned by Security Group so that the changes of a group are
+// read in order
+type Kinesis struct {
+	Svc        kinesisiface.KinesisAPI
+	StreamName string
+}
+
+// Name is kinesis
+func (k Kinesis) Name() string { return "kinesis" }
+
+// Put puts the records, maxBatch at a time. The records the stream failed to take are put again once.
+func (k Kinesis) Put(records []Record) error {
+	var entries []*kinesis.PutRecordsRequestEntry
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return errs.Wrap(errs.Target, "put kinesis records", err)
+		}
+		entries = append(entries, &kinesis.PutRecordsRequestEntry{Data: data, PartitionKey: aws.String(record.SecurityGroupID)})
+	}
+	for start := 0; start < len(entries); start += maxBatch {
+		end := start + maxBatch
+		if end > len(entries) {
+			end = len(entries)
+		}
+		batch := entries[start:end]
+		for attempt := 0; len(batch) != 0; attempt++ {
+			out, err := k.Svc.PutRecords(&kinesis.PutRecordsInput{StreamName: aws.String(k.StreamName), Records: batch})
+			if err != nil {
+				return errs.Wrap(errs.Target, "put kinesis records", err)
+			}
+			var failed []*kinesis.PutRecordsRequestEntry
+			message := ""
+			for i, result := range out.Records {
+				if result.ErrorCode != nil {
+					failed, message = append(failed, batch[i]), aws.StringValue(result.ErrorMessage)
+				}
+			}
+			if len(failed) != 0 && attempt == 1 {
+				return errs.Errorf(errs.Target, "put kinesis records", "%d records failed: %s", len(failed), message)
+			}
+			batch = failed
+		}
+	}
+	return nil
+}
+
+// Firehose puts the records to a Firehose delivery stream, one JSON document per line so that the delivered objects
+// are JSON lines
+type Firehose struct {
+	Svc                firehoseiface.FirehoseAPI
+	DeliveryStreamName string
+}
+
+// Name is firehose
+func (f Firehose) Name() string { return "firehose" }
+
+// Put puts the records, maxBatch at a time. The records the stream failed to take are put again once.
+func (f Firehose) Put(records []Record) error {
+	var entries []*firehose.Record
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return errs.Wrap(errs.Target, "put firehose records", err)
+		}
+		entries = append(entries, &firehose.Record{Data: append(data, '\n')})
+	}
+	for start := 0; start < len(entries); start += maxBatch {
+		end := start + maxBatch
+		if end > len(entries) {
+			end = len(entries)
+		}
+		batch := entries[start:end]
+		for attempt := 0; len(batch) != 0; attempt++ {
+			out, err := f.Svc.PutRecordBatch(&firehose.PutRecordBatchInput{DeliveryStreamName: aws.String(f.DeliveryStreamName), Records: batch})
+			if err != nil {
+				return errs.Wrap(errs.Target, "put firehose records", err)
+			}
+			var failed []*firehose.Record
+			message := ""
+			for i, result := range out.RequestResponses {
+				if result.ErrorCode != nil {
+					failed, message = append(failed, batch[i]), aws.StringValue(result.ErrorMessage)
+				}
+			}
+			if len(failed) != 0 && attempt == 1 {
+				return errs.Errorf(errs.Target, "put firehose records", "%d records failed: %s", len(failed), message)
+			}
+			batch = failed
+		}
+	}
+	return nil
+}
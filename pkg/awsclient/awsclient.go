@@ -0,0 +1,243 @@
+package awsclient
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/cloudtrail/cloudtrailiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/aws/aws-sdk-go/service/configservice/configserviceiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elasticbeanstalk"
+	"github.com/aws/aws-sdk-go/service/elasticbeanstalk/elasticbeanstalkiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/firehose/firehoseiface"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+	"github.com/aws/aws-sdk-go/service/servicequotas/servicequotasiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/aws/aws-sdk-go/service/ssmincidents"
+	"github.com/aws/aws-sdk-go/service/ssmincidents/ssmincidentsiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/flags"
+)
+
+// Clients holds the AWS service clients used to sync a Security Group.
+// The clients of optional features are nil when the feature is disabled.
+type Clients struct {
+	EC2         ec2iface.EC2API
+	AutoScaling autoscalingiface.AutoScalingAPI
+	// ElasticBeanstalk resolves the Elastic Beanstalk environments that alias AutoScaling Groups
+	ElasticBeanstalk elasticbeanstalkiface.ElasticBeanstalkAPI
+	SNS              snsiface.SNSAPI
+	SQS              sqsiface.SQSAPI
+	Lambda           lambdaiface.LambdaAPI
+	EventBridge      eventbridgeiface.EventBridgeAPI
+	ELBv2            elbv2iface.ELBV2API
+	Route53          route53iface.Route53API
+	CloudWatch       cloudwatchiface.CloudWatchAPI
+	ServiceQuotas    servicequotasiface.ServiceQuotasAPI
+	DynamoDB         dynamodbiface.DynamoDBAPI
+	ConfigService    configserviceiface.ConfigServiceAPI
+	CloudTrail       cloudtrailiface.CloudTrailAPI
+	S3               s3iface.S3API
+	SSM              ssmiface.SSMAPI
+	SSMIncidents     ssmincidentsiface.SSMIncidentsAPI
+	SecretsManager   secretsmanageriface.SecretsManagerAPI
+	// Kinesis and Firehose receive the audit records
+	Kinesis  kinesisiface.KinesisAPI
+	Firehose firehoseiface.FirehoseAPI
+}
+
+// Factory builds the AWS clients for the given region
+type Factory func(region string) (Clients, error)
+
+// Options selects which optional clients get built
+type Options struct {
+	// Endpoint, when set, overrides the endpoint of every service, e.g. http://localhost:4566 for LocalStack
+	Endpoint string
+	SNS      bool
+	SQS      bool
+	Lambda   bool
+	// EventBridge is only used by the bootstrap
+	EventBridge   bool
+	ELBv2         bool
+	Route53       bool
+	CloudWatch    bool
+	ServiceQuotas bool
+	DynamoDB      bool
+	// ConfigService is only used by the AWS Config rule
+	ConfigService bool
+	CloudTrail    bool
+	S3            bool
+	SSM           bool
+	SSMIncidents  bool
+	// SecretsManager reads the Sentry DSN
+	SecretsManager bool
+	Kinesis        bool
+	Firehose       bool
+	// RetryBudget draws the retries of every client from the invocation's retry budget
+	RetryBudget bool
+}
+
+// NewSessionFactory returns a Factory that creates the clients from a new AWS session of the given region, or of the
+// function's when it is empty, see Region.
+// Only the EC2, AutoScaling and ElasticBeanstalk clients are always built, the rest depend on opts.
+func NewSessionFactory(opts Options) Factory {
+	return func(region string) (Clients, error) {
+		region, err := Region(region)
+		if err != nil {
+			return Clients{}, err
+		}
+		awsCfg := &aws.Config{Region: aws.String(region)}
+		if opts.Endpoint != "" {
+			// LocalStack and moto serve the buckets on their single endpoint, not on virtual hosts
+			awsCfg = awsCfg.WithEndpoint(opts.Endpoint).WithS3ForcePathStyle(true)
+		}
+		if opts.RetryBudget {
+			// The budget is checked on every failure, also those the services already flagged as retryable
+			awsCfg.EnforceShouldRetryCheck = aws.Bool(true)
+		}
+		sess, err := session.NewSession(awsCfg)
+		if err != nil {
+			return Clients{}, err
+		}
+		if opts.RetryBudget {
+			sess.Handlers.Validate.PushBackNamed(budgetHandler)
+		}
+		clients := Clients{
+			EC2:         ec2.New(sess),
+			AutoScaling: autoscaling.New(sess),
+			// Building a client makes no calls, environment aliases can come with any request
+			ElasticBeanstalk: elasticbeanstalk.New(sess),
+		}
+		if opts.SNS {
+			clients.SNS = sns.New(sess)
+		}
+		if opts.SQS {
+			clients.SQS = sqs.New(sess)
+		}
+		if opts.Lambda {
+			clients.Lambda = lambda.New(sess)
+		}
+		if opts.EventBridge {
+			clients.EventBridge = eventbridge.New(sess)
+		}
+		if opts.ELBv2 {
+			clients.ELBv2 = elbv2.New(sess)
+		}
+		if opts.Route53 {
+			clients.Route53 = route53.New(sess)
+		}
+		if opts.CloudWatch {
+			clients.CloudWatch = cloudwatch.New(sess)
+		}
+		if opts.ServiceQuotas {
+			clients.ServiceQuotas = servicequotas.New(sess)
+		}
+		if opts.DynamoDB {
+			clients.DynamoDB = dynamodb.New(sess)
+		}
+		if opts.ConfigService {
+			clients.ConfigService = configservice.New(sess)
+		}
+		if opts.CloudTrail {
+			clients.CloudTrail = cloudtrail.New(sess)
+		}
+		if opts.S3 {
+			clients.S3 = s3.New(sess)
+		}
+		if opts.SSM {
+			clients.SSM = ssm.New(sess)
+		}
+		if opts.SSMIncidents {
+			clients.SSMIncidents = ssmincidents.New(sess)
+		}
+		if opts.SecretsManager {
+			clients.SecretsManager = secretsmanager.New(sess)
+		}
+		if opts.Kinesis {
+			clients.Kinesis = kinesis.New(sess)
+		}
+		if opts.Firehose {
+			clients.Firehose = firehose.New(sess)
+		}
+		return clients, nil
+	}
+}
+
+// ForConfig returns a session Factory that builds only the clients of the features enabled in cfg
+func ForConfig(cfg config.Config) Factory {
+	return NewSessionFactory(OptionsFor(cfg))
+}
+
+// OptionsFor selects the optional clients of the features enabled in cfg. The Lambda client is also built when the
+// feature flags, of the environment or of the AppConfig profile, can enable the asynchronous apply.
+func OptionsFor(cfg config.Config) Options {
+	return Options{
+		Endpoint:       cfg.EndpointURL,
+		SNS:            cfg.ApprovalTopicARN != "" || cfg.AlertTopicARN != "" || cfg.TenantTopics(),
+		SQS:            cfg.RemovalDelayQueueURL != "" || cfg.DeferredSyncQueueURL != "",
+		Lambda:         cfg.AsyncApply || cfg.FeatureFlags.Has(flags.AsyncApply) || cfg.FeatureFlagsProfile != "",
+		ELBv2:          len(cfg.TargetGroupARNs) != 0,
+		Route53:        cfg.HealthChecks,
+		CloudWatch:     cfg.MetricsNamespace != "",
+		ServiceQuotas:  cfg.MetricsNamespace != "" && cfg.RulesQuota == 0,
+		DynamoDB:       cfg.StateTable != "",
+		CloudTrail:     cfg.StateTable != "" && cfg.DriftAttribution,
+		S3:             cfg.ReportBucket != "" || cfg.PlanBucket != "",
+		SSM:            cfg.OpsItemThreshold > 0 || cfg.CreatesSecurityGroup() || cfg.BlueGreen,
+		SSMIncidents:   cfg.IncidentResponsePlanARN != "" && len(cfg.CriticalSecurityGroups) != 0,
+		SecretsManager: cfg.SentryDSNSecret != "",
+		Kinesis:        cfg.AuditKinesisStream != "",
+		Firehose:       cfg.AuditFirehoseStream != "",
+		RetryBudget:    cfg.RetryBudget > 0,
+	}
+}
+
+// Cached wraps a Factory so that the clients of every region are built only once and reused by later
+// invocations of a warm Lambda container. Failed builds are not cached.
+func Cached(factory Factory) Factory {
+	var mu sync.Mutex
+	clients := make(map[string]Clients)
+	return func(region string) (Clients, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if c, ok := clients[region]; ok {
+			return c, nil
+		}
+		c, err := factory(region)
+		if err != nil {
+			return c, err
+		}
+		clients[region] = c
+		return c, nil
+	}
+}
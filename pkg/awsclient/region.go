@@ -0,0 +1,32 @@
+package awsclient
+
+import (
+	"os"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+)
+
+// DefaultRegion is the region of the function's environment: AWS_REGION, set by Lambda, else AWS_DEFAULT_REGION
+func DefaultRegion() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// Region gets the region the clients are built for: the given one, e.g. the event's, else DefaultRegion. Manual
+// invocations may carry none, and an empty region would only fail on the first call, so it is refused here along with
+// the malformed ones.
+func Region(region string) (string, error) {
+	if region == "" {
+		region = DefaultRegion()
+	}
+	if region == "" {
+		return "", errs.Errorf(errs.Config, "resolve region", "the request has no region and neither AWS_REGION nor AWS_DEFAULT_REGION is set")
+	}
+	if !event.ValidRegion(region) {
+		return "", errs.Errorf(errs.Config, "resolve region", "%q is not a valid region", region)
+	}
+	return region, nil
+}
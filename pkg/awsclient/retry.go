@@ -0,0 +1,30 @@
+package awsclient
+
+import (
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+)
+
+// budgetRetryer is the service's own retryer, whose retries are drawn from the running invocation's retry budget
+type budgetRetryer struct {
+	request.Retryer
+}
+
+// ShouldRetry retries the retryable failures as long as the call has retries left and the budget allows one more
+func (r budgetRetryer) ShouldRetry(req *request.Request) bool {
+	if !r.Retryer.ShouldRetry(req) || req.RetryCount >= r.MaxRetries() {
+		return false
+	}
+	return retry.Current().Allow(req.ClientInfo.ServiceName+":"+req.Operation.Name, req.Error)
+}
+
+// budgetHandler wraps the retryer of every request, so each service keeps its own retry limit and delays, e.g.
+// DynamoDB's 10 retries
+var budgetHandler = request.NamedHandler{
+	Name: "sgsync.RetryBudget",
+	Fn: func(req *request.Request) {
+		if _, ok := req.Retryer.(budgetRetryer); !ok && req.Retryer != nil {
+			req.Retryer = budgetRetryer{req.Retryer}
+		}
+	},
+}
@@ -0,0 +1,318 @@
+package bluegreen
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// GreenOfTag tags a green Security Group with the ID of the group it was built from
+const GreenOfTag = "sg-sync:green-of"
+
+// ReplacedByTag tags a retired Security Group with the ID of the green group that replaced it
+const ReplacedByTag = "sg-sync:replaced-by"
+
+// greenSuffix is the suffix of the green groups' names, the build time, e.g. web-bg-1603172856
+var greenSuffix = regexp.MustCompile(`-bg-[0-9]+$`)
+
+// Change is a change of a rule set of the active group, applied to the green group
+type Change struct {
+	Rule    target.Rule
+	Added   []string
+	Removed []string
+}
+
+// Swap is the outcome of a blue/green swap
+type Swap struct {
+	// ActiveSecurityGroupID is the green group, attached in place of RetiredSecurityGroupID
+	ActiveSecurityGroupID  string `json:"active_security_group_id"`
+	RetiredSecurityGroupID string `json:"retired_security_group_id"`
+	// NetworkInterfaces are the network interfaces the green group was attached to
+	NetworkInterfaces []string `json:"network_interfaces"`
+	// LaunchTemplates are the launch template versions created with the green group, template:version
+	LaunchTemplates []string `json:"launch_templates,omitempty"`
+	// Deleted is true when the retired group was deleted. It is kept, tagged with ReplacedByTag, while anything still
+	// references it.
+	Deleted bool `json:"deleted"`
+}
+
+// Build creates the green Security Group: a copy of the active group's ingress and egress rules with the changes
+// applied. owners maps the added CIDRs to their instances. The green group is verified to have exactly the ingress
+// rules it was built with, and deleted when it fails to build or to verify.
+func Build(activeID string, changes []Change, owners cidr.IPSet, ec2Svc ec2iface.EC2API) (string, error) {
+	active, err := target.Describe(activeID, ec2Svc)
+	if err != nil {
+		return "", err
+	}
+	greenID, err := target.Create(target.GroupSpec{
+		Name:        greenSuffix.ReplaceAllString(aws.StringValue(active.GroupName), "") + fmt.Sprintf("-bg-%d", time.Now().Unix()),
+		Description: aws.StringValue(active.Description),
+		VpcID:       aws.StringValue(active.VpcId),
+		Tags:        userTags(active.Tags),
+	}, map[string]string{GreenOfTag: activeID}, ec2Svc)
+	if err != nil {
+		return "", err
+	}
+	if err := build(active, greenID, changes, owners, ec2Svc); err != nil {
+		Discard(greenID, ec2Svc)
+		return "", err
+	}
+	return greenID, nil
+}
+
+// Copies the rules of the active group into the green one, applies the changes and verifies the result
+func build(active *ec2.SecurityGroup, greenID string, changes []Change, owners cidr.IPSet, ec2Svc ec2iface.EC2API) error {
+	activeID := aws.StringValue(active.GroupId)
+	removed := make(map[string]struct{})
+	for _, change := range changes {
+		for key := range target.PermissionKeys(target.Permissions(change.Rule, change.Removed)) {
+			removed[key] = struct{}{}
+		}
+	}
+	ingress := filterRanges(copyPermissions(active.IpPermissions, activeID, greenID), removed)
+	if len(ingress) != 0 {
+		_, err := ec2Svc.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{GroupId: aws.String(greenID), IpPermissions: ingress})
+		if err != nil {
+			return errs.Wrap(errs.Target, "copy security group ingress", err)
+		}
+	}
+	expected := target.PermissionKeys(ingress)
+	for _, change := range changes {
+		if err := target.Authorize(greenID, change.Rule, change.Added, owners, ec2Svc); err != nil {
+			return err
+		}
+		for key := range target.PermissionKeys(target.Permissions(change.Rule, change.Added)) {
+			expected[key] = struct{}{}
+		}
+	}
+	if err := copyEgress(active, greenID, ec2Svc); err != nil {
+		return err
+	}
+
+	green, err := target.Describe(greenID, ec2Svc)
+	if err != nil {
+		return err
+	}
+	built := target.PermissionKeys(green.IpPermissions)
+	for key := range expected {
+		if _, ok := built[key]; !ok {
+			return errs.Errorf(errs.Target, "verify green security group", "%s is missing %s", greenID, key)
+		}
+	}
+	for key := range built {
+		if _, ok := expected[key]; !ok {
+			return errs.Errorf(errs.Target, "verify green security group", "%s has the unexpected %s", greenID, key)
+		}
+	}
+	return nil
+}
+
+// Copies the egress rules of the active group. A new group allows all the outbound traffic, which is revoked unless
+// the active group allows it too.
+func copyEgress(active *ec2.SecurityGroup, greenID string, ec2Svc ec2iface.EC2API) error {
+	allowAll := []*ec2.IpPermission{{IpProtocol: aws.String(target.AllProtocol), IpRanges: []*ec2.IpRange{{CidrIp: aws.String("0.0.0.0/0")}}}}
+	defaults := target.PermissionKeys(allowAll)
+	egress := copyPermissions(active.IpPermissionsEgress, aws.StringValue(active.GroupId), greenID)
+	kept := filterRanges(egress, defaults)
+	if len(target.PermissionKeys(kept)) == len(target.PermissionKeys(egress)) {
+		_, err := ec2Svc.RevokeSecurityGroupEgress(&ec2.RevokeSecurityGroupEgressInput{GroupId: aws.String(greenID), IpPermissions: allowAll})
+		if err != nil {
+			return errs.Wrap(errs.Target, "revoke security group egress", err)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	_, err := ec2Svc.AuthorizeSecurityGroupEgress(&ec2.AuthorizeSecurityGroupEgressInput{GroupId: aws.String(greenID), IpPermissions: kept})
+	return errs.Wrap(errs.Target, "copy security group egress", err)
+}
+
+// SwapIn attaches the green group in place of the active one to every network interface the active one is attached
+// to. Every interface gets its new groups in a single call. When an interface fails, the ones already swapped are
+// swapped back. Returns the swapped interfaces.
+func SwapIn(activeID string, greenID string, ec2Svc ec2iface.EC2API) ([]string, error) {
+	var enis []*ec2.NetworkInterface
+	err := ec2Svc.DescribeNetworkInterfacesPages(&ec2.DescribeNetworkInterfacesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("group-id"), Values: []*string{aws.String(activeID)}}},
+	}, func(page *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+		enis = append(enis, page.NetworkInterfaces...)
+		return true
+	})
+	if err != nil {
+		return nil, errs.Wrap(errs.Target, "describe network interfaces", err)
+	}
+	for _, eni := range enis {
+		// The interfaces of AWS services, e.g. RDS or load balancers, can only be changed through their service
+		if aws.BoolValue(eni.RequesterManaged) {
+			return nil, errs.Errorf(errs.Target, "swap security group", "network interface %s is managed by %s", aws.StringValue(eni.NetworkInterfaceId), aws.StringValue(eni.RequesterId))
+		}
+	}
+
+	var swapped []*ec2.NetworkInterface
+	for _, eni := range enis {
+		if err := setGroups(eni, activeID, greenID, ec2Svc); err != nil {
+			for _, done := range swapped {
+				// A failed swap back leaves the interface with the green group, which has the same rules and more
+				setGroups(done, greenID, activeID, ec2Svc)
+			}
+			return nil, err
+		}
+		swapped = append(swapped, eni)
+	}
+	ids := make([]string, len(swapped))
+	for i, eni := range swapped {
+		ids[i] = aws.StringValue(eni.NetworkInterfaceId)
+	}
+	return ids, nil
+}
+
+// Replaces the group from with the group to among the groups of the network interface
+func setGroups(eni *ec2.NetworkInterface, from string, to string, ec2Svc ec2iface.EC2API) error {
+	var groups []*string
+	for _, group := range eni.Groups {
+		id := aws.StringValue(group.GroupId)
+		if id == from {
+			id = to
+		}
+		groups = append(groups, aws.String(id))
+	}
+	_, err := ec2Svc.ModifyNetworkInterfaceAttribute(&ec2.ModifyNetworkInterfaceAttributeInput{
+		NetworkInterfaceId: eni.NetworkInterfaceId,
+		Groups:             groups,
+	})
+	return errs.Wrap(errs.Target, "modify network interface attribute", err)
+}
+
+// MarkReplaced tags the swapped out group with the green group that replaced it, so that Resolve finds the green group
+// through it
+func MarkReplaced(activeID string, greenID string, ec2Svc ec2iface.EC2API) error {
+	_, err := ec2Svc.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String(activeID)},
+		Tags:      []*ec2.Tag{{Key: aws.String(ReplacedByTag), Value: aws.String(greenID)}},
+	})
+	target.Invalidate(activeID)
+	return errs.Wrap(errs.Target, "tag replaced security group", err)
+}
+
+// maxReplacements is how many replacements Resolve follows, in case the tags loop
+const maxReplacements = 10
+
+// Resolve gets the group that replaced the Security Group, following the ReplacedByTag of the swapped out groups. The
+// group itself when it wasn't replaced.
+func Resolve(sgID string, ec2Svc ec2iface.EC2API) (string, error) {
+	for i := 0; i < maxReplacements; i++ {
+		group, err := target.Describe(sgID, ec2Svc)
+		if err != nil {
+			return sgID, err
+		}
+		replacedBy := ""
+		for _, tag := range group.Tags {
+			if aws.StringValue(tag.Key) == ReplacedByTag {
+				replacedBy = aws.StringValue(tag.Value)
+			}
+		}
+		if replacedBy == "" {
+			return sgID, nil
+		}
+		sgID = replacedBy
+	}
+	return sgID, errs.Errorf(errs.Target, "resolve security group", "more than %d replacements of the security group", maxReplacements)
+}
+
+// Retire deletes the swapped out group. A group that is still referenced, e.g. by the rules of another group or by a
+// launch template the instances would fail to launch from without it, is kept. Returns whether it was deleted.
+func Retire(activeID string, ec2Svc ec2iface.EC2API) (bool, error) {
+	templates, err := LaunchTemplatesUsing(activeID, ec2Svc)
+	if err != nil || len(templates) != 0 {
+		return false, err
+	}
+	_, err = ec2Svc.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: aws.String(activeID)})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == "DependencyViolation" {
+		return false, nil
+	}
+	if err != nil {
+		return false, errs.Wrap(errs.Target, "delete retired security group", err)
+	}
+	target.Invalidate(activeID)
+	return true, nil
+}
+
+// Discard deletes a green group that won't be swapped in. Failures are ignored, the group is tagged with GreenOfTag.
+func Discard(greenID string, ec2Svc ec2iface.EC2API) {
+	ec2Svc.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: aws.String(greenID)})
+	target.Invalidate(greenID)
+}
+
+// Copies the permissions, without the read-only fields of the described ones. The references to the active group
+// become references to the green group.
+func copyPermissions(perms []*ec2.IpPermission, activeID string, greenID string) []*ec2.IpPermission {
+	var copies []*ec2.IpPermission
+	for _, perm := range perms {
+		c := &ec2.IpPermission{IpProtocol: perm.IpProtocol, FromPort: perm.FromPort, ToPort: perm.ToPort}
+		for _, r := range perm.IpRanges {
+			c.IpRanges = append(c.IpRanges, &ec2.IpRange{CidrIp: r.CidrIp, Description: r.Description})
+		}
+		for _, r := range perm.Ipv6Ranges {
+			c.Ipv6Ranges = append(c.Ipv6Ranges, &ec2.Ipv6Range{CidrIpv6: r.CidrIpv6, Description: r.Description})
+		}
+		for _, p := range perm.PrefixListIds {
+			c.PrefixListIds = append(c.PrefixListIds, &ec2.PrefixListId{PrefixListId: p.PrefixListId, Description: p.Description})
+		}
+		for _, pair := range perm.UserIdGroupPairs {
+			groupID := pair.GroupId
+			if aws.StringValue(groupID) == activeID {
+				groupID = aws.String(greenID)
+			}
+			c.UserIdGroupPairs = append(c.UserIdGroupPairs, &ec2.UserIdGroupPair{
+				GroupId:                groupID,
+				UserId:                 pair.UserId,
+				VpcPeeringConnectionId: pair.VpcPeeringConnectionId,
+				Description:            pair.Description,
+			})
+		}
+		copies = append(copies, c)
+	}
+	return copies
+}
+
+// Drops the IPv4 ranges whose keys are dropped, and the permissions left without sources
+func filterRanges(perms []*ec2.IpPermission, dropped map[string]struct{}) []*ec2.IpPermission {
+	var kept []*ec2.IpPermission
+	for _, perm := range perms {
+		var ranges []*ec2.IpRange
+		for _, r := range perm.IpRanges {
+			if _, ok := dropped[target.RangeKey(perm, aws.StringValue(r.CidrIp))]; !ok {
+				ranges = append(ranges, r)
+			}
+		}
+		perm.IpRanges = ranges
+		if len(perm.IpRanges)+len(perm.Ipv6Ranges)+len(perm.PrefixListIds)+len(perm.UserIdGroupPairs) != 0 {
+			kept = append(kept, perm)
+		}
+	}
+	return kept
+}
+
+// Gets the tags of the group that can be copied, all but the reserved aws: ones and those of the sync's own bookkeeping
+func userTags(tags []*ec2.Tag) map[string]string {
+	copied := make(map[string]string)
+	for _, tag := range tags {
+		key := aws.StringValue(tag.Key)
+		if strings.HasPrefix(key, "aws:") || key == GreenOfTag || key == ReplacedByTag {
+			continue
+		}
+		copied[key] = aws.StringValue(tag.Value)
+	}
+	return copied
+}
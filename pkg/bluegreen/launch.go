@@ -0,0 +1,226 @@
+package bluegreen
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// Launch is a launch template of an AutoScaling Group, which the group's new instances are launched from
+type Launch struct {
+	AutoScalingGroupName string
+	Template             *autoscaling.LaunchTemplateSpecification
+	// Mixed is true when the template is the one of the group's mixed instances policy
+	Mixed bool
+}
+
+// Launches gets the launch templates of the AutoScaling Group. A launch configuration can't be changed, so the group
+// can't be swapped when its launch configuration uses the active group.
+func Launches(asgName string, activeID string, asSvc autoscalingiface.AutoScalingAPI) ([]Launch, error) {
+	out, err := asSvc.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{AutoScalingGroupNames: []*string{aws.String(asgName)}})
+	if err != nil {
+		return nil, errs.Wrap(errs.Source, "describe autoscaling groups", err)
+	}
+	var launches []Launch
+	for _, group := range out.AutoScalingGroups {
+		if name := aws.StringValue(group.LaunchConfigurationName); name != "" {
+			configs, err := asSvc.DescribeLaunchConfigurations(&autoscaling.DescribeLaunchConfigurationsInput{LaunchConfigurationNames: []*string{aws.String(name)}})
+			if err != nil {
+				return nil, errs.Wrap(errs.Source, "describe launch configurations", err)
+			}
+			for _, config := range configs.LaunchConfigurations {
+				if contains(aws.StringValueSlice(config.SecurityGroups), activeID) {
+					return nil, errs.Errorf(errs.Config, "swap security group", "the launch configuration %s of %s uses %s, blueGreen needs a launch template", name, asgName, activeID)
+				}
+			}
+		}
+		if group.LaunchTemplate != nil {
+			launches = append(launches, Launch{AutoScalingGroupName: asgName, Template: group.LaunchTemplate})
+		}
+		if group.MixedInstancesPolicy != nil && group.MixedInstancesPolicy.LaunchTemplate != nil && group.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification != nil {
+			launches = append(launches, Launch{AutoScalingGroupName: asgName, Template: group.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification, Mixed: true})
+		}
+	}
+	return launches, nil
+}
+
+// UpdateLaunches replaces the active group with the green group in the launch templates' versions the AutoScaling
+// Group launches from. A new version is created and the group is pointed at it: through the template's default version
+// when the group launches from the default, through the group itself when it launches from a fixed version. Returns the
+// updated templates.
+func UpdateLaunches(launches []Launch, activeID string, greenID string, asSvc autoscalingiface.AutoScalingAPI, ec2Svc ec2iface.EC2API) ([]string, error) {
+	var updated []string
+	for _, launch := range launches {
+		spec := launch.Template
+		version := aws.StringValue(spec.Version)
+		if version == "" {
+			version = "$Default"
+		}
+		out, err := ec2Svc.DescribeLaunchTemplateVersions(&ec2.DescribeLaunchTemplateVersionsInput{
+			LaunchTemplateId:   spec.LaunchTemplateId,
+			LaunchTemplateName: spec.LaunchTemplateName,
+			Versions:           []*string{aws.String(version)},
+		})
+		if err != nil {
+			return updated, errs.Wrap(errs.Target, "describe launch template versions", err)
+		}
+		for _, current := range out.LaunchTemplateVersions {
+			data, ok := replaceGroup(current.LaunchTemplateData, activeID, greenID)
+			if !ok {
+				continue
+			}
+			created, err := ec2Svc.CreateLaunchTemplateVersion(&ec2.CreateLaunchTemplateVersionInput{
+				LaunchTemplateId:   current.LaunchTemplateId,
+				SourceVersion:      aws.String(strconv.FormatInt(aws.Int64Value(current.VersionNumber), 10)),
+				VersionDescription: aws.String("Swaps " + activeID + " for " + greenID),
+				LaunchTemplateData: data,
+			})
+			if err != nil {
+				return updated, errs.Wrap(errs.Target, "create launch template version", err)
+			}
+			newVersion := strconv.FormatInt(aws.Int64Value(created.LaunchTemplateVersion.VersionNumber), 10)
+			if err := pointAt(launch, current.LaunchTemplateId, version, newVersion, asSvc, ec2Svc); err != nil {
+				return updated, err
+			}
+			updated = append(updated, aws.StringValue(current.LaunchTemplateId)+":"+newVersion)
+		}
+	}
+	return updated, nil
+}
+
+// Points the AutoScaling Group at the new version of the launch template
+func pointAt(launch Launch, templateID *string, version string, newVersion string, asSvc autoscalingiface.AutoScalingAPI, ec2Svc ec2iface.EC2API) error {
+	switch version {
+	case "$Latest":
+		return nil
+	case "$Default":
+		_, err := ec2Svc.ModifyLaunchTemplate(&ec2.ModifyLaunchTemplateInput{LaunchTemplateId: templateID, DefaultVersion: aws.String(newVersion)})
+		return errs.Wrap(errs.Target, "modify launch template", err)
+	}
+	spec := &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: templateID, Version: aws.String(newVersion)}
+	input := &autoscaling.UpdateAutoScalingGroupInput{AutoScalingGroupName: aws.String(launch.AutoScalingGroupName)}
+	if launch.Mixed {
+		input.MixedInstancesPolicy = &autoscaling.MixedInstancesPolicy{LaunchTemplate: &autoscaling.LaunchTemplate{LaunchTemplateSpecification: spec}}
+	} else {
+		input.LaunchTemplate = spec
+	}
+	_, err := asSvc.UpdateAutoScalingGroup(input)
+	return errs.Wrap(errs.Target, "update autoscaling group", err)
+}
+
+// LaunchTemplatesUsing gets the launch templates whose default or latest version uses the Security Group
+func LaunchTemplatesUsing(sgID string, ec2Svc ec2iface.EC2API) ([]string, error) {
+	var templates []string
+	err := ec2Svc.DescribeLaunchTemplateVersionsPages(&ec2.DescribeLaunchTemplateVersionsInput{
+		Versions: []*string{aws.String("$Latest"), aws.String("$Default")},
+	}, func(page *ec2.DescribeLaunchTemplateVersionsOutput, lastPage bool) bool {
+		for _, version := range page.LaunchTemplateVersions {
+			if _, ok := replaceGroup(version.LaunchTemplateData, sgID, sgID); ok && !contains(templates, aws.StringValue(version.LaunchTemplateId)) {
+				templates = append(templates, aws.StringValue(version.LaunchTemplateId))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errs.Wrap(errs.Target, "describe launch template versions", err)
+	}
+	return templates, nil
+}
+
+// Builds the launch template data that replaces the group from with the group to, in the instance's groups or in the
+// network interfaces' ones. ok is false when the data doesn't use the group from. The network interfaces are replaced
+// as a whole by a new version, so every field of theirs is copied.
+func replaceGroup(data *ec2.ResponseLaunchTemplateData, from string, to string) (*ec2.RequestLaunchTemplateData, bool) {
+	if data == nil {
+		return nil, false
+	}
+	replaced := &ec2.RequestLaunchTemplateData{}
+	ok := false
+	if contains(aws.StringValueSlice(data.SecurityGroupIds), from) {
+		replaced.SecurityGroupIds = aws.StringSlice(swapID(aws.StringValueSlice(data.SecurityGroupIds), from, to))
+		ok = true
+	}
+	uses := false
+	for _, eni := range data.NetworkInterfaces {
+		uses = uses || contains(aws.StringValueSlice(eni.Groups), from)
+	}
+	if uses {
+		for _, eni := range data.NetworkInterfaces {
+			replaced.NetworkInterfaces = append(replaced.NetworkInterfaces, interfaceRequest(eni, from, to))
+		}
+		ok = true
+	}
+	return replaced, ok
+}
+
+// Copies the network interface of a launch template version into the request of a new version
+func interfaceRequest(eni *ec2.LaunchTemplateInstanceNetworkInterfaceSpecification, from string, to string) *ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest {
+	req := &ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+		AssociateCarrierIpAddress:      eni.AssociateCarrierIpAddress,
+		AssociatePublicIpAddress:       eni.AssociatePublicIpAddress,
+		DeleteOnTermination:            eni.DeleteOnTermination,
+		Description:                    eni.Description,
+		DeviceIndex:                    eni.DeviceIndex,
+		Groups:                         aws.StringSlice(swapID(aws.StringValueSlice(eni.Groups), from, to)),
+		InterfaceType:                  eni.InterfaceType,
+		Ipv4PrefixCount:                eni.Ipv4PrefixCount,
+		Ipv6AddressCount:               eni.Ipv6AddressCount,
+		Ipv6PrefixCount:                eni.Ipv6PrefixCount,
+		NetworkCardIndex:               eni.NetworkCardIndex,
+		NetworkInterfaceId:             eni.NetworkInterfaceId,
+		PrimaryIpv6:                    eni.PrimaryIpv6,
+		PrivateIpAddress:               eni.PrivateIpAddress,
+		PrivateIpAddresses:             eni.PrivateIpAddresses,
+		SecondaryPrivateIpAddressCount: eni.SecondaryPrivateIpAddressCount,
+		SubnetId:                       eni.SubnetId,
+	}
+	for _, prefix := range eni.Ipv4Prefixes {
+		req.Ipv4Prefixes = append(req.Ipv4Prefixes, &ec2.Ipv4PrefixSpecificationRequest{Ipv4Prefix: prefix.Ipv4Prefix})
+	}
+	for _, prefix := range eni.Ipv6Prefixes {
+		req.Ipv6Prefixes = append(req.Ipv6Prefixes, &ec2.Ipv6PrefixSpecificationRequest{Ipv6Prefix: prefix.Ipv6Prefix})
+	}
+	for _, address := range eni.Ipv6Addresses {
+		req.Ipv6Addresses = append(req.Ipv6Addresses, &ec2.InstanceIpv6AddressRequest{Ipv6Address: address.Ipv6Address})
+	}
+	if tracking := eni.ConnectionTrackingSpecification; tracking != nil {
+		req.ConnectionTrackingSpecification = &ec2.ConnectionTrackingSpecificationRequest{
+			TcpEstablishedTimeout: tracking.TcpEstablishedTimeout,
+			UdpStreamTimeout:      tracking.UdpStreamTimeout,
+			UdpTimeout:            tracking.UdpTimeout,
+		}
+	}
+	if srd := eni.EnaSrdSpecification; srd != nil {
+		req.EnaSrdSpecification = &ec2.EnaSrdSpecificationRequest{EnaSrdEnabled: srd.EnaSrdEnabled}
+		if srd.EnaSrdUdpSpecification != nil {
+			req.EnaSrdSpecification.EnaSrdUdpSpecification = &ec2.EnaSrdUdpSpecificationRequest{EnaSrdUdpEnabled: srd.EnaSrdUdpSpecification.EnaSrdUdpEnabled}
+		}
+	}
+	return req
+}
+
+// Replaces the ID from with the ID to
+func swapID(ids []string, from string, to string) []string {
+	swapped := make([]string, len(ids))
+	for i, id := range ids {
+		if id == from {
+			id = to
+		}
+		swapped[i] = id
+	}
+	return swapped
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	lambdasvc "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// RuleName is the name of the EventBridge rule that forwards the lifecycle events to the function
+const RuleName = "sg-sync-lifecycle"
+
+// Identifies the rule's target and the function's permission, so that re-runs update them instead of adding new ones
+const (
+	targetID    = "sg-sync"
+	statementID = "sg-sync-lifecycle-events"
+)
+
+// EventPattern matches the launch and terminate lifecycle actions of the AutoScaling Groups
+func EventPattern(asgNames []string) (string, error) {
+	pattern, err := json.Marshal(map[string]interface{}{
+		"source":      []string{"aws.autoscaling"},
+		"detail-type": []string{"EC2 Instance-launch Lifecycle Action", "EC2 Instance-terminate Lifecycle Action"},
+		"detail":      map[string][]string{"AutoScalingGroupName": asgNames},
+	})
+	return string(pattern), err
+}
+
+// EventRule creates, or updates, the EventBridge rule of the AutoScaling Groups' lifecycle events, lets it invoke the
+// function and makes the function its target. Every step is idempotent. The AutoScaling Groups are added to the ones the
+// rule already matches, so that onboarding a group keeps the groups onboarded before.
+func EventRule(functionARN string, asgNames []string, eventsSvc eventbridgeiface.EventBridgeAPI, lambdaSvc lambdaiface.LambdaAPI) error {
+	current, err := ruleGroups(eventsSvc)
+	if err != nil {
+		return err
+	}
+	pattern, err := EventPattern(mergeNames(current, asgNames))
+	if err != nil {
+		return errs.Wrap(errs.Config, "event pattern", err)
+	}
+	rule, err := eventsSvc.PutRule(&eventbridge.PutRuleInput{
+		Name:         aws.String(RuleName),
+		Description:  aws.String("Forwards the AutoScaling lifecycle actions to the Security Group sync"),
+		EventPattern: aws.String(pattern),
+		State:        aws.String(eventbridge.RuleStateEnabled),
+	})
+	if err != nil {
+		return errs.Wrap(errs.Target, "put rule", err)
+	}
+
+	_, err = lambdaSvc.AddPermission(&lambdasvc.AddPermissionInput{
+		FunctionName: aws.String(functionARN),
+		StatementId:  aws.String(statementID),
+		Action:       aws.String("lambda:InvokeFunction"),
+		Principal:    aws.String("events.amazonaws.com"),
+		SourceArn:    rule.RuleArn,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == lambdasvc.ErrCodeResourceConflictException {
+		// The statement is already in place, as long as it grants this rule
+		err = samePermission(functionARN, aws.StringValue(rule.RuleArn), lambdaSvc)
+	}
+	if err != nil {
+		return errs.Wrap(errs.Target, "add permission", err)
+	}
+
+	out, err := eventsSvc.PutTargets(&eventbridge.PutTargetsInput{
+		Rule:    aws.String(RuleName),
+		Targets: []*eventbridge.Target{{Id: aws.String(targetID), Arn: aws.String(functionARN)}},
+	})
+	if err != nil {
+		return errs.Wrap(errs.Target, "put targets", err)
+	}
+	if aws.Int64Value(out.FailedEntryCount) > 0 {
+		return errs.Errorf(errs.Target, "put targets", "%s: %s", aws.StringValue(out.FailedEntries[0].ErrorCode), aws.StringValue(out.FailedEntries[0].ErrorMessage))
+	}
+	return nil
+}
+
+// Gets the AutoScaling Groups the rule matches, none when the rule doesn't exist yet
+func ruleGroups(eventsSvc eventbridgeiface.EventBridgeAPI) ([]string, error) {
+	rule, err := eventsSvc.DescribeRule(&eventbridge.DescribeRuleInput{Name: aws.String(RuleName)})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == eventbridge.ErrCodeResourceNotFoundException {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errs.Wrap(errs.Target, "describe rule", err)
+	}
+	var pattern struct {
+		Detail struct {
+			AutoScalingGroupName []string `json:"AutoScalingGroupName"`
+		} `json:"detail"`
+	}
+	if err := json.Unmarshal([]byte(aws.StringValue(rule.EventPattern)), &pattern); err != nil {
+		return nil, errs.Errorf(errs.Config, "describe rule", "the pattern of %s isn't the function's: %v", RuleName, err)
+	}
+	return pattern.Detail.AutoScalingGroupName, nil
+}
+
+// Merges the AutoScaling Group names, sorted and without duplicates
+func mergeNames(current []string, added []string) []string {
+	seen := make(map[string]struct{}, len(current)+len(added))
+	var names []string
+	for _, name := range append(append([]string(nil), current...), added...) {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Checks that the function's statement, which already exists, grants the rule ruleARN
+func samePermission(functionARN string, ruleARN string, lambdaSvc lambdaiface.LambdaAPI) error {
+	out, err := lambdaSvc.GetPolicy(&lambdasvc.GetPolicyInput{FunctionName: aws.String(functionARN)})
+	if err != nil {
+		return errs.Wrap(errs.Target, "get policy", err)
+	}
+	var policy struct {
+		Statement []struct {
+			Sid       string
+			Condition struct {
+				ArnLike map[string]string
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(aws.StringValue(out.Policy)), &policy); err != nil {
+		return errs.Errorf(errs.Target, "get policy", "the policy of %s: %v", functionARN, err)
+	}
+	for _, statement := range policy.Statement {
+		if statement.Sid != statementID {
+			continue
+		}
+		if source := statement.Condition.ArnLike["AWS:SourceArn"]; source != ruleARN {
+			return errs.Errorf(errs.Config, "add permission", "the statement %s of %s grants %q, not the rule %s", statementID, functionARN, source, ruleARN)
+		}
+		return nil
+	}
+	return errs.Errorf(errs.Target, "add permission", "the statement %s of %s conflicts but isn't in its policy", statementID, functionARN)
+}
@@ -0,0 +1,51 @@
+package bootstrap
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+)
+
+// LaunchHookName is the name of the lifecycle hook of the launching instances
+const LaunchHookName = "sg-sync-launching"
+
+// TerminateHookName is the name of the lifecycle hook of the terminating instances
+const TerminateHookName = "sg-sync-terminating"
+
+// DefaultHeartbeatTimeout leaves the sync enough time to retry throttled calls, without holding instances for long
+// when the function never answers
+const DefaultHeartbeatTimeout = 5 * time.Minute
+
+// Hook is a lifecycle hook to create
+type Hook struct {
+	Name       string
+	Transition string
+}
+
+// Hooks are the launch and terminate hooks the function listens to
+var Hooks = []Hook{
+	{Name: LaunchHookName, Transition: event.TransitionLaunching},
+	{Name: TerminateHookName, Transition: event.TransitionTerminating},
+}
+
+// LifecycleHooks creates, or updates, the launch and terminate hooks of the AutoScaling Group. defaultResult is the
+// result of the hooks that time out. PutLifecycleHook is an upsert, so it is safe to run it again.
+func LifecycleHooks(asgName string, heartbeat time.Duration, defaultResult string, asSvc autoscalingiface.AutoScalingAPI) error {
+	for _, hook := range Hooks {
+		_, err := asSvc.PutLifecycleHook(&autoscaling.PutLifecycleHookInput{
+			AutoScalingGroupName: aws.String(asgName),
+			LifecycleHookName:    aws.String(hook.Name),
+			LifecycleTransition:  aws.String(hook.Transition),
+			HeartbeatTimeout:     aws.Int64(int64(heartbeat / time.Second)),
+			DefaultResult:        aws.String(defaultResult),
+		})
+		if err != nil {
+			return errs.Wrap(errs.Source, "put lifecycle hook "+hook.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package cidr
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// Host gets the normalized CIDR of the single address, /32 for IPv4 and /128 for IPv6. IPv4-mapped IPv6 addresses are
+// unmapped to their IPv4 address.
+func Host(ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", fmt.Errorf("malformed IP %q: %w", ip, err)
+	}
+	if addr.Zone() != "" {
+		return "", fmt.Errorf("malformed IP %q: zones are not allowed", ip)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()).String(), nil
+}
+
+// Normalize parses the CIDR and gets its canonical form, with the host bits masked off, e.g. 10.0.0.7/24 into
+// 10.0.0.0/24
+func Normalize(cidr string) (string, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", fmt.Errorf("malformed CIDR %q: %w", cidr, err)
+	}
+	return prefix.Masked().String(), nil
+}
+
+// IsHost checks whether the CIDR covers a single address. Malformed CIDRs are not.
+func IsHost(cidr string) bool {
+	prefix, err := netip.ParsePrefix(cidr)
+	return err == nil && prefix.IsSingleIP()
+}
+
+// DelegatedPrefixBits is the length of the IPv4 prefixes EC2 delegates to the network interfaces
+const DelegatedPrefixBits = 28
+
+// IsDelegatedPrefix checks whether the CIDR is an IPv4 CIDR as wide as a delegated prefix. Malformed CIDRs are not.
+func IsDelegatedPrefix(cidr string) bool {
+	prefix, err := netip.ParsePrefix(cidr)
+	return err == nil && prefix.Addr().Is4() && prefix.Bits() == DelegatedPrefixBits
+}
+
+// Mask widens the IPv4 CIDR to the mask, e.g. 203.0.113.12/32 to 203.0.113.0/24 with 24. IPv6 CIDRs and the ones
+// already as wide are returned as they are.
+func Mask(cidr string, bits int) (string, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", fmt.Errorf("malformed CIDR %q: %w", cidr, err)
+	}
+	if !prefix.Addr().Is4() || prefix.Bits() <= bits {
+		return prefix.Masked().String(), nil
+	}
+	return netip.PrefixFrom(prefix.Addr(), bits).Masked().String(), nil
+}
+
+// Addr gets the address of the CIDR
+func Addr(cidr string) (string, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", fmt.Errorf("malformed CIDR %q: %w", cidr, err)
+	}
+	return prefix.Addr().String(), nil
+}
@@ -0,0 +1,83 @@
+package cidr
+
+import "testing"
+
+func TestHost(t *testing.T) {
+	tests := []struct {
+		ip      string
+		want    string
+		wantErr bool
+	}{
+		{ip: "203.0.113.10", want: "203.0.113.10/32"},
+		{ip: "2001:db8::1", want: "2001:db8::1/128"},
+		{ip: "::ffff:203.0.113.10", want: "203.0.113.10/32"},
+		{ip: "fe80::1%eth0", wantErr: true},
+		{ip: "203.0.113", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			got, err := Host(tt.ip)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Host(%q) error = %v, want error %v", tt.ip, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Host(%q) = %q, want %q", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		cidr    string
+		want    string
+		wantErr bool
+	}{
+		{cidr: "10.0.0.7/24", want: "10.0.0.0/24"},
+		{cidr: "203.0.113.10/32", want: "203.0.113.10/32"},
+		{cidr: "2001:db8::1/64", want: "2001:db8::/64"},
+		{cidr: "10.0.0.7", wantErr: true},
+		{cidr: "10.0.0.0/33", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			got, err := Normalize(tt.cidr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Normalize(%q) error = %v, want error %v", tt.cidr, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.cidr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		cidr string
+		bits int
+		want string
+	}{
+		{cidr: "203.0.113.12/32", bits: 24, want: "203.0.113.0/24"},
+		{cidr: "203.0.113.12/32", bits: 32, want: "203.0.113.12/32"},
+		{cidr: "10.1.0.0/16", bits: 24, want: "10.1.0.0/16"},
+		{cidr: "2001:db8::1/128", bits: 24, want: "2001:db8::1/128"},
+	}
+	for _, tt := range tests {
+		if got, err := Mask(tt.cidr, tt.bits); err != nil || got != tt.want {
+			t.Errorf("Mask(%q, %d) = %q, %v, want %q", tt.cidr, tt.bits, got, err, tt.want)
+		}
+	}
+	if _, err := Mask("203.0.113", 24); err == nil {
+		t.Error("Mask of a malformed CIDR succeeded")
+	}
+}
+
+func TestIsHostAndIsDelegatedPrefix(t *testing.T) {
+	if !IsHost("203.0.113.10/32") || !IsHost("2001:db8::1/128") || IsHost("10.0.0.0/24") || IsHost("malformed") {
+		t.Error("IsHost only holds for the /32 and /128 CIDRs")
+	}
+	if !IsDelegatedPrefix("10.0.0.16/28") || IsDelegatedPrefix("10.0.0.0/24") || IsDelegatedPrefix("2001:db8::/28") {
+		t.Error("IsDelegatedPrefix only holds for the IPv4 /28 CIDRs")
+	}
+}
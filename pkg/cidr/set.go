@@ -0,0 +1,91 @@
+package cidr
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// IPSet is a set of normalized CIDRs, each with a value: e.g. the ID of the instance of a desired CIDR or the
+// description of an existing rule. Its listings are sorted, so that logs and responses are deterministic.
+type IPSet map[string]string
+
+// NewIPSet creates an empty IPSet
+func NewIPSet() IPSet {
+	return make(IPSet)
+}
+
+// Add normalizes the CIDR and adds it to the set with its value, replacing the value it had
+func (s IPSet) Add(cidr string, value string) error {
+	normalized, err := Normalize(cidr)
+	if err != nil {
+		return err
+	}
+	s[normalized] = value
+	return nil
+}
+
+// Keep adds the CIDR like Add, keeping it as it is when it is malformed, e.g. a rule of a Security Group that the
+// removal guard should still see
+func (s IPSet) Keep(cidr string, value string) {
+	if err := s.Add(cidr, value); err != nil {
+		s[cidr] = value
+	}
+}
+
+// Contains checks whether the set holds the CIDR, whatever its notation. Malformed CIDRs are only matched as they are.
+func (s IPSet) Contains(cidr string) bool {
+	if normalized, err := Normalize(cidr); err == nil {
+		cidr = normalized
+	}
+	_, ok := s[cidr]
+	return ok
+}
+
+// Covers checks whether one of the CIDRs of the set contains the address of the host CIDR, e.g. 10.0.0.5/32 is
+// covered by 10.0.0.0/24
+func (s IPSet) Covers(hostCIDR string) bool {
+	host, err := netip.ParsePrefix(hostCIDR)
+	if err != nil {
+		return false
+	}
+	for c := range s {
+		if prefix, err := netip.ParsePrefix(c); err == nil && prefix.Bits() <= host.Bits() && prefix.Contains(host.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// CIDRs lists the CIDRs of the set, sorted
+func (s IPSet) CIDRs() []string {
+	cidrs := make([]string, 0, len(s))
+	for c := range s {
+		cidrs = append(cidrs, c)
+	}
+	sort.Strings(cidrs)
+	return cidrs
+}
+
+// Diff lists, sorted, the CIDRs of the set that other doesn't hold
+func (s IPSet) Diff(other IPSet) []string {
+	var diff []string
+	for c := range s {
+		if _, ok := other[c]; !ok {
+			diff = append(diff, c)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// Union creates the set of the CIDRs of both sets. The values of other win.
+func (s IPSet) Union(other IPSet) IPSet {
+	union := make(IPSet, len(s)+len(other))
+	for c, value := range s {
+		union[c] = value
+	}
+	for c, value := range other {
+		union[c] = value
+	}
+	return union
+}
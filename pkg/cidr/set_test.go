@@ -0,0 +1,65 @@
+package cidr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIPSetAdd(t *testing.T) {
+	s := NewIPSet()
+	if err := s.Add("10.0.0.7/24", "i-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("10.0.0.0/24", "i-b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("10.0.0.7", "i-c"); err == nil {
+		t.Error("Add of a malformed CIDR succeeded")
+	}
+	if want := (IPSet{"10.0.0.0/24": "i-b"}); !reflect.DeepEqual(s, want) {
+		t.Errorf("got %v, want %v", s, want)
+	}
+}
+
+func TestIPSetKeep(t *testing.T) {
+	s := NewIPSet()
+	s.Keep("10.0.0.7/24", "normalized")
+	s.Keep("10.0.0.7", "malformed")
+	if want := (IPSet{"10.0.0.0/24": "normalized", "10.0.0.7": "malformed"}); !reflect.DeepEqual(s, want) {
+		t.Errorf("got %v, want %v", s, want)
+	}
+	if !s.Contains("10.0.0.1/24") || !s.Contains("10.0.0.7") || s.Contains("10.0.1.0/24") {
+		t.Errorf("Contains of %v doesn't match the CIDRs whatever their notation", s)
+	}
+}
+
+func TestIPSetCovers(t *testing.T) {
+	s := IPSet{"10.0.0.0/24": "", "203.0.113.10/32": ""}
+	tests := map[string]bool{
+		"10.0.0.5/32":     true,
+		"203.0.113.10/32": true,
+		"203.0.113.11/32": false,
+		"10.0.0.0/16":     false,
+		"malformed":       false,
+	}
+	for host, want := range tests {
+		if got := s.Covers(host); got != want {
+			t.Errorf("Covers(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestIPSetListings(t *testing.T) {
+	s := IPSet{"203.0.113.11/32": "i-b", "203.0.113.10/32": "i-a"}
+	other := IPSet{"203.0.113.11/32": "i-c", "203.0.113.12/32": "i-d"}
+	if got, want := s.CIDRs(), []string{"203.0.113.10/32", "203.0.113.11/32"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CIDRs() = %v, want %v", got, want)
+	}
+	if got, want := s.Diff(other), []string{"203.0.113.10/32"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+	want := IPSet{"203.0.113.10/32": "i-a", "203.0.113.11/32": "i-c", "203.0.113.12/32": "i-d"}
+	if got := s.Union(other); !reflect.DeepEqual(got, want) {
+		t.Errorf("Union() = %v, want %v", got, want)
+	}
+}
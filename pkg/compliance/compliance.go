@@ -0,0 +1,72 @@
+package compliance
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/aws/aws-sdk-go/service/configservice/configserviceiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+)
+
+// ResourceType is the AWS Config resource type of the evaluated Security Groups
+const ResourceType = "AWS::EC2::SecurityGroup"
+
+// maxAnnotation is the longest annotation AWS Config accepts
+const maxAnnotation = 256
+
+// Evaluate tells whether the Security Group matches the AutoScaling Group's desired state once the sync is done. It is
+// NON_COMPLIANT while removals are parked or blocked or when a stage failure was tolerated, with the annotation
+// saying why.
+func Evaluate(result syncer.Result) (complianceType string, annotation string) {
+	var reasons []string
+	if len(result.PendingRemovals) != 0 {
+		reasons = append(reasons, fmt.Sprintf("%d removals pending approval", len(result.PendingRemovals)))
+	}
+	if len(result.BlockedRemovals) != 0 {
+		reasons = append(reasons, fmt.Sprintf("%d broad CIDRs blocked", len(result.BlockedRemovals)))
+	}
+	if len(result.Failures) != 0 {
+		reasons = append(reasons, fmt.Sprintf("%d stage failures", len(result.Failures)))
+	}
+	if len(reasons) == 0 {
+		return configservice.ComplianceTypeCompliant, "Rules match the AutoScaling Group's instances"
+	}
+	annotation = strings.Join(reasons, ", ")
+	if len(annotation) > maxAnnotation {
+		annotation = annotation[:maxAnnotation]
+	}
+	return configservice.ComplianceTypeNonCompliant, annotation
+}
+
+// Put submits the evaluation of the Security Group to the AWS Config rule that issued resultToken
+func Put(configSvc configserviceiface.ConfigServiceAPI, resultToken string, sgID string, complianceType string, annotation string, at time.Time) error {
+	_, err := configSvc.PutEvaluations(&configservice.PutEvaluationsInput{
+		ResultToken: aws.String(resultToken),
+		Evaluations: []*configservice.Evaluation{{
+			ComplianceResourceType: aws.String(ResourceType),
+			ComplianceResourceId:   aws.String(sgID),
+			ComplianceType:         aws.String(complianceType),
+			Annotation:             aws.String(annotation),
+			OrderingTimestamp:      aws.Time(at),
+		}},
+	})
+	return errs.Wrap(errs.Target, "put evaluations", err)
+}
+
+// PutNotApplicable marks the Security Group NOT_APPLICABLE, e.g. once it left the rule's scope
+func PutNotApplicable(configSvc configserviceiface.ConfigServiceAPI, resultToken string, sgID string, at time.Time) error {
+	_, err := configSvc.PutEvaluations(&configservice.PutEvaluationsInput{
+		ResultToken: aws.String(resultToken),
+		Evaluations: []*configservice.Evaluation{{
+			ComplianceResourceType: aws.String(ResourceType),
+			ComplianceResourceId:   aws.String(sgID),
+			ComplianceType:         aws.String(configservice.ComplianceTypeNotApplicable),
+			OrderingTimestamp:      aws.Time(at),
+		}},
+	})
+	return errs.Wrap(errs.Target, "put evaluations", err)
+}
@@ -0,0 +1,659 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/flags"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/maintenance"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/metrics"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/policy"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// Config holds the settings of the Lambda function, read from its environmental variables and the layers over them
+type Config struct {
+	// SecurityGroupID is the ID of the managed Security Group
+	SecurityGroupID string
+	// SecurityGroupIDs, when set, are all the Security Groups the lifecycle events sync, Concurrency of them at once
+	SecurityGroupIDs []string
+	Concurrency      int
+	// HandlerMode is the role of the function when one artifact serves several, see handler.Modes
+	HandlerMode string
+	// EndpointURL overrides the endpoint of every AWS service, e.g. for LocalStack
+	EndpointURL string
+	// ElasticBeanstalkEnvironment is the environment whose AutoScaling Group the manual, task and rule syncs default to
+	ElasticBeanstalkEnvironment string
+	// RemovalApprovalThreshold parks removals of more IPs than this until they get approved. 0 disables the gate.
+	RemovalApprovalThreshold int
+	// MaxRemovals caps the CIDRs a sync removes, the rest are removed by the next syncs. 0 disables the cap.
+	MaxRemovals int
+	// ApprovalTopicARN is the SNS topic that receives the approval requests
+	ApprovalTopicARN string
+	// ExpectedVpcID refuses to sync unless the Security Group belongs to this VPC
+	ExpectedVpcID string
+	// RequireSameVPC refuses to sync unless the Security Group belongs to the VPC of the AutoScaling Group's instances
+	RequireSameVPC bool
+	// AllowBroadRemovals lets the sync remove rules wider than /32 (IPv4) or /128 (IPv6). Off by default.
+	AllowBroadRemovals bool
+	// RuleCIDRMask is the mask the instances' IPv4 CIDRs are widened to, e.g. 24 for the /24 containing each IP. 32 by
+	// default, the instances' own IPs.
+	RuleCIDRMask int
+	// AggregateCIDRs merges the contiguous IPs of the instances into the smallest covering CIDRs. Off by default.
+	AggregateCIDRs bool
+	// CollectOrphans removes, on reconcile runs, the managed rules whose instances no longer exist
+	CollectOrphans bool
+	// MaxRuleAge removes the managed rules that are not desired anymore and are older than this. 0 disables it.
+	MaxRuleAge time.Duration
+	// RemovalCooldown keeps the IP of a terminating instance until its termination is older than this
+	RemovalCooldown time.Duration
+	// RespectScaleInProtection keeps the IP of a terminating instance while it is protected from scale in
+	RespectScaleInProtection bool
+	// RemovalDelayQueueURL is the SQS queue of the delayed removals. When set, terminating instances' IPs are removed by
+	// a later invocation instead of immediately.
+	RemovalDelayQueueURL string
+	// RemovalDelay is how long the removals are delayed. SQS caps it at 15 minutes.
+	RemovalDelay time.Duration
+	// DryRun makes the lifecycle events and the scheduled reconciles calculate the changes without applying them
+	DryRun bool
+	// AuditOnly makes the lifecycle events calculate and report the changes without applying them, and leave the
+	// lifecycle action to whatever else completes it, e.g. while the function shadows another one
+	AuditOnly bool
+	// AsyncApply completes the lifecycle action right away and applies the changes in an asynchronous invocation
+	AsyncApply bool
+	// FunctionName is the name of the function itself, re-invoked by AsyncApply
+	FunctionName string
+	// FailureLifecycleResult is the result sent to the AutoScaling Group when the sync fails. Defaults to ABANDON.
+	FailureLifecycleResult string
+	// LaunchFailureLifecycleResult and TerminateFailureLifecycleResult override FailureLifecycleResult for the
+	// launching and the terminating instances, when set
+	LaunchFailureLifecycleResult    string
+	TerminateFailureLifecycleResult string
+	// RetryBudget is the total number of retries the AWS calls of a lifecycle event, or of any other invocation, can
+	// make. Once it is spent, a lifecycle event gives up with FailureLifecycleResult. 0 leaves the retries to the SDK.
+	RetryBudget int
+	// BlueGreen applies the lifecycle events' changes to a copy of the Security Group and swaps it in on the network
+	// interfaces, instead of changing the active group. The active group is tracked in SecurityGroupParameter.
+	BlueGreen bool
+	// ReferenceSourceGroup authorizes the instances' security group as the rules' source instead of their IPs
+	ReferenceSourceGroup bool
+	// SourceSecurityGroupID is the security group referenced by ReferenceSourceGroup. Required by it, the group
+	// the instances happen to share may let in other workloads too.
+	SourceSecurityGroupID string
+	// ReturnRules are the rules also managed on the instances' own security group, so that the protected endpoint can
+	// reach them back. Empty disables them.
+	ReturnRules []target.Rule
+	// ReturnSecurityGroupID is the instances' security group of the ReturnRules. Required by them.
+	ReturnSecurityGroupID string
+	// ReturnSourceCIDRs are the protected endpoint's IPs the ReturnRules let in. When empty, the synced Security Group
+	// is referenced instead.
+	ReturnSourceCIDRs []string
+	// DeniedCIDRs are never authorized, e.g. the ranges of shared NAT gateways: the instances whose IPs they contain
+	// are skipped
+	DeniedCIDRs []string
+	// TargetGroupARNs are the ALB/NLB target groups the instances are registered with on launch and deregistered from
+	// on termination
+	TargetGroupARNs []string
+	// TargetGroupPort is the port of the registered targets. 0 uses the target groups' port.
+	TargetGroupPort int64
+	// TargetGroupOnly only updates the target groups, the Security Group is left untouched
+	TargetGroupOnly bool
+	// HealthChecks creates a Route 53 health check for every added IP and deletes it when the IP is removed
+	HealthChecks bool
+	// HealthCheckType is the type of the health checks, TCP, HTTP or HTTPS. Defaults to TCP.
+	HealthCheckType string
+	// HealthCheckPort is the port the health checks probe. Defaults to 443.
+	HealthCheckPort int64
+	// HealthCheckPath is the resource path of the HTTP(S) health checks
+	HealthCheckPath string
+	// MetricsNamespace is the CloudWatch namespace of the SyncSucceeded and SyncFailed metrics. Empty disables them.
+	MetricsNamespace string
+	// PrometheusPushgatewayURL and PrometheusRemoteWriteURL are the Prometheus backends the invocation metrics are
+	// pushed to, as the PrometheusJob job, along with or instead of CloudWatch
+	PrometheusPushgatewayURL string
+	PrometheusRemoteWriteURL string
+	PrometheusJob            string
+	// DogStatsDAddr is the DogStatsD server the invocation metrics are sent to, e.g. the Datadog Lambda extension's
+	// localhost:8125. DatadogAPIKey submits them to the API of DatadogSite instead.
+	DogStatsDAddr string
+	DatadogAPIKey string
+	DatadogSite   string
+	// AuditKinesisStream and AuditFirehoseStream are the Kinesis Data Stream and the Firehose delivery stream every
+	// change of the rules is streamed to, as an audit.Record
+	AuditKinesisStream  string
+	AuditFirehoseStream string
+	// RulesQuota is the quota of inbound rules per security group. When set, the syncs add no more rules than it leaves
+	// room for. 0 looks it up in Service Quotas, for the metrics alone.
+	RulesQuota int
+	// PublicIPWait is how long a launch event waits for the instance's public IP before syncing. 0 disables the wait.
+	PublicIPWait time.Duration
+	// StatusCheckWait is how long a launch event waits for the instance to pass both EC2 status checks before syncing.
+	// The launch fails, and its IP is never authorized, when they don't pass in time. 0 disables the gate.
+	StatusCheckWait time.Duration
+	// ENIDeviceIndex is the device index of the network interface whose address is synced. Negative uses the
+	// instance's public IP.
+	ENIDeviceIndex int64
+	// IPDiscovery is how the instances' addresses are described, source.DiscoveryInstances or
+	// source.DiscoveryInterfaces
+	IPDiscovery string
+	// PrefixDelegation is how the IPv4 prefixes delegated to the instances' network interfaces are synced,
+	// source.PrefixesOff, source.PrefixesAdd or source.PrefixesReplace
+	PrefixDelegation string
+	// StateTable is the DynamoDB table that records which CIDRs the sync owns. Empty relies on the rules' descriptions
+	// alone.
+	StateTable string
+	// DriftAttribution looks up who made the external changes in CloudTrail
+	DriftAttribution bool
+	// MutationRate is how many authorize and revoke calls per second a container makes on every Security Group, with
+	// bursts of MutationBurst calls. 0 disables the limit.
+	MutationRate  float64
+	MutationBurst int
+	// SecurityGroupCacheTTL is how long warm containers reuse a described Security Group
+	SecurityGroupCacheTTL time.Duration
+	// OwnershipNamespace is recorded in the rules the deployment creates, and the deployment only manages the rules of
+	// its namespace, so that several deployments can share a Security Group
+	OwnershipNamespace string
+	// Rules are the protocols and ports of the managed rules. Defaults to tcp 443.
+	Rules []target.Rule
+	// InstancePorts adds the extra ports that instances declare with their target.ExtraPortsTag
+	InstancePorts bool
+	// RulesFromTags reads the rules of every Security Group from its target.PortsTag, when it has one
+	RulesFromTags bool
+	// StagePolicy decides which stage failures abandon the lifecycle action and which ones only raise an alert
+	StagePolicy policy.Policy
+	// ApplyOrder is whether the sync adds before removing (default) or the other way round
+	ApplyOrder policy.Order
+	// AlertTopicARN is the SNS topic that receives the alerts of the tolerated stage failures
+	AlertTopicARN string
+	// SlackWebhookURL, PagerDutyRoutingKey and WebhookURL are the notification channels that get the alerts and the
+	// approval requests, on top of their SNS topics
+	SlackWebhookURL     string
+	PagerDutyRoutingKey string
+	WebhookURL          string
+	// CriticalSecurityGroups are the Security Groups whose sync failures escalate to Incident Manager, through the
+	// IncidentResponsePlanARN response plan
+	CriticalSecurityGroups  []string
+	IncidentResponsePlanARN string
+	// SentryDSNSecret is the Secrets Manager secret of the DSN of the Sentry project that gets the handlers' errors and
+	// panics, as SentryEnvironment
+	SentryDSNSecret   string
+	SentryEnvironment string
+	// LogSampling, LogRedactFields and LogRedactAccountIDs are the settings of the function's logger, see logging.Settings
+	LogSampling         string
+	LogRedactFields     string
+	LogRedactAccountIDs bool
+	// OpsItemThreshold is the number of consecutive failed syncs of a pair that opens an OpsItem. 0 disables them.
+	OpsItemThreshold int
+	// Pairs are the AutoScaling Groups and Security Groups covered by the scheduled modes, e.g. the compliance report
+	Pairs []Pair
+	// ReportBucket is the S3 bucket of the compliance reports, under ReportPrefix
+	ReportBucket string
+	ReportPrefix string
+	// PlanBucket is the S3 bucket of the plans of the plan mode, under PlanPrefix. A plan older than PlanMaxAge is no
+	// longer applied.
+	PlanBucket string
+	PlanPrefix string
+	PlanMaxAge time.Duration
+	// TeardownToken is the confirmation token the teardown requests must carry. The teardown mode refuses them all
+	// when it is empty.
+	TeardownToken string
+	// SecurityGroupParameter is the SSM parameter that holds the ID of the Security Group the function created, when
+	// SecurityGroupID isn't set, or of the active group of BlueGreen. Defaults to /sg-sync/<function name>/security-group-id.
+	SecurityGroupParameter string
+	// RecreateSecurityGroup recreates the Security Group after SecurityGroupName, SecurityGroupDescription,
+	// SecurityGroupVpcID and SecurityGroupTags when it was deleted
+	RecreateSecurityGroup    bool
+	SecurityGroupName        string
+	SecurityGroupDescription string
+	SecurityGroupVpcID       string
+	SecurityGroupTags        map[string]string
+	// FeatureFlags are the flags enabled for the whole function or for single AutoScaling Groups
+	FeatureFlags flags.Set
+	// MaintenanceWindows are the windows during which the lifecycle events and the scheduled reconciles only record the
+	// changes, DeferredSyncQueueURL is the SQS queue that applies them once the windows close
+	MaintenanceWindows   maintenance.Windows
+	DeferredSyncQueueURL string
+	// AccessWindows are the windows during which the managed rules of an AutoScaling Group exist. Outside of them, the
+	// syncs remove the group's managed rules. The groups without windows are always synced.
+	AccessWindows map[string]maintenance.Windows
+	// FeatureFlagsProfile is the AWS AppConfig feature flags profile whose flags are enabled along with FeatureFlags,
+	// read from the AppConfig Lambda extension
+	FeatureFlagsProfile string
+	// TenantTag is the tag of the AutoScaling Groups whose value names their tenant, e.g. team. The groups of a tenant
+	// in Tenants are synced with the tenant's block of configuration.
+	TenantTag string
+	Tenants   map[string]Tenant
+	// HookTargets are the settings of the lifecycle hooks by name, e.g. the Security Group and the ports of the service
+	// each hook of an AutoScaling Group protects. The hook's NotificationMetadata goes over them.
+	HookTargets map[string]event.HookSettings
+	// ConfigParameter and ConfigAppConfig are the SSM parameter and the AppConfig profile of the parameter layer, see
+	// Layer. They are only read from the environment.
+	ConfigParameter string
+	ConfigAppConfig string
+
+	// settings are the settings that aren't left to their defaults, with their sources
+	settings  map[string]Setting
+	layersErr error
+
+	stagePolicyErr  error
+	applyOrderErr   error
+	rulesErr        error
+	returnRulesErr  error
+	featureFlagsErr error
+	maintenanceErr  error
+	accessErr       error
+	tenantsErr      error
+	hookTargetsErr  error
+}
+
+// FromEnv reads the Config from the environmental variables
+func FromEnv() Config {
+	return Resolve()
+}
+
+// Reads the Config, every setting from its layer
+func (r *resolver) config() Config {
+	stagePolicy, stagePolicyErr := policy.Parse(r.getenv("stageFailurePolicy"))
+	applyOrder, applyOrderErr := policy.ParseOrder(r.getenv("applyOrder"))
+	featureFlags, featureFlagsErr := flags.Parse(r.getenv("featureFlags"))
+	windows, maintenanceErr := r.maintenanceWindowsEnv("maintenanceWindows", "maintenanceTimezone")
+	accessWindows, accessErr := r.accessWindowsEnv("accessWindows", "accessTimezone")
+	tenants, tenantsErr := tenantsEnv(r.getenv("tenants"))
+	hookTargets, hookTargetsErr := hookTargetsEnv(r.getenv("hookTargets"))
+	rules, rulesErr := []target.Rule{target.DefaultRule}, error(nil)
+	if spec := r.getenv("rules"); spec != "" {
+		rules, rulesErr = target.ParseRules(spec)
+	}
+	var returnRules []target.Rule
+	var returnRulesErr error
+	if spec := r.getenv("returnRules"); spec != "" {
+		returnRules, returnRulesErr = target.ParseRules(spec)
+	}
+	return Config{
+		SecurityGroupID:                 r.getenv("securityGroupID"),
+		ElasticBeanstalkEnvironment:     r.getenv("elasticBeanstalkEnvironment"),
+		EndpointURL:                     r.getenv("endpointURL"),
+		HandlerMode:                     os.Getenv("HANDLER_MODE"),
+		SecurityGroupIDs:                r.listEnv("securityGroupIDs"),
+		Concurrency:                     r.intEnv("concurrency", 0),
+		RemovalApprovalThreshold:        r.intEnv("removalApprovalThreshold", 0),
+		MaxRemovals:                     r.intEnv("maxRemovalsPerSync", 0),
+		ApprovalTopicARN:                r.getenv("approvalTopicARN"),
+		ExpectedVpcID:                   r.getenv("expectedVpcID"),
+		RequireSameVPC:                  r.boolEnv("requireSameVPC", false),
+		AllowBroadRemovals:              r.boolEnv("allowBroadRemovals", false),
+		AggregateCIDRs:                  r.boolEnv("aggregateCIDRs", false),
+		RuleCIDRMask:                    r.intEnv("ruleCidrMask", 32),
+		CollectOrphans:                  r.boolEnv("collectOrphans", false),
+		MaxRuleAge:                      time.Duration(r.intEnv("maxRuleAgeDays", 0)) * 24 * time.Hour,
+		RemovalCooldown:                 time.Duration(r.intEnv("removalCooldownSeconds", 0)) * time.Second,
+		RespectScaleInProtection:        r.boolEnv("respectScaleInProtection", false),
+		RemovalDelayQueueURL:            r.getenv("removalDelayQueueURL"),
+		RemovalDelay:                    time.Duration(r.intEnv("removalDelaySeconds", 300)) * time.Second,
+		DryRun:                          r.boolEnv("dryRun", false),
+		AuditOnly:                       r.boolEnv("auditOnly", false),
+		AsyncApply:                      r.boolEnv("asyncApply", false),
+		FunctionName:                    os.Getenv("AWS_LAMBDA_FUNCTION_NAME"),
+		FailureLifecycleResult:          r.stringEnv("failureLifecycleResult", "ABANDON"),
+		LaunchFailureLifecycleResult:    r.getenv("launchFailureLifecycleResult"),
+		TerminateFailureLifecycleResult: r.getenv("terminateFailureLifecycleResult"),
+		RetryBudget:                     r.intEnv("retryBudget", 0),
+		BlueGreen:                       r.boolEnv("blueGreen", false),
+		Rules:                           rules,
+		RulesFromTags:                   r.boolEnv("rulesFromTags", false),
+		InstancePorts:                   r.boolEnv("instancePortsFromTags", false),
+		ReferenceSourceGroup:            r.boolEnv("referenceSourceGroup", false),
+		TargetGroupARNs:                 r.listEnv("targetGroupARNs"),
+		MetricsNamespace:                r.getenv("metricsNamespace"),
+		PrometheusPushgatewayURL:        r.getenv("prometheusPushgatewayURL"),
+		PrometheusRemoteWriteURL:        r.getenv("prometheusRemoteWriteURL"),
+		PrometheusJob:                   r.stringEnv("prometheusJob", "sg-sync"),
+		DogStatsDAddr:                   r.getenv("dogstatsdAddr"),
+		DatadogAPIKey:                   r.getenv("datadogAPIKey"),
+		DatadogSite:                     r.stringEnv("datadogSite", metrics.DefaultDatadogSite),
+		AuditKinesisStream:              r.getenv("auditKinesisStream"),
+		AuditFirehoseStream:             r.getenv("auditFirehoseStream"),
+		StateTable:                      r.getenv("stateTable"),
+		DriftAttribution:                r.boolEnv("driftAttribution", false),
+		MutationRate:                    r.floatEnv("mutationRate", 0),
+		MutationBurst:                   r.intEnv("mutationBurst", 1),
+		SecurityGroupCacheTTL:           time.Duration(r.intEnv("securityGroupCacheTTLSeconds", 10)) * time.Second,
+		OwnershipNamespace:              r.getenv("ownershipNamespace"),
+		PublicIPWait:                    time.Duration(r.intEnv("publicIPWaitSeconds", 0)) * time.Second,
+		StatusCheckWait:                 time.Duration(r.intEnv("statusCheckWaitSeconds", 0)) * time.Second,
+		ENIDeviceIndex:                  int64(r.intEnv("eniDeviceIndex", -1)),
+		IPDiscovery:                     r.stringEnv("ipDiscovery", source.DiscoveryInstances),
+		PrefixDelegation:                r.stringEnv("prefixDelegation", source.PrefixesOff),
+		RulesQuota:                      r.intEnv("rulesQuota", 0),
+		HealthChecks:                    r.boolEnv("healthChecks", false),
+		HealthCheckType:                 r.stringEnv("healthCheckType", "TCP"),
+		HealthCheckPort:                 int64(r.intEnv("healthCheckPort", target.HTTPSPort)),
+		HealthCheckPath:                 r.getenv("healthCheckPath"),
+		TargetGroupPort:                 int64(r.intEnv("targetGroupPort", 0)),
+		TargetGroupOnly:                 r.boolEnv("targetGroupOnly", false),
+		SourceSecurityGroupID:           r.getenv("sourceSecurityGroupID"),
+		ReturnRules:                     returnRules,
+		ReturnSecurityGroupID:           r.getenv("returnSecurityGroupID"),
+		ReturnSourceCIDRs:               r.listEnv("returnSourceCIDRs"),
+		DeniedCIDRs:                     r.listEnv("deniedCIDRs"),
+		StagePolicy:                     stagePolicy,
+		AlertTopicARN:                   r.getenv("alertTopicARN"),
+		SlackWebhookURL:                 r.getenv("slackWebhookURL"),
+		PagerDutyRoutingKey:             r.getenv("pagerDutyRoutingKey"),
+		WebhookURL:                      r.getenv("webhookURL"),
+		CriticalSecurityGroups:          r.listEnv("criticalSecurityGroups"),
+		IncidentResponsePlanARN:         r.getenv("incidentResponsePlanARN"),
+		OpsItemThreshold:                r.intEnv("opsItemThreshold", 0),
+		SentryDSNSecret:                 r.getenv("sentryDSNSecretARN"),
+		SentryEnvironment:               r.getenv("sentryEnvironment"),
+		LogSampling:                     r.getenv("logSampling"),
+		LogRedactFields:                 r.getenv("logRedactFields"),
+		LogRedactAccountIDs:             r.boolEnv("logRedactAccountIDs", false),
+		Pairs:                           r.pairsEnv("pairs", r.getenv("securityGroupID")),
+		ReportBucket:                    r.getenv("reportBucket"),
+		ReportPrefix:                    r.stringEnv("reportPrefix", "sg-sync-reports/"),
+		PlanBucket:                      r.getenv("planBucket"),
+		PlanPrefix:                      r.stringEnv("planPrefix", "sg-sync-plans/"),
+		PlanMaxAge:                      time.Duration(r.intEnv("planMaxAgeMinutes", 60)) * time.Minute,
+		TeardownToken:                   r.getenv("teardownConfirmationToken"),
+		FeatureFlags:                    featureFlags,
+		RecreateSecurityGroup:           r.boolEnv("recreateSecurityGroup", false),
+		SecurityGroupParameter:          r.stringEnv("securityGroupParameter", "/sg-sync/"+os.Getenv("AWS_LAMBDA_FUNCTION_NAME")+"/security-group-id"),
+		SecurityGroupName:               r.getenv("securityGroupName"),
+		SecurityGroupDescription:        r.stringEnv("securityGroupDescription", "Public IPs of the AutoScaling Group, managed by sg-sync"),
+		SecurityGroupVpcID:              r.stringEnv("securityGroupVpcID", r.getenv("expectedVpcID")),
+		SecurityGroupTags:               r.tagsEnv("securityGroupTags"),
+		FeatureFlagsProfile:             r.getenv("featureFlagsAppConfig"),
+		MaintenanceWindows:              windows,
+		DeferredSyncQueueURL:            r.stringEnv("deferredSyncQueueURL", r.getenv("removalDelayQueueURL")),
+		maintenanceErr:                  maintenanceErr,
+		AccessWindows:                   accessWindows,
+		accessErr:                       accessErr,
+		featureFlagsErr:                 featureFlagsErr,
+		stagePolicyErr:                  stagePolicyErr,
+		ApplyOrder:                      applyOrder,
+		applyOrderErr:                   applyOrderErr,
+		rulesErr:                        rulesErr,
+		returnRulesErr:                  returnRulesErr,
+		TenantTag:                       r.getenv("tenantTag"),
+		HookTargets:                     hookTargets,
+		hookTargetsErr:                  hookTargetsErr,
+		ConfigParameter:                 os.Getenv("configParameter"),
+		ConfigAppConfig:                 os.Getenv("configAppConfig"),
+		Tenants:                         tenants,
+		tenantsErr:                      tenantsErr,
+	}
+}
+
+// NotificationChannels gets the targets of the configured notification channels, keyed by the channels' names: the
+// webhooks' URLs and PagerDuty's routing key. See notify.Channel.
+func (c Config) NotificationChannels() map[string]string {
+	channels := make(map[string]string)
+	for name, target := range map[string]string{"slack": c.SlackWebhookURL, "pagerduty": c.PagerDutyRoutingKey, "webhook": c.WebhookURL} {
+		if target != "" {
+			channels[name] = target
+		}
+	}
+	return channels
+}
+
+// CreatesSecurityGroup returns true when the function creates and adopts its own Security Group: no Security Group is
+// configured, but the spec of one is
+func (c Config) CreatesSecurityGroup() bool {
+	return c.SecurityGroupID == "" && len(c.SecurityGroupIDs) == 0 && c.SecurityGroupName != "" && c.SecurityGroupVpcID != ""
+}
+
+// DefaultAutoScalingGroup gets the AutoScaling Group name that the requests without one default to: the alias of the
+// configured Elastic Beanstalk environment, if any
+func (c Config) DefaultAutoScalingGroup() string {
+	if c.ElasticBeanstalkEnvironment == "" {
+		return ""
+	}
+	return source.EnvironmentPrefix + c.ElasticBeanstalkEnvironment
+}
+
+// GroupSpec gets the spec of the Security Group the sync creates
+func (c Config) GroupSpec() target.GroupSpec {
+	return target.GroupSpec{Name: c.SecurityGroupName, Description: c.SecurityGroupDescription, VpcID: c.SecurityGroupVpcID, Tags: c.SecurityGroupTags}
+}
+
+// FailureResult gets the result sent to the AutoScaling Group when the sync of a launching, or terminating,
+// instance fails
+func (c Config) FailureResult(terminating bool) string {
+	if terminating {
+		return orDefault(c.TerminateFailureLifecycleResult, c.FailureLifecycleResult)
+	}
+	return orDefault(c.LaunchFailureLifecycleResult, c.FailureLifecycleResult)
+}
+
+// Critical returns true when the Security Group is flagged as critical
+func (c Config) Critical(sgID string) bool {
+	for _, critical := range c.CriticalSecurityGroups {
+		if critical == sgID {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessClosed checks whether the AutoScaling Group has access windows and none of them is open at now
+func (c Config) AccessClosed(asgName string, now time.Time) bool {
+	windows, ok := c.AccessWindows[asgName]
+	if !ok {
+		return false
+	}
+	_, open := windows.Active(now)
+	return !open
+}
+
+// Validate checks the settings that can be checked without calling AWS
+func (c Config) Validate() error {
+	if c.layersErr != nil {
+		return errs.Errorf(errs.Config, "validate config", "%w", c.layersErr)
+	}
+	if c.TargetGroupOnly && len(c.TargetGroupARNs) == 0 {
+		return errs.Errorf(errs.Config, "validate config", "targetGroupOnly needs targetGroupARNs")
+	}
+	if c.SecurityGroupID != "" && !target.ValidID(c.SecurityGroupID) {
+		return errs.Errorf(errs.Config, "validate config", "securityGroupID %q is not a valid security group ID", c.SecurityGroupID)
+	}
+	for _, sgID := range c.SecurityGroupIDs {
+		if !target.ValidID(sgID) {
+			return errs.Errorf(errs.Config, "validate config", "securityGroupIDs: %q is not a valid security group ID", sgID)
+		}
+	}
+	// The flag may be scoped to some AutoScaling Groups, which need the function's name all the same
+	if (c.AsyncApply || c.FeatureFlags.Has(flags.AsyncApply)) && c.FunctionName == "" {
+		return errs.Errorf(errs.Config, "validate config", "asyncApply needs AWS_LAMBDA_FUNCTION_NAME")
+	}
+	if c.FailureLifecycleResult != "ABANDON" && c.FailureLifecycleResult != "CONTINUE" {
+		return errs.Errorf(errs.Config, "validate config", "failureLifecycleResult must be ABANDON or CONTINUE, got %q", c.FailureLifecycleResult)
+	}
+	for name, result := range map[string]string{"launchFailureLifecycleResult": c.LaunchFailureLifecycleResult, "terminateFailureLifecycleResult": c.TerminateFailureLifecycleResult} {
+		if result != "" && result != "ABANDON" && result != "CONTINUE" {
+			return errs.Errorf(errs.Config, "validate config", "%s must be ABANDON or CONTINUE, got %q", name, result)
+		}
+	}
+	if c.IPDiscovery != source.DiscoveryInstances && c.IPDiscovery != source.DiscoveryInterfaces {
+		return errs.Errorf(errs.Config, "validate config", "ipDiscovery must be %s or %s, got %q", source.DiscoveryInstances, source.DiscoveryInterfaces, c.IPDiscovery)
+	}
+	if c.RuleCIDRMask < 16 || c.RuleCIDRMask > 32 {
+		// A mistyped mask can't open the Security Group to more than a /16 per instance
+		return errs.Errorf(errs.Config, "validate config", "ruleCidrMask must be between 16 and 32, got %d", c.RuleCIDRMask)
+	}
+	switch c.PrefixDelegation {
+	case source.PrefixesOff, source.PrefixesAdd, source.PrefixesReplace:
+	default:
+		return errs.Errorf(errs.Config, "validate config", "prefixDelegation must be %s, %s or %s, got %q", source.PrefixesOff, source.PrefixesAdd, source.PrefixesReplace, c.PrefixDelegation)
+	}
+	if c.IPDiscovery == source.DiscoveryInterfaces && c.InstancePorts {
+		// The network interfaces don't carry the instances' tags, the declared ports would all be removed
+		return errs.Errorf(errs.Config, "validate config", "ipDiscovery=%s doesn't support instancePortsFromTags", source.DiscoveryInterfaces)
+	}
+	if c.OwnershipNamespace != "" && !target.ValidNamespace(c.OwnershipNamespace) {
+		return errs.Errorf(errs.Config, "validate config", "ownershipNamespace %q must be at most 32 letters, digits, dots, dashes and underscores", c.OwnershipNamespace)
+	}
+	if c.RetryBudget < 0 {
+		return errs.Errorf(errs.Config, "validate config", "retryBudget must be 0 or more, got %d", c.RetryBudget)
+	}
+	if c.MaxRemovals < 0 {
+		return errs.Errorf(errs.Config, "validate config", "maxRemovalsPerSync must be 0 or more, got %d", c.MaxRemovals)
+	}
+	if c.BlueGreen && (c.SecurityGroupID == "" || len(c.SecurityGroupIDs) != 0 || c.StateTable != "" || c.ReferenceSourceGroup || c.TenantTag != "") {
+		return errs.Errorf(errs.Config, "validate config", "blueGreen needs securityGroupID and doesn't support securityGroupIDs, stateTable, referenceSourceGroup or tenantTag")
+	}
+	if c.BlueGreen && c.HandlerMode == "batch" {
+		// The coalesced reconciles of the batches sync the active Security Group in place, without a swap
+		return errs.Errorf(errs.Config, "validate config", "blueGreen doesn't support the batch handler")
+	}
+	if c.ReferenceSourceGroup && c.SourceSecurityGroupID == "" {
+		return errs.Errorf(errs.Config, "validate config", "referenceSourceGroup needs sourceSecurityGroupID")
+	}
+	if c.SourceSecurityGroupID != "" && !target.ValidID(c.SourceSecurityGroupID) {
+		return errs.Errorf(errs.Config, "validate config", "sourceSecurityGroupID %q is not a valid security group ID", c.SourceSecurityGroupID)
+	}
+	if c.HealthChecks && c.HealthCheckType != "TCP" && c.HealthCheckType != "HTTP" && c.HealthCheckType != "HTTPS" {
+		return errs.Errorf(errs.Config, "validate config", "healthCheckType must be TCP, HTTP or HTTPS, got %q", c.HealthCheckType)
+	}
+	for _, pair := range c.Pairs {
+		if pair.AutoScalingGroupName == "" || !target.ValidID(pair.SecurityGroupID) {
+			return errs.Errorf(errs.Config, "validate config", "pairs: %q=%q is not a valid AutoScaling Group and security group ID", pair.AutoScalingGroupName, pair.SecurityGroupID)
+		}
+	}
+	if c.rulesErr != nil {
+		return errs.Wrap(errs.Config, "validate config", fmt.Errorf("rules: %w", c.rulesErr))
+	}
+	if c.returnRulesErr != nil {
+		return errs.Wrap(errs.Config, "validate config", fmt.Errorf("returnRules: %w", c.returnRulesErr))
+	}
+	if len(c.ReturnRules) != 0 && c.ReturnSecurityGroupID == "" {
+		return errs.Errorf(errs.Config, "validate config", "returnRules need returnSecurityGroupID")
+	}
+	if c.ReturnSecurityGroupID != "" && !target.ValidID(c.ReturnSecurityGroupID) {
+		return errs.Errorf(errs.Config, "validate config", "returnSecurityGroupID %q is not a valid security group ID", c.ReturnSecurityGroupID)
+	}
+	for _, sgID := range append([]string{c.SecurityGroupID}, c.SecurityGroupIDs...) {
+		if c.ReturnSecurityGroupID != "" && sgID == c.ReturnSecurityGroupID {
+			return errs.Errorf(errs.Config, "validate config", "returnSecurityGroupID %q is synced by the function", c.ReturnSecurityGroupID)
+		}
+	}
+	for _, spec := range c.ReturnSourceCIDRs {
+		if _, err := cidr.Normalize(spec); err != nil {
+			return errs.Wrap(errs.Config, "validate config", fmt.Errorf("returnSourceCIDRs: %w", err))
+		}
+	}
+	for _, spec := range c.DeniedCIDRs {
+		if _, err := cidr.Normalize(spec); err != nil {
+			return errs.Wrap(errs.Config, "validate config", fmt.Errorf("deniedCIDRs: %w", err))
+		}
+	}
+	if c.tenantsErr != nil {
+		return errs.Wrap(errs.Config, "validate config", fmt.Errorf("tenants: %w", c.tenantsErr))
+	}
+	if len(c.Tenants) != 0 && c.TenantTag == "" {
+		return errs.Errorf(errs.Config, "validate config", "tenants needs tenantTag")
+	}
+	if c.RecreateSecurityGroup && (c.SecurityGroupName == "" || c.SecurityGroupVpcID == "") {
+		return errs.Errorf(errs.Config, "validate config", "recreateSecurityGroup needs securityGroupName and securityGroupVpcID")
+	}
+	if c.maintenanceErr != nil {
+		return errs.Wrap(errs.Config, "validate config", fmt.Errorf("maintenanceWindows: %w", c.maintenanceErr))
+	}
+	if c.accessErr != nil {
+		return errs.Wrap(errs.Config, "validate config", fmt.Errorf("accessWindows: %w", c.accessErr))
+	}
+	if c.featureFlagsErr != nil {
+		return errs.Wrap(errs.Config, "validate config", fmt.Errorf("featureFlags: %w", c.featureFlagsErr))
+	}
+	if c.applyOrderErr != nil {
+		return errs.Wrap(errs.Config, "validate config", fmt.Errorf("applyOrder: %w", c.applyOrderErr))
+	}
+	if c.hookTargetsErr != nil {
+		return errs.Errorf(errs.Config, "validate config", "hookTargets: %w", c.hookTargetsErr)
+	}
+	if c.stagePolicyErr != nil {
+		return errs.Wrap(errs.Config, "validate config", fmt.Errorf("stageFailurePolicy: %w", c.stagePolicyErr))
+	}
+	return nil
+}
+
+// Reads an integer setting, falling back to def when it is missing or malformed
+func (r *resolver) intEnv(key string, def int) int {
+	v, err := strconv.Atoi(r.getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// Reads a float setting, falling back to def when it is missing or malformed
+func (r *resolver) floatEnv(key string, def float64) float64 {
+	v, err := strconv.ParseFloat(r.getenv(key), 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// Reads a string setting, falling back to def when it is missing
+func (r *resolver) stringEnv(key string, def string) string {
+	if v := r.getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// Reads a comma separated list setting, skipping the empty entries
+func (r *resolver) listEnv(key string) []string {
+	var list []string
+	for _, v := range strings.Split(r.getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Reads a comma separated list of key=value tags
+func (r *resolver) tagsEnv(key string) map[string]string {
+	tags := make(map[string]string)
+	for _, entry := range r.listEnv(key) {
+		k, v, _ := strings.Cut(entry, "=")
+		tags[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return tags
+}
+
+// Reads the maintenance windows and the time zone they are evaluated in, UTC by default
+func (r *resolver) maintenanceWindowsEnv(key string, timezoneKey string) (maintenance.Windows, error) {
+	location, err := time.LoadLocation(r.stringEnv(timezoneKey, "UTC"))
+	if err != nil {
+		return nil, err
+	}
+	return maintenance.ParseWindows(r.getenv(key), location)
+}
+
+// Reads the access windows of the AutoScaling Groups and the time zone they are evaluated in, UTC by default
+func (r *resolver) accessWindowsEnv(key string, timezoneKey string) (map[string]maintenance.Windows, error) {
+	location, err := time.LoadLocation(r.stringEnv(timezoneKey, "UTC"))
+	if err != nil {
+		return nil, err
+	}
+	return maintenance.ParseGroupWindows(r.getenv(key), location)
+}
+
+// Reads a boolean setting, falling back to def when it is missing or malformed
+func (r *resolver) boolEnv(key string, def bool) bool {
+	v, err := strconv.ParseBool(r.getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+)
+
+// Reads the hooks' settings of a JSON object of lifecycle hook names and their settings, e.g.
+// {"payments-launching":{"sgID":"sg-0123456789abcdef0","port":8443},"search-launching":{"rules":[{"proto":"tcp","ports":[9200]}]}}
+func hookTargetsEnv(spec string) (map[string]event.HookSettings, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var hooks map[string]event.HookSettings
+	if err := json.Unmarshal([]byte(spec), &hooks); err != nil {
+		return nil, err
+	}
+	for name, settings := range hooks {
+		if name == "" {
+			return nil, fmt.Errorf("a hook has no name")
+		}
+		if err := settings.Validate("hook " + name); err != nil {
+			return nil, err
+		}
+	}
+	return hooks, nil
+}
+
+// HookSettings gets the settings of the lifecycle hook: the ones of hookTargets, with the metadata's over them
+func (c Config) HookSettings(hookName string, metadata event.HookSettings) event.HookSettings {
+	return metadata.Over(c.HookTargets[hookName])
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/policy"
+)
+
+// Source is a layer of the configuration. Every source takes precedence over the ones before it: the defaults, the
+// environmental variables, the SSM parameter or AppConfig profile, the hook's NotificationMetadata and the event.
+type Source string
+
+// Sources of the configuration, from the lowest precedence to the highest
+const (
+	SourceDefault   Source = "default"
+	SourceEnv       Source = "env"
+	SourceParameter Source = "parameter"
+	SourceHook      Source = "hook"
+	SourceEvent     Source = "event"
+)
+
+// precedence ranks the sources of the layers
+var precedence = map[Source]int{SourceParameter: 0, SourceHook: 1, SourceEvent: 2}
+
+// overridable are the settings the hooks and the events may set, and how they are set over the config: the ones that
+// tune a single sync, rather than where it reads and writes, which clients the function builds or how far its
+// removals may go
+var overridable = map[string]func(c *Config, r *resolver){
+	"dryRun": func(c *Config, r *resolver) { c.DryRun = r.boolEnv("dryRun", c.DryRun) },
+	"respectScaleInProtection": func(c *Config, r *resolver) {
+		c.RespectScaleInProtection = r.boolEnv("respectScaleInProtection", c.RespectScaleInProtection)
+	},
+	"aggregateCIDRs": func(c *Config, r *resolver) { c.AggregateCIDRs = r.boolEnv("aggregateCIDRs", c.AggregateCIDRs) },
+	"collectOrphans": func(c *Config, r *resolver) { c.CollectOrphans = r.boolEnv("collectOrphans", c.CollectOrphans) },
+	"failureLifecycleResult": func(c *Config, r *resolver) {
+		c.FailureLifecycleResult = r.stringEnv("failureLifecycleResult", c.FailureLifecycleResult)
+	},
+	"launchFailureLifecycleResult": func(c *Config, r *resolver) {
+		c.LaunchFailureLifecycleResult = r.stringEnv("launchFailureLifecycleResult", c.LaunchFailureLifecycleResult)
+	},
+	"terminateFailureLifecycleResult": func(c *Config, r *resolver) {
+		c.TerminateFailureLifecycleResult = r.stringEnv("terminateFailureLifecycleResult", c.TerminateFailureLifecycleResult)
+	},
+	"publicIPWaitSeconds": func(c *Config, r *resolver) {
+		c.PublicIPWait = time.Duration(r.intEnv("publicIPWaitSeconds", int(c.PublicIPWait/time.Second))) * time.Second
+	},
+	"statusCheckWaitSeconds": func(c *Config, r *resolver) {
+		c.StatusCheckWait = time.Duration(r.intEnv("statusCheckWaitSeconds", int(c.StatusCheckWait/time.Second))) * time.Second
+	},
+	"removalCooldownSeconds": func(c *Config, r *resolver) {
+		c.RemovalCooldown = time.Duration(r.intEnv("removalCooldownSeconds", int(c.RemovalCooldown/time.Second))) * time.Second
+	},
+	"stageFailurePolicy": func(c *Config, r *resolver) {
+		c.StagePolicy, c.stagePolicyErr = policy.Parse(r.getenv("stageFailurePolicy"))
+	},
+	"applyOrder": func(c *Config, r *resolver) {
+		c.ApplyOrder, c.applyOrderErr = policy.ParseOrder(r.getenv("applyOrder"))
+	},
+}
+
+// secrets are the settings whose values are never logged
+var secrets = map[string]bool{
+	"datadogAPIKey":             true,
+	"pagerDutyRoutingKey":       true,
+	"slackWebhookURL":           true,
+	"webhookURL":                true,
+	"teardownConfirmationToken": true,
+	"tenants":                   true,
+}
+
+// Layer is a set of settings over the environmental variables, keyed by the variables' names. Err is the error of
+// reading the layer, which fails the config's validation.
+type Layer struct {
+	Source Source
+	Values map[string]string
+	Err    error
+}
+
+// ParseLayer reads the layer of a JSON object of settings, e.g. {"dryRun":true,"publicIPWaitSeconds":30}. The strings
+// are taken as they are, the other values as their JSON, e.g. the rules' matrix.
+func ParseLayer(source Source, data []byte) Layer {
+	layer := Layer{Source: source, Values: make(map[string]string)}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		layer.Err = fmt.Errorf("%s layer: %w", source, err)
+		return layer
+	}
+	for key, raw := range values {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			layer.Values[key] = s
+			continue
+		}
+		layer.Values[key] = string(raw)
+	}
+	return layer
+}
+
+// Setting is the effective value of a setting and the source it came from
+type Setting struct {
+	Value  string `json:"value"`
+	Source Source `json:"source"`
+}
+
+// resolver reads the settings from the layers, the highest first, then from the environmental variables, and records
+// where every one came from
+type resolver struct {
+	layers   []Layer
+	settings map[string]Setting
+	read     map[string]bool
+}
+
+// Builds the resolver of the layers, ordered by precedence
+func newResolver(layers []Layer) *resolver {
+	ordered := append([]Layer(nil), layers...)
+	sort.SliceStable(ordered, func(i, j int) bool { return precedence[ordered[i].Source] < precedence[ordered[j].Source] })
+	return &resolver{layers: ordered, settings: make(map[string]Setting), read: make(map[string]bool)}
+}
+
+// Gets the setting, empty when no layer nor the environment sets it
+func (r *resolver) getenv(key string) string {
+	r.read[key] = true
+	for i := len(r.layers) - 1; i >= 0; i-- {
+		if value, ok := r.layers[i].Values[key]; ok {
+			r.settings[key] = Setting{Value: value, Source: r.layers[i].Source}
+			return value
+		}
+	}
+	value := os.Getenv(key)
+	if value != "" {
+		r.settings[key] = Setting{Value: value, Source: SourceEnv}
+	}
+	return value
+}
+
+// Checks the layers: they were read, they only set known settings and the hooks' and events' only set the
+// overridable ones
+func (r *resolver) err() error {
+	for _, layer := range r.layers {
+		if layer.Err != nil {
+			return layer.Err
+		}
+		for key := range layer.Values {
+			if _, ok := overridable[key]; !ok && (layer.Source == SourceHook || layer.Source == SourceEvent) {
+				return fmt.Errorf("%s layer: %q can't be set per %s", layer.Source, key, layer.Source)
+			}
+			if !r.read[key] {
+				return fmt.Errorf("%s layer: unknown setting %q", layer.Source, key)
+			}
+		}
+	}
+	return nil
+}
+
+// Resolve reads the Config from the layers over the environmental variables, each setting from the layer of the
+// highest precedence that sets it
+func Resolve(layers ...Layer) Config {
+	r := newResolver(layers)
+	c := r.config()
+	c.settings, c.layersErr = r.settings, r.err()
+	return c
+}
+
+// Override sets the settings of the layers, e.g. the hook's and the event's, over the config. Only the overridable
+// settings may be set, the config is otherwise left as it is.
+func (c Config) Override(layers ...Layer) Config {
+	r := newResolver(layers)
+	for _, layer := range r.layers {
+		for key := range layer.Values {
+			if set, ok := overridable[key]; ok && !r.read[key] {
+				set(&c, r)
+			}
+		}
+	}
+	settings := make(map[string]Setting, len(c.settings)+len(r.settings))
+	for key, setting := range c.settings {
+		settings[key] = setting
+	}
+	for key, setting := range r.settings {
+		settings[key] = setting
+	}
+	c.settings = settings
+	if err := r.err(); err != nil && c.layersErr == nil {
+		c.layersErr = err
+	}
+	return c
+}
+
+// Effective gets the settings that aren't left to their defaults, with their sources. The secrets are redacted.
+func (c Config) Effective() map[string]Setting {
+	effective := make(map[string]Setting, len(c.settings))
+	for key, setting := range c.settings {
+		if secrets[key] {
+			setting.Value = "[redacted]"
+		}
+		effective[key] = setting
+	}
+	return effective
+}
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"strings"
+)
+
+// Pair is an AutoScaling Group and the Security Group it syncs
+type Pair struct {
+	AutoScalingGroupName string `json:"asgName"`
+	SecurityGroupID      string `json:"sgID"`
+}
+
+// Reads the pairs of a comma separated list of asgName=sgID entries. Entries without a Security Group sync the
+// default one.
+func (r *resolver) pairsEnv(key string, defaultSgID string) []Pair {
+	var pairs []Pair
+	for _, entry := range r.listEnv(key) {
+		asgName, sgID, found := strings.Cut(entry, "=")
+		if !found {
+			sgID = defaultSgID
+		}
+		pairs = append(pairs, Pair{AutoScalingGroupName: strings.TrimSpace(asgName), SecurityGroupID: strings.TrimSpace(sgID)})
+	}
+	return pairs
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// Tenant is the block of configuration of the AutoScaling Groups tagged with the tenant's name. The empty fields keep
+// the function's configuration.
+type Tenant struct {
+	SecurityGroupID     string           `json:"sgID,omitempty"`
+	RuleSets            []target.RuleSet `json:"rules,omitempty"`
+	AlertTopicARN       string           `json:"alertTopicARN,omitempty"`
+	ApprovalTopicARN    string           `json:"approvalTopicARN,omitempty"`
+	SlackWebhookURL     string           `json:"slackWebhookURL,omitempty"`
+	PagerDutyRoutingKey string           `json:"pagerDutyRoutingKey,omitempty"`
+	WebhookURL          string           `json:"webhookURL,omitempty"`
+	// Rules are the expanded RuleSets
+	Rules []target.Rule `json:"-"`
+}
+
+// Reads the tenants of a JSON object of tenant names and their blocks, e.g.
+// {"payments":{"sgID":"sg-0123456789abcdef0","rules":[{"proto":"tcp","ports":[443]}],"slackWebhookURL":"..."}}
+func tenantsEnv(spec string) (map[string]Tenant, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var tenants map[string]Tenant
+	if err := json.Unmarshal([]byte(spec), &tenants); err != nil {
+		return nil, err
+	}
+	for name, tenant := range tenants {
+		if tenant.SecurityGroupID != "" && !target.ValidID(tenant.SecurityGroupID) {
+			return nil, fmt.Errorf("tenant %q: %q is not a valid security group ID", name, tenant.SecurityGroupID)
+		}
+		if len(tenant.RuleSets) != 0 {
+			rules, err := target.ExpandRules(tenant.RuleSets)
+			if err != nil {
+				return nil, fmt.Errorf("tenant %q: %w", name, err)
+			}
+			tenant.Rules = rules
+		}
+		tenants[name] = tenant
+	}
+	return tenants, nil
+}
+
+// ForTenant returns the configuration of the tenant's AutoScaling Groups: the function's, with the tenant's block over
+// it. A tenant with its own Security Group syncs that one alone. ok is false when the tenant has no block.
+func (c Config) ForTenant(name string) (cfg Config, ok bool) {
+	tenant, ok := c.Tenants[name]
+	if !ok {
+		return c, false
+	}
+	if tenant.SecurityGroupID != "" {
+		c.SecurityGroupID, c.SecurityGroupIDs = tenant.SecurityGroupID, nil
+	}
+	if len(tenant.Rules) != 0 {
+		c.Rules, c.RulesFromTags = tenant.Rules, false
+	}
+	c.AlertTopicARN = orDefault(tenant.AlertTopicARN, c.AlertTopicARN)
+	c.ApprovalTopicARN = orDefault(tenant.ApprovalTopicARN, c.ApprovalTopicARN)
+	c.SlackWebhookURL = orDefault(tenant.SlackWebhookURL, c.SlackWebhookURL)
+	c.PagerDutyRoutingKey = orDefault(tenant.PagerDutyRoutingKey, c.PagerDutyRoutingKey)
+	c.WebhookURL = orDefault(tenant.WebhookURL, c.WebhookURL)
+	return c, true
+}
+
+// TenantTopics checks whether a tenant publishes to an alert or approval topic of its own, for which the function needs
+// the SNS client even without topics of its own
+func (c Config) TenantTopics() bool {
+	for _, tenant := range c.Tenants {
+		if tenant.AlertTopicARN != "" || tenant.ApprovalTopicARN != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Gets the value, or def when it is empty
+func orDefault(value string, def string) string {
+	if value != "" {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,72 @@
+package diff
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"sort"
+)
+
+// An aligned block of IPv4 addresses
+type block struct {
+	addr   uint32
+	prefix int
+}
+
+func (b block) size() uint64 { return 1 << uint(32-b.prefix) }
+
+// Checks whether next is the upper half of the block that b is the lower half of
+func (b block) sibling(next block) bool {
+	return b.prefix == next.prefix && b.prefix > 0 && uint64(b.addr)%(2*b.size()) == 0 &&
+		uint64(next.addr) == uint64(b.addr)+b.size()
+}
+
+func (b block) String() string {
+	var ip [4]byte
+	binary.BigEndian.PutUint32(ip[:], b.addr)
+	return netip.PrefixFrom(netip.AddrFrom4(ip), b.prefix).String()
+}
+
+// Aggregate merges the contiguous IPv4 CIDRs into the smallest set of CIDRs that covers exactly the same addresses,
+// e.g. 10.0.0.0/32 and 10.0.0.1/32 into 10.0.0.0/31. IPv6 and unparseable CIDRs are returned as they are.
+func Aggregate(cidrs []string) []string {
+	var blocks []block
+	var others []string
+	seen := make(map[block]struct{})
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil || !prefix.Addr().Is4() {
+			others = append(others, cidr)
+			continue
+		}
+		ip := prefix.Masked().Addr().As4()
+		b := block{addr: binary.BigEndian.Uint32(ip[:]), prefix: prefix.Bits()}
+		if _, ok := seen[b]; !ok {
+			seen[b] = struct{}{}
+			blocks = append(blocks, b)
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		if blocks[i].addr != blocks[j].addr {
+			return blocks[i].addr < blocks[j].addr
+		}
+		return blocks[i].prefix < blocks[j].prefix
+	})
+
+	// Sorted by address, a block either lies within the last merged block or starts after it
+	var merged []block
+	for _, b := range blocks {
+		if n := len(merged); n != 0 && uint64(b.addr) < uint64(merged[n-1].addr)+merged[n-1].size() {
+			continue
+		}
+		merged = append(merged, b)
+		for n := len(merged); n >= 2 && merged[n-2].sibling(merged[n-1]); n = len(merged) {
+			merged = append(merged[:n-2], block{addr: merged[n-2].addr, prefix: merged[n-2].prefix - 1})
+		}
+	}
+
+	aggregated := make([]string, 0, len(merged)+len(others))
+	for _, b := range merged {
+		aggregated = append(aggregated, b.String())
+	}
+	return append(aggregated, others...)
+}
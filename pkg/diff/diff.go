@@ -0,0 +1,15 @@
+package diff
+
+import "github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+
+// IPsToAdd calculates which AutoScaling Group IPs cannot be found in the Security Group IPs. These ones will be added to SG.
+// They are sorted.
+func IPsToAdd(asgIPs cidr.IPSet, sgIPs cidr.IPSet) []string {
+	return asgIPs.Diff(sgIPs)
+}
+
+// IPsToRemove calculates which Security Group IPs cannot be found in the AutoScaling Group IPs. These ones will be removed from SG.
+// They are sorted.
+func IPsToRemove(sgIPs cidr.IPSet, asgIPs cidr.IPSet) []string {
+	return sgIPs.Diff(asgIPs)
+}
@@ -0,0 +1,41 @@
+package diff_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/diff"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/fakeaws"
+)
+
+// The sizes of the benchmarked fleets
+var fleets = []int{1000, 10000}
+
+// The percentage of the fleet replaced between the Security Group and the AutoScaling Group
+const churn = 10
+
+func BenchmarkDiff(b *testing.B) {
+	for _, n := range fleets {
+		asgIPs, sgIPs := fakeaws.FleetIPs(n, n*churn/100)
+		b.Run(fmt.Sprintf("instances=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				diff.IPsToAdd(asgIPs, sgIPs)
+				diff.IPsToRemove(sgIPs, asgIPs)
+			}
+		})
+	}
+}
+
+func BenchmarkAggregate(b *testing.B) {
+	for _, n := range fleets {
+		asgIPs, _ := fakeaws.FleetIPs(n, 0)
+		cidrs := asgIPs.CIDRs()
+		b.Run(fmt.Sprintf("instances=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				diff.Aggregate(cidrs)
+			}
+		})
+	}
+}
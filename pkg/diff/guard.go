@@ -0,0 +1,17 @@
+package diff
+
+import "github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+
+// GuardRemovals splits the CIDRs to remove into the ones that are safe to revoke and the blocked ones.
+// Only CIDRs as narrow as the rules the sync creates (/32 for IPv4, /128 for IPv6) are safe, so that a buggy
+// diff can never revoke broad access such as 0.0.0.0/0 or ::/0. Unparseable CIDRs are blocked too.
+func GuardRemovals(cidrs []string) (allowed []string, blocked []string) {
+	for _, c := range cidrs {
+		if cidr.IsHost(c) {
+			allowed = append(allowed, c)
+		} else {
+			blocked = append(blocked, c)
+		}
+	}
+	return allowed, blocked
+}
@@ -0,0 +1,83 @@
+package errs
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Category classifies a failure so that callers can branch on it instead of matching error strings
+type Category string
+
+const (
+	// Config is a missing or invalid setting
+	Config Category = "ConfigError"
+	// Source is a failure while collecting the desired IPs (AutoScaling Group, instances)
+	Source Category = "SourceError"
+	// Target is a failure while reading or updating the Security Group
+	Target Category = "TargetError"
+	// Lifecycle is a failure while completing the lifecycle action
+	Lifecycle Category = "LifecycleError"
+	// Throttle is an AWS throttling or transient error, of any stage, that is safe to retry
+	Throttle Category = "ThrottleError"
+	// Unknown is any error that hasn't been categorized
+	Unknown Category = "UnknownError"
+)
+
+// Error is a categorized error. Op describes the operation that failed.
+type Error struct {
+	Category Category
+	Op       string
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.Category, e.Op, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Wrap categorizes err. AWS throttling and transient errors are always categorized as Throttle.
+// Errors that are already categorized keep their category. Returns nil when err is nil.
+func Wrap(category Category, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	if request.IsErrorThrottle(err) || request.IsErrorRetryable(err) {
+		category = Throttle
+	}
+	return &Error{Category: category, Op: op, Err: err}
+}
+
+// Errorf creates a new categorized error with a formatted message
+func Errorf(category Category, op string, format string, args ...interface{}) error {
+	return &Error{Category: category, Op: op, Err: fmt.Errorf(format, args...)}
+}
+
+// CategoryOf returns the category of err, or Unknown when it isn't categorized
+func CategoryOf(err error) Category {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Category
+	}
+	return Unknown
+}
+
+// OpOf returns the operation that failed, or an empty string when err isn't categorized
+func OpOf(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Op
+	}
+	return ""
+}
+
+// Is reports whether err belongs to the category
+func Is(err error, category Category) bool {
+	return err != nil && CategoryOf(err) == category
+}
@@ -0,0 +1,207 @@
+package event
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// IncomingEvent is the event that CloudWatch triggers
+type IncomingEvent struct {
+	Version    string    `json:"version"`
+	ID         string    `json:"id"`
+	DetailType string    `json:"detail-type"`
+	Source     string    `json:"source"`
+	AccountID  string    `json:"account"`
+	Region     string    `json:"region"`
+	Resources  []string  `json:"resources"`
+	Detail     Detail    `json:"detail"`
+	Time       time.Time `json:"time"`
+	// ReplayName is set by EventBridge on the events replayed from an archive
+	ReplayName string `json:"replay-name,omitempty"`
+	// AsyncApply marks an event re-invoked by the function itself to apply the changes after the lifecycle action has
+	// already been completed
+	AsyncApply bool `json:"sgSyncAsyncApply,omitempty"`
+	// Event is TestNotification on the test message the AutoScaling Group sends to a hook's notification target
+	Event string `json:"Event,omitempty"`
+	// ManualInvocation is set instead of the rest on a console or CLI test invocation
+	ManualInvocation
+	// Config is the event's layer of settings, over the hook's, e.g. {"dryRun":true}
+	Config json.RawMessage `json:"sgSyncConfig,omitempty"`
+}
+
+// Detail contain the details of the EC2 lifecycle hook
+type Detail struct {
+	LifecycleHookName    string `json:"LifecycleHookName"`
+	AutoScalingGroupName string `json:"AutoScalingGroupName"`
+	LifecycleActionToken string `json:"LifecycleActionToken"`
+	LifecycleTransition  string `json:"LifecycleTransition"`
+	EC2InstanceID        string `json:"EC2InstanceId"`
+	// NotificationMetadata is the hook's metadata, read as its HookSettings
+	NotificationMetadata string `json:"NotificationMetadata,omitempty"`
+}
+
+// ModeIP authorizes the instances' public IPs
+const ModeIP = "ip"
+
+// ModeReference authorizes the instances' security group as the source of the rules
+const ModeReference = "reference"
+
+// HookSettings are the per-hook overrides of the configuration, set as JSON in the hook's NotificationMetadata, e.g.
+// {"sgID":"sg-0123456789abcdef0","rules":[{"proto":"tcp","ports":[443]}],"mode":"ip"}
+type HookSettings struct {
+	SecurityGroupID string           `json:"sgID,omitempty"`
+	Port            int64            `json:"port,omitempty"`
+	Rules           []target.RuleSet `json:"rules,omitempty"`
+	Mode            string           `json:"mode,omitempty"`
+	// Config is the hook's layer of settings, e.g. {"failureLifecycleResult":"CONTINUE"}
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// Settings parses the hook's NotificationMetadata. Metadata that is not a JSON object, e.g. free text, carries no
+// settings.
+func (d Detail) Settings() (settings HookSettings, err error) {
+	metadata := strings.TrimSpace(d.NotificationMetadata)
+	if len(metadata) > maxMetadataLength {
+		return settings, errs.Errorf(errs.Config, "parse notification metadata", "the metadata is longer than %d bytes", maxMetadataLength)
+	}
+	if !strings.HasPrefix(metadata, "{") {
+		return settings, nil
+	}
+	if err := json.Unmarshal([]byte(metadata), &settings); err != nil {
+		return settings, errs.Wrap(errs.Config, "parse notification metadata", err)
+	}
+	return settings, settings.Validate("parse notification metadata")
+}
+
+// Validate checks the settings, failing as the op
+func (s HookSettings) Validate(op string) error {
+	if s.SecurityGroupID != "" && !target.ValidID(s.SecurityGroupID) {
+		return errs.Errorf(errs.Config, op, "%q is not a valid security group ID", s.SecurityGroupID)
+	}
+	if s.Mode != "" && s.Mode != ModeIP && s.Mode != ModeReference {
+		return errs.Errorf(errs.Config, op, "unknown mode %q", s.Mode)
+	}
+	if s.Port < 0 || s.Port > 65535 {
+		return errs.Errorf(errs.Config, op, "invalid port %d", s.Port)
+	}
+	if len(s.Rules) != 0 {
+		if _, err := target.ExpandRules(s.Rules); err != nil {
+			return errs.Wrap(errs.Config, op, err)
+		}
+	}
+	return nil
+}
+
+// Over gets the settings with the ones s sets over base's
+func (s HookSettings) Over(base HookSettings) HookSettings {
+	if s.SecurityGroupID != "" {
+		base.SecurityGroupID = s.SecurityGroupID
+	}
+	if s.Port != 0 {
+		base.Port = s.Port
+	}
+	if len(s.Rules) != 0 {
+		base.Rules = s.Rules
+	}
+	if s.Mode != "" {
+		base.Mode = s.Mode
+	}
+	if len(s.Config) != 0 {
+		base.Config = s.Config
+	}
+	return base
+}
+
+// TransitionLaunching is the lifecycle transition of an instance that is being launched
+const TransitionLaunching = "autoscaling:EC2_INSTANCE_LAUNCHING"
+
+// TransitionTerminating is the lifecycle transition of an instance that is being terminated
+const TransitionTerminating = "autoscaling:EC2_INSTANCE_TERMINATING"
+
+// IsTerminating returns true when the event was triggered by an instance that is being terminated
+func (d Detail) IsTerminating() bool {
+	return d.LifecycleTransition == TransitionTerminating
+}
+
+// maxMetadataLength is the longest NotificationMetadata a lifecycle hook takes
+const maxMetadataLength = 1023
+
+// maxNameLength is the longest AutoScaling Group and lifecycle hook name
+const maxNameLength = 255
+
+var (
+	instanceIDPattern = regexp.MustCompile(`^i-([0-9a-f]{8}|[0-9a-f]{17})$`)
+	hookNamePattern   = regexp.MustCompile(`^[A-Za-z0-9_/-]+$`)
+	tokenPattern      = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	regionPattern     = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+)
+
+// Validate checks that the event carries everything needed to sync and complete the lifecycle action, well formed.
+// The event is reachable by anyone who can put events on the bus, so every field the sync and the AWS calls use is
+// checked rather than trusted.
+func (e IncomingEvent) Validate() error {
+	d := e.Detail
+	if d.AutoScalingGroupName == "" || d.EC2InstanceID == "" || d.LifecycleHookName == "" {
+		return errs.Errorf(errs.Config, "validate event", "AutoScalingGroupName, EC2InstanceId and LifecycleHookName are required")
+	}
+	if d.LifecycleTransition != TransitionLaunching && d.LifecycleTransition != TransitionTerminating {
+		return errs.Errorf(errs.Config, "validate event", "unknown lifecycle transition %q", d.LifecycleTransition)
+	}
+	if !validName(d.AutoScalingGroupName) {
+		return errs.Errorf(errs.Config, "validate event", "%q is not a valid AutoScaling Group name", d.AutoScalingGroupName)
+	}
+	if !instanceIDPattern.MatchString(d.EC2InstanceID) {
+		return errs.Errorf(errs.Config, "validate event", "%q is not a valid instance ID", d.EC2InstanceID)
+	}
+	if len(d.LifecycleHookName) > maxNameLength || !hookNamePattern.MatchString(d.LifecycleHookName) {
+		return errs.Errorf(errs.Config, "validate event", "%q is not a valid lifecycle hook name", d.LifecycleHookName)
+	}
+	if d.LifecycleActionToken != "" && !tokenPattern.MatchString(d.LifecycleActionToken) {
+		return errs.Errorf(errs.Config, "validate event", "%q is not a valid lifecycle action token", d.LifecycleActionToken)
+	}
+	if !ValidRegion(e.Region) {
+		return errs.Errorf(errs.Config, "validate event", "%q is not a valid region", e.Region)
+	}
+	return nil
+}
+
+// ValidRegion checks whether the region is empty, for the function's own, or looks like an AWS region. The clients
+// are built for the event's region, so a malformed one must never reach the endpoints.
+func ValidRegion(region string) bool {
+	return region == "" || regionPattern.MatchString(region)
+}
+
+// Checks whether the AutoScaling Group name is at most maxNameLength characters, of valid UTF-8 without control
+// characters
+func validName(name string) bool {
+	if !utf8.ValidString(name) || utf8.RuneCountInString(name) > maxNameLength {
+		return false
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// TestNotification is the Event of the AutoScaling Group's test message
+const TestNotification = "autoscaling:TEST_NOTIFICATION"
+
+// IsTestNotification returns true when the event is the AutoScaling Group's test message, which carries no lifecycle
+// action
+func (e IncomingEvent) IsTestNotification() bool {
+	return e.Event == TestNotification
+}
+
+// IsReplay returns true when the event was replayed from an EventBridge archive
+func (e IncomingEvent) IsReplay() bool {
+	return e.ReplayName != ""
+}
@@ -0,0 +1,50 @@
+package event_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+)
+
+// goldenEvents are the fixtures of the golden events, the seeds of the fuzzing
+const goldenEvents = "../handler/testdata/events/*.json"
+
+// Parses and validates the mutations of the golden events. No mutation may panic, and the notification metadata of
+// an accepted event may only carry a valid port:
+//
+//	go test -fuzz FuzzParseEvent ./pkg/event
+func FuzzParseEvent(f *testing.F) {
+	paths, err := filepath.Glob(goldenEvents)
+	if err != nil || len(paths) == 0 {
+		f.Fatalf("no golden events in %s: %v", goldenEvents, err)
+	}
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(raw)
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		var request event.IncomingEvent
+		if json.Unmarshal(input, &request) != nil {
+			return
+		}
+		request.IsTestNotification()
+		request.IsReplay()
+		err := request.Validate()
+		if request.IsManual() {
+			err = request.ManualInvocation.Validate()
+		}
+		if err != nil {
+			return
+		}
+		if settings, err := request.Detail.Settings(); err == nil && (settings.Port < 0 || settings.Port > 65535) {
+			t.Errorf("Settings accepted the port %d of %q", settings.Port, input)
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package event
+
+import (
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// ManualInvocation is the minimal payload of a console or CLI test invocation, e.g.
+// {"asgName":"web-asg","securityGroupId":"sg-0123456789abcdef0","dryRun":true}
+type ManualInvocation struct {
+	AutoScalingGroupName string `json:"asgName,omitempty"`
+	// SecurityGroupID defaults to the configured one
+	SecurityGroupID string `json:"securityGroupId,omitempty"`
+	DryRun          bool   `json:"dryRun,omitempty"`
+}
+
+// IsManual returns true when the event is a manual invocation's payload: it has some of its fields, and neither the
+// envelope nor the detail of an EventBridge event
+func (e IncomingEvent) IsManual() bool {
+	return e.ManualInvocation != ManualInvocation{} && e.DetailType == "" && e.Source == "" && e.Detail == Detail{}
+}
+
+// Validate checks the manual invocation's AutoScaling Group and Security Group, as the events' are checked
+func (m ManualInvocation) Validate() error {
+	if m.AutoScalingGroupName == "" {
+		return errs.Errorf(errs.Config, "validate manual invocation", "asgName is required")
+	}
+	if !validName(m.AutoScalingGroupName) {
+		return errs.Errorf(errs.Config, "validate manual invocation", "%q is not a valid AutoScaling Group name", m.AutoScalingGroupName)
+	}
+	if m.SecurityGroupID != "" && !target.ValidID(m.SecurityGroupID) {
+		return errs.Errorf(errs.Config, "validate manual invocation", "%q is not a valid security group ID", m.SecurityGroupID)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package fakeaws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+)
+
+// AutoScaling is the fake AutoScaling client of a fleet
+type AutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+	rec    *recorder
+	groups map[string]*autoscaling.Group
+}
+
+// DescribeAutoScalingGroups describes the groups of the fleet among the requested ones
+func (c *AutoScaling) DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	c.rec.record("DescribeAutoScalingGroups", input)
+	out := &autoscaling.DescribeAutoScalingGroupsOutput{}
+	for _, name := range input.AutoScalingGroupNames {
+		if group, ok := c.groups[aws.StringValue(name)]; ok {
+			out.AutoScalingGroups = append(out.AutoScalingGroups, group)
+		}
+	}
+	return out, nil
+}
+
+// CompleteLifecycleAction records the call
+func (c *AutoScaling) CompleteLifecycleAction(input *autoscaling.CompleteLifecycleActionInput) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	c.rec.record("CompleteLifecycleAction", input)
+	return &autoscaling.CompleteLifecycleActionOutput{}, nil
+}
+
+// RecordLifecycleActionHeartbeat records the call
+func (c *AutoScaling) RecordLifecycleActionHeartbeat(input *autoscaling.RecordLifecycleActionHeartbeatInput) (*autoscaling.RecordLifecycleActionHeartbeatOutput, error) {
+	c.rec.record("RecordLifecycleActionHeartbeat", input)
+	return &autoscaling.RecordLifecycleActionHeartbeatOutput{}, nil
+}
+
+// DescribeLifecycleHooks describes the requested hooks with the default heartbeat timeout of an hour, whatever their
+// group
+func (c *AutoScaling) DescribeLifecycleHooks(input *autoscaling.DescribeLifecycleHooksInput) (*autoscaling.DescribeLifecycleHooksOutput, error) {
+	c.rec.record("DescribeLifecycleHooks", input)
+	out := &autoscaling.DescribeLifecycleHooksOutput{}
+	for _, name := range input.LifecycleHookNames {
+		out.LifecycleHooks = append(out.LifecycleHooks, &autoscaling.LifecycleHook{
+			AutoScalingGroupName: input.AutoScalingGroupName,
+			LifecycleHookName:    name,
+			HeartbeatTimeout:     aws.Int64(3600),
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,252 @@
+package fakeaws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// EC2 is the fake EC2 client of a fleet. The Security Groups' rules change with the authorize and revoke calls, as
+// EC2's would.
+type EC2 struct {
+	ec2iface.EC2API
+	rec       *recorder
+	instances map[string]*ec2.Instance
+	groups    map[string]*ec2.SecurityGroup
+	// elasticIPs are the instances' Elastic IPs, by instance ID
+	elasticIPs map[string]string
+}
+
+// Permissions gets the current ingress rules of the Security Group
+func (c *EC2) Permissions(sgID string) []*ec2.IpPermission {
+	if group, ok := c.groups[sgID]; ok {
+		return group.IpPermissions
+	}
+	return nil
+}
+
+// Adds the Security Group with the rules. The rules are copied, so that the fleet can be reused, and merged by
+// protocol and ports as EC2 does.
+func (c *EC2) addGroup(sgID string, perms []*ec2.IpPermission) {
+	group := &ec2.SecurityGroup{GroupId: aws.String(sgID)}
+	c.authorize(group, perms)
+	c.groups[sgID] = group
+}
+
+// DescribeInstances describes the requested instances of the fleet
+func (c *EC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	c.rec.record("DescribeInstances", input)
+	return c.describeInstances(input), nil
+}
+
+// DescribeInstancesPages describes the requested instances of the fleet in a single page
+func (c *EC2) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	c.rec.record("DescribeInstancesPages", input)
+	fn(c.describeInstances(input), true)
+	return nil
+}
+
+// Describes the requested instances of the fleet, the unknown ones are left out
+func (c *EC2) describeInstances(input *ec2.DescribeInstancesInput) *ec2.DescribeInstancesOutput {
+	rsv := &ec2.Reservation{}
+	for _, id := range input.InstanceIds {
+		if inst, ok := c.instances[aws.StringValue(id)]; ok {
+			rsv.Instances = append(rsv.Instances, awsutil.CopyOf(inst).(*ec2.Instance))
+		}
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{rsv}}
+}
+
+// DescribeAddresses describes the Elastic IPs of the instances of the instance-id filter
+func (c *EC2) DescribeAddresses(input *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	c.rec.record("DescribeAddresses", input)
+	out := &ec2.DescribeAddressesOutput{}
+	for _, filter := range input.Filters {
+		if aws.StringValue(filter.Name) != "instance-id" {
+			continue
+		}
+		for _, id := range filter.Values {
+			if ip, ok := c.elasticIPs[aws.StringValue(id)]; ok {
+				out.Addresses = append(out.Addresses, &ec2.Address{InstanceId: id, PublicIp: aws.String(ip)})
+			}
+		}
+	}
+	return out, nil
+}
+
+// DescribeSecurityGroups describes the requested Security Groups, failing as EC2 does on an unknown one
+func (c *EC2) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	c.rec.record("DescribeSecurityGroups", input)
+	out := &ec2.DescribeSecurityGroupsOutput{}
+	for _, id := range input.GroupIds {
+		group, ok := c.groups[aws.StringValue(id)]
+		if !ok {
+			return nil, awserr.New("InvalidGroup.NotFound", "The security group '"+aws.StringValue(id)+"' does not exist", nil)
+		}
+		out.SecurityGroups = append(out.SecurityGroups, awsutil.CopyOf(group).(*ec2.SecurityGroup))
+	}
+	return out, nil
+}
+
+// AuthorizeSecurityGroupIngress adds the rules, failing as EC2 does on a duplicate one
+func (c *EC2) AuthorizeSecurityGroupIngress(input *ec2.AuthorizeSecurityGroupIngressInput) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	c.rec.record("AuthorizeSecurityGroupIngress", input)
+	group, ok := c.groups[aws.StringValue(input.GroupId)]
+	if !ok {
+		return nil, awserr.New("InvalidGroup.NotFound", "The security group '"+aws.StringValue(input.GroupId)+"' does not exist", nil)
+	}
+	if err := c.authorize(group, input.IpPermissions); err != nil {
+		return nil, err
+	}
+	return &ec2.AuthorizeSecurityGroupIngressOutput{Return: aws.Bool(true)}, nil
+}
+
+// Adds the permissions to the group, merged into its permissions of the same protocol and ports
+func (c *EC2) authorize(group *ec2.SecurityGroup, perms []*ec2.IpPermission) error {
+	for _, perm := range perms {
+		existing := c.permission(group, perm, true)
+		for _, ipRange := range perm.IpRanges {
+			for _, r := range existing.IpRanges {
+				if aws.StringValue(r.CidrIp) == aws.StringValue(ipRange.CidrIp) {
+					return awserr.New("InvalidPermission.Duplicate", "the specified rule already exists", nil)
+				}
+			}
+			existing.IpRanges = append(existing.IpRanges, awsutil.CopyOf(ipRange).(*ec2.IpRange))
+		}
+		for _, pair := range perm.UserIdGroupPairs {
+			existing.UserIdGroupPairs = append(existing.UserIdGroupPairs, awsutil.CopyOf(pair).(*ec2.UserIdGroupPair))
+		}
+	}
+	return nil
+}
+
+// RevokeSecurityGroupIngress removes the rules, failing as EC2 does on a missing one
+func (c *EC2) RevokeSecurityGroupIngress(input *ec2.RevokeSecurityGroupIngressInput) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+	c.rec.record("RevokeSecurityGroupIngress", input)
+	group, ok := c.groups[aws.StringValue(input.GroupId)]
+	if !ok {
+		return nil, awserr.New("InvalidGroup.NotFound", "The security group '"+aws.StringValue(input.GroupId)+"' does not exist", nil)
+	}
+	for _, perm := range input.IpPermissions {
+		existing := c.permission(group, perm, false)
+		if existing == nil {
+			return nil, awserr.New("InvalidPermission.NotFound", "the specified rule does not exist", nil)
+		}
+		for _, ipRange := range perm.IpRanges {
+			kept := existing.IpRanges[:0]
+			for _, r := range existing.IpRanges {
+				if aws.StringValue(r.CidrIp) != aws.StringValue(ipRange.CidrIp) {
+					kept = append(kept, r)
+				}
+			}
+			if len(kept) == len(existing.IpRanges) {
+				return nil, awserr.New("InvalidPermission.NotFound", "the specified rule does not exist", nil)
+			}
+			existing.IpRanges = kept
+		}
+		for _, pair := range perm.UserIdGroupPairs {
+			kept := existing.UserIdGroupPairs[:0]
+			for _, p := range existing.UserIdGroupPairs {
+				if aws.StringValue(p.GroupId) != aws.StringValue(pair.GroupId) {
+					kept = append(kept, p)
+				}
+			}
+			existing.UserIdGroupPairs = kept
+		}
+	}
+	return &ec2.RevokeSecurityGroupIngressOutput{Return: aws.Bool(true)}, nil
+}
+
+// Gets the group's permission of the same protocol and ports as perm, created when missing and create is set
+func (c *EC2) permission(group *ec2.SecurityGroup, perm *ec2.IpPermission, create bool) *ec2.IpPermission {
+	for _, existing := range group.IpPermissions {
+		if aws.StringValue(existing.IpProtocol) == aws.StringValue(perm.IpProtocol) &&
+			aws.Int64Value(existing.FromPort) == aws.Int64Value(perm.FromPort) &&
+			aws.Int64Value(existing.ToPort) == aws.Int64Value(perm.ToPort) {
+			return existing
+		}
+	}
+	if !create {
+		return nil
+	}
+	existing := &ec2.IpPermission{IpProtocol: perm.IpProtocol, FromPort: perm.FromPort, ToPort: perm.ToPort}
+	group.IpPermissions = append(group.IpPermissions, existing)
+	return existing
+}
+
+// WaitUntilInstanceExistsWithContext succeeds when the fleet has the instances, the fleet never changes by itself
+func (c *EC2) WaitUntilInstanceExistsWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, opts ...request.WaiterOption) error {
+	c.rec.record("WaitUntilInstanceExists", input)
+	for _, id := range input.InstanceIds {
+		if _, ok := c.instances[aws.StringValue(id)]; !ok {
+			return awserr.New(request.WaiterResourceNotReadyErrorCode, "exceeded wait attempts", nil)
+		}
+	}
+	return nil
+}
+
+// WaitUntilInstanceRunningWithContext succeeds when the fleet's instances are running, the fleet never changes by
+// itself
+func (c *EC2) WaitUntilInstanceRunningWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, opts ...request.WaiterOption) error {
+	c.rec.record("WaitUntilInstanceRunning", input)
+	return c.waitRunning(input.InstanceIds)
+}
+
+// WaitUntilInstanceStatusOkWithContext succeeds when the fleet's instances are running, their status checks always
+// pass
+func (c *EC2) WaitUntilInstanceStatusOkWithContext(ctx aws.Context, input *ec2.DescribeInstanceStatusInput, opts ...request.WaiterOption) error {
+	c.rec.record("WaitUntilInstanceStatusOk", input)
+	return c.waitRunning(input.InstanceIds)
+}
+
+// WaitUntilSystemStatusOkWithContext succeeds when the fleet's instances are running, their status checks always pass
+func (c *EC2) WaitUntilSystemStatusOkWithContext(ctx aws.Context, input *ec2.DescribeInstanceStatusInput, opts ...request.WaiterOption) error {
+	c.rec.record("WaitUntilSystemStatusOk", input)
+	return c.waitRunning(input.InstanceIds)
+}
+
+// Fails as an exhausted waiter when any of the instances isn't part of the fleet or isn't running
+func (c *EC2) waitRunning(instanceIDs []*string) error {
+	for _, id := range instanceIDs {
+		inst, ok := c.instances[aws.StringValue(id)]
+		if !ok || aws.StringValue(inst.State.Name) != ec2.InstanceStateNameRunning {
+			return awserr.New(request.WaiterResourceNotReadyErrorCode, "exceeded wait attempts", nil)
+		}
+	}
+	return nil
+}
+
+// DescribeNetworkInterfacesPages describes, in a single page, the primary network interfaces of the fleet's instances
+// of the attachment.instance-id filter
+func (c *EC2) DescribeNetworkInterfacesPages(input *ec2.DescribeNetworkInterfacesInput, fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool) error {
+	c.rec.record("DescribeNetworkInterfacesPages", input)
+	out := &ec2.DescribeNetworkInterfacesOutput{}
+	for _, filter := range input.Filters {
+		if aws.StringValue(filter.Name) != "attachment.instance-id" {
+			continue
+		}
+		for _, id := range filter.Values {
+			inst, ok := c.instances[aws.StringValue(id)]
+			if !ok || aws.StringValue(inst.State.Name) == ec2.InstanceStateNameTerminated {
+				continue
+			}
+			eni := &ec2.NetworkInterface{
+				Attachment: &ec2.NetworkInterfaceAttachment{InstanceId: inst.InstanceId, DeviceIndex: aws.Int64(0), Status: aws.String(ec2.AttachmentStatusAttached)},
+			}
+			if inst.PublicIpAddress != nil {
+				eni.Association = &ec2.NetworkInterfaceAssociation{PublicIp: inst.PublicIpAddress}
+			}
+			for _, instanceENI := range inst.NetworkInterfaces {
+				for _, prefix := range instanceENI.Ipv4Prefixes {
+					eni.Ipv4Prefixes = append(eni.Ipv4Prefixes, &ec2.Ipv4PrefixSpecification{Ipv4Prefix: prefix.Ipv4Prefix})
+				}
+			}
+			out.NetworkInterfaces = append(out.NetworkInterfaces, eni)
+		}
+	}
+	fn(out, true)
+	return nil
+}
@@ -0,0 +1,129 @@
+// Package fakeaws holds in-memory EC2 and AutoScaling clients that record the calls made to them, so that the handlers
+// can be run against a fleet without AWS, e.g. by the golden events harness. The calls the fakes don't implement
+// panic, which the handlers report as a PanicError.
+package fakeaws
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+)
+
+// Call is a call made to a fake client
+type Call struct {
+	Operation string
+	Input     interface{}
+}
+
+// recorder records the calls of the fake clients of a fleet, in order
+type recorder struct {
+	mu    sync.Mutex
+	calls []Call
+}
+
+// Records the call
+func (r *recorder) record(operation string, input interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, Call{Operation: operation, Input: input})
+}
+
+// Instance is an instance of the fleet
+type Instance struct {
+	ID       string
+	PublicIP string
+	// ElasticIP is the Elastic IP associated with the instance that DescribeInstances doesn't reflect yet
+	ElasticIP string
+	// Prefixes are the IPv4 prefixes delegated to the instance's primary network interface
+	Prefixes []string
+	// State defaults to running
+	State string
+}
+
+// Fleet is an AutoScaling Group, its instances and the Security Group it is synced to
+type Fleet struct {
+	AutoScalingGroupName string
+	Instances            []Instance
+	SecurityGroupID      string
+	// Permissions are the ingress rules the Security Group starts with
+	Permissions []*ec2.IpPermission
+	// OtherGroups are the other Security Groups of the fleet's hooks, with the ingress rules they start with
+	OtherGroups map[string][]*ec2.IpPermission
+}
+
+// Env is the fake clients of a fleet
+type Env struct {
+	EC2         *EC2
+	AutoScaling *AutoScaling
+	rec         *recorder
+}
+
+// New builds the fake clients of the fleet
+func New(fleet Fleet) *Env {
+	rec := &recorder{}
+	env := &Env{
+		EC2:         &EC2{rec: rec, instances: make(map[string]*ec2.Instance), groups: make(map[string]*ec2.SecurityGroup), elasticIPs: make(map[string]string)},
+		AutoScaling: &AutoScaling{rec: rec, groups: make(map[string]*autoscaling.Group)},
+		rec:         rec,
+	}
+	group := &autoscaling.Group{AutoScalingGroupName: aws.String(fleet.AutoScalingGroupName)}
+	for _, instance := range fleet.Instances {
+		state := instance.State
+		if state == "" {
+			state = ec2.InstanceStateNameRunning
+		}
+		group.Instances = append(group.Instances, &autoscaling.Instance{InstanceId: aws.String(instance.ID), ProtectedFromScaleIn: aws.Bool(false)})
+		inst := &ec2.Instance{InstanceId: aws.String(instance.ID), State: &ec2.InstanceState{Name: aws.String(state)}}
+		if instance.PublicIP != "" {
+			inst.PublicIpAddress = aws.String(instance.PublicIP)
+		}
+		if len(instance.Prefixes) != 0 {
+			eni := &ec2.InstanceNetworkInterface{Attachment: &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)}}
+			for _, prefix := range instance.Prefixes {
+				eni.Ipv4Prefixes = append(eni.Ipv4Prefixes, &ec2.InstanceIpv4Prefix{Ipv4Prefix: aws.String(prefix)})
+			}
+			inst.NetworkInterfaces = []*ec2.InstanceNetworkInterface{eni}
+		}
+		env.EC2.instances[instance.ID] = inst
+		if instance.ElasticIP != "" {
+			env.EC2.elasticIPs[instance.ID] = instance.ElasticIP
+		}
+	}
+	if fleet.AutoScalingGroupName != "" {
+		env.AutoScaling.groups[fleet.AutoScalingGroupName] = group
+	}
+	if fleet.SecurityGroupID != "" {
+		env.EC2.addGroup(fleet.SecurityGroupID, fleet.Permissions)
+	}
+	for sgID, perms := range fleet.OtherGroups {
+		env.EC2.addGroup(sgID, perms)
+	}
+	return env
+}
+
+// Factory builds the fake clients for every region. The clients of the other services are nil, the features that
+// need them have to stay disabled.
+func (e *Env) Factory() awsclient.Factory {
+	return func(region string) (awsclient.Clients, error) {
+		return awsclient.Clients{EC2: e.EC2, AutoScaling: e.AutoScaling}, nil
+	}
+}
+
+// Calls gets the calls made to the fake clients, in order
+func (e *Env) Calls() []Call {
+	e.rec.mu.Lock()
+	defer e.rec.mu.Unlock()
+	return append([]Call(nil), e.rec.calls...)
+}
+
+// Operations gets the operations of the calls made to the fake clients, in order
+func (e *Env) Operations() []string {
+	var operations []string
+	for _, call := range e.Calls() {
+		operations = append(operations, call.Operation)
+	}
+	return operations
+}
@@ -0,0 +1,32 @@
+package fakeaws
+
+import (
+	"fmt"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+)
+
+// IP gets the i-th address of 10.0.0.0/8, the public IP of the i-th instance of a generated fleet
+func IP(i int) string {
+	return fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+}
+
+// InstanceID gets the ID of the i-th instance of a generated fleet
+func InstanceID(i int) string {
+	return fmt.Sprintf("i-%017x", i)
+}
+
+// FleetIPs builds the desired IPs of a generated fleet of n instances and the Security Group's IPs, churned of them
+// being out of date
+func FleetIPs(n int, churned int) (asgIPs cidr.IPSet, sgIPs cidr.IPSet) {
+	asgIPs, sgIPs = make(cidr.IPSet, n), make(cidr.IPSet, n)
+	for i := 0; i < n; i++ {
+		asgIPs[IP(i)+"/32"] = InstanceID(i)
+		if i < n-churned {
+			sgIPs[IP(i)+"/32"] = ""
+		} else {
+			sgIPs[IP(n+i)+"/32"] = ""
+		}
+	}
+	return asgIPs, sgIPs
+}
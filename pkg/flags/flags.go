@@ -0,0 +1,145 @@
+package flags
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/parameter"
+)
+
+// Flag is a feature flag gating a risky behavior
+type Flag string
+
+const (
+	// StrictRemoval only removes the managed rules, the ones the sync created
+	StrictRemoval Flag = "strictRemoval"
+	// Aggregation merges the contiguous IPs into covering CIDRs
+	Aggregation Flag = "aggregation"
+	// AsyncApply completes the lifecycle action right away and applies the changes asynchronously
+	AsyncApply Flag = "asyncApply"
+)
+
+// known are the flags that can be set
+var known = map[Flag]struct{}{StrictRemoval: {}, Aggregation: {}, AsyncApply: {}}
+
+// Set maps the enabled flags to the AutoScaling Groups they are enabled for. An empty list enables the flag for all of
+// them.
+type Set map[Flag][]string
+
+// Parse reads a comma separated list of flags, each one either enabled for the whole function or, as flag:asgName,
+// for a single AutoScaling Group, e.g. "aggregation,strictRemoval:web-asg"
+func Parse(spec string) (Set, error) {
+	set := make(Set)
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, asgName, scoped := strings.Cut(entry, ":")
+		flag := Flag(name)
+		if _, ok := known[flag]; !ok {
+			return set, fmt.Errorf("unknown feature flag %q", name)
+		}
+		set.enable(flag, asgName, scoped)
+	}
+	return set, nil
+}
+
+// Enables the flag for the AutoScaling Group, or for all of them when it isn't scoped
+func (s Set) enable(flag Flag, asgName string, scoped bool) {
+	asgNames, ok := s[flag]
+	switch {
+	case ok && len(asgNames) == 0:
+		// Already enabled everywhere
+	case !scoped:
+		s[flag] = []string{}
+	default:
+		s[flag] = append(asgNames, asgName)
+	}
+}
+
+// Enabled checks whether the flag is enabled for the AutoScaling Group
+func (s Set) Enabled(flag Flag, asgName string) bool {
+	asgNames, ok := s[flag]
+	if !ok {
+		return false
+	}
+	if len(asgNames) == 0 {
+		return true
+	}
+	for _, name := range asgNames {
+		if name == asgName {
+			return true
+		}
+	}
+	return false
+}
+
+// Has checks whether the flag is enabled for any AutoScaling Group
+func (s Set) Has(flag Flag) bool {
+	_, ok := s[flag]
+	return ok
+}
+
+// Active lists, sorted, the flags enabled for the AutoScaling Group
+func (s Set) Active(asgName string) []string {
+	var active []string
+	for flag := range s {
+		if s.Enabled(flag, asgName) {
+			active = append(active, string(flag))
+		}
+	}
+	sort.Strings(active)
+	return active
+}
+
+// Merge creates the set of the flags enabled by either set
+func (s Set) Merge(other Set) Set {
+	merged := make(Set, len(s)+len(other))
+	for _, set := range []Set{s, other} {
+		for flag, asgNames := range set {
+			if len(asgNames) == 0 {
+				merged.enable(flag, "", false)
+			}
+			for _, asgName := range asgNames {
+				merged.enable(flag, asgName, true)
+			}
+		}
+	}
+	return merged
+}
+
+// appConfigFlag is a flag of an AWS AppConfig feature flags profile. The asgs attribute scopes it to AutoScaling Groups.
+type appConfigFlag struct {
+	Enabled bool     `json:"enabled"`
+	ASGs    []string `json:"asgs"`
+}
+
+// FromAppConfig reads the feature flags profile, e.g. "applications/sg-sync/environments/prod/configurations/flags",
+// from the AWS AppConfig Lambda extension. The profile looks like {"aggregation":{"enabled":true,"asgs":["web-asg"]}}.
+func FromAppConfig(profile string) (Set, error) {
+	body, err := parameter.AppConfig(profile)
+	if err != nil {
+		return nil, fmt.Errorf("get the feature flags profile: %w", err)
+	}
+
+	var profileFlags map[string]appConfigFlag
+	if err := json.Unmarshal(body, &profileFlags); err != nil {
+		return nil, fmt.Errorf("parse the feature flags profile: %w", err)
+	}
+	set := make(Set)
+	for name, f := range profileFlags {
+		flag := Flag(name)
+		if _, ok := known[flag]; !ok || !f.Enabled {
+			continue
+		}
+		if len(f.ASGs) == 0 {
+			set.enable(flag, "", false)
+		}
+		for _, asgName := range f.ASGs {
+			set.enable(flag, asgName, true)
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"sync"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/parameter"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+// adopted is the ID of the Security Group the function adopted, reused by the warm invocations
+var adopted struct {
+	sync.Mutex
+	sgID string
+}
+
+// Syncs the Security Group the function created and adopted, when none is configured. Returns the ID of the group
+// when this invocation created it.
+func withAdoptedGroup(input syncer.Input, clients awsclient.Clients, cfg config.Config, logger *zap.Logger) (syncer.Input, string, error) {
+	if input.SecurityGroupID != "" || !cfg.CreatesSecurityGroup() {
+		return input, "", nil
+	}
+	sgID, created, err := adoptSecurityGroup(clients, cfg, logger)
+	if err != nil {
+		logger.Error("Failed to adopt the Security Group", zap.Error(err))
+		return input, "", err
+	}
+	input.SecurityGroupID = sgID
+	if created {
+		return input, sgID, nil
+	}
+	return input, "", nil
+}
+
+// Gets the Security Group the function adopted like withAdoptedGroup, without creating or recording one, e.g. for a
+// dry run. The input keeps no Security Group when none was adopted yet.
+func withExistingGroup(input syncer.Input, clients awsclient.Clients, cfg config.Config, logger *zap.Logger) (syncer.Input, error) {
+	if input.SecurityGroupID != "" || !cfg.CreatesSecurityGroup() {
+		return input, nil
+	}
+	adopted.Lock()
+	sgID := adopted.sgID
+	adopted.Unlock()
+	if sgID == "" {
+		var err error
+		if sgID, _, err = parameter.Get(clients.SSM, cfg.SecurityGroupParameter); err != nil {
+			return input, err
+		}
+	}
+	if sgID == "" {
+		spec := cfg.GroupSpec()
+		var err error
+		if sgID, err = target.FindTagged(target.CreatedByTag, cfg.FunctionName, spec.VpcID, clients.EC2); err != nil {
+			return input, err
+		}
+	}
+	if sgID == "" {
+		logger.Info("The Security Group would be created", zap.String("name", cfg.SecurityGroupName), zap.String("vpcID", cfg.SecurityGroupVpcID))
+	}
+	input.SecurityGroupID = sgID
+	return input, nil
+}
+
+// Gets the Security Group the function adopted: the one of the SSM parameter, else the one tagged as created by the
+// function, else a new one. The parameter is written once, so that later runs, and other containers, adopt the same
+// group. A group this invocation created is deleted when another one's is adopted instead.
+func adoptSecurityGroup(clients awsclient.Clients, cfg config.Config, logger *zap.Logger) (sgID string, created bool, err error) {
+	adopted.Lock()
+	defer adopted.Unlock()
+	if adopted.sgID != "" {
+		return adopted.sgID, false, nil
+	}
+
+	sgID, found, err := parameter.Get(clients.SSM, cfg.SecurityGroupParameter)
+	if err != nil {
+		return "", false, err
+	}
+	if !found {
+		spec := cfg.GroupSpec()
+		sgID, err = target.FindTagged(target.CreatedByTag, cfg.FunctionName, spec.VpcID, clients.EC2)
+		if err != nil {
+			return "", false, err
+		}
+		if sgID == "" {
+			if sgID, created, err = target.CreateOrFind(spec, map[string]string{target.CreatedByTag: cfg.FunctionName}, clients.EC2); err != nil {
+				return "", false, err
+			}
+			if created {
+				logger.Info("Created the Security Group", zap.String("sgID", sgID), zap.String("name", spec.Name), zap.String("vpcID", spec.VpcID))
+			} else {
+				logger.Info("Another invocation created the Security Group first, using it", zap.String("sgID", sgID), zap.String("name", spec.Name))
+			}
+		}
+		stored, err := parameter.PutOnce(clients.SSM, cfg.SecurityGroupParameter, sgID, "Security Group synced by "+cfg.FunctionName)
+		if err != nil {
+			return "", false, err
+		}
+		if stored != sgID {
+			logger.Warn("Another invocation adopted a Security Group first, using it", zap.String("sgID", stored), zap.String("unused", sgID))
+			if created {
+				if err := target.Delete(sgID, clients.EC2); err != nil {
+					logger.Warn("Failed to delete the unused Security Group", zap.String("sgID", sgID), zap.Error(err))
+				}
+			}
+			sgID, created = stored, false
+		}
+	}
+	adopted.sgID = sgID
+	return sgID, created, nil
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/alert"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/attribution"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/metrics"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"go.uber.org/zap"
+)
+
+// Publishes an alert for the stage failures that the policy tolerated, if any
+func alertFailures(clients awsclient.Clients, cfg config.Config, input syncer.Input, result syncer.Result, logger *zap.Logger) {
+	if len(result.Failures) == 0 {
+		return
+	}
+	logger.Warn("Sync carried on past stage failures", zap.Any("failures", result.Failures))
+	channels := notifier(clients, cfg, cfg.AlertTopicARN)
+	if len(channels) == 0 {
+		return
+	}
+
+	err := channels.Notify(alert.Alert{
+		AutoScalingGroupName: input.AutoScalingGroupName,
+		SecurityGroupID:      input.SecurityGroupID,
+		Rules:                input.Rules,
+		Failures:             result.Failures,
+		CIDRFailures:         result.CIDRFailures,
+		CreatedAt:            time.Now().UTC(),
+	}.Message())
+	if err != nil {
+		logger.Error("Failed to publish the alert", zap.Error(err))
+	}
+}
+
+// Reports the changes other actors made to the managed rule sets, if any, with the ExternalChanges metric and an
+// alert. Returns the changes, attributed to whoever made them when enabled.
+func reportDrift(clients awsclient.Clients, cfg config.Config, input syncer.Input, result syncer.Result, logger *zap.Logger) []syncer.Drift {
+	drift := result.Drift
+	if len(drift) == 0 {
+		return drift
+	}
+	if cfg.DriftAttribution {
+		attributed, err := attribution.Attribute(clients.CloudTrail, input.SecurityGroupID, drift)
+		if err != nil {
+			logger.Warn("Failed to attribute the external changes", zap.Error(err))
+		}
+		drift = attributed
+		logger.Info("External changes", zap.Any("drift", drift))
+	}
+	if cfg.MetricsNamespace != "" {
+		if err := metrics.PublishDrift(clients.CloudWatch, cfg.MetricsNamespace, input.AutoScalingGroupName, input.SecurityGroupID, len(drift)); err != nil {
+			logger.Error("Failed to publish the drift metric", zap.Error(err))
+		}
+	}
+	channels := notifier(clients, cfg, cfg.AlertTopicARN)
+	if len(channels) == 0 {
+		return drift
+	}
+
+	err := channels.Notify(alert.Alert{
+		AutoScalingGroupName: input.AutoScalingGroupName,
+		SecurityGroupID:      input.SecurityGroupID,
+		Rules:                input.Rules,
+		Drift:                drift,
+		CreatedAt:            time.Now().UTC(),
+	}.Message())
+	if err != nil {
+		logger.Error("Failed to publish the drift alert", zap.Error(err))
+	}
+	return drift
+}
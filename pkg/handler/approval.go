@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/approval"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"go.uber.org/zap"
+)
+
+// Publishes an approval request for the parked removals of the sync, if any
+func requestApproval(clients awsclient.Clients, cfg config.Config, input syncer.Input, result syncer.Result, logger *zap.Logger) {
+	if len(result.PendingRemovals) == 0 || result.DryRun {
+		return
+	}
+	channels := notifier(clients, cfg, cfg.ApprovalTopicARN)
+	if len(channels) == 0 {
+		logger.Warn("Removals are pending approval but no notification channel is configured")
+		return
+	}
+
+	err := channels.Notify(approval.Request{
+		AutoScalingGroupName: input.AutoScalingGroupName,
+		SecurityGroupID:      input.SecurityGroupID,
+		Rules:                input.Rules,
+		PendingRemovals:      result.PendingRemovals,
+		CreatedAt:            time.Now().UTC(),
+	}.Message())
+	if err != nil {
+		logger.Error("Failed to publish the approval request", zap.Error(err))
+	}
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/audit"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"go.uber.org/zap"
+)
+
+// Streams the records of the changes the syncs of the Security Groups made to every configured audit stream. Failures
+// are logged, they never fail the invocation.
+func streamChanges(newClients awsclient.Factory, cfg config.Config, region string, asgName string, targets []syncer.TargetResult, logger *zap.Logger) {
+	if cfg.AuditKinesisStream == "" && cfg.AuditFirehoseStream == "" {
+		return
+	}
+	var records []audit.Record
+	now := time.Now()
+	for _, t := range targets {
+		sync := audit.Sync{Region: region, AutoScalingGroupName: asgName, SecurityGroupID: t.SecurityGroupID, Function: cfg.FunctionName, Time: now}
+		records = append(records, audit.Records(sync, t.Result)...)
+	}
+	if len(records) == 0 {
+		return
+	}
+	clients, err := newClients(region)
+	if err != nil {
+		logger.Error("Failed to create session", zap.Error(err))
+		return
+	}
+	var streams []audit.Stream
+	if cfg.AuditKinesisStream != "" {
+		streams = append(streams, audit.Kinesis{Svc: clients.Kinesis, StreamName: cfg.AuditKinesisStream})
+	}
+	if cfg.AuditFirehoseStream != "" {
+		streams = append(streams, audit.Firehose{Svc: clients.Firehose, DeliveryStreamName: cfg.AuditFirehoseStream})
+	}
+	for _, stream := range streams {
+		if err := stream.Put(records); err != nil {
+			logger.Error("Failed to stream the audit records", zap.String("stream", stream.Name()), zap.Int("records", len(records)), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/lifecycle"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/policy"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"go.uber.org/zap"
+)
+
+// BatchHandler consumes the lifecycle events from SQS. The events of a batch that sync the same AutoScaling Group and
+// Security Group are coalesced into a single reconcile, so that bursts of scale events (step scaling, instance
+// refreshes) don't turn into as many sequential Security Group changes.
+type BatchHandler struct {
+	lifecycle  *LifecycleHandler
+	newClients awsclient.Factory
+	cfg        config.Config
+	logger     *zap.Logger
+}
+
+// NewBatch creates a BatchHandler that builds its AWS clients with newClients. The config is validated as the batch
+// mode's, whatever HANDLER_MODE is.
+// It is meant to be created once, at cold start, and reused across invocations.
+func NewBatch(cfg config.Config, newClients awsclient.Factory) *BatchHandler {
+	cfg.HandlerMode = "batch"
+	return &BatchHandler{lifecycle: New(cfg, newClients), newClients: newClients, cfg: cfg, logger: logging.New()}
+}
+
+// coalesced are the events of a batch that are handled by one reconcile
+type coalesced struct {
+	// handler is the handler of the events' tenant
+	handler  *LifecycleHandler
+	input    syncer.Input
+	requests []event.IncomingEvent
+	records  []string
+}
+
+// A lifecycle event of the batch and its message
+type batchEvent struct {
+	request   event.IncomingEvent
+	messageID string
+}
+
+// Handle coalesces the events of the batch and reconciles every group once. Terminations go first, so that the rules
+// they free make room for the launches' rules within the Security Group's quota.
+func (h *BatchHandler) Handle(sqsEvent events.SQSEvent) (response events.SQSEventResponse, err error) {
+	defer h.logger.Sync()
+
+	var batch []batchEvent
+	for _, record := range sqsEvent.Records {
+		var request event.IncomingEvent
+		if err := json.Unmarshal([]byte(record.Body), &request); err != nil {
+			// Redelivering a malformed message won't fix it
+			h.logger.Error("Dropping malformed event", zap.String("messageID", record.MessageId), zap.Error(err))
+			continue
+		}
+		if request.IsTestNotification() {
+			h.logger.Info("Skipping the test notification", zap.String("messageID", record.MessageId))
+			continue
+		}
+		if err := request.Validate(); err != nil {
+			h.logger.Error("Dropping invalid event", zap.String("messageID", record.MessageId), zap.Error(err))
+			continue
+		}
+		batch = append(batch, batchEvent{request: request, messageID: record.MessageId})
+	}
+	sort.SliceStable(batch, func(i, j int) bool {
+		return batch[i].request.Detail.IsTerminating() && !batch[j].request.Detail.IsTerminating()
+	})
+
+	var order []string
+	groups := make(map[string]*coalesced)
+	tenants := make(map[string]batchTenant)
+	for _, e := range batch {
+		request := e.request
+		tenant := h.tenantOf(request, tenants)
+		var input syncer.Input
+		err := tenant.err
+		if err == nil {
+			input, err = tenant.handler.hookInput(request)
+		}
+		if err != nil || request.IsReplay() || request.AsyncApply || len(tenant.handler.invocationLayers(request)) != 0 {
+			// Handled on their own, e.g. to complete them with the failure result or with their own config
+			if _, err := h.lifecycle.Handle(request); err != nil {
+				h.logger.Warn("Event failed", zap.String("messageID", e.messageID), zap.Error(err))
+			}
+			continue
+		}
+
+		key := coalesceKey(request, input, tenant.name)
+		group, ok := groups[key]
+		if !ok {
+			group = &coalesced{handler: tenant.handler, input: input}
+			groups[key] = group
+			order = append(order, key)
+		}
+		group.requests = append(group.requests, request)
+		group.records = append(group.records, e.messageID)
+	}
+
+	for _, key := range order {
+		if err := h.reconcile(groups[key]); err != nil {
+			for _, id := range groups[key].records {
+				response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: id})
+			}
+		}
+	}
+	return response, nil
+}
+
+// The tenant of an AutoScaling Group of the batch and its handler
+type batchTenant struct {
+	handler *LifecycleHandler
+	name    string
+	err     error
+}
+
+// Gets the tenant of the event's AutoScaling Group, reading its tenant tag once per batch. The events whose tenant
+// can't be resolved are handled on their own, which refuses them.
+func (h *BatchHandler) tenantOf(request event.IncomingEvent, tenants map[string]batchTenant) batchTenant {
+	key := request.Region + "|" + request.Detail.AutoScalingGroupName
+	if tenant, ok := tenants[key]; ok {
+		return tenant
+	}
+	tenant := batchTenant{handler: h.lifecycle}
+	if h.lifecycle.cfgErr == nil && h.cfg.TenantTag != "" {
+		clients, err := h.newClients(request.Region)
+		if err != nil {
+			tenant.err = errs.Wrap(errs.Config, "create session", err)
+		} else {
+			tenant.handler, tenant.name, tenant.err = h.lifecycle.forTenant(clients, request)
+		}
+	}
+	tenants[key] = tenant
+	return tenant
+}
+
+// Builds the key of the events that can share a reconcile: same region, tenant, AutoScaling Group, Security Group,
+// rules and mode
+func coalesceKey(request event.IncomingEvent, input syncer.Input, tenant string) string {
+	rules := make([]string, 0, len(input.Rules))
+	for _, rule := range input.Rules {
+		rules = append(rules, rule.String())
+	}
+	mode := event.ModeIP
+	if input.ReferenceSourceGroup {
+		mode = event.ModeReference
+	}
+	return strings.Join([]string{request.Region, tenant, input.AutoScalingGroupName, input.SecurityGroupID, strings.Join(rules, ","), mode}, "|")
+}
+
+// Reconciles the group once, excluding all its terminating instances, and completes the lifecycle action of every
+// one of its events. Returns an error only when the events should be redelivered.
+func (h *BatchHandler) reconcile(group *coalesced) error {
+	lh, cfg := group.handler, group.handler.cfg
+	logger := lh.logger.With(zap.String("asgName", group.input.AutoScalingGroupName), zap.String("sgID", group.input.SecurityGroupID))
+	if lh.cfgErr != nil {
+		return lh.cfgErr
+	}
+	budget := retry.Start(cfg.RetryBudget)
+	defer retry.Stop(budget)
+	clients, err := h.newClients(group.requests[0].Region)
+	if err != nil {
+		logger.Error("Failed to create session", zap.Error(err))
+		return errs.Wrap(errs.Config, "create session", err)
+	}
+	if len(group.requests) > 1 {
+		logger.Info("Coalescing lifecycle events into one reconcile", zap.Int("events", len(group.requests)))
+	}
+
+	// A dry run or a change freeze changes nothing: neither the Security Group is created, nor the instances registered
+	_, frozen := cfg.MaintenanceWindows.Active(time.Now())
+	readOnly := cfg.DryRun || frozen
+	input := group.input
+	if readOnly {
+		input, err = withExistingGroup(input, clients, cfg, logger)
+	} else {
+		input, _, err = withAdoptedGroup(input, clients, cfg, logger)
+	}
+	if err != nil {
+		return err
+	}
+	for _, request := range group.requests {
+		if request.Detail.IsTerminating() {
+			input.Order = policy.RemoveFirst
+			input.ExcludeInstanceIDs = append(input.ExcludeInstanceIDs, request.Detail.EC2InstanceID)
+			if request.Time.After(input.ExcludedSince) {
+				input.ExcludedSince = request.Time
+			}
+		}
+	}
+	// The launching instances that fail their status checks are left out of the reconcile and fail their launch
+	failedChecks := make(map[string]bool)
+	for _, request := range group.requests {
+		lh.waitForPublicIP(clients, request.Detail)
+		if err := lh.waitForStatusChecks(clients, request.Detail); err != nil {
+			failedChecks[request.Detail.EC2InstanceID] = true
+			input.ExcludeInstanceIDs = append(input.ExcludeInstanceIDs, request.Detail.EC2InstanceID)
+			continue
+		}
+		if readOnly {
+			continue
+		}
+		if _, err := lh.updateTargetGroups(clients, request); err != nil {
+			logger.Error("Failed to update the target groups", zap.String("instanceID", request.Detail.EC2InstanceID), zap.Error(err))
+		}
+	}
+
+	started := time.Now()
+	result := syncer.Result{DryRun: true}
+	if input.SecurityGroupID != "" {
+		result, _, err = syncOrDefer(clients, cfg, group.requests[0].Region, input, logger)
+	}
+	if err != nil {
+		logger.Error("Coalesced reconcile failed", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+	} else {
+		requestApproval(clients, cfg, input, result, logger)
+		alertFailures(clients, cfg, input, result, logger)
+		reportDrift(clients, cfg, input, result, logger)
+		followHealthChecks(clients, cfg, result, logger)
+	}
+	for _, request := range group.requests {
+		if err != nil || failedChecks[request.Detail.EC2InstanceID] {
+			lh.completeLifecycle(clients, request, cfg.FailureResult(request.Detail.IsTerminating()))
+			continue
+		}
+		lh.completeLifecycle(clients, request, lifecycle.ResultContinue)
+	}
+	recordOutcome(h.newClients, cfg, syncOutcome{group.requests[0].Region, input.AutoScalingGroupName, input.SecurityGroupID, result, started, err}, logger)
+	trackFailures(h.newClients, cfg, group.requests[0].Region, input.AutoScalingGroupName, input.SecurityGroupID, err, logger)
+	reportError(h.newClients, cfg, errorReport{group.requests[0].Region, group.requests[0].ID, input.AutoScalingGroupName, input.SecurityGroupID, err}, logger)
+	return nil
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/bluegreen"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/parameter"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"go.uber.org/zap"
+)
+
+// maxSwapAttempts is how many times a blue/green sync starts over when other invocations keep swapping first
+const maxSwapAttempts = 3
+
+// Gets the active Security Group of the blue/green mode: the one of the SSM parameter, else the configured one,
+// followed to the group that replaced it when a swap didn't get to update the parameter
+func activeGroup(clients awsclient.Clients, cfg config.Config) (string, error) {
+	sgID, found, err := parameter.Get(clients.SSM, cfg.SecurityGroupParameter)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		sgID = cfg.SecurityGroupID
+	}
+	return bluegreen.Resolve(sgID, clients.EC2)
+}
+
+// Resolves the Security Group of the input to the active one of the blue/green mode: the configured group to the
+// active group, and a group that was swapped out since the input was built to the group that replaced it
+func withActiveGroup(input syncer.Input, clients awsclient.Clients, cfg config.Config) (syncer.Input, error) {
+	if !cfg.BlueGreen {
+		return input, nil
+	}
+	var err error
+	if input.SecurityGroupID == cfg.SecurityGroupID {
+		input.SecurityGroupID, err = activeGroup(clients, cfg)
+	} else {
+		input.SecurityGroupID, err = bluegreen.Resolve(input.SecurityGroupID, clients.EC2)
+	}
+	return input, err
+}
+
+// Applies the changes to a green copy of the active Security Group and swaps it in on the network interfaces, instead
+// of changing the active group. When another invocation swapped first, the sync starts over from its group.
+func (h *LifecycleHandler) swapStep(pc *pipelineContext) error {
+	for attempt := 1; ; attempt++ {
+		raced, err := h.swap(pc)
+		if !raced {
+			return err
+		}
+		if attempt == maxSwapAttempts {
+			return errs.Errorf(errs.Target, "swap security group", "other invocations swapped the security group first %d times", attempt)
+		}
+		h.logger.Warn("Another invocation swapped the Security Group first, starting over", zap.String("sgID", pc.input.SecurityGroupID))
+		if pc.input.SecurityGroupID, err = activeGroup(pc.clients, h.cfg); err != nil {
+			return err
+		}
+		pc.sgIDs = []string{pc.input.SecurityGroupID}
+	}
+}
+
+// Diffs the active group, builds the green one and swaps it in. raced is true when the active group changed in the
+// meantime, the green group is then discarded.
+func (h *LifecycleHandler) swap(pc *pipelineContext) (raced bool, err error) {
+	if err := h.diffStep(pc); err != nil {
+		return false, err
+	}
+	changes := blueGreenChanges(pc.result)
+	if len(changes) == 0 {
+		pc.result.DryRun = false
+		return false, nil
+	}
+	activeID, ec2Svc := pc.input.SecurityGroupID, pc.clients.EC2
+	logger := h.logger.With(zap.String("sgID", activeID))
+	launches, err := bluegreen.Launches(pc.input.AutoScalingGroupName, activeID, pc.clients.AutoScaling)
+	if err != nil {
+		pc.response = Response{SecurityGroupID: activeID, Result: pc.result}
+		return false, err
+	}
+	greenID, err := bluegreen.Build(activeID, changes, pc.result.Owners, ec2Svc)
+	if err != nil {
+		logger.Error("Failed to build the green Security Group", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		// The result stays a dry run, the active group wasn't changed
+		pc.response = Response{SecurityGroupID: activeID, Result: pc.result}
+		return false, err
+	}
+	logger = logger.With(zap.String("greenID", greenID))
+
+	// The active group is read again right before the swap, another invocation may have swapped it meanwhile
+	current, err := activeGroup(pc.clients, h.cfg)
+	if err != nil || current != activeID {
+		bluegreen.Discard(greenID, ec2Svc)
+		return err == nil, err
+	}
+	enis, err := bluegreen.SwapIn(activeID, greenID, ec2Svc)
+	if err != nil {
+		logger.Error("Failed to swap the green Security Group", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		bluegreen.Discard(greenID, ec2Svc)
+		pc.response = Response{SecurityGroupID: activeID, Result: pc.result}
+		return false, err
+	}
+	logger.Info("Swapped the green Security Group in", zap.Strings("networkInterfaces", enis))
+
+	// The network interfaces already have the green group: the failures below are logged, the next syncs find the
+	// green group through the tag or the parameter
+	if err := bluegreen.MarkReplaced(activeID, greenID, ec2Svc); err != nil {
+		logger.Error("Failed to tag the swapped out Security Group", zap.Error(err))
+	}
+	if err := parameter.Put(pc.clients.SSM, h.cfg.SecurityGroupParameter, greenID, "Active Security Group of "+h.cfg.FunctionName); err != nil {
+		logger.Error("Failed to record the active Security Group", zap.String("parameter", h.cfg.SecurityGroupParameter), zap.Error(err))
+	}
+	// The new instances launch with the green group too. The swapped out group is kept while a launch template
+	// still uses it.
+	templates, err := bluegreen.UpdateLaunches(launches, activeID, greenID, pc.clients.AutoScaling, ec2Svc)
+	if err != nil {
+		logger.Error("Failed to update the launch templates", zap.Error(err), zap.Strings("launchTemplates", templates))
+	}
+	deleted, err := bluegreen.Retire(activeID, ec2Svc)
+	if err != nil {
+		logger.Error("Failed to delete the swapped out Security Group", zap.Error(err))
+	}
+	pc.input.SecurityGroupID, pc.sgIDs, pc.result.DryRun = greenID, []string{greenID}, false
+	pc.swap = &bluegreen.Swap{ActiveSecurityGroupID: greenID, RetiredSecurityGroupID: activeID, NetworkInterfaces: enis, LaunchTemplates: templates, Deleted: deleted}
+	return false, nil
+}
+
+// Gets the changes of every rule set of the diff
+func blueGreenChanges(result syncer.Result) []bluegreen.Change {
+	var changes []bluegreen.Change
+	for _, rr := range result.Rules {
+		if len(rr.AddedIPs) != 0 || len(rr.Revoked) != 0 {
+			changes = append(changes, bluegreen.Change{Rule: rr.Rule, Added: rr.AddedIPs, Removed: rr.Revoked})
+		}
+	}
+	return changes
+}
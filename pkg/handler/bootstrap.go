@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/bootstrap"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"go.uber.org/zap"
+)
+
+// BootstrapRequest is the event of the setup mode, e.g. sent by a deployment pipeline once the function is deployed
+type BootstrapRequest struct {
+	// AutoScalingGroupNames default to the AutoScaling Groups of the configured pairs
+	AutoScalingGroupNames []string `json:"asgNames,omitempty"`
+	// Heartbeat is the heartbeat timeout of the hooks, e.g. 5m
+	Heartbeat string `json:"heartbeat,omitempty"`
+	// DefaultResult is the result of the hooks that time out, ABANDON (default) or CONTINUE
+	DefaultResult string `json:"defaultResult,omitempty"`
+	// FunctionARN, when set, is the sync function that the EventBridge rule forwards the lifecycle events to
+	FunctionARN string `json:"functionARN,omitempty"`
+	Region      string `json:"region,omitempty"`
+}
+
+// BootstrapResponse lists the AutoScaling Groups whose hooks are in place
+type BootstrapResponse struct {
+	AutoScalingGroupNames []string `json:"asgNames"`
+	// EventRule is the EventBridge rule that was set up, if any
+	EventRule string `json:"eventRule,omitempty"`
+}
+
+// BootstrapHandler onboards AutoScaling Groups, as the CLI's bootstrap subcommand does: it puts their lifecycle hooks
+// and, given the sync function, the EventBridge rule that forwards their events to it
+type BootstrapHandler struct {
+	newClients awsclient.Factory
+	cfg        config.Config
+	logger     *zap.Logger
+}
+
+// NewBootstrap creates a BootstrapHandler that builds its AWS clients with newClients, which needs the Lambda and
+// EventBridge clients. It is meant to be created once, at cold start, and reused across invocations.
+func NewBootstrap(cfg config.Config, newClients awsclient.Factory) *BootstrapHandler {
+	return &BootstrapHandler{newClients: newClients, cfg: cfg, logger: logging.New()}
+}
+
+// Handle puts the lifecycle hooks of every AutoScaling Group of the request, then the EventBridge rule
+func (h *BootstrapHandler) Handle(request BootstrapRequest) (response BootstrapResponse, err error) {
+	defer h.logger.Sync()
+	budget := retry.Start(h.cfg.RetryBudget)
+	defer retry.Stop(budget)
+	h.logger.Info("BootstrapRequest", zap.Any("Request", request))
+
+	names := request.AutoScalingGroupNames
+	if len(names) == 0 {
+		for _, pair := range h.cfg.Pairs {
+			names = append(names, pair.AutoScalingGroupName)
+		}
+	}
+	if len(names) == 0 {
+		return response, errs.Errorf(errs.Config, "bootstrap", "asgNames or pairs are required")
+	}
+	heartbeat := bootstrap.DefaultHeartbeatTimeout
+	if request.Heartbeat != "" {
+		if heartbeat, err = time.ParseDuration(request.Heartbeat); err != nil {
+			return response, errs.Wrap(errs.Config, "bootstrap", err)
+		}
+	}
+	defaultResult := request.DefaultResult
+	if defaultResult == "" {
+		defaultResult = "ABANDON"
+	}
+	if request.Region == "" {
+		request.Region = awsclient.DefaultRegion()
+	}
+
+	clients, err := h.newClients(request.Region)
+	if err != nil {
+		h.logger.Error("Failed to create session", zap.Error(err))
+		return response, errs.Wrap(errs.Config, "create session", err)
+	}
+
+	for _, name := range names {
+		asgName, err := source.ResolveGroupName(name, clients.ElasticBeanstalk)
+		if err != nil {
+			return response, err
+		}
+		if err := bootstrap.LifecycleHooks(asgName, heartbeat, defaultResult, clients.AutoScaling); err != nil {
+			h.logger.Error("Failed to put the lifecycle hooks", zap.String("asgName", asgName), zap.Error(err))
+			return response, err
+		}
+		h.logger.Info("Lifecycle hooks are in place", zap.String("asgName", asgName))
+		response.AutoScalingGroupNames = append(response.AutoScalingGroupNames, asgName)
+	}
+
+	if request.FunctionARN == "" {
+		return response, nil
+	}
+	if err := bootstrap.EventRule(request.FunctionARN, response.AutoScalingGroupNames, clients.EventBridge, clients.Lambda); err != nil {
+		h.logger.Error("Failed to set up the EventBridge rule", zap.Error(err))
+		return response, err
+	}
+	response.EventRule = bootstrap.RuleName
+	return response, nil
+}
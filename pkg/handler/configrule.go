@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/compliance"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+// RuleParameters are the parameters of the AWS Config custom rule, naming the AutoScaling Group and the Security
+// Group it evaluates
+type RuleParameters struct {
+	AutoScalingGroupName string `json:"asgName"`
+	SecurityGroupID      string `json:"sgID"`
+}
+
+// ConfigRuleHandler is the function of an AWS Config custom rule. Every evaluation reconciles the Security Group with
+// the AutoScaling Group and reports it to the rule as COMPLIANT or NON_COMPLIANT.
+type ConfigRuleHandler struct {
+	newClients awsclient.Factory
+	cfg        config.Config
+	logger     *zap.Logger
+}
+
+// NewConfigRule creates a ConfigRuleHandler that builds its AWS clients with newClients.
+// It is meant to be created once, at cold start, and reused across invocations.
+func NewConfigRule(cfg config.Config, newClients awsclient.Factory) *ConfigRuleHandler {
+	configure(cfg)
+	return &ConfigRuleHandler{newClients: newClients, cfg: cfg, logger: logging.New()}
+}
+
+// Handle reconciles the Security Group of the rule's parameters and submits its evaluation
+func (h *ConfigRuleHandler) Handle(configEvent events.ConfigEvent) error {
+	logger := h.logger
+	defer logger.Sync()
+	budget := retry.Start(h.cfg.RetryBudget)
+	defer retry.Stop(budget)
+
+	params, err := parseRuleParameters(configEvent.RuleParameters, h.cfg.DefaultAutoScalingGroup(), h.cfg.SecurityGroupID)
+	if err != nil {
+		logger.Error("Invalid rule parameters", zap.Error(err))
+		return err
+	}
+	logger = logger.With(zap.String("configRule", configEvent.ConfigRuleName), zap.String("asgName", params.AutoScalingGroupName), zap.String("sgID", params.SecurityGroupID))
+
+	clients, err := h.newClients(awsclient.DefaultRegion())
+	if err != nil {
+		logger.Error("Failed to create session", zap.Error(err))
+		return errs.Wrap(errs.Config, "create session", err)
+	}
+	if params.AutoScalingGroupName, err = source.ResolveGroupName(params.AutoScalingGroupName, clients.ElasticBeanstalk); err != nil {
+		logger.Error("Failed to resolve the AutoScaling Group", zap.Error(err))
+		return err
+	}
+
+	if configEvent.EventLeftScope {
+		return compliance.PutNotApplicable(clients.ConfigService, configEvent.ResultToken, params.SecurityGroupID, time.Now().UTC())
+	}
+
+	input := newInput(h.cfg, params.AutoScalingGroupName, params.SecurityGroupID)
+	input.CollectOrphans = h.cfg.CollectOrphans
+	result, _, err := syncOrDefer(clients, h.cfg, awsclient.DefaultRegion(), input, logger)
+	if err != nil {
+		logger.Error("Sync failed", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		result.Failures = append(result.Failures, syncer.Failure{Category: errs.CategoryOf(err), Error: err.Error()})
+	}
+	alertFailures(clients, h.cfg, input, result, logger)
+	result.Drift = reportDrift(clients, h.cfg, input, result, logger)
+
+	complianceType, annotation := compliance.Evaluate(result)
+	logger.Info("Evaluation", zap.String("compliance", complianceType), zap.String("annotation", annotation))
+	if err := compliance.Put(clients.ConfigService, configEvent.ResultToken, params.SecurityGroupID, complianceType, annotation, time.Now().UTC()); err != nil {
+		logger.Error("Failed to submit the evaluation", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// Parses the rule's parameters. The AutoScaling Group and Security Group default to the configured ones.
+func parseRuleParameters(raw string, defaultASG string, defaultSgID string) (RuleParameters, error) {
+	var params RuleParameters
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), &params); err != nil {
+			return params, errs.Wrap(errs.Config, "parse rule parameters", err)
+		}
+	}
+	if params.AutoScalingGroupName == "" {
+		params.AutoScalingGroupName = defaultASG
+	}
+	if params.SecurityGroupID == "" {
+		params.SecurityGroupID = defaultSgID
+	}
+	if params.AutoScalingGroupName == "" || !target.ValidID(params.SecurityGroupID) {
+		return params, errs.Errorf(errs.Config, "parse rule parameters", "asgName and a valid sgID are required")
+	}
+	return params, nil
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+// ResourceProperties are the properties of the CloudFormation custom resource. CloudFormation passes every scalar as a
+// string.
+type ResourceProperties struct {
+	AutoScalingGroupName string `json:"asgName"`
+	SecurityGroupID      string `json:"sgID"`
+	// Port, when set, restricts the sync to the tcp rule of this port instead of the configured rules
+	Port int64 `json:"port,omitempty,string"`
+}
+
+// CustomResourceHandler handles the events of a CloudFormation custom resource. Creating and updating the resource
+// fully syncs its Security Group, deleting it removes all the managed rules. The response is signaled to CloudFormation
+// by cfn.LambdaWrap.
+type CustomResourceHandler struct {
+	newClients awsclient.Factory
+	cfg        config.Config
+	logger     *zap.Logger
+}
+
+// NewCustomResource creates a CustomResourceHandler that builds its AWS clients with newClients.
+// It is meant to be created once, at cold start, and reused across invocations.
+func NewCustomResource(cfg config.Config, newClients awsclient.Factory) *CustomResourceHandler {
+	configure(cfg)
+	return &CustomResourceHandler{newClients: newClients, cfg: cfg, logger: logging.New()}
+}
+
+// Handle syncs the resource's Security Group on Create and Update, and removes its managed rules on Delete. The
+// physical ID is the AutoScaling Group and the Security Group, e.g. my-asg/sg-0123456789abcdef0: updating either one
+// replaces the resource, and CloudFormation then deletes the previous one.
+func (h *CustomResourceHandler) Handle(ctx context.Context, request cfn.Event) (physicalResourceID string, data map[string]interface{}, err error) {
+	logger := h.logger.With(zap.String("requestType", string(request.RequestType)), zap.String("logicalResourceID", request.LogicalResourceID))
+	defer logger.Sync()
+	budget := retry.Start(h.cfg.RetryBudget)
+	defer retry.Stop(budget)
+	logger.Info("CustomResourceEvent", zap.String("stackID", request.StackID), zap.Any("properties", request.ResourceProperties))
+
+	props, err := h.parseProperties(request.ResourceProperties)
+	if err != nil {
+		logger.Error("Invalid resource properties", zap.Error(err))
+		// A resource that was never created has nothing to remove
+		if request.RequestType == cfn.RequestDelete {
+			return request.PhysicalResourceID, nil, nil
+		}
+		return request.PhysicalResourceID, nil, err
+	}
+
+	region := awsclient.DefaultRegion()
+	clients, err := h.newClients(region)
+	if err != nil {
+		logger.Error("Failed to create session", zap.Error(err))
+		return request.PhysicalResourceID, nil, errs.Wrap(errs.Config, "create session", err)
+	}
+
+	if request.RequestType == cfn.RequestDelete {
+		return request.PhysicalResourceID, nil, h.remove(clients, request.PhysicalResourceID, props, logger)
+	}
+
+	asgName, err := source.ResolveGroupName(props.AutoScalingGroupName, clients.ElasticBeanstalk)
+	if err != nil {
+		logger.Error("Failed to resolve the AutoScaling Group", zap.Error(err))
+		return request.PhysicalResourceID, nil, err
+	}
+	// Returned on failure too, so that the rollback's Delete removes the rules the failed sync added
+	physicalResourceID = asgName + "/" + props.SecurityGroupID
+
+	input := newInput(h.cfg, asgName, props.SecurityGroupID)
+	input = withPort(input, props.Port)
+	input.CollectOrphans = h.cfg.CollectOrphans
+	started := time.Now()
+	result, _, err := syncOrDefer(clients, h.cfg, region, input, logger)
+	recordOutcome(h.newClients, h.cfg, syncOutcome{region, asgName, props.SecurityGroupID, result, started, err}, logger)
+	trackFailures(h.newClients, h.cfg, region, asgName, props.SecurityGroupID, err, logger)
+	reportError(h.newClients, h.cfg, errorReport{region, request.RequestID, asgName, props.SecurityGroupID, err}, logger)
+	if err != nil {
+		logger.Error("Sync failed", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		return physicalResourceID, nil, err
+	}
+	followHealthChecks(clients, h.cfg, result, logger)
+	return physicalResourceID, map[string]interface{}{
+		"AutoScalingGroupName": asgName,
+		"SecurityGroupId":      props.SecurityGroupID,
+		"AddedIPs":             strings.Join(result.AddedIPs, ","),
+	}, nil
+}
+
+// Removes the managed rules of the resource's Security Group. The Security Group is the one of the physical ID, which
+// is the one that was synced. A physical ID of another shape belongs to a resource whose creation failed before the
+// sync, and a deleted Security Group has no rules left: both have nothing to remove.
+func (h *CustomResourceHandler) remove(clients awsclient.Clients, physicalResourceID string, props ResourceProperties, logger *zap.Logger) error {
+	i := strings.LastIndex(physicalResourceID, "/")
+	if i < 0 || !target.ValidID(physicalResourceID[i+1:]) {
+		logger.Info("Nothing to remove", zap.String("physicalResourceID", physicalResourceID))
+		return nil
+	}
+	input := newInput(h.cfg, physicalResourceID[:i], physicalResourceID[i+1:])
+	input, err := withActiveGroup(withPort(input, props.Port), clients, h.cfg)
+	if target.IsNotFound(err) {
+		logger.Warn("The Security Group no longer exists", zap.String("sgID", input.SecurityGroupID))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := syncer.RemoveManaged(withState(input, clients, h.cfg), clients.EC2, logger)
+	if target.IsNotFound(err) {
+		logger.Warn("The Security Group no longer exists", zap.String("sgID", input.SecurityGroupID))
+		return nil
+	}
+	if err != nil {
+		logger.Error("Failed to remove the managed rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		return err
+	}
+	logger.Info("Managed rules removed", zap.Strings("removedIPs", result.RemovedIPs), zap.Strings("blockedRemovals", result.BlockedRemovals), zap.Strings("throttledRemovals", result.ThrottledRemovals))
+	return nil
+}
+
+// Decodes and validates the resource's properties. The AutoScaling Group and Security Group default to the configured
+// ones.
+func (h *CustomResourceHandler) parseProperties(properties map[string]interface{}) (props ResourceProperties, err error) {
+	raw, err := json.Marshal(properties)
+	if err != nil {
+		return props, errs.Wrap(errs.Config, "parse resource properties", err)
+	}
+	if err := json.Unmarshal(raw, &props); err != nil {
+		return props, errs.Wrap(errs.Config, "parse resource properties", err)
+	}
+	if props.AutoScalingGroupName == "" {
+		props.AutoScalingGroupName = h.cfg.DefaultAutoScalingGroup()
+	}
+	if props.SecurityGroupID == "" {
+		props.SecurityGroupID = h.cfg.SecurityGroupID
+	}
+	if props.AutoScalingGroupName == "" || !target.ValidID(props.SecurityGroupID) {
+		return props, errs.Errorf(errs.Config, "parse resource properties", "asgName and a valid sgID are required")
+	}
+	return props, nil
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/lifecycle"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+	"go.uber.org/zap"
+)
+
+// DLQHandler consumes the lifecycle events that ended up in the dead-letter queue. Events whose lifecycle action is
+// still waiting are handled as usual. The others are re-run as a plain reconcile of the AutoScaling Group, so that
+// dropped events eventually converge.
+type DLQHandler struct {
+	lifecycle  *LifecycleHandler
+	newClients awsclient.Factory
+	cfg        config.Config
+	logger     *zap.Logger
+}
+
+// NewDLQ creates a DLQHandler that builds its AWS clients with newClients.
+// It is meant to be created once, at cold start, and reused across invocations.
+func NewDLQ(cfg config.Config, newClients awsclient.Factory) *DLQHandler {
+	return &DLQHandler{lifecycle: New(cfg, newClients), newClients: newClients, cfg: cfg, logger: logging.New()}
+}
+
+// Handle reprocesses every failed event of the batch
+func (h *DLQHandler) Handle(sqsEvent events.SQSEvent) (response events.SQSEventResponse, err error) {
+	defer h.logger.Sync()
+	for _, record := range sqsEvent.Records {
+		if err := h.reprocess(record); err != nil {
+			h.logger.Warn("Failed event not reprocessed", zap.String("messageID", record.MessageId), zap.Error(err))
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+		}
+	}
+	return response, nil
+}
+
+func (h *DLQHandler) reprocess(record events.SQSMessage) error {
+	budget := retry.Start(h.cfg.RetryBudget)
+	defer retry.Stop(budget)
+	var request event.IncomingEvent
+	if err := json.Unmarshal([]byte(record.Body), &request); err != nil {
+		// Redelivering a malformed message won't fix it
+		h.logger.Error("Dropping malformed event", zap.String("messageID", record.MessageId), zap.Error(err))
+		return nil
+	}
+	if err := request.Validate(); err != nil {
+		h.logger.Error("Dropping invalid event", zap.String("messageID", record.MessageId), zap.Error(err))
+		return nil
+	}
+	if h.lifecycle.cfgErr != nil {
+		return h.lifecycle.cfgErr
+	}
+
+	clients, err := h.newClients(request.Region)
+	if err != nil {
+		return errs.Wrap(errs.Config, "create session", err)
+	}
+
+	active, err := lifecycle.Active(clients.AutoScaling, request.Detail)
+	if err != nil {
+		return err
+	}
+	if active {
+		h.logger.Info("The lifecycle action is still waiting, handling the event", zap.String("instanceID", request.Detail.EC2InstanceID))
+		_, err := h.lifecycle.handle(request)
+		return err
+	}
+
+	h.logger.Info("The lifecycle action is gone, reconciling the AutoScaling Group", zap.String("asgName", request.Detail.AutoScalingGroupName))
+	lh, err := h.lifecycle.forInvocation(request)
+	if err != nil {
+		h.logger.Error("Dropping event with invalid configuration overrides", zap.String("messageID", record.MessageId), zap.Error(err))
+		return nil
+	}
+	input, err := lh.hookInput(request)
+	if err != nil {
+		h.logger.Error("Dropping event with invalid hook settings", zap.String("messageID", record.MessageId), zap.Error(err))
+		return nil
+	}
+	cfg := lh.cfg
+	result, _, err := syncOrDefer(clients, cfg, request.Region, input, h.logger)
+	if err != nil {
+		return err
+	}
+	requestApproval(clients, cfg, input, result, h.logger)
+	alertFailures(clients, cfg, input, result, h.logger)
+	result.Drift = reportDrift(clients, cfg, input, result, h.logger)
+	followHealthChecks(clients, cfg, result, h.logger)
+	return nil
+}
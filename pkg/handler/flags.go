@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/flags"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"go.uber.org/zap"
+)
+
+// flagsRefresh is how long the flags of the AppConfig profile are reused before being read again
+const flagsRefresh = 30 * time.Second
+
+var profileFlags struct {
+	sync.Mutex
+	set    flags.Set
+	readAt time.Time
+}
+
+// Gets the feature flags: the config's, along with the AppConfig profile's when one is set. A failed read of the
+// profile is logged and the flags last read are kept.
+func featureFlags(cfg config.Config) flags.Set {
+	if cfg.FeatureFlagsProfile == "" {
+		return cfg.FeatureFlags
+	}
+	profileFlags.Lock()
+	defer profileFlags.Unlock()
+	if time.Since(profileFlags.readAt) > flagsRefresh {
+		set, err := flags.FromAppConfig(cfg.FeatureFlagsProfile)
+		if err != nil {
+			logging.New().Warn("Failed to read the feature flags profile", zap.String("profile", cfg.FeatureFlagsProfile), zap.Error(err))
+		} else {
+			profileFlags.set = set
+		}
+		profileFlags.readAt = time.Now()
+	}
+	return cfg.FeatureFlags.Merge(profileFlags.set)
+}
@@ -0,0 +1,58 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/fakeaws"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/handler"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// goldenEvents are the fixtures of the golden events, the seeds of the fuzzing
+const goldenEvents = "testdata/events/*.json"
+
+// Handles the mutations of the golden events. No mutation may panic, and no mutation the validation refuses may
+// reach the AWS clients:
+//
+//	go test -fuzz FuzzHandle ./pkg/handler
+func FuzzHandle(f *testing.F) {
+	paths, err := filepath.Glob(goldenEvents)
+	if err != nil || len(paths) == 0 {
+		f.Fatalf("no golden events in %s: %v", goldenEvents, err)
+	}
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(raw)
+	}
+	cfg := goldenConfig()
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		var request event.IncomingEvent
+		if json.Unmarshal(input, &request) != nil {
+			return
+		}
+		validateErr := request.Validate()
+		if request.IsManual() {
+			validateErr = request.ManualInvocation.Validate()
+		}
+
+		target.Reset()
+		env := fakeaws.New(fleet)
+		_, err := handler.New(cfg, env.Factory()).Handle(request)
+		var panicErr *handler.PanicError
+		if errors.As(err, &panicErr) {
+			t.Fatalf("the handler panicked on %q: %v", input, panicErr.Value)
+		}
+		if validateErr != nil && len(env.Calls()) != 0 {
+			t.Errorf("the invalid event %q (%v) made the calls %v", input, validateErr, env.Operations())
+		}
+	})
+}
@@ -0,0 +1,462 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	sqsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/fakeaws"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/handler"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/policy"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+const (
+	asgName = "web-asg"
+	sgID    = "sg-0123456789abcdef0"
+	// searchSGID is the Security Group of the search-launching hook of hookFleet
+	searchSGID = "sg-0fedcba9876543210"
+)
+
+// initialRules are the CIDRs the Security Group of the fleet starts with
+var initialRules = []string{"203.0.113.10/32", "203.0.113.11/32"}
+
+// The fleet of the cases: two instances in service, whose IPs the Security Group already allows, and a launching one
+var fleet = fakeaws.Fleet{
+	AutoScalingGroupName: asgName,
+	SecurityGroupID:      sgID,
+	Instances: []fakeaws.Instance{
+		{ID: "i-0000000000000000a", PublicIP: "203.0.113.10"},
+		{ID: "i-0000000000000000b", PublicIP: "203.0.113.11"},
+		{ID: "i-0000000000000000c", PublicIP: "203.0.113.12"},
+	},
+	Permissions: target.Permissions(target.DefaultRule, initialRules),
+}
+
+// Gets the config of the cases. It comes from the fleet alone, not from the environment the tests run in, which is
+// restored afterwards.
+func goldenConfig() config.Config {
+	environ := os.Environ()
+	defer func() {
+		os.Clearenv()
+		for _, variable := range environ {
+			key, value, _ := strings.Cut(variable, "=")
+			os.Setenv(key, value)
+		}
+	}()
+	os.Clearenv()
+	os.Setenv("securityGroupID", sgID)
+	os.Setenv("AWS_REGION", "us-east-1")
+	return config.FromEnv()
+}
+
+// Runs the lifecycle handler on the golden events of testdata/events, against in-memory EC2 and AutoScaling clients,
+// and checks the API calls it made and the Response it returned. A new case is a new entry of cases, with its fixture
+// in testdata/events when none of the existing ones fits.
+func TestGoldenEvents(t *testing.T) {
+	cfg := goldenConfig()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := run(cfg, c); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// expectation is what a case checks once the handler returned
+type expectation struct {
+	// operations are the API calls, in order
+	operations []string
+	added      []string
+	removed    []string
+	// rules are the CIDRs the Security Group allows afterwards, sorted
+	rules []string
+	// category is the category of the error, empty when the handler succeeds
+	category errs.Category
+	// lifecycleResult is the result the lifecycle action was completed with, empty when it wasn't
+	lifecycleResult string
+	// addedByInstance are the added CIDRs of every instance, sorted. Only checked when the case sets it.
+	addedByInstance map[string][]string
+	// otherRules are the rules the fleet's other Security Groups allow afterwards, as "<port> <cidr>", sorted. Only
+	// checked when the case sets it.
+	otherRules map[string][]string
+	// skipped are the skips of the response, as "<action> <reason> <cidr> <instance ID>", sorted. Only checked when the
+	// case sets it.
+	skipped []string
+}
+
+type goldenCase struct {
+	name  string
+	event string
+	want  expectation
+	// configure changes the config of the case, nil keeps it
+	configure func(cfg *config.Config)
+	// fleet replaces the fleet of the case, nil keeps it
+	fleet *fakeaws.Fleet
+	// batch delivers the event in an SQS batch to the batch handler instead, whose response has no IPs
+	batch bool
+	// batchedWith are the other events of the case's batch
+	batchedWith []string
+}
+
+// The fleet whose search-launching hook syncs another Security Group, which starts empty, on port 9200
+var hookFleet = func() fakeaws.Fleet {
+	f := fleet
+	f.OtherGroups = map[string][]*ec2.IpPermission{searchSGID: nil}
+	return f
+}()
+
+// The fleet whose launching instance is still pending, and so fails its status checks
+var pendingFleet = func() fakeaws.Fleet {
+	f := fleet
+	f.Instances = append([]fakeaws.Instance(nil), fleet.Instances...)
+	f.Instances[2].State = ec2.InstanceStateNamePending
+	return f
+}()
+
+// The fleet whose launching instance's Elastic IP isn't reflected by DescribeInstances yet
+var elasticIPFleet = func() fakeaws.Fleet {
+	f := fleet
+	f.Instances = append([]fakeaws.Instance(nil), fleet.Instances...)
+	f.Instances[2].PublicIP, f.Instances[2].ElasticIP = "", "203.0.113.12"
+	return f
+}()
+
+// The fleet whose Security Group holds a managed /24 rule, created while the mask was 24 for an instance that has been
+// replaced since
+var widenedFleet = func() fakeaws.Fleet {
+	f := fleet
+	f.Permissions = target.Permissions(target.DefaultRule, []string{"203.0.113.0/24"})
+	meta := target.RuleMeta{InstanceID: "i-0000000000000000d", Rule: target.DefaultRule.String()}
+	f.Permissions[0].IpRanges[0].Description = aws.String(target.Description(meta))
+	return f
+}()
+
+// The fleet whose launching instance has an IPv4 prefix delegated to its network interface
+var prefixFleet = func() fakeaws.Fleet {
+	f := fleet
+	f.Instances = append([]fakeaws.Instance(nil), fleet.Instances...)
+	f.Instances[2].Prefixes = []string{"10.0.1.16/28"}
+	return f
+}()
+
+var cases = []goldenCase{
+	{name: "launch adds the launching instance's IP", event: "launch.json", want: expectation{
+		operations:      []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups", "DescribeSecurityGroups", "AuthorizeSecurityGroupIngress", "DescribeSecurityGroups", "CompleteLifecycleAction"},
+		added:           []string{"203.0.113.12/32"},
+		rules:           []string{"203.0.113.10/32", "203.0.113.11/32", "203.0.113.12/32"},
+		lifecycleResult: "CONTINUE",
+	}},
+	{name: "terminate removes the terminating instance's IP", event: "terminate.json", want: expectation{
+		operations:      []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups", "DescribeSecurityGroups", "AuthorizeSecurityGroupIngress", "RevokeSecurityGroupIngress", "DescribeSecurityGroups", "CompleteLifecycleAction"},
+		added:           []string{"203.0.113.12/32"},
+		removed:         []string{"203.0.113.11/32"},
+		rules:           []string{"203.0.113.10/32", "203.0.113.12/32"},
+		lifecycleResult: "CONTINUE",
+	}},
+	{
+		name:  "remove-first revokes the terminating instance's IP before authorizing",
+		event: "terminate.json",
+		want: expectation{
+			operations:      []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups", "DescribeSecurityGroups", "RevokeSecurityGroupIngress", "AuthorizeSecurityGroupIngress", "DescribeSecurityGroups", "CompleteLifecycleAction"},
+			added:           []string{"203.0.113.12/32"},
+			removed:         []string{"203.0.113.11/32"},
+			rules:           []string{"203.0.113.10/32", "203.0.113.12/32"},
+			lifecycleResult: "CONTINUE",
+		},
+		configure: func(cfg *config.Config) { cfg.ApplyOrder = policy.RemoveFirst },
+	},
+	{
+		name:  "launch skips the launching instance's IP within the denied CIDRs",
+		event: "launch.json",
+		want: expectation{
+			operations:      []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups", "CompleteLifecycleAction"},
+			rules:           initialRules,
+			lifecycleResult: "CONTINUE",
+			skipped:         []string{"add denylisted 203.0.113.12/32 i-0000000000000000c"},
+		},
+		configure: func(cfg *config.Config) { cfg.DeniedCIDRs = []string{"203.0.113.12/31"} },
+	},
+	{
+		name:  "launch leaves the additions beyond the rules quota to the next syncs",
+		event: "launch.json",
+		want: expectation{
+			operations:      []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups", "CompleteLifecycleAction"},
+			rules:           initialRules,
+			lifecycleResult: "CONTINUE",
+			skipped:         []string{"add over_quota 203.0.113.12/32 i-0000000000000000c"},
+		},
+		configure: func(cfg *config.Config) { cfg.RulesQuota = 2 },
+	},
+	{
+		name:  "launch discovers the IPs through the network interfaces",
+		event: "launch.json",
+		want: expectation{
+			operations:      []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeNetworkInterfacesPages", "DescribeSecurityGroups", "DescribeSecurityGroups", "AuthorizeSecurityGroupIngress", "DescribeSecurityGroups", "CompleteLifecycleAction"},
+			added:           []string{"203.0.113.12/32"},
+			rules:           []string{"203.0.113.10/32", "203.0.113.11/32", "203.0.113.12/32"},
+			lifecycleResult: "CONTINUE",
+		},
+		configure: func(cfg *config.Config) { cfg.IPDiscovery = source.DiscoveryInterfaces },
+	},
+	{
+		name:  "a launch that fails its status checks is abandoned",
+		event: "launch.json",
+		want: expectation{
+			operations:      []string{"DescribeLifecycleHooks", "WaitUntilInstanceStatusOk", "CompleteLifecycleAction"},
+			rules:           initialRules,
+			category:        errs.Source,
+			lifecycleResult: "ABANDON",
+		},
+		configure: func(cfg *config.Config) { cfg.StatusCheckWait = time.Second },
+		fleet:     &pendingFleet,
+	},
+	{
+		name:  "launch falls back to the launching instance's Elastic IP",
+		event: "launch.json",
+		want: expectation{
+			operations:      []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeAddresses", "DescribeSecurityGroups", "DescribeSecurityGroups", "AuthorizeSecurityGroupIngress", "DescribeSecurityGroups", "CompleteLifecycleAction"},
+			added:           []string{"203.0.113.12/32"},
+			rules:           []string{"203.0.113.10/32", "203.0.113.11/32", "203.0.113.12/32"},
+			lifecycleResult: "CONTINUE",
+		},
+		fleet: &elasticIPFleet,
+	},
+	{
+		name:  "launch adds the launching instance's delegated prefix instead of its IP",
+		event: "launch.json",
+		want: expectation{
+			operations:      []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups", "DescribeSecurityGroups", "AuthorizeSecurityGroupIngress", "DescribeSecurityGroups", "CompleteLifecycleAction"},
+			added:           []string{"10.0.1.16/28"},
+			rules:           []string{"10.0.1.16/28", "203.0.113.10/32", "203.0.113.11/32"},
+			lifecycleResult: "CONTINUE",
+		},
+		configure: func(cfg *config.Config) { cfg.PrefixDelegation = source.PrefixesReplace },
+		fleet:     &prefixFleet,
+	},
+	{
+		name:  "launch adds the launching instance's delegated prefix along with its IP",
+		event: "launch.json",
+		want: expectation{
+			operations:      []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups", "DescribeSecurityGroups", "AuthorizeSecurityGroupIngress", "DescribeSecurityGroups", "CompleteLifecycleAction"},
+			added:           []string{"10.0.1.16/28", "203.0.113.12/32"},
+			rules:           []string{"10.0.1.16/28", "203.0.113.10/32", "203.0.113.11/32", "203.0.113.12/32"},
+			lifecycleResult: "CONTINUE",
+			addedByInstance: map[string][]string{"i-0000000000000000c": {"10.0.1.16/28", "203.0.113.12/32"}},
+		},
+		configure: func(cfg *config.Config) { cfg.PrefixDelegation = source.PrefixesAdd },
+		fleet:     &prefixFleet,
+	},
+	{
+		name:  "launch replaces the instances' IPs with the /24 containing them",
+		event: "launch.json",
+		want: expectation{
+			operations:      []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups", "DescribeSecurityGroups", "AuthorizeSecurityGroupIngress", "RevokeSecurityGroupIngress", "DescribeSecurityGroups", "CompleteLifecycleAction"},
+			added:           []string{"203.0.113.0/24"},
+			removed:         []string{"203.0.113.10/32", "203.0.113.11/32"},
+			rules:           []string{"203.0.113.0/24"},
+			lifecycleResult: "CONTINUE",
+		},
+		configure: func(cfg *config.Config) { cfg.RuleCIDRMask = 24 },
+	},
+	{
+		name:  "launch replaces the managed /24 with the instances' IPs once the mask is back to 32",
+		event: "launch.json",
+		want: expectation{
+			operations:      []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups", "DescribeSecurityGroups", "AuthorizeSecurityGroupIngress", "RevokeSecurityGroupIngress", "DescribeSecurityGroups", "CompleteLifecycleAction"},
+			added:           []string{"203.0.113.10/32", "203.0.113.11/32", "203.0.113.12/32"},
+			removed:         []string{"203.0.113.0/24"},
+			rules:           []string{"203.0.113.10/32", "203.0.113.11/32", "203.0.113.12/32"},
+			lifecycleResult: "CONTINUE",
+		},
+		fleet: &widenedFleet,
+	},
+	{
+		name:  "a failed launch gets the launch's own lifecycle result",
+		event: "launch.json",
+		want: expectation{
+			operations:      []string{"DescribeLifecycleHooks", "WaitUntilInstanceStatusOk", "CompleteLifecycleAction"},
+			rules:           initialRules,
+			category:        errs.Source,
+			lifecycleResult: "CONTINUE",
+		},
+		configure: func(cfg *config.Config) {
+			cfg.StatusCheckWait, cfg.LaunchFailureLifecycleResult, cfg.TerminateFailureLifecycleResult = time.Second, "CONTINUE", "ABANDON"
+		},
+		fleet: &pendingFleet,
+	},
+	{name: "a manual invocation dry runs the reconcile", event: "manual.json", want: expectation{
+		operations: []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups"},
+		added:      []string{"203.0.113.12/32"},
+		rules:      initialRules,
+	}},
+	{name: "the event's layer dry runs the launch", event: "launch-dry-run.json", want: expectation{
+		operations:      []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups", "CompleteLifecycleAction"},
+		added:           []string{"203.0.113.12/32"},
+		rules:           initialRules,
+		lifecycleResult: "CONTINUE",
+	}},
+	{name: "the event's layer can't set the Security Group", event: "launch-refused-override.json", want: expectation{
+		operations:      []string{"CompleteLifecycleAction"},
+		rules:           initialRules,
+		category:        errs.Config,
+		lifecycleResult: "ABANDON",
+	}},
+	{
+		name:  "the launching hook's target dry runs the launch",
+		event: "launch.json",
+		want: expectation{
+			operations:      []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups", "CompleteLifecycleAction"},
+			added:           []string{"203.0.113.12/32"},
+			rules:           initialRules,
+			lifecycleResult: "CONTINUE",
+		},
+		configure: func(cfg *config.Config) {
+			cfg.HookTargets = map[string]event.HookSettings{"sg-sync-launching": {Config: json.RawMessage(`{"dryRun":true}`)}}
+		},
+	},
+	{
+		name:  "a batched launch is a dry run too",
+		event: "launch.json",
+		want: expectation{
+			operations:      []string{"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups", "CompleteLifecycleAction"},
+			rules:           initialRules,
+			lifecycleResult: "CONTINUE",
+		},
+		configure: func(cfg *config.Config) { cfg.DryRun = true },
+		batch:     true,
+	},
+	{
+		name:  "a batch keeps the events of hooks that sync different Security Groups apart",
+		event: "launch.json",
+		want: expectation{
+			operations: []string{
+				"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups", "DescribeSecurityGroups", "AuthorizeSecurityGroupIngress", "CompleteLifecycleAction",
+				"DescribeSecurityGroups", "DescribeAutoScalingGroups", "DescribeInstancesPages", "DescribeSecurityGroups", "DescribeSecurityGroups", "AuthorizeSecurityGroupIngress", "CompleteLifecycleAction",
+			},
+			rules:           []string{"203.0.113.10/32", "203.0.113.11/32", "203.0.113.12/32"},
+			lifecycleResult: "CONTINUE",
+			otherRules:      map[string][]string{searchSGID: {"9200 203.0.113.10/32", "9200 203.0.113.11/32", "9200 203.0.113.12/32"}},
+		},
+		configure: func(cfg *config.Config) {
+			cfg.HookTargets = map[string]event.HookSettings{"search-launching": {SecurityGroupID: searchSGID, Port: 9200}}
+		},
+		fleet:       &hookFleet,
+		batch:       true,
+		batchedWith: []string{"launch-search.json"},
+	},
+	{name: "a test notification changes nothing", event: "test-notification.json", want: expectation{rules: initialRules}},
+	{name: "a scheduled event is a Config error", event: "scheduled.json", want: expectation{rules: initialRules, category: errs.Config}},
+	{name: "a malformed event is a Config error", event: "malformed.json", want: expectation{rules: initialRules, category: errs.Config}},
+}
+
+// Runs a new handler on the case's event, against a new fleet, and checks the outcome
+func run(cfg config.Config, c goldenCase) error {
+	raw, err := os.ReadFile(filepath.Join("testdata", "events", c.event))
+	if err != nil {
+		return err
+	}
+	var request event.IncomingEvent
+	if err := json.Unmarshal(raw, &request); err != nil {
+		return fmt.Errorf("decode %s: %w", c.event, err)
+	}
+
+	if c.configure != nil {
+		c.configure(&cfg)
+	}
+	f := fleet
+	if c.fleet != nil {
+		f = *c.fleet
+	}
+	target.Reset()
+	env := fakeaws.New(f)
+	var response handler.Response
+	if c.batch {
+		records := []sqsevents.SQSMessage{{MessageId: "1", Body: string(raw)}}
+		for i, name := range c.batchedWith {
+			body, err := os.ReadFile(filepath.Join("testdata", "events", name))
+			if err != nil {
+				return err
+			}
+			records = append(records, sqsevents.SQSMessage{MessageId: fmt.Sprint(i + 2), Body: string(body)})
+		}
+		_, err = handler.NewBatch(cfg, env.Factory()).Handle(sqsevents.SQSEvent{Records: records})
+	} else {
+		response, err = handler.New(cfg, env.Factory()).Handle(request)
+	}
+
+	var got expectation
+	got.operations = env.Operations()
+	got.added, got.removed = response.AddedIPs, response.RemovedIPs
+	got.rules = rules(env.EC2.Permissions(sgID))
+	if err != nil {
+		got.category = errs.CategoryOf(err)
+	}
+	for _, call := range env.Calls() {
+		if input, ok := call.Input.(*autoscaling.CompleteLifecycleActionInput); ok {
+			got.lifecycleResult = aws.StringValue(input.LifecycleActionResult)
+		}
+	}
+	if c.want.addedByInstance != nil {
+		got.addedByInstance = make(map[string][]string, len(response.AddedByInstance))
+		for instanceID, cidrs := range response.AddedByInstance {
+			got.addedByInstance[instanceID] = append([]string(nil), cidrs...)
+			sort.Strings(got.addedByInstance[instanceID])
+		}
+	}
+	if c.want.otherRules != nil {
+		got.otherRules = make(map[string][]string)
+		for otherID := range f.OtherGroups {
+			got.otherRules[otherID] = portRules(env.EC2.Permissions(otherID))
+		}
+	}
+	if c.want.skipped != nil {
+		for _, skip := range response.Skipped {
+			got.skipped = append(got.skipped, fmt.Sprintf("%s %s %s %s", skip.Action, skip.Reason, skip.CIDR, skip.InstanceID))
+		}
+		sort.Strings(got.skipped)
+	}
+	if !reflect.DeepEqual(got, c.want) {
+		return fmt.Errorf("got %+v (error: %v), want %+v", got, err, c.want)
+	}
+	return nil
+}
+
+// Gets the CIDRs of the permissions, sorted
+func rules(perms []*ec2.IpPermission) []string {
+	var cidrs []string
+	for _, perm := range perms {
+		for _, ipRange := range perm.IpRanges {
+			cidrs = append(cidrs, aws.StringValue(ipRange.CidrIp))
+		}
+	}
+	sort.Strings(cidrs)
+	return cidrs
+}
+
+// Gets the rules of the permissions as "<port> <cidr>", sorted
+func portRules(perms []*ec2.IpPermission) []string {
+	var rules []string
+	for _, perm := range perms {
+		for _, ipRange := range perm.IpRanges {
+			rules = append(rules, fmt.Sprintf("%d %s", aws.Int64Value(perm.FromPort), aws.StringValue(ipRange.CidrIp)))
+		}
+	}
+	sort.Strings(rules)
+	return rules
+}
@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	lambdasvc "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/bluegreen"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/lifecycle"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/queue"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/version"
+	"go.uber.org/zap"
+)
+
+// Response returns the list of IPs that were added and removed, along with the rest of the sync's result
+type Response struct {
+	// SchemaVersion is the version of the response's shape, see SchemaVersion
+	SchemaVersion int `json:"schema_version"`
+	// Build identifies the build that made the changes
+	Build *version.Info `json:"build,omitempty"`
+	// SecurityGroupID is the Security Group of the result, when a single one was synced
+	SecurityGroupID string `json:"sg_id,omitempty"`
+	syncer.Result
+	// DeferredRemovals are the IPs whose removal was enqueued for a delayed sync
+	DeferredRemovals []string `json:"deferred_removals,omitempty"`
+	// AsyncApply is true when the lifecycle action was completed right away and the changes are applied by an
+	// asynchronous invocation
+	AsyncApply bool `json:"async_apply,omitempty"`
+	// TargetGroups are the target groups the instance was registered with or deregistered from
+	TargetGroups []string `json:"target_groups,omitempty"`
+	// Targets are the results of every Security Group, when several are synced
+	Targets []syncer.TargetResult `json:"targets,omitempty"`
+	// CreatedSecurityGroupID is the Security Group the function created, when none was configured
+	CreatedSecurityGroupID string `json:"created_security_group_id,omitempty"`
+	// FeatureFlags are the feature flags enabled for the AutoScaling Group
+	FeatureFlags []string `json:"feature_flags,omitempty"`
+	// DeferredUntil is when the maintenance window that deferred the changes closes. The result is the changes the
+	// sync would have made.
+	DeferredUntil *time.Time `json:"deferred_until,omitempty"`
+	// Retries are the retries of the event's AWS calls, when it has a retry budget and retried any
+	Retries *retry.Report `json:"retries,omitempty"`
+	// BlueGreen is the swap of the Security Group for a green copy with the changes, in the blue/green mode
+	BlueGreen *bluegreen.Swap `json:"blue_green,omitempty"`
+	// Tenant is the tenant of the AutoScaling Group, by its tenant tag
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// LifecycleHandler handles the AutoScaling Lifecycle Hook events using the clients built by its Factory
+type LifecycleHandler struct {
+	newClients awsclient.Factory
+	cfg        config.Config
+	cfgErr     error
+	logger     *zap.Logger
+}
+
+// New creates a LifecycleHandler that builds its AWS clients with newClients.
+// It is meant to be created once, at cold start, and reused across invocations.
+func New(cfg config.Config, newClients awsclient.Factory) *LifecycleHandler {
+	configure(cfg)
+	h := &LifecycleHandler{newClients: newClients, cfg: cfg, cfgErr: cfg.Validate(), logger: logging.New()}
+	if h.cfgErr != nil {
+		h.logger.Error("Invalid configuration", zap.Error(h.cfgErr))
+	}
+	return h
+}
+
+// PanicError is returned when the handler panicked. The lifecycle action has been completed with the failure result.
+type PanicError struct {
+	Value interface{} `json:"value"`
+	Stack string      `json:"stack"`
+}
+
+func (e *PanicError) Error() string { return fmt.Sprintf("handler panicked: %v", e.Value) }
+
+// Handle Automatically update (add/remove) a specific security group's rules based on the public IPs of an autoscaling group's managed EC2 instances.
+// This lambda function is initiated by AutoScaling Lifecycle Hooks.
+func (h *LifecycleHandler) Handle(request event.IncomingEvent) (response Response, err error) {
+	defer h.logger.Sync()
+	if !event.ValidRegion(request.Region) {
+		// The clients, the metrics' included, are never built for a malformed region
+		err = errs.Errorf(errs.Config, "validate event", "%q is not a valid region", request.Region)
+		h.logger.Error("Invalid event", zap.Error(err))
+		return Response{SchemaVersion: SchemaVersion, Build: build()}, err
+	}
+	if request.Region == "" {
+		// Manual invocations carry no region, the outcome's metrics and reports are of the function's
+		request.Region = awsclient.DefaultRegion()
+	}
+	if request.IsManual() {
+		// The reconcile records the outcome itself
+		return h.handleManual(request)
+	}
+	started := time.Now()
+	budget := retry.Start(h.cfg.RetryBudget)
+	defer retry.Stop(budget)
+	defer func() {
+		response.SchemaVersion = SchemaVersion
+		response.Build = build()
+		response.Retries = budget.Report()
+		asgName, sgID := request.Detail.AutoScalingGroupName, response.SecurityGroupID
+		if sgID == "" {
+			sgID = h.cfg.SecurityGroupID
+		}
+		recordOutcome(h.newClients, h.cfg, syncOutcome{request.Region, asgName, sgID, combinedResult(response), started, err}, h.logger)
+		streamChanges(h.newClients, h.cfg, request.Region, asgName, response.Targets, h.logger)
+		trackFailures(h.newClients, h.cfg, request.Region, asgName, sgID, err, h.logger)
+		reportError(h.newClients, h.cfg, errorReport{request.Region, request.ID, asgName, sgID, err}, h.logger)
+		h.escalate(request, response, err)
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			err = h.recoverPanic(request, r)
+		}
+	}()
+	return h.handle(request)
+}
+
+// Logs the panic with its stack and completes the lifecycle action with the failure result, so that the
+// instance doesn't hang until the hook's heartbeat timeout
+func (h *LifecycleHandler) recoverPanic(request event.IncomingEvent, r interface{}) error {
+	panicErr := &PanicError{Value: r, Stack: string(debug.Stack())}
+	h.logger.Error("Handler panicked", zap.Any("panic", r), zap.String("stack", panicErr.Stack))
+
+	clients, err := h.newClients(request.Region)
+	if err != nil {
+		h.logger.Error("Failed to create session", zap.Error(err))
+		return panicErr
+	}
+	h.completeLifecycle(clients, request, h.cfg.FailureResult(request.Detail.IsTerminating()))
+	return panicErr
+}
+
+// Completes the lifecycle action, logging failures. The sync's own outcome is what gets returned to the caller.
+// Asynchronous invocations skip it, the action has already been completed, and so do the invalid events, which carry
+// no action to complete.
+func (h *LifecycleHandler) completeLifecycle(clients awsclient.Clients, request event.IncomingEvent, result string) {
+	if request.AsyncApply || request.Validate() != nil {
+		return
+	}
+	if err := lifecycle.Complete(clients.AutoScaling, request.Detail, result); err != nil {
+		h.logger.Error("Failed to complete the lifecycle action", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+	}
+}
+
+func (h *LifecycleHandler) handle(request event.IncomingEvent) (response Response, err error) {
+	logger := h.logger
+	logger.Info("IncomingEvent", zap.Any("Request", request))
+
+	clients, err := h.newClients(request.Region)
+	if err != nil {
+		logger.Error("Failed to create session", zap.Error(err))
+		return response, errs.Wrap(errs.Config, "create session", err)
+	}
+
+	if request.IsReplay() {
+		return h.handleReplay(clients, request)
+	}
+	ih, err := h.forInvocation(request)
+	if err != nil {
+		h.completeLifecycle(clients, request, h.cfg.FailureResult(request.Detail.IsTerminating()))
+		return response, err
+	}
+	th, tenant, err := ih.forTenant(clients, request)
+	if err != nil {
+		h.completeLifecycle(clients, request, h.cfg.FailureResult(request.Detail.IsTerminating()))
+		return Response{Tenant: tenant}, err
+	}
+	response, err = th.run(&pipelineContext{request: request, clients: clients}, th.pipeline(request))
+	response.Tenant = tenant
+	return response, err
+}
+
+// Builds the sync input of the event, with the settings of its hook over the config's
+func (h *LifecycleHandler) hookInput(request event.IncomingEvent) (syncer.Input, error) {
+	input := newInput(h.cfg, request.Detail.AutoScalingGroupName, h.cfg.SecurityGroupID)
+	settings, err := h.hookSettings(request)
+	if err != nil {
+		return input, err
+	}
+	return withHookSettings(input, settings)
+}
+
+// Gets the settings of the event's hook: the ones hookTargets maps its name to, with its NotificationMetadata's over
+// them, so that the hooks of an AutoScaling Group can sync different Security Groups and ports
+func (h *LifecycleHandler) hookSettings(request event.IncomingEvent) (event.HookSettings, error) {
+	metadata, err := request.Detail.Settings()
+	if err != nil {
+		return metadata, err
+	}
+	return h.cfg.HookSettings(request.Detail.LifecycleHookName, metadata), nil
+}
+
+// Enqueues the removal of the terminating instance's suppressed IPs, so that a delayed sync removes them once the
+// instance is gone. Returns the IPs that were enqueued.
+func (h *LifecycleHandler) deferRemovals(clients awsclient.Clients, request event.IncomingEvent, input syncer.Input, result syncer.Result) []string {
+	if h.cfg.RemovalDelayQueueURL == "" || len(result.SuppressedRemovals) == 0 || result.DryRun {
+		return nil
+	}
+	err := queue.EnqueueRemoval(clients.SQS, h.cfg.RemovalDelayQueueURL, h.cfg.RemovalDelay, queue.RemovalMessage{
+		Region:               request.Region,
+		AutoScalingGroupName: input.AutoScalingGroupName,
+		SecurityGroupID:      input.SecurityGroupID,
+		Rules:                input.Rules,
+		InstanceID:           input.ExcludeInstanceID,
+		CIDRs:                result.SuppressedRemovals,
+		EnqueuedAt:           time.Now().UTC(),
+	})
+	if err != nil {
+		h.logger.Error("Failed to enqueue the delayed removal", zap.Error(err))
+		return nil
+	}
+	h.logger.Info("Removal deferred", zap.Any("deferredRemovals", result.SuppressedRemovals), zap.Duration("delay", h.cfg.RemovalDelay))
+	return result.SuppressedRemovals
+}
+
+// Invokes the function asynchronously with the event. Without a Lambda client, e.g. when the feature flag enabled the
+// asynchronous apply after the clients were built, the invocation fails and the caller applies inline.
+func invokeAsync(lambdaSvc lambdaiface.LambdaAPI, functionName string, request event.IncomingEvent) error {
+	if lambdaSvc == nil {
+		return errs.Errorf(errs.Config, "invoke async", "no Lambda client")
+	}
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return errs.Wrap(errs.Config, "invoke async", err)
+	}
+	_, err = lambdaSvc.Invoke(&lambdasvc.InvokeInput{
+		FunctionName:   aws.String(functionName),
+		InvocationType: aws.String(lambdasvc.InvocationTypeEvent),
+		Payload:        payload,
+	})
+	return errs.Wrap(errs.Target, "invoke async", err)
+}
+
+// Combines the IPs added and removed in every Security Group of the response, for the metrics
+func combinedResult(response Response) syncer.Result {
+	result := response.Result
+	for _, t := range response.Targets {
+		result.AddedIPs = append(result.AddedIPs, t.AddedIPs...)
+		result.RemovedIPs = append(result.RemovedIPs, t.RemovedIPs...)
+	}
+	return result
+}
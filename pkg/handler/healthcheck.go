@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/healthcheck"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"go.uber.org/zap"
+)
+
+// Creates the Route 53 health checks of the added IPs and deletes the ones of the removed IPs, if enabled. Failures
+// are logged, the health checks are best effort.
+func followHealthChecks(clients awsclient.Clients, cfg config.Config, result syncer.Result, logger *zap.Logger) {
+	if !cfg.HealthChecks || result.DryRun {
+		return
+	}
+	settings := healthcheck.Settings{Type: cfg.HealthCheckType, Port: cfg.HealthCheckPort, Path: cfg.HealthCheckPath}
+	for _, cidr := range result.AddedIPs {
+		if err := healthcheck.Create(clients.Route53, cidr, result.Owners[cidr], settings); err != nil {
+			logger.Error("Failed to create the health check", zap.String("cidr", cidr), zap.Error(err))
+		}
+	}
+	removed := append(append(append([]string{}, result.RemovedIPs...), result.CollectedOrphans...), result.ExpiredRules...)
+	if len(removed) == 0 {
+		return
+	}
+	if err := healthcheck.Delete(clients.Route53, removed, settings); err != nil {
+		logger.Error("Failed to delete the health checks", zap.Strings("cidrs", removed), zap.Error(err))
+	}
+}
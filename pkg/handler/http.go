@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"go.uber.org/zap"
+)
+
+// ActionSync updates the Security Group
+const ActionSync = "sync"
+
+// ActionDryRun only calculates the IPs that would be added and removed
+const ActionDryRun = "dry-run"
+
+// ActionApproveRemovals updates the Security Group, removing only the approved IPs that are still stale
+const ActionApproveRemovals = "approve-removals"
+
+// SyncRequest is the JSON body of a manual "sync now" HTTP request
+type SyncRequest struct {
+	AutoScalingGroupName string `json:"asgName"`
+	SecurityGroupID      string `json:"sgID"`
+	Action               string `json:"action"`
+	// Port, when set, restricts the sync to the tcp rule of this port instead of the configured rules
+	Port int64 `json:"port"`
+	// ApprovedRemovals are the IPs approved for removal, used by ActionApproveRemovals
+	ApprovedRemovals []string `json:"approvedRemovals"`
+	// Config is the request's layer of settings, e.g. {"dryRun":true}
+	Config json.RawMessage `json:"sgSyncConfig,omitempty"`
+}
+
+// HTTPHandler handles manual sync requests coming from API Gateway (HTTP API) or a Lambda Function URL.
+// Authentication is left to the caller's integration (IAM auth or an authorizer).
+type HTTPHandler struct {
+	newClients awsclient.Factory
+	cfg        config.Config
+	logger     *zap.Logger
+}
+
+// NewHTTP creates a HTTPHandler that builds its AWS clients with newClients.
+// It is meant to be created once, at cold start, and reused across invocations.
+func NewHTTP(cfg config.Config, newClients awsclient.Factory) *HTTPHandler {
+	configure(cfg)
+	return &HTTPHandler{newClients: newClients, cfg: cfg, logger: logging.New()}
+}
+
+// Handle runs the sync described by the request's body and returns the result as JSON
+func (h *HTTPHandler) Handle(request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	logger := h.logger
+	defer logger.Sync()
+
+	syncRequest, err := parseSyncRequest(request, h.cfg.DefaultAutoScalingGroup(), h.cfg.SecurityGroupID)
+	if err != nil {
+		logger.Error("Invalid sync request", zap.Error(err))
+		return jsonResponse(http.StatusBadRequest, map[string]string{"error": err.Error()}), nil
+	}
+	logger.Info("SyncRequest", zap.Any("Request", syncRequest))
+	cfg, err := withEventLayer(h.cfg, syncRequest.Config, logger)
+	if err != nil {
+		return jsonResponse(http.StatusBadRequest, map[string]string{"error": err.Error()}), nil
+	}
+	h = &HTTPHandler{newClients: h.newClients, cfg: cfg, logger: logger}
+	budget := retry.Start(h.cfg.RetryBudget)
+	defer retry.Stop(budget)
+
+	clients, err := h.newClients(awsclient.DefaultRegion())
+	if err != nil {
+		logger.Error("Failed to create session", zap.Error(err))
+		return jsonResponse(http.StatusInternalServerError, map[string]string{"error": err.Error()}), nil
+	}
+	if syncRequest.AutoScalingGroupName, err = source.ResolveGroupName(syncRequest.AutoScalingGroupName, clients.ElasticBeanstalk); err != nil {
+		logger.Error("Failed to resolve the AutoScaling Group", zap.Error(err))
+		return jsonResponse(statusOf(err), map[string]string{"error": err.Error(), "category": string(errs.CategoryOf(err))}), nil
+	}
+
+	input := newInput(h.cfg, syncRequest.AutoScalingGroupName, syncRequest.SecurityGroupID)
+	input = withPort(input, syncRequest.Port)
+	input.DryRun = input.DryRun || syncRequest.Action == ActionDryRun
+	input.CollectOrphans = h.cfg.CollectOrphans
+	if syncRequest.Action == ActionApproveRemovals {
+		input.ApprovedRemovals = append([]string{}, syncRequest.ApprovedRemovals...)
+	}
+
+	started := time.Now()
+	result, until, err := syncOrDefer(clients, h.cfg, awsclient.DefaultRegion(), input, logger)
+	recordOutcome(h.newClients, h.cfg, syncOutcome{awsclient.DefaultRegion(), input.AutoScalingGroupName, input.SecurityGroupID, result, started, err}, logger)
+	trackFailures(h.newClients, h.cfg, awsclient.DefaultRegion(), input.AutoScalingGroupName, input.SecurityGroupID, err, logger)
+	reportError(h.newClients, h.cfg, errorReport{awsclient.DefaultRegion(), request.RequestContext.RequestID, input.AutoScalingGroupName, input.SecurityGroupID, err}, logger)
+	if err != nil {
+		body := map[string]interface{}{"error": err.Error(), "category": string(errs.CategoryOf(err))}
+		if len(result.CIDRFailures) != 0 {
+			body["cidr_failures"] = result.CIDRFailures
+		}
+		return jsonResponse(statusOf(err), body), nil
+	}
+	requestApproval(clients, h.cfg, input, result, logger)
+	alertFailures(clients, h.cfg, input, result, logger)
+	result.Drift = reportDrift(clients, h.cfg, input, result, logger)
+	followHealthChecks(clients, h.cfg, result, logger)
+	return jsonResponse(http.StatusOK, Response{SchemaVersion: SchemaVersion, Build: build(), Result: result, DeferredUntil: deferredUntil(until)}), nil
+}
+
+// Decodes and validates the request's body. The AutoScaling Group and Security Group default to defaultASG and
+// defaultSGID.
+func parseSyncRequest(request events.APIGatewayV2HTTPRequest, defaultASG string, defaultSGID string) (syncRequest SyncRequest, err error) {
+	body := []byte(request.Body)
+	if request.IsBase64Encoded {
+		if body, err = base64.StdEncoding.DecodeString(request.Body); err != nil {
+			return syncRequest, err
+		}
+	}
+	if err := json.Unmarshal(body, &syncRequest); err != nil {
+		return syncRequest, err
+	}
+
+	if syncRequest.AutoScalingGroupName == "" {
+		syncRequest.AutoScalingGroupName = defaultASG
+	}
+	if syncRequest.SecurityGroupID == "" {
+		syncRequest.SecurityGroupID = defaultSGID
+	}
+	if syncRequest.Action == "" {
+		syncRequest.Action = ActionSync
+	}
+	if syncRequest.AutoScalingGroupName == "" || syncRequest.SecurityGroupID == "" {
+		return syncRequest, fmt.Errorf("asgName and sgID are required")
+	}
+	if syncRequest.Action != ActionSync && syncRequest.Action != ActionDryRun && syncRequest.Action != ActionApproveRemovals {
+		return syncRequest, fmt.Errorf("unknown action %q", syncRequest.Action)
+	}
+	return syncRequest, nil
+}
+
+// Maps the error's category to an HTTP status code
+func statusOf(err error) int {
+	switch errs.CategoryOf(err) {
+	case errs.Config:
+		return http.StatusBadRequest
+	case errs.Throttle:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// Builds an HTTP response with the JSON encoded body
+func jsonResponse(status int, body interface{}) events.APIGatewayV2HTTPResponse {
+	out, _ := json.Marshal(body)
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(out),
+	}
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/incident"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/policy"
+	"go.uber.org/zap"
+)
+
+// Starts an Incident Manager incident when the sync of a critical Security Group left a terminating instance's IP
+// unrevoked or abandoned a launching instance. Failures are logged, they never fail the invocation.
+func (h *LifecycleHandler) escalate(request event.IncomingEvent, response Response, syncErr error) {
+	if h.cfg.IncidentResponsePlanARN == "" || request.IsReplay() {
+		return
+	}
+	input, err := h.hookInput(request)
+	if err != nil || !h.cfg.Critical(input.SecurityGroupID) {
+		return
+	}
+
+	var reason string
+	switch {
+	case request.Detail.IsTerminating() && (syncErr != nil || failedStage(response, policy.StageRemove)):
+		reason = "the IPs of a terminating instance were not revoked"
+	case !request.Detail.IsTerminating() && syncErr != nil && !request.AsyncApply && h.cfg.FailureResult(false) == "ABANDON":
+		reason = "a launching instance was abandoned"
+	default:
+		return
+	}
+
+	details := incident.Details{
+		AutoScalingGroupName: request.Detail.AutoScalingGroupName,
+		SecurityGroupID:      input.SecurityGroupID,
+		InstanceID:           request.Detail.EC2InstanceID,
+		Transition:           request.Detail.LifecycleTransition,
+		Reason:               reason,
+		AddedIPs:             response.AddedIPs,
+		RemovedIPs:           response.RemovedIPs,
+		PendingRemovals:      response.PendingRemovals,
+		Failures:             response.Failures,
+		At:                   time.Now().UTC(),
+	}
+	if syncErr != nil {
+		details.Category, details.Error = errs.CategoryOf(syncErr), syncErr.Error()
+	}
+
+	clients, err := h.newClients(request.Region)
+	if err != nil {
+		h.logger.Error("Failed to create session", zap.Error(err))
+		return
+	}
+	arn, err := incident.Start(clients.SSMIncidents, h.cfg.IncidentResponsePlanARN, details)
+	if err != nil {
+		h.logger.Error("Failed to start the incident", zap.Error(err))
+		return
+	}
+	h.logger.Warn("Incident started", zap.String("incidentRecordArn", arn), zap.String("reason", reason))
+}
+
+// Returns true when the policy tolerated a failure of the stage
+func failedStage(response Response, stage policy.Stage) bool {
+	for _, failure := range response.Failures {
+		if failure.Stage == stage {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/flags"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/state"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// Applies the process-wide settings of the config. Every handler constructor calls it.
+func configure(cfg config.Config) {
+	target.CacheTTL = cfg.SecurityGroupCacheTTL
+	target.MutationRate, target.MutationBurst = cfg.MutationRate, cfg.MutationBurst
+	source.ENIDeviceIndex, source.Discovery = cfg.ENIDeviceIndex, cfg.IPDiscovery
+	source.PrefixDelegation = cfg.PrefixDelegation
+	target.Namespace = cfg.OwnershipNamespace
+}
+
+// Builds the sync input of the AutoScaling Group and Security Group, with the settings that come from the config and
+// the feature flags of the AutoScaling Group
+func newInput(cfg config.Config, asgName string, sgID string) syncer.Input {
+	featureFlags := featureFlags(cfg)
+	return syncer.Input{
+		AutoScalingGroupName:     asgName,
+		SecurityGroupID:          sgID,
+		RemovalApprovalThreshold: cfg.RemovalApprovalThreshold,
+		MaxRemovals:              cfg.MaxRemovals,
+		RulesQuota:               cfg.RulesQuota,
+		DeniedCIDRs:              cfg.DeniedCIDRs,
+		ExpectedVpcID:            cfg.ExpectedVpcID,
+		RequireSameVPC:           cfg.RequireSameVPC,
+		AllowBroadRemovals:       cfg.AllowBroadRemovals,
+		DryRun:                   cfg.DryRun,
+		RuleCIDRMask:             cfg.RuleCIDRMask,
+		AggregateCIDRs:           cfg.AggregateCIDRs || featureFlags.Enabled(flags.Aggregation, asgName),
+		StrictRemoval:            featureFlags.Enabled(flags.StrictRemoval, asgName),
+		MaxRuleAge:               cfg.MaxRuleAge,
+		RemovalCooldown:          cfg.RemovalCooldown,
+		RespectScaleInProtection: cfg.RespectScaleInProtection,
+		Rules:                    cfg.Rules,
+		RulesFromTags:            cfg.RulesFromTags,
+		InstancePorts:            cfg.InstancePorts,
+		ReferenceSourceGroup:     cfg.ReferenceSourceGroup,
+		SourceGroupID:            cfg.SourceSecurityGroupID,
+		ReturnRules:              cfg.ReturnRules,
+		ReturnGroupID:            cfg.ReturnSecurityGroupID,
+		ReturnSourceCIDRs:        cfg.ReturnSourceCIDRs,
+		Policy:                   cfg.StagePolicy,
+		Order:                    cfg.ApplyOrder,
+		Recreate:                 recreateSpec(cfg),
+		AccessClosed:             cfg.AccessClosed(asgName, time.Now()),
+	}
+}
+
+// Gets the spec the deleted Security Groups are recreated after, nil when they aren't
+func recreateSpec(cfg config.Config) *target.GroupSpec {
+	if !cfg.RecreateSecurityGroup {
+		return nil
+	}
+	spec := cfg.GroupSpec()
+	return &spec
+}
+
+// Records the managed rules in the state table, when one is configured
+func withState(input syncer.Input, clients awsclient.Clients, cfg config.Config) syncer.Input {
+	if cfg.StateTable != "" && clients.DynamoDB != nil {
+		input.StateStore = &state.Store{Svc: clients.DynamoDB, Table: cfg.StateTable}
+	}
+	return input
+}
+
+// Restricts the sync to the tcp rule of the port, when one is requested. It takes precedence over the Security
+// Group's tag.
+func withPort(input syncer.Input, port int64) syncer.Input {
+	if port != 0 {
+		input.Rules = []target.Rule{{Protocol: target.TCPProtocol, Port: port}}
+		input.RulesFromTags = false
+	}
+	return input
+}
+
+// Applies the hook's settings over the input built from the config
+func withHookSettings(input syncer.Input, settings event.HookSettings) (syncer.Input, error) {
+	if settings.SecurityGroupID != "" {
+		input.SecurityGroupID = settings.SecurityGroupID
+	}
+	if len(settings.Rules) != 0 {
+		rules, err := target.ExpandRules(settings.Rules)
+		if err != nil {
+			return input, errs.Wrap(errs.Config, "hook settings", err)
+		}
+		input.Rules, input.RulesFromTags = rules, false
+	}
+	input = withPort(input, settings.Port)
+	switch settings.Mode {
+	case event.ModeIP:
+		input.ReferenceSourceGroup = false
+	case event.ModeReference:
+		input.ReferenceSourceGroup = true
+	}
+	return input, nil
+}
@@ -0,0 +1,361 @@
+//go:build integration
+
+package handler_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/handler"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/lifecycle"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+const region = "us-east-1"
+
+// The AutoScaling Group, Security Group and hooks that the scenarios run against
+type fixture struct {
+	cfg        config.Config
+	newClients awsclient.Factory
+	clients    awsclient.Clients
+	name       string
+	sgID       string
+	instances  []string
+}
+
+// A scenario passes when it returns no error
+type scenario struct {
+	name string
+	run  func(f *fixture) error
+}
+
+var scenarios = []scenario{
+	{"launch adds every instance's IP", launch},
+	{"a repeated launch changes nothing", repeatedLaunch},
+	{"terminate removes the instance's IP", terminate},
+	{"a throttled authorization is a Throttle error", throttledLaunch},
+	{"a denied authorization is a Target error", deniedLaunch},
+	{"a missing Security Group is a Config error", missingSecurityGroup},
+}
+
+// Runs the lifecycle handler end to end against LocalStack (or moto): it describes a real AutoScaling Group, diffs
+// and updates a real Security Group and completes the lifecycle actions. It is behind the integration build tag so that
+// regular test runs never touch it, and is skipped when endpointURL isn't set:
+//
+//	docker run --rm -p 4566:4566 localstack/localstack
+//	endpointURL=http://localhost:4566 go test -tags integration -run TestIntegration ./pkg/handler
+//
+// The scenarios run in order against the same fixture, each as a subtest.
+func TestIntegration(t *testing.T) {
+	if os.Getenv("endpointURL") == "" {
+		t.Skip("endpointURL is required, e.g. http://localhost:4566")
+	}
+	// LocalStack and moto accept any credentials
+	for key, value := range map[string]string{"AWS_ACCESS_KEY_ID": "test", "AWS_SECRET_ACCESS_KEY": "test"} {
+		if os.Getenv(key) == "" {
+			t.Setenv(key, value)
+		}
+	}
+
+	f, err := setUp()
+	if f != nil {
+		t.Cleanup(func() { f.tearDown(t) })
+	}
+	if err != nil {
+		t.Fatal("set up:", err)
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if err := s.run(f); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// Creates the Security Group and an AutoScaling Group of integrationFleetSize instances, and waits for them to get
+// their public IPs. The default size is one more than the instances source describes per call, so that the fleet is
+// described in several batches.
+func setUp() (*fixture, error) {
+	cfg := config.FromEnv()
+	f := &fixture{cfg: cfg, newClients: awsclient.ForConfig(cfg), name: "sg-sync-integration-" + strconv.FormatInt(time.Now().Unix(), 10)}
+	clients, err := f.newClients(region)
+	if err != nil {
+		return nil, err
+	}
+	f.clients = clients
+
+	group, err := clients.EC2.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+		GroupName:   aws.String(f.name),
+		Description: aws.String("sg-sync integration scenarios"),
+	})
+	if err != nil {
+		return f, fmt.Errorf("create security group: %w", err)
+	}
+	f.sgID = aws.StringValue(group.GroupId)
+	f.cfg.SecurityGroupID = f.sgID
+
+	_, err = clients.EC2.CreateLaunchTemplate(&ec2.CreateLaunchTemplateInput{
+		LaunchTemplateName: aws.String(f.name),
+		LaunchTemplateData: &ec2.RequestLaunchTemplateData{
+			ImageId:      aws.String(envOr("integrationAMI", "ami-df5de72bdb3b")),
+			InstanceType: aws.String("t3.micro"),
+		},
+	})
+	if err != nil {
+		return f, fmt.Errorf("create launch template: %w", err)
+	}
+	size, _ := strconv.ParseInt(envOr("integrationFleetSize", "1001"), 10, 64)
+	_, err = clients.AutoScaling.CreateAutoScalingGroup(&autoscaling.CreateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(f.name),
+		LaunchTemplate:       &autoscaling.LaunchTemplateSpecification{LaunchTemplateName: aws.String(f.name)},
+		AvailabilityZones:    aws.StringSlice([]string{region + "a"}),
+		MinSize:              aws.Int64(size),
+		MaxSize:              aws.Int64(size),
+		DesiredCapacity:      aws.Int64(size),
+	})
+	if err != nil {
+		return f, fmt.Errorf("create autoscaling group: %w", err)
+	}
+	for _, transition := range []string{event.TransitionLaunching, event.TransitionTerminating} {
+		_, err = clients.AutoScaling.PutLifecycleHook(&autoscaling.PutLifecycleHookInput{
+			AutoScalingGroupName: aws.String(f.name),
+			LifecycleHookName:    aws.String(hookName(transition)),
+			LifecycleTransition:  aws.String(transition),
+			DefaultResult:        aws.String("ABANDON"),
+		})
+		if err != nil {
+			return f, fmt.Errorf("put lifecycle hook: %w", err)
+		}
+	}
+
+	for deadline := time.Now().Add(5 * time.Minute); ; time.Sleep(2 * time.Second) {
+		instances, err := source.ASGInstances(f.name, "", clients.AutoScaling, clients.EC2)
+		if err != nil {
+			return f, err
+		}
+		f.instances = f.instances[:0]
+		for _, instance := range instances {
+			if instance.PublicIP != "" {
+				f.instances = append(f.instances, instance.ID)
+			}
+		}
+		if int64(len(f.instances)) == size {
+			sort.Strings(f.instances)
+			return f, nil
+		}
+		if time.Now().After(deadline) {
+			return f, fmt.Errorf("%d of the %d instances got a public IP", len(f.instances), size)
+		}
+	}
+}
+
+// Deletes what setUp created. Failures are logged, the emulator is usually thrown away anyway.
+func (f *fixture) tearDown(t *testing.T) {
+	if _, err := f.clients.AutoScaling.DeleteAutoScalingGroup(&autoscaling.DeleteAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(f.name),
+		ForceDelete:          aws.Bool(true),
+	}); err != nil {
+		t.Log("tear down:", err)
+	}
+	if _, err := f.clients.EC2.DeleteLaunchTemplate(&ec2.DeleteLaunchTemplateInput{LaunchTemplateName: aws.String(f.name)}); err != nil {
+		t.Log("tear down:", err)
+	}
+	if f.sgID != "" {
+		if _, err := f.clients.EC2.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: aws.String(f.sgID)}); err != nil {
+			t.Log("tear down:", err)
+		}
+	}
+}
+
+func launch(f *fixture) error {
+	response, result, err := f.handle(f.cfg, event.TransitionLaunching, f.instances[0], nil)
+	if err != nil {
+		return err
+	}
+	if len(response.AddedIPs) != len(f.instances) {
+		return fmt.Errorf("added %d IPs, want %d", len(response.AddedIPs), len(f.instances))
+	}
+	if err := expectResult(result, lifecycle.ResultContinue); err != nil {
+		return err
+	}
+	return f.expectRules("")
+}
+
+func repeatedLaunch(f *fixture) error {
+	response, result, err := f.handle(f.cfg, event.TransitionLaunching, f.instances[0], nil)
+	if err != nil {
+		return err
+	}
+	if len(response.AddedIPs) != 0 || len(response.RemovedIPs) != 0 {
+		return fmt.Errorf("added %v and removed %v, want no changes", response.AddedIPs, response.RemovedIPs)
+	}
+	return expectResult(result, lifecycle.ResultContinue)
+}
+
+func terminate(f *fixture) error {
+	terminating := f.instances[len(f.instances)-1]
+	response, result, err := f.handle(f.cfg, event.TransitionTerminating, terminating, nil)
+	if err != nil {
+		return err
+	}
+	if len(response.RemovedIPs) != 1 {
+		return fmt.Errorf("removed %v, want the IP of %s", response.RemovedIPs, terminating)
+	}
+	if err := expectResult(result, lifecycle.ResultContinue); err != nil {
+		return err
+	}
+	return f.expectRules(terminating)
+}
+
+// The instance terminate excluded is still in service, so a launch authorizes its IP again
+func throttledLaunch(f *fixture) error {
+	return f.failedLaunch(awserr.New("Throttling", "Rate exceeded", nil), errs.Throttle)
+}
+
+func deniedLaunch(f *fixture) error {
+	return f.failedLaunch(awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil), errs.Target)
+}
+
+// Runs a launch whose authorizations fail with fault, and checks that it fails with the category, abandons the
+// lifecycle action and leaves the rules as they were
+func (f *fixture) failedLaunch(fault error, category errs.Category) error {
+	_, result, err := f.handle(f.cfg, event.TransitionLaunching, f.instances[0], fault)
+	if err == nil {
+		return errors.New("the sync succeeded")
+	}
+	if errs.CategoryOf(err) != category {
+		return fmt.Errorf("got a %s error, want a %s one: %w", errs.CategoryOf(err), category, err)
+	}
+	if err := expectResult(result, lifecycle.ResultAbandon); err != nil {
+		return err
+	}
+	return f.expectRules(f.instances[len(f.instances)-1])
+}
+
+func missingSecurityGroup(f *fixture) error {
+	cfg := f.cfg
+	cfg.SecurityGroupID = "sg-00000000000000000"
+	_, result, err := f.handle(cfg, event.TransitionLaunching, f.instances[0], nil)
+	if err == nil {
+		return errors.New("the sync succeeded")
+	}
+	if errs.CategoryOf(err) != errs.Config || !target.IsNotFound(err) {
+		return fmt.Errorf("got a %s error, want a Config InvalidGroup.NotFound one: %w", errs.CategoryOf(err), err)
+	}
+	return expectResult(result, lifecycle.ResultAbandon)
+}
+
+// Checks the result the lifecycle action was completed with
+func expectResult(got string, want string) error {
+	if got != want {
+		return fmt.Errorf("completed the lifecycle action with %q, want %q", got, want)
+	}
+	return nil
+}
+
+// completions records the results the lifecycle actions are completed with
+type completions struct {
+	autoscalingiface.AutoScalingAPI
+	results []string
+}
+
+func (c *completions) CompleteLifecycleAction(input *autoscaling.CompleteLifecycleActionInput) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	c.results = append(c.results, aws.StringValue(input.LifecycleActionResult))
+	return c.AutoScalingAPI.CompleteLifecycleAction(input)
+}
+
+// faultyEC2 fails every authorization with err, e.g. a throttling or a permission error
+type faultyEC2 struct {
+	ec2iface.EC2API
+	err error
+}
+
+func (c *faultyEC2) AuthorizeSecurityGroupIngress(*ec2.AuthorizeSecurityGroupIngressInput) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	return nil, c.err
+}
+
+// Runs a new handler with the lifecycle event of the instance, as EventBridge would deliver it. The authorizations
+// fail with fault, unless it is nil. Returns the result the lifecycle action was completed with, empty when it wasn't.
+func (f *fixture) handle(cfg config.Config, transition string, instanceID string, fault error) (handler.Response, string, error) {
+	recorded := &completions{}
+	newClients := func(region string) (awsclient.Clients, error) {
+		clients, err := f.newClients(region)
+		if err != nil {
+			return clients, err
+		}
+		recorded.AutoScalingAPI = clients.AutoScaling
+		clients.AutoScaling = recorded
+		if fault != nil {
+			clients.EC2 = &faultyEC2{EC2API: clients.EC2, err: fault}
+		}
+		return clients, nil
+	}
+	response, err := handler.New(cfg, newClients).Handle(event.IncomingEvent{
+		Version:    "0",
+		ID:         f.name + "-" + instanceID,
+		DetailType: "EC2 Instance-launch Lifecycle Action",
+		Source:     "aws.autoscaling",
+		Region:     region,
+		Time:       time.Now().UTC(),
+		Detail: event.Detail{
+			LifecycleHookName:    hookName(transition),
+			AutoScalingGroupName: f.name,
+			LifecycleTransition:  transition,
+			EC2InstanceID:        instanceID,
+		},
+	})
+	var result string
+	if len(recorded.results) != 0 {
+		result = recorded.results[len(recorded.results)-1]
+	}
+	return response, result, err
+}
+
+// Checks that the Security Group's rules are exactly the public IPs of the instances, but the excluded one
+func (f *fixture) expectRules(excludeInstanceID string) error {
+	want, err := source.ASGPublicIPs(f.name, excludeInstanceID, f.clients.AutoScaling, f.clients.EC2)
+	if err != nil {
+		return err
+	}
+	got, err := target.SecurityGroupIPs(f.sgID, target.DefaultRule, f.clients.EC2)
+	if err != nil {
+		return err
+	}
+	missing, extra := want.Diff(got), got.Diff(want)
+	if len(missing) != 0 || len(extra) != 0 {
+		return fmt.Errorf("the rules miss %v and have the extra %v", missing, extra)
+	}
+	return nil
+}
+
+func hookName(transition string) string {
+	if transition == event.TransitionTerminating {
+		return "sg-sync-terminating"
+	}
+	return "sg-sync-launching"
+}
+
+func envOr(key string, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/parameter"
+	"go.uber.org/zap"
+)
+
+// LoadConfig reads the config of the environmental variables, with the parameter layer of the configParameter SSM
+// parameter and the configAppConfig profile over them, the profile over the parameter. It is meant to be called once,
+// at cold start, before the clients are built: the layer can enable the features that need them. A failed read fails
+// the config's validation. The logger is configured with the resolved settings.
+func LoadConfig() config.Config {
+	cfg := config.FromEnv()
+	var layers []config.Layer
+	if cfg.ConfigParameter != "" {
+		layers = append(layers, ssmLayer(cfg))
+	}
+	if cfg.ConfigAppConfig != "" {
+		layers = append(layers, appConfigLayer(cfg.ConfigAppConfig))
+	}
+	if len(layers) != 0 {
+		cfg = config.Resolve(layers...)
+	}
+	logging.Configure(logging.Settings{Sampling: cfg.LogSampling, RedactFields: cfg.LogRedactFields, RedactAccountIDs: cfg.LogRedactAccountIDs})
+	return cfg
+}
+
+// Reads the layer of the SSM parameter, with a client of its own since the function's aren't built yet
+func ssmLayer(cfg config.Config) config.Layer {
+	clients, err := awsclient.NewSessionFactory(awsclient.Options{Endpoint: cfg.EndpointURL, SSM: true})(awsclient.DefaultRegion())
+	if err != nil {
+		return config.Layer{Source: config.SourceParameter, Err: fmt.Errorf("parameter layer: %w", err)}
+	}
+	value, found, err := parameter.Get(clients.SSM, cfg.ConfigParameter)
+	if err == nil && !found {
+		err = fmt.Errorf("parameter %s not found", cfg.ConfigParameter)
+	}
+	if err != nil {
+		return config.Layer{Source: config.SourceParameter, Err: fmt.Errorf("parameter layer: %w", err)}
+	}
+	return config.ParseLayer(config.SourceParameter, []byte(value))
+}
+
+// Reads the layer of the AppConfig profile
+func appConfigLayer(profile string) config.Layer {
+	body, err := parameter.AppConfig(profile)
+	if err != nil {
+		return config.Layer{Source: config.SourceParameter, Err: fmt.Errorf("parameter layer: %w", err)}
+	}
+	return config.ParseLayer(config.SourceParameter, body)
+}
+
+// Gets the layers of the event's hook and of the event, when they set any. Invalid settings carry no layer, the sync
+// input reports them.
+func (h *LifecycleHandler) invocationLayers(request event.IncomingEvent) []config.Layer {
+	var layers []config.Layer
+	if settings, err := h.hookSettings(request); err == nil && len(settings.Config) != 0 {
+		layers = append(layers, config.ParseLayer(config.SourceHook, settings.Config))
+	}
+	if len(request.Config) != 0 {
+		layers = append(layers, config.ParseLayer(config.SourceEvent, request.Config))
+	}
+	return layers
+}
+
+// Gets the handler of the invocation: a copy of the handler with the hook's and the event's layers over its config,
+// or the handler itself when they set nothing. The effective config is logged either way.
+func (h *LifecycleHandler) forInvocation(request event.IncomingEvent) (*LifecycleHandler, error) {
+	if h.cfgErr != nil {
+		return h, nil
+	}
+	layers := h.invocationLayers(request)
+	cfg, err := overrideConfig(h.cfg, layers, h.logger)
+	if err != nil || len(layers) == 0 {
+		return h, err
+	}
+	return &LifecycleHandler{newClients: h.newClients, cfg: cfg, logger: h.logger}, nil
+}
+
+// Gets the config of an invocation with the event's layer over it, e.g. the sgSyncConfig of a Step Functions task or
+// of a manual sync request
+func withEventLayer(cfg config.Config, layer json.RawMessage, logger *zap.Logger) (config.Config, error) {
+	var layers []config.Layer
+	if len(layer) != 0 {
+		layers = append(layers, config.ParseLayer(config.SourceEvent, layer))
+	}
+	return overrideConfig(cfg, layers, logger)
+}
+
+// Sets the layers over the config, which is left as it is when they are invalid. The effective config is logged
+// either way.
+func overrideConfig(cfg config.Config, layers []config.Layer, logger *zap.Logger) (config.Config, error) {
+	if len(layers) == 0 {
+		logger.Info("Effective configuration", zap.Any("config", cfg.Effective()))
+		return cfg, nil
+	}
+	overridden := cfg.Override(layers...)
+	if err := overridden.Validate(); err != nil {
+		logger.Error("Invalid configuration overrides", zap.Error(err))
+		return cfg, err
+	}
+	logger.Info("Effective configuration", zap.Any("config", overridden.Effective()))
+	return overridden, nil
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/maintenance"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/queue"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"go.uber.org/zap"
+)
+
+// Syncs the pair, or, while a maintenance window is open, calculates the changes with a dry run and defers them until
+// it closes. Every entrypoint that syncs outside of the lifecycle pipeline goes through it, so that none of them changes
+// the Security Group during a change freeze, and in the blue/green mode they all sync the active group. until is the
+// end of the window that deferred the changes, zero when none did.
+func syncOrDefer(clients awsclient.Clients, cfg config.Config, region string, input syncer.Input, logger *zap.Logger) (result syncer.Result, until time.Time, err error) {
+	if input, err = withActiveGroup(input, clients, cfg); err != nil {
+		return result, time.Time{}, err
+	}
+	until, frozen := cfg.MaintenanceWindows.Active(time.Now())
+	frozen = frozen && !input.DryRun
+	input.DryRun = input.DryRun || frozen
+	result, err = syncer.Sync(withState(input, clients, cfg), clients.AutoScaling, clients.EC2, logger)
+	if err != nil || !frozen {
+		return result, time.Time{}, err
+	}
+	deferChanges(clients, cfg, region, input, result, until, logger)
+	return result, until, nil
+}
+
+// Records the changes a maintenance window deferred and enqueues the sync that applies them once it closes. Without a
+// queue, they are applied by the first sync after the window.
+func deferChanges(clients awsclient.Clients, cfg config.Config, region string, input syncer.Input, result syncer.Result, until time.Time, logger *zap.Logger) {
+	logger.Info("Changes deferred by a maintenance window", zap.Strings("intendedAdds", result.AddedIPs), zap.Strings("intendedRemovals", result.RemovedIPs), zap.Time("deferredUntil", until))
+	if cfg.DeferredSyncQueueURL == "" || (len(result.AddedIPs) == 0 && len(result.RemovedIPs) == 0) {
+		return
+	}
+	now := time.Now().UTC()
+	err := queue.EnqueueDeferredSync(clients.SQS, cfg.DeferredSyncQueueURL, queue.DeferredSync{
+		Region:               region,
+		AutoScalingGroupName: input.AutoScalingGroupName,
+		SecurityGroupID:      input.SecurityGroupID,
+		Rules:                input.Rules,
+		IntendedAdds:         result.AddedIPs,
+		IntendedRemovals:     result.RemovedIPs,
+		DeferredAt:           now,
+		DeferredUntil:        until,
+	}, now)
+	if err != nil {
+		logger.Error("Failed to enqueue the deferred sync", zap.Error(err))
+	}
+}
+
+// Publishes the changes of the deferred sync that were applied once its window closed. A sync that found nothing left
+// to apply, e.g. because another deferred sync of the pair applied it first, publishes nothing.
+func publishApplied(clients awsclient.Clients, cfg config.Config, msg queue.DeferredSync, result syncer.Result, logger *zap.Logger) {
+	logger.Info("Deferred changes applied", zap.Strings("intendedAdds", msg.IntendedAdds), zap.Strings("intendedRemovals", msg.IntendedRemovals),
+		zap.Strings("addedIPs", result.AddedIPs), zap.Strings("removedIPs", result.RemovedIPs))
+	if len(result.AddedIPs) == 0 && len(result.RemovedIPs) == 0 {
+		return
+	}
+	channels := notifier(clients, cfg, cfg.AlertTopicARN)
+	if len(channels) == 0 {
+		return
+	}
+
+	err := channels.Notify(maintenance.Applied{
+		AutoScalingGroupName: msg.AutoScalingGroupName,
+		SecurityGroupID:      msg.SecurityGroupID,
+		Rules:                msg.Rules,
+		IntendedAdds:         msg.IntendedAdds,
+		IntendedRemovals:     msg.IntendedRemovals,
+		AddedIPs:             result.AddedIPs,
+		RemovedIPs:           result.RemovedIPs,
+		DeferredAt:           msg.DeferredAt,
+		AppliedAt:            time.Now().UTC(),
+	}.Message())
+	if err != nil {
+		logger.Error("Failed to publish the applied deferred changes", zap.Error(err))
+	}
+}
+
+// Gets the DeferredUntil of a response, nil when nothing was deferred
+func deferredUntil(until time.Time) *time.Time {
+	if until.IsZero() {
+		return nil
+	}
+	return &until
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"go.uber.org/zap"
+)
+
+// Handles the minimal payload of a console or CLI test invocation: its pair is synced the way the scheduled reconcile
+// syncs a configured one, and no lifecycle action is completed
+func (h *LifecycleHandler) handleManual(request event.IncomingEvent) (Response, error) {
+	manual := request.ManualInvocation
+	logger := h.logger.With(zap.String("asgName", manual.AutoScalingGroupName), zap.Bool("dryRun", manual.DryRun))
+	logger.Info("Manual invocation, reconciling the AutoScaling Group without completing any lifecycle action")
+	response := Response{SchemaVersion: SchemaVersion, Build: build()}
+	if h.cfgErr != nil {
+		return response, h.cfgErr
+	}
+	if err := manual.Validate(); err != nil {
+		logger.Error("Invalid manual invocation", zap.Error(err))
+		return response, err
+	}
+	pair := config.Pair{AutoScalingGroupName: manual.AutoScalingGroupName, SecurityGroupID: manual.SecurityGroupID}
+	if pair.SecurityGroupID == "" {
+		pair.SecurityGroupID = h.cfg.SecurityGroupID
+	}
+	if pair.SecurityGroupID == "" {
+		return response, errs.Errorf(errs.Config, "validate manual invocation", "securityGroupId is required when securityGroupID isn't configured")
+	}
+
+	clients, err := h.newClients(request.Region)
+	if err != nil {
+		logger.Error("Failed to create session", zap.Error(err))
+		return response, errs.Wrap(errs.Config, "create session", err)
+	}
+	cfg := h.cfg
+	cfg.DryRun = cfg.DryRun || manual.DryRun
+	reconciler := &ReconcileHandler{newClients: h.newClients, cfg: cfg, logger: h.logger}
+	result, err := reconciler.reconcile(clients, request.Region, request.ID, pair)
+	response.SecurityGroupID, response.Result, response.DeferredUntil = pair.SecurityGroupID, result.Result, result.DeferredUntil
+	return response, err
+}
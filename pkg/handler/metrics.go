@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/metrics"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+// syncOutcome is the outcome of a sync, as recorded by the metrics
+type syncOutcome struct {
+	region  string
+	asgName string
+	sgID    string
+	result  syncer.Result
+	started time.Time
+	err     error
+}
+
+// Publishes the outcome metrics of the sync to CloudWatch and Prometheus and, after a successful one, the rule usage
+// of the Security Group to CloudWatch, if enabled. Streams the sync's changes to the audit streams. Failures are
+// logged, they never fail the invocation.
+func recordOutcome(newClients awsclient.Factory, cfg config.Config, outcome syncOutcome, logger *zap.Logger) {
+	pushInvocation(cfg, outcome, logger)
+	streamChanges(newClients, cfg, outcome.region, outcome.asgName, []syncer.TargetResult{{SecurityGroupID: outcome.sgID, Result: outcome.result}}, logger)
+	if cfg.MetricsNamespace == "" {
+		return
+	}
+	clients, err := newClients(outcome.region)
+	if err == nil {
+		err = metrics.PublishOutcome(clients.CloudWatch, cfg.MetricsNamespace, outcome.asgName, outcome.sgID, outcome.err)
+	}
+	if err != nil {
+		logger.Error("Failed to publish the outcome metrics", zap.Error(err))
+		return
+	}
+	if outcome.err == nil && outcome.sgID != "" {
+		recordRuleUsage(clients, cfg, outcome.region, outcome.sgID, logger)
+	}
+}
+
+// Pushes the invocation metrics of the sync to every configured Prometheus and Datadog backend
+func pushInvocation(cfg config.Config, outcome syncOutcome, logger *zap.Logger) {
+	var pushers []metrics.Pusher
+	if cfg.DogStatsDAddr != "" {
+		pushers = append(pushers, metrics.DogStatsD{Addr: cfg.DogStatsDAddr})
+	}
+	if cfg.DatadogAPIKey != "" {
+		pushers = append(pushers, metrics.DatadogAPI{APIKey: cfg.DatadogAPIKey, Site: cfg.DatadogSite})
+	}
+	if cfg.PrometheusPushgatewayURL != "" {
+		pushers = append(pushers, metrics.Pushgateway{URL: cfg.PrometheusPushgatewayURL, Job: cfg.PrometheusJob})
+	}
+	if cfg.PrometheusRemoteWriteURL != "" {
+		pushers = append(pushers, metrics.RemoteWrite{URL: cfg.PrometheusRemoteWriteURL, Job: cfg.PrometheusJob})
+	}
+	if len(pushers) == 0 {
+		return
+	}
+
+	now := time.Now()
+	invocation := metrics.Invocation{
+		AutoScalingGroupName: outcome.asgName,
+		SecurityGroupID:      outcome.sgID,
+		Region:               outcome.region,
+		Added:                len(outcome.result.AddedIPs),
+		Removed:              len(outcome.result.RemovedIPs),
+		Failed:               outcome.err != nil,
+		Duration:             now.Sub(outcome.started),
+		Time:                 now,
+	}
+	for _, pusher := range pushers {
+		if err := pusher.Push(invocation); err != nil {
+			logger.Error("Failed to push the invocation metrics", zap.String("backend", pusher.Name()), zap.Error(err))
+		}
+	}
+}
+
+// Publishes the managed rule count and the rules quota utilization of the Security Group
+func recordRuleUsage(clients awsclient.Clients, cfg config.Config, region string, sgID string, logger *zap.Logger) {
+	total, managed, err := target.RuleCounts(sgID, clients.EC2)
+	if err != nil {
+		logger.Error("Failed to count the Security Group's rules", zap.Error(err))
+		return
+	}
+	quota := cfg.RulesQuota
+	if quota == 0 {
+		if quota, err = metrics.RulesQuota(clients.ServiceQuotas, region); err != nil {
+			logger.Warn("Failed to get the rules quota, assuming the default", zap.Int("quota", quota), zap.Error(err))
+		}
+	}
+	if err := metrics.PublishRuleUsage(clients.CloudWatch, cfg.MetricsNamespace, sgID, managed, total, quota); err != nil {
+		logger.Error("Failed to publish the rule usage metrics", zap.Error(err))
+	}
+}
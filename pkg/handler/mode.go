@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"sort"
+
+	"github.com/aws/aws-lambda-go/cfn"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// ModeLifecycle is the default mode, the handler of the lifecycle hooks' events
+const ModeLifecycle = "lifecycle"
+
+// Mode is a role the function can serve, selected with HANDLER_MODE. Every mode handles its own event type and shares
+// the sync engine with the others.
+type Mode struct {
+	// Options adds the clients the mode needs on top of the config's, if any
+	Options func(opts *awsclient.Options)
+	// New builds the handler function of the mode, as lambda.Start takes it
+	New func(cfg config.Config, newClients awsclient.Factory) interface{}
+}
+
+// Modes are the registered modes by name
+var Modes = map[string]Mode{
+	ModeLifecycle: {New: func(cfg config.Config, newClients awsclient.Factory) interface{} {
+		return New(cfg, newClients).Handle
+	}},
+	"batch": {New: func(cfg config.Config, newClients awsclient.Factory) interface{} {
+		return NewBatch(cfg, newClients).Handle
+	}},
+	"dlq": {New: func(cfg config.Config, newClients awsclient.Factory) interface{} {
+		return NewDLQ(cfg, newClients).Handle
+	}},
+	"queue": {New: func(cfg config.Config, newClients awsclient.Factory) interface{} {
+		return NewQueue(cfg, newClients).Handle
+	}},
+	"reconcile": {New: func(cfg config.Config, newClients awsclient.Factory) interface{} {
+		return NewReconcile(cfg, newClients).Handle
+	}},
+	"report": {New: func(cfg config.Config, newClients awsclient.Factory) interface{} {
+		return NewReport(cfg, newClients).Handle
+	}},
+	"http": {New: func(cfg config.Config, newClients awsclient.Factory) interface{} {
+		return NewHTTP(cfg, newClients).Handle
+	}},
+	"plan": {New: func(cfg config.Config, newClients awsclient.Factory) interface{} {
+		return NewPlan(cfg, newClients).Handle
+	}},
+	"teardown": {New: func(cfg config.Config, newClients awsclient.Factory) interface{} {
+		return NewTeardown(cfg, newClients).Handle
+	}},
+	"stepfunctions": {New: func(cfg config.Config, newClients awsclient.Factory) interface{} {
+		return NewTask(cfg, newClients).Handle
+	}},
+	"config-rule": {
+		Options: func(opts *awsclient.Options) { opts.ConfigService = true },
+		New: func(cfg config.Config, newClients awsclient.Factory) interface{} {
+			return NewConfigRule(cfg, newClients).Handle
+		},
+	},
+	"custom-resource": {New: func(cfg config.Config, newClients awsclient.Factory) interface{} {
+		return cfn.LambdaWrap(NewCustomResource(cfg, newClients).Handle)
+	}},
+	"bootstrap": {
+		Options: func(opts *awsclient.Options) { opts.Lambda, opts.EventBridge = true, true },
+		New: func(cfg config.Config, newClients awsclient.Factory) interface{} {
+			return NewBootstrap(cfg, newClients).Handle
+		},
+	},
+}
+
+// ForMode builds the handler function of the config's mode, with cached clients of the features the config and the
+// mode need
+func ForMode(cfg config.Config) (interface{}, error) {
+	name := cfg.HandlerMode
+	if name == "" {
+		name = ModeLifecycle
+	}
+	mode, ok := Modes[name]
+	if !ok {
+		return nil, errs.Errorf(errs.Config, "select handler mode", "unknown HANDLER_MODE %q, expected one of %v", name, ModeNames())
+	}
+	opts := awsclient.OptionsFor(cfg)
+	if mode.Options != nil {
+		mode.Options(&opts)
+	}
+	return mode.New(cfg, awsclient.Cached(awsclient.NewSessionFactory(opts))), nil
+}
+
+// ModeNames lists the names of the registered modes, sorted
+func ModeNames() []string {
+	names := make([]string, 0, len(Modes))
+	for name := range Modes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
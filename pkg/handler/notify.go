@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"sort"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/notify"
+)
+
+// Builds the channels of the messages that go to the SNS topic, when set, and to every channel the config sets, by
+// name. It is empty when there is none.
+func notifier(clients awsclient.Clients, cfg config.Config, topicARN string) notify.FanOut {
+	var channels notify.FanOut
+	if topicARN != "" {
+		channels = append(channels, notify.SNS{Svc: clients.SNS, TopicARN: topicARN})
+	}
+	targets := cfg.NotificationChannels()
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if channel, ok := notify.Channel(name, targets[name]); ok {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/opsitem"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/state"
+	"go.uber.org/zap"
+)
+
+// The consecutive failures of every pair, counted by the container when there's no state table
+var failureCounts = struct {
+	sync.Mutex
+	m map[string]int
+}{m: make(map[string]int)}
+
+// Counts the consecutive failures of the pair and opens an OpsItem once they reach the threshold, if enabled. A
+// successful sync resets the count. Failures are logged, they never fail the invocation.
+func trackFailures(newClients awsclient.Factory, cfg config.Config, region string, asgName string, sgID string, syncErr error, logger *zap.Logger) {
+	if cfg.OpsItemThreshold == 0 || asgName == "" || sgID == "" {
+		return
+	}
+	clients, err := newClients(region)
+	if err != nil {
+		logger.Error("Failed to create session", zap.Error(err))
+		return
+	}
+	var store *state.Store
+	if cfg.StateTable != "" {
+		store = &state.Store{Svc: clients.DynamoDB, Table: cfg.StateTable}
+	}
+
+	if syncErr == nil {
+		resetFailures(store, sgID, asgName, logger)
+		return
+	}
+	count, err := countFailure(store, sgID, asgName)
+	if err != nil {
+		logger.Error("Failed to count the failure", zap.Error(err))
+		return
+	}
+	if count < cfg.OpsItemThreshold {
+		return
+	}
+
+	id, err := opsitem.Open(clients.SSM, opsitem.Failure{
+		AutoScalingGroupName: asgName,
+		SecurityGroupID:      sgID,
+		Count:                count,
+		Category:             errs.CategoryOf(syncErr),
+		Error:                syncErr.Error(),
+		At:                   time.Now().UTC(),
+	})
+	if err != nil {
+		logger.Error("Failed to open the OpsItem", zap.Error(err))
+		return
+	}
+	logger.Warn("Persistent sync failure tracked by an OpsItem", zap.String("opsItemID", id), zap.Int("consecutiveFailures", count))
+}
+
+// Counts one more failure of the pair, in the state table when there is one
+func countFailure(store *state.Store, sgID string, asgName string) (int, error) {
+	if store != nil {
+		return store.CountFailure(sgID, asgName)
+	}
+	failureCounts.Lock()
+	defer failureCounts.Unlock()
+	failureCounts.m[asgName+"/"+sgID]++
+	return failureCounts.m[asgName+"/"+sgID], nil
+}
+
+// Resets the failures of the pair
+func resetFailures(store *state.Store, sgID string, asgName string, logger *zap.Logger) {
+	if store != nil {
+		if err := store.ResetFailures(sgID, asgName); err != nil {
+			logger.Error("Failed to reset the failures count", zap.Error(err))
+		}
+		return
+	}
+	failureCounts.Lock()
+	defer failureCounts.Unlock()
+	delete(failureCounts.m, asgName+"/"+sgID)
+}
@@ -0,0 +1,345 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/bluegreen"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/flags"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/lifecycle"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/policy"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"go.uber.org/zap"
+)
+
+// pipelineContext is the state the steps of the lifecycle pipeline share. Every step reads what the previous ones
+// filled in and fills in its own part.
+type pipelineContext struct {
+	request event.IncomingEvent
+	clients awsclient.Clients
+	// input is the sync input of the event, filled in by parse and resolve
+	input syncer.Input
+	// sgIDs are the Security Groups the event syncs
+	sgIDs []string
+	// targetGroups are the target groups that were updated and tgErr their tolerated failure
+	targetGroups []string
+	tgErr        error
+	// result is the result of the sync of a single Security Group
+	result syncer.Result
+	// targets are the results of the syncs of several Security Groups and targetsErr the failure of any of them
+	targets    []syncer.TargetResult
+	targetsErr error
+	// createdSGID is the Security Group the function created on its first run
+	createdSGID string
+	// deferredUntil is when the maintenance window that deferred the changes closes, zero when none did
+	deferredUntil time.Time
+	// swap is the blue/green swap of the Security Group, nil when none was made
+	swap *bluegreen.Swap
+	// flags are the feature flags enabled for the AutoScaling Group
+	flags    []string
+	response Response
+}
+
+// step is a stage of the lifecycle pipeline. A failed step stops the pipeline and the lifecycle action is completed
+// with the failure result.
+type step struct {
+	name string
+	run  func(pc *pipelineContext) error
+}
+
+// Builds the steps the event goes through. The modes swap steps rather than branching inside them.
+func (h *LifecycleHandler) pipeline(request event.IncomingEvent) []step {
+	switch {
+	case h.cfgErr != nil:
+		return []step{{"config", func(*pipelineContext) error { return h.cfgErr }}}
+	case request.IsTestNotification():
+		return []step{{"test notification", func(*pipelineContext) error {
+			h.logger.Info("Test notification, nothing to sync")
+			return nil
+		}}}
+	case h.asyncApply(request.Detail.AutoScalingGroupName) && !request.AsyncApply && !h.cfg.AuditOnly:
+		return []step{{"validate", h.validateStep}, {"complete", h.completeStep}, {"apply async", h.applyAsyncStep}}
+	}
+	parse, targetGroups := step{"parse", h.parseStep}, step{"target groups", h.targetGroupsStep}
+	apply, verify := step{"apply", h.applyStep}, step{"verify", h.verifyStep}
+	until, frozen := h.cfg.MaintenanceWindows.Active(time.Now())
+	switch {
+	case h.cfg.DryRun || h.cfg.AuditOnly:
+		apply = step{"diff", h.diffStep}
+	case frozen:
+		apply = step{"defer", func(pc *pipelineContext) error { return h.deferStep(pc, until) }}
+	case h.cfg.BlueGreen:
+		apply = step{"swap", h.swapStep}
+	}
+	if h.cfg.DryRun || h.cfg.AuditOnly || frozen {
+		// Nothing is changed: neither the Security Group is created, nor the instance registered
+		parse, targetGroups = step{"parse", h.parseExistingStep}, step{"target groups", h.targetGroupsPlanStep}
+		verify = step{"verify", func(*pipelineContext) error { return nil }}
+	}
+	steps := []step{
+		{"validate", h.validateStep},
+		parse,
+		{"resolve", h.resolveStep},
+		targetGroups,
+		apply,
+		verify,
+		{"report", h.reportStep},
+	}
+	if h.cfg.AuditOnly {
+		// The lifecycle action is left to whatever else completes it
+		return steps
+	}
+	return append(steps, step{"complete", h.completeStep})
+}
+
+// Checks whether the AutoScaling Group's events are applied asynchronously, by the config or its feature flag
+func (h *LifecycleHandler) asyncApply(asgName string) bool {
+	return h.cfg.AsyncApply || (featureFlags(h.cfg).Enabled(flags.AsyncApply, asgName) && h.cfg.FunctionName != "")
+}
+
+// Runs the steps in order. The response is the one the steps built, also when one of them failed.
+func (h *LifecycleHandler) run(pc *pipelineContext, steps []step) (Response, error) {
+	for _, s := range steps {
+		err := s.run(pc)
+		if budgetErr := retry.Current().Err(); err == nil && budgetErr != nil {
+			// A spent budget stops the pipeline, also when the step tolerated the failure
+			h.logger.Warn("Retry budget exhausted", zap.String("step", s.name), zap.Error(budgetErr))
+			err = budgetErr
+		}
+		if err != nil {
+			h.logger.Debug("Pipeline step failed", zap.String("step", s.name), zap.Error(err))
+			h.completeLifecycle(pc.clients, pc.request, h.cfg.FailureResult(pc.request.Detail.IsTerminating()))
+			return pc.response, err
+		}
+	}
+	return pc.response, nil
+}
+
+// Validates the event before it is synced
+func (h *LifecycleHandler) validateStep(pc *pipelineContext) error {
+	if err := pc.request.Validate(); err != nil {
+		h.logger.Error("Invalid event", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// Builds the sync input out of the config and the hook's settings
+func (h *LifecycleHandler) parseStep(pc *pipelineContext) error {
+	input, err := h.hookInput(pc.request)
+	if err != nil {
+		h.logger.Error("Invalid hook settings", zap.Error(err))
+		return err
+	}
+	pc.input, pc.createdSGID, err = withAdoptedGroup(input, pc.clients, h.cfg, h.logger)
+	if err != nil {
+		return err
+	}
+	h.activeFlags(pc)
+	return nil
+}
+
+// Builds the sync input like parseStep, without creating or adopting a Security Group
+func (h *LifecycleHandler) parseExistingStep(pc *pipelineContext) error {
+	input, err := h.hookInput(pc.request)
+	if err != nil {
+		h.logger.Error("Invalid hook settings", zap.Error(err))
+		return err
+	}
+	if pc.input, err = withExistingGroup(input, pc.clients, h.cfg, h.logger); err != nil {
+		return err
+	}
+	h.activeFlags(pc)
+	return nil
+}
+
+// Records the feature flags enabled for the AutoScaling Group
+func (h *LifecycleHandler) activeFlags(pc *pipelineContext) {
+	pc.flags = featureFlags(h.cfg).Active(pc.request.Detail.AutoScalingGroupName)
+	if len(pc.flags) != 0 {
+		h.logger.Info("Feature flags", zap.Strings("flags", pc.flags))
+	}
+}
+
+// Resolves the sources of the sync: waits for the launching instance's public IP and status checks, excludes the terminating instance
+// and picks the Security Groups, the active one in the blue/green mode
+func (h *LifecycleHandler) resolveStep(pc *pipelineContext) error {
+	if h.cfg.BlueGreen {
+		if pc.input.SecurityGroupID != h.cfg.SecurityGroupID {
+			return errs.Errorf(errs.Config, "resolve security group", "blueGreen doesn't support the hook's securityGroupID %q", pc.input.SecurityGroupID)
+		}
+		active, err := activeGroup(pc.clients, h.cfg)
+		if err != nil {
+			h.logger.Error("Failed to resolve the active Security Group", zap.Error(err))
+			return err
+		}
+		pc.input.SecurityGroupID = active
+	}
+	h.waitForPublicIP(pc.clients, pc.request.Detail)
+	if err := h.waitForStatusChecks(pc.clients, pc.request.Detail); err != nil {
+		return err
+	}
+	if pc.request.Detail.IsTerminating() {
+		pc.input.ExcludeInstanceID = pc.request.Detail.EC2InstanceID
+		pc.input.ExcludedSince = pc.request.Time
+		pc.input.DeferRemoval = h.cfg.RemovalDelayQueueURL != ""
+	}
+	pc.sgIDs = h.securityGroups(pc.request, pc.input)
+	return nil
+}
+
+// Registers or deregisters the instance with the target groups. The failure stops the pipeline unless the policy
+// tolerates it.
+func (h *LifecycleHandler) targetGroupsStep(pc *pipelineContext) error {
+	pc.targetGroups, pc.tgErr = h.updateTargetGroups(pc.clients, pc.request)
+	if pc.tgErr != nil && h.cfg.StagePolicy.ActionFor(policy.StageTargets) != policy.Continue {
+		return pc.tgErr
+	}
+	return nil
+}
+
+// Lists the target groups the instance would be registered with or deregistered from, without updating them
+func (h *LifecycleHandler) targetGroupsPlanStep(pc *pipelineContext) error {
+	pc.targetGroups = h.cfg.TargetGroupARNs
+	if len(pc.targetGroups) != 0 {
+		h.logger.Info("Target groups left untouched", zap.String("instanceID", pc.request.Detail.EC2InstanceID), zap.Strings("targetGroupARNs", pc.targetGroups))
+	}
+	return nil
+}
+
+// Fetches the Security Groups' rules, diffs them with the instances' IPs and applies the changes
+func (h *LifecycleHandler) applyStep(pc *pipelineContext) error {
+	if h.cfg.TargetGroupOnly {
+		return nil
+	}
+	input := withState(pc.input, pc.clients, h.cfg)
+	if len(pc.sgIDs) > 1 {
+		// The Security Groups that synced are still reported, the failure is returned once the lifecycle completes
+		pc.targets, pc.targetsErr = syncer.SyncTargets(input, pc.sgIDs, h.cfg.Concurrency, pc.clients.AutoScaling, pc.clients.EC2, h.logger)
+		return nil
+	}
+	result, err := syncer.Sync(input, pc.clients.AutoScaling, pc.clients.EC2, h.logger)
+	pc.result = result
+	if err != nil {
+		// The partial result carries the diff of the failed sync, e.g. for the incident
+		pc.response = Response{SecurityGroupID: pc.input.SecurityGroupID, Result: result}
+		return err
+	}
+	return nil
+}
+
+// Calculates the changes like applyStep, without applying them
+func (h *LifecycleHandler) diffStep(pc *pipelineContext) error {
+	pc.input.DryRun = true
+	if pc.input.SecurityGroupID == "" && len(pc.sgIDs) <= 1 {
+		// The Security Group the function creates doesn't exist yet, all of the IPs would be added to it
+		pc.result.DryRun = true
+		return nil
+	}
+	return h.applyStep(pc)
+}
+
+// Reads the Security Groups back to check that the changes were applied. A mismatch, e.g. a rule another actor
+// changed meanwhile, fails the verify stage.
+func (h *LifecycleHandler) verifyStep(pc *pipelineContext) error {
+	if pc.targets == nil {
+		err := verifyChanges(pc.input.SecurityGroupID, pc.result, pc.clients)
+		return h.toleratedVerify(err, &pc.result)
+	}
+	for i := range pc.targets {
+		t := &pc.targets[i]
+		if t.Error != "" {
+			continue
+		}
+		if err := h.toleratedVerify(verifyChanges(t.SecurityGroupID, t.Result, pc.clients), &t.Result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Consults the stage failure policy about a verify failure. A tolerated one is recorded in the result.
+func (h *LifecycleHandler) toleratedVerify(err error, result *syncer.Result) error {
+	if err == nil {
+		return nil
+	}
+	h.logger.Error("The Security Group doesn't hold the changes", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+	if h.cfg.StagePolicy.ActionFor(policy.StageVerify) != policy.Continue {
+		return err
+	}
+	result.Failures = append(result.Failures, syncer.Failure{Stage: policy.StageVerify, Category: errs.CategoryOf(err), Error: err.Error()})
+	return nil
+}
+
+// Calculates the changes like diffStep while a maintenance window is open, and defers them until it closes
+func (h *LifecycleHandler) deferStep(pc *pipelineContext, until time.Time) error {
+	if err := h.diffStep(pc); err != nil {
+		return err
+	}
+	pc.deferredUntil = until
+	if pc.targets == nil {
+		deferChanges(pc.clients, h.cfg, pc.request.Region, pc.input, pc.result, until, h.logger)
+		return nil
+	}
+	for _, t := range pc.targets {
+		if t.Error != "" {
+			continue
+		}
+		input := pc.input
+		input.SecurityGroupID = t.SecurityGroupID
+		deferChanges(pc.clients, h.cfg, pc.request.Region, input, t.Result, until, h.logger)
+	}
+	return nil
+}
+
+// Reports the result: approvals, alerts, drift, health checks and deferred removals. Builds the response.
+func (h *LifecycleHandler) reportStep(pc *pipelineContext) error {
+	if pc.targets != nil {
+		pc.response = h.reportTargets(pc)
+		pc.response.FeatureFlags, pc.response.CreatedSecurityGroupID = pc.flags, pc.createdSGID
+		pc.response.DeferredUntil = deferredUntil(pc.deferredUntil)
+		return nil
+	}
+	result := pc.result
+	if pc.tgErr != nil {
+		result.Failures = append(result.Failures, syncer.Failure{Stage: policy.StageTargets, Category: errs.CategoryOf(pc.tgErr), Error: pc.tgErr.Error()})
+	}
+	logger := h.logger
+	requestApproval(pc.clients, h.cfg, pc.input, result, logger)
+	alertFailures(pc.clients, h.cfg, pc.input, result, logger)
+	result.Drift = reportDrift(pc.clients, h.cfg, pc.input, result, logger)
+	followHealthChecks(pc.clients, h.cfg, result, logger)
+	deferred := h.deferRemovals(pc.clients, pc.request, pc.input, result)
+	pc.response = Response{Result: result, DeferredRemovals: deferred, TargetGroups: pc.targetGroups, FeatureFlags: pc.flags, CreatedSecurityGroupID: pc.createdSGID, BlueGreen: pc.swap}
+	pc.response.SecurityGroupID = pc.input.SecurityGroupID
+	pc.response.DeferredUntil = deferredUntil(pc.deferredUntil)
+	return nil
+}
+
+// Completes the lifecycle action with CONTINUE, unless one of several Security Groups failed
+func (h *LifecycleHandler) completeStep(pc *pipelineContext) error {
+	if pc.targetsErr != nil {
+		return pc.targetsErr
+	}
+	h.completeLifecycle(pc.clients, pc.request, lifecycle.ResultContinue)
+	return nil
+}
+
+// Re-invokes the function asynchronously to apply the changes. If the re-invocation fails, the changes are applied
+// inline.
+func (h *LifecycleHandler) applyAsyncStep(pc *pipelineContext) error {
+	// The lifecycle action has been completed, neither the inline apply nor a failure completes it again
+	pc.request.AsyncApply = true
+	if err := invokeAsync(pc.clients.Lambda, h.cfg.FunctionName, pc.request); err != nil {
+		h.logger.Error("Failed to invoke the asynchronous apply, applying inline", zap.Error(err))
+		var inlineErr error
+		pc.response, inlineErr = h.handle(pc.request)
+		return inlineErr
+	}
+	h.logger.Info("Lifecycle action completed, the changes are applied asynchronously")
+	pc.response = Response{AsyncApply: true}
+	return nil
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"errors"
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/plan"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+// Actions of the plan requests
+const (
+	ActionPlan  = "plan"
+	ActionApply = "apply"
+)
+
+// PlanRequest asks for the plan of a sync, or for a saved plan to be applied
+type PlanRequest struct {
+	Action string `json:"action"`
+	// AutoScalingGroupName, SecurityGroupID, Port and Region are the sync of a plan action
+	AutoScalingGroupName string `json:"asgName,omitempty"`
+	SecurityGroupID      string `json:"sgID,omitempty"`
+	Port                 int64  `json:"port,omitempty"`
+	Region               string `json:"region,omitempty"`
+	// PlanID is the plan of an apply action
+	PlanID string `json:"planID,omitempty"`
+}
+
+// PlanResponse is the plan that was saved or applied
+type PlanResponse struct {
+	plan.Plan
+	// Applied is true when the plan was applied
+	Applied bool `json:"applied"`
+}
+
+// PlanHandler computes the syncs' diffs as plans saved to S3, and applies them in separate invocations. It never
+// completes lifecycle actions.
+type PlanHandler struct {
+	newClients awsclient.Factory
+	cfg        config.Config
+	logger     *zap.Logger
+}
+
+// NewPlan creates a PlanHandler that builds its AWS clients with newClients.
+// It is meant to be created once, at cold start, and reused across invocations.
+func NewPlan(cfg config.Config, newClients awsclient.Factory) *PlanHandler {
+	configure(cfg)
+	return &PlanHandler{newClients: newClients, cfg: cfg, logger: logging.New()}
+}
+
+// Handle plans the sync or applies the plan of the request
+func (h *PlanHandler) Handle(request PlanRequest) (PlanResponse, error) {
+	defer h.logger.Sync()
+	budget := retry.Start(h.cfg.RetryBudget)
+	defer retry.Stop(budget)
+	h.logger.Info("PlanRequest", zap.Any("Request", request))
+	if h.cfg.PlanBucket == "" {
+		return PlanResponse{}, errs.Errorf(errs.Config, "handle plan request", "planBucket is not set")
+	}
+	if h.cfg.StateTable != "" || h.cfg.ReferenceSourceGroup {
+		return PlanResponse{}, errs.Errorf(errs.Config, "handle plan request", "the plans don't support stateTable or referenceSourceGroup")
+	}
+	switch request.Action {
+	case ActionPlan:
+		return h.plan(request)
+	case ActionApply:
+		return h.apply(request)
+	}
+	return PlanResponse{}, errs.Errorf(errs.Config, "handle plan request", "unknown action %q, expected %s or %s", request.Action, ActionPlan, ActionApply)
+}
+
+// Computes the diff of the sync with a dry run and saves it as a plan, with the fingerprint of the rules it was
+// computed from
+func (h *PlanHandler) plan(request PlanRequest) (PlanResponse, error) {
+	if request.AutoScalingGroupName == "" {
+		request.AutoScalingGroupName = h.cfg.DefaultAutoScalingGroup()
+	}
+	if request.SecurityGroupID == "" {
+		request.SecurityGroupID = h.cfg.SecurityGroupID
+	}
+	if request.AutoScalingGroupName == "" || request.SecurityGroupID == "" {
+		return PlanResponse{}, errs.Errorf(errs.Config, "plan sync", "asgName and sgID are required")
+	}
+	if request.Region == "" {
+		request.Region = awsclient.DefaultRegion()
+	}
+	clients, err := h.newClients(request.Region)
+	if err != nil {
+		h.logger.Error("Failed to create session", zap.Error(err))
+		return PlanResponse{}, errs.Wrap(errs.Config, "create session", err)
+	}
+	asgName, err := source.ResolveGroupName(request.AutoScalingGroupName, clients.ElasticBeanstalk)
+	if err != nil {
+		return PlanResponse{}, err
+	}
+
+	input, err := withActiveGroup(withPort(newInput(h.cfg, asgName, request.SecurityGroupID), request.Port), clients, h.cfg)
+	if err != nil {
+		return PlanResponse{}, err
+	}
+	// The dry run diffs the rules described here, through the cache
+	group, err := target.Describe(input.SecurityGroupID, clients.EC2)
+	if err != nil {
+		return PlanResponse{}, err
+	}
+	input.DryRun, input.CollectOrphans = true, h.cfg.CollectOrphans
+	result, err := syncer.Sync(input, clients.AutoScaling, clients.EC2, h.logger)
+	if err != nil {
+		h.logger.Error("Failed to plan the sync", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		return PlanResponse{}, err
+	}
+	p := plan.New(request.Region, asgName, group, result)
+	if err := plan.Save(p, clients.S3, h.cfg.PlanBucket, h.cfg.PlanPrefix); err != nil {
+		h.logger.Error("Failed to save the plan", zap.Error(err))
+		return PlanResponse{}, err
+	}
+	h.logger.Info("Plan saved", zap.String("planID", p.ID), zap.Any("changes", p.Changes))
+	return PlanResponse{Plan: p}, nil
+}
+
+// Applies the saved plan, refusing it when the Security Group's rules changed since it was made. The plan is saved
+// again with the time it was applied, so that it is applied only once.
+func (h *PlanHandler) apply(request PlanRequest) (PlanResponse, error) {
+	region := request.Region
+	if region == "" {
+		region = awsclient.DefaultRegion()
+	}
+	clients, err := h.newClients(region)
+	if err != nil {
+		h.logger.Error("Failed to create session", zap.Error(err))
+		return PlanResponse{}, errs.Wrap(errs.Config, "create session", err)
+	}
+	p, err := plan.Load(request.PlanID, clients.S3, h.cfg.PlanBucket, h.cfg.PlanPrefix)
+	if err != nil {
+		h.logger.Error("Failed to load the plan", zap.String("planID", request.PlanID), zap.Error(err))
+		return PlanResponse{}, err
+	}
+	// The plan is saved back to the bucket of the request's region, and applied with the clients of its own
+	s3Svc := clients.S3
+	if p.Region != region {
+		if clients, err = h.newClients(p.Region); err != nil {
+			return PlanResponse{Plan: p}, errs.Wrap(errs.Config, "create session", err)
+		}
+	}
+
+	logger := h.logger.With(zap.String("planID", p.ID), zap.String("sgID", p.SecurityGroupID))
+	started := time.Now()
+	err = plan.Apply(p, h.cfg.PlanMaxAge, clients.EC2)
+	result := p.Result()
+	if refused(err) {
+		result = syncer.Result{}
+	}
+	if err != nil {
+		logger.Error("Failed to apply the plan", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+	}
+	recordOutcome(h.newClients, h.cfg, syncOutcome{p.Region, p.AutoScalingGroupName, p.SecurityGroupID, result, started, err}, logger)
+	reportError(h.newClients, h.cfg, errorReport{p.Region, p.ID, p.AutoScalingGroupName, p.SecurityGroupID, err}, logger)
+	if err != nil {
+		return PlanResponse{Plan: p}, err
+	}
+
+	applied := time.Now().UTC()
+	p.AppliedAt = &applied
+	if err := plan.Save(p, s3Svc, h.cfg.PlanBucket, h.cfg.PlanPrefix); err != nil {
+		logger.Error("Failed to mark the plan as applied", zap.Error(err))
+	}
+	logger.Info("Plan applied", zap.Any("changes", p.Changes))
+	return PlanResponse{Plan: p, Applied: true}, nil
+}
+
+// Checks whether the plan was refused before any change, rather than failing half way
+func refused(err error) bool {
+	return errors.Is(err, plan.ErrApplied) || errors.Is(err, plan.ErrExpired) || errors.Is(err, plan.ErrDiverged)
+}
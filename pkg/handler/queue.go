@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/queue"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"go.uber.org/zap"
+)
+
+// QueueHandler consumes the delayed removals and the deferred syncs from SQS. A removal is applied by syncing the
+// AutoScaling Group once the instance is truly gone. Messages of instances that still exist are reported as failed, so
+// that SQS redelivers them after the queue's visibility timeout. A deferred sync is applied once its maintenance
+// window closed.
+type QueueHandler struct {
+	newClients awsclient.Factory
+	cfg        config.Config
+	logger     *zap.Logger
+}
+
+// NewQueue creates a QueueHandler that builds its AWS clients with newClients.
+// It is meant to be created once, at cold start, and reused across invocations.
+func NewQueue(cfg config.Config, newClients awsclient.Factory) *QueueHandler {
+	configure(cfg)
+	return &QueueHandler{newClients: newClients, cfg: cfg, logger: logging.New()}
+}
+
+// Handle processes every delayed removal and deferred sync of the batch
+func (h *QueueHandler) Handle(sqsEvent events.SQSEvent) (response events.SQSEventResponse, err error) {
+	defer h.logger.Sync()
+	for _, record := range sqsEvent.Records {
+		handle := h.handleRemoval
+		if queue.KindOf(record.Body) == queue.KindDeferredSync {
+			handle = h.handleDeferredSync
+		}
+		if err := handle(record); err != nil {
+			h.logger.Warn("Queued message not applied", zap.String("messageID", record.MessageId), zap.Error(err))
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+		}
+	}
+	return response, nil
+}
+
+func (h *QueueHandler) handleRemoval(record events.SQSMessage) error {
+	budget := retry.Start(h.cfg.RetryBudget)
+	defer retry.Stop(budget)
+	msg, err := queue.ParseRemoval(record.Body)
+	if err != nil {
+		// Redelivering a malformed message won't fix it
+		h.logger.Error("Dropping malformed removal message", zap.String("messageID", record.MessageId), zap.Error(err))
+		return nil
+	}
+	h.logger.Info("RemovalMessage", zap.Any("Message", msg))
+	if msg.Region == "" {
+		msg.Region = awsclient.DefaultRegion()
+	}
+
+	clients, err := h.newClients(msg.Region)
+	if err != nil {
+		return errs.Wrap(errs.Config, "create session", err)
+	}
+
+	gone, err := source.GoneInstances([]string{msg.InstanceID}, clients.EC2)
+	if err != nil {
+		return err
+	}
+	if _, ok := gone[msg.InstanceID]; !ok {
+		return errs.Errorf(errs.Source, "verify instance", "instance %s still exists", msg.InstanceID)
+	}
+
+	input := newInput(h.cfg, msg.AutoScalingGroupName, msg.SecurityGroupID)
+	if len(msg.Rules) != 0 {
+		input.Rules = msg.Rules
+	}
+	input.ApprovedRemovals = msg.CIDRs
+	started := time.Now()
+	result, _, err := syncOrDefer(clients, h.cfg, msg.Region, input, h.logger)
+	recordOutcome(h.newClients, h.cfg, syncOutcome{msg.Region, input.AutoScalingGroupName, input.SecurityGroupID, result, started, err}, h.logger)
+	trackFailures(h.newClients, h.cfg, msg.Region, input.AutoScalingGroupName, input.SecurityGroupID, err, h.logger)
+	reportError(h.newClients, h.cfg, errorReport{msg.Region, record.MessageId, input.AutoScalingGroupName, input.SecurityGroupID, err}, h.logger)
+	if err != nil {
+		return err
+	}
+	followHealthChecks(clients, h.cfg, result, h.logger)
+	return nil
+}
+
+// Applies the changes a maintenance window deferred by syncing the pair. While a window is still open, e.g. another
+// one opened or the window is longer than the queue's delay, the message is enqueued again until it closes.
+func (h *QueueHandler) handleDeferredSync(record events.SQSMessage) error {
+	budget := retry.Start(h.cfg.RetryBudget)
+	defer retry.Stop(budget)
+	msg, err := queue.ParseDeferredSync(record.Body)
+	if err != nil {
+		// Redelivering a malformed message won't fix it
+		h.logger.Error("Dropping malformed deferred sync message", zap.String("messageID", record.MessageId), zap.Error(err))
+		return nil
+	}
+	h.logger.Info("DeferredSync", zap.Any("Message", msg))
+	if msg.Region == "" {
+		msg.Region = awsclient.DefaultRegion()
+	}
+
+	clients, err := h.newClients(msg.Region)
+	if err != nil {
+		return errs.Wrap(errs.Config, "create session", err)
+	}
+
+	now := time.Now().UTC()
+	if until, open := h.cfg.MaintenanceWindows.Active(now); open {
+		if h.cfg.DeferredSyncQueueURL == "" {
+			return errs.Errorf(errs.Config, "defer sync", "maintenance window open until %s and no deferredSyncQueueURL", until)
+		}
+		msg.DeferredUntil = until
+		h.logger.Info("Maintenance window still open", zap.Time("deferredUntil", until))
+		return queue.EnqueueDeferredSync(clients.SQS, h.cfg.DeferredSyncQueueURL, msg, now)
+	}
+
+	input := newInput(h.cfg, msg.AutoScalingGroupName, msg.SecurityGroupID)
+	if len(msg.Rules) != 0 {
+		input.Rules = msg.Rules
+	}
+	started := time.Now()
+	input, err = withActiveGroup(input, clients, h.cfg)
+	if err != nil {
+		return err
+	}
+	result, err := syncer.Sync(withState(input, clients, h.cfg), clients.AutoScaling, clients.EC2, h.logger)
+	recordOutcome(h.newClients, h.cfg, syncOutcome{msg.Region, input.AutoScalingGroupName, input.SecurityGroupID, result, started, err}, h.logger)
+	trackFailures(h.newClients, h.cfg, msg.Region, input.AutoScalingGroupName, input.SecurityGroupID, err, h.logger)
+	reportError(h.newClients, h.cfg, errorReport{msg.Region, record.MessageId, input.AutoScalingGroupName, input.SecurityGroupID, err}, h.logger)
+	if err != nil {
+		return err
+	}
+	requestApproval(clients, h.cfg, input, result, h.logger)
+	alertFailures(clients, h.cfg, input, result, h.logger)
+	followHealthChecks(clients, h.cfg, result, h.logger)
+	publishApplied(clients, h.cfg, msg, result, h.logger)
+	return nil
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"go.uber.org/zap"
+)
+
+// ReconcileResponse holds the results of every pair of a scheduled reconcile
+type ReconcileResponse struct {
+	SchemaVersion int               `json:"schema_version"`
+	Pairs         []ReconcileResult `json:"pairs"`
+}
+
+// ReconcileResult is the result of the reconcile of a pair
+type ReconcileResult struct {
+	AutoScalingGroupName string `json:"asgName"`
+	SecurityGroupID      string `json:"sgID"`
+	syncer.Result
+	// Error is the error the pair failed with, if any
+	Error string `json:"error,omitempty"`
+	// DeferredUntil is when the maintenance window that deferred the changes closes
+	DeferredUntil *time.Time `json:"deferred_until,omitempty"`
+}
+
+// ReconcileHandler syncs all the configured pairs on a schedule, so that the Security Groups converge even when
+// lifecycle events are lost
+type ReconcileHandler struct {
+	newClients awsclient.Factory
+	cfg        config.Config
+	cfgErr     error
+	logger     *zap.Logger
+}
+
+// NewReconcile creates a ReconcileHandler that builds its AWS clients with newClients.
+// It is meant to be created once, at cold start, and reused across invocations.
+func NewReconcile(cfg config.Config, newClients awsclient.Factory) *ReconcileHandler {
+	configure(cfg)
+	h := &ReconcileHandler{newClients: newClients, cfg: cfg, cfgErr: cfg.Validate(), logger: logging.New()}
+	if h.cfgErr == nil && len(cfg.Pairs) == 0 {
+		h.cfgErr = errs.Errorf(errs.Config, "validate config", "the scheduled reconcile needs pairs")
+	}
+	if h.cfgErr != nil {
+		h.logger.Error("Invalid configuration", zap.Error(h.cfgErr))
+	}
+	return h
+}
+
+// Handle syncs every pair. A failing pair doesn't stop the others, the first failure is returned once they are all
+// done so that the invocation counts as failed.
+func (h *ReconcileHandler) Handle(scheduled events.CloudWatchEvent) (response ReconcileResponse, err error) {
+	defer h.logger.Sync()
+	budget := retry.Start(h.cfg.RetryBudget)
+	defer retry.Stop(budget)
+	response.SchemaVersion = SchemaVersion
+	if h.cfgErr != nil {
+		return response, h.cfgErr
+	}
+
+	region := scheduled.Region
+	if region == "" {
+		region = awsclient.DefaultRegion()
+	}
+	clients, err := h.newClients(region)
+	if err != nil {
+		h.logger.Error("Failed to create session", zap.Error(err))
+		return response, errs.Wrap(errs.Config, "create session", err)
+	}
+
+	var firstErr error
+	for _, pair := range h.cfg.Pairs {
+		result, err := h.reconcile(clients, region, scheduled.ID, pair)
+		response.Pairs = append(response.Pairs, result)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return response, firstErr
+}
+
+// Syncs the pair, with the approval requests, alerts and health checks of a lifecycle event's sync
+func (h *ReconcileHandler) reconcile(clients awsclient.Clients, region string, eventID string, pair config.Pair) (ReconcileResult, error) {
+	logger := h.logger.With(zap.String("asgName", pair.AutoScalingGroupName), zap.String("sgID", pair.SecurityGroupID))
+	entry := ReconcileResult{AutoScalingGroupName: pair.AutoScalingGroupName, SecurityGroupID: pair.SecurityGroupID}
+
+	asgName, err := source.ResolveGroupName(pair.AutoScalingGroupName, clients.ElasticBeanstalk)
+	if err != nil {
+		logger.Error("Failed to resolve the AutoScaling Group", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		entry.Error = err.Error()
+		return entry, err
+	}
+	input := newInput(h.cfg, asgName, pair.SecurityGroupID)
+	input.CollectOrphans = h.cfg.CollectOrphans
+	// The first reconcile after the maintenance window applies the changes it deferred
+	started := time.Now()
+	result, until, err := syncOrDefer(clients, h.cfg, region, input, logger)
+	recordOutcome(h.newClients, h.cfg, syncOutcome{region, asgName, pair.SecurityGroupID, result, started, err}, logger)
+	trackFailures(h.newClients, h.cfg, region, asgName, pair.SecurityGroupID, err, logger)
+	reportError(h.newClients, h.cfg, errorReport{region, eventID, asgName, pair.SecurityGroupID, err}, logger)
+	entry.Result = result
+	if err != nil {
+		logger.Error("Reconcile failed", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		entry.Error = err.Error()
+		return entry, err
+	}
+	entry.DeferredUntil = deferredUntil(until)
+	requestApproval(clients, h.cfg, input, result, logger)
+	alertFailures(clients, h.cfg, input, result, logger)
+	entry.Drift = reportDrift(clients, h.cfg, input, result, logger)
+	followHealthChecks(clients, h.cfg, result, logger)
+	return entry, nil
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/lifecycle"
+	"go.uber.org/zap"
+)
+
+// Handles an event replayed from an EventBridge archive. Its lifecycle action is only completed when it is still
+// waiting. Otherwise the AutoScaling Group is reconciled without completing anything and without excluding the
+// event's instance, so that historical replays can't abandon or drop live instances.
+func (h *LifecycleHandler) handleReplay(clients awsclient.Clients, request event.IncomingEvent) (response Response, err error) {
+	logger := h.logger.With(zap.String("replayName", request.ReplayName))
+	if h.cfgErr != nil {
+		return response, h.cfgErr
+	}
+	if err := request.Validate(); err != nil {
+		logger.Error("Invalid replayed event", zap.Error(err))
+		return response, err
+	}
+
+	if time.Since(request.Time) < lifecycle.MaxActionLifetime {
+		active, err := lifecycle.Active(clients.AutoScaling, request.Detail)
+		if err != nil {
+			return response, err
+		}
+		if active {
+			logger.Info("The replayed event's lifecycle action is still waiting, handling it")
+			request.ReplayName = ""
+			return h.handle(request)
+		}
+	}
+
+	logger.Info("Replayed event, reconciling the AutoScaling Group without completing the lifecycle action")
+	input, err := h.hookInput(request)
+	if err != nil {
+		return response, err
+	}
+	result, until, err := syncOrDefer(clients, h.cfg, request.Region, input, logger)
+	if err != nil {
+		return response, err
+	}
+	requestApproval(clients, h.cfg, input, result, logger)
+	alertFailures(clients, h.cfg, input, result, logger)
+	result.Drift = reportDrift(clients, h.cfg, input, result, logger)
+	followHealthChecks(clients, h.cfg, result, logger)
+	return Response{SecurityGroupID: input.SecurityGroupID, Result: result, DeferredUntil: deferredUntil(until)}, nil
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/attribution"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/report"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+// ReportResponse holds the S3 keys of the report
+type ReportResponse struct {
+	Keys []string `json:"keys"`
+}
+
+// ReportHandler produces the compliance report of all the configured pairs on a schedule. It never changes the
+// Security Groups, every pair is checked with a dry run.
+type ReportHandler struct {
+	newClients awsclient.Factory
+	cfg        config.Config
+	cfgErr     error
+	logger     *zap.Logger
+}
+
+// NewReport creates a ReportHandler that builds its AWS clients with newClients.
+// It is meant to be created once, at cold start, and reused across invocations.
+func NewReport(cfg config.Config, newClients awsclient.Factory) *ReportHandler {
+	configure(cfg)
+	h := &ReportHandler{newClients: newClients, cfg: cfg, cfgErr: cfg.Validate(), logger: logging.New()}
+	if h.cfgErr == nil && (cfg.ReportBucket == "" || len(cfg.Pairs) == 0) {
+		h.cfgErr = errs.Errorf(errs.Config, "validate config", "the report needs reportBucket and pairs")
+	}
+	if h.cfgErr != nil {
+		h.logger.Error("Invalid configuration", zap.Error(h.cfgErr))
+	}
+	return h
+}
+
+// Handle checks every pair and uploads the report to S3
+func (h *ReportHandler) Handle(scheduled events.CloudWatchEvent) (response ReportResponse, err error) {
+	defer h.logger.Sync()
+	budget := retry.Start(h.cfg.RetryBudget)
+	defer retry.Stop(budget)
+	if h.cfgErr != nil {
+		return response, h.cfgErr
+	}
+
+	region := scheduled.Region
+	if region == "" {
+		region = awsclient.DefaultRegion()
+	}
+	clients, err := h.newClients(region)
+	if err != nil {
+		h.logger.Error("Failed to create session", zap.Error(err))
+		return response, errs.Wrap(errs.Config, "create session", err)
+	}
+
+	rep := report.Report{GeneratedAt: time.Now().UTC()}
+	for _, pair := range h.cfg.Pairs {
+		rep.Entries = append(rep.Entries, h.check(clients, pair))
+	}
+
+	response.Keys, err = report.Upload(clients.S3, h.cfg.ReportBucket, h.cfg.ReportPrefix, rep)
+	if err != nil {
+		h.logger.Error("Failed to upload the report", zap.Error(err))
+		return response, err
+	}
+	h.logger.Info("Report uploaded", zap.String("bucket", h.cfg.ReportBucket), zap.Strings("keys", response.Keys))
+	return response, nil
+}
+
+// Checks the pair with a dry run and gathers its rule counts
+func (h *ReportHandler) check(clients awsclient.Clients, pair config.Pair) report.Entry {
+	logger := h.logger.With(zap.String("asgName", pair.AutoScalingGroupName), zap.String("sgID", pair.SecurityGroupID))
+	entry := report.Entry{AutoScalingGroupName: pair.AutoScalingGroupName, SecurityGroupID: pair.SecurityGroupID}
+
+	asgName, err := source.ResolveGroupName(pair.AutoScalingGroupName, clients.ElasticBeanstalk)
+	if err != nil {
+		logger.Error("Failed to resolve the AutoScaling Group", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		entry.Error = err.Error()
+		return entry
+	}
+	input, err := withActiveGroup(newInput(h.cfg, asgName, pair.SecurityGroupID), clients, h.cfg)
+	if err != nil {
+		logger.Error("Failed to resolve the active Security Group", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		entry.Error = err.Error()
+		return entry
+	}
+	input.DryRun = true
+	result, err := syncer.Sync(withState(input, clients, h.cfg), clients.AutoScaling, clients.EC2, logger)
+	if err != nil {
+		logger.Error("Failed to check the pair", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		entry.Error = err.Error()
+		return entry
+	}
+	entry.PendingAdds = result.AddedIPs
+	entry.PendingRemovals = append(append([]string{}, result.RemovedIPs...), result.PendingRemovals...)
+	entry.Drift = result.Drift
+	if h.cfg.DriftAttribution && len(entry.Drift) != 0 {
+		if entry.Drift, err = attribution.Attribute(clients.CloudTrail, pair.SecurityGroupID, entry.Drift); err != nil {
+			logger.Warn("Failed to attribute the external changes", zap.Error(err))
+		}
+	}
+	entry.Failures = result.Failures
+
+	if entry.TotalRules, entry.ManagedRules, err = target.RuleCounts(pair.SecurityGroupID, clients.EC2); err != nil {
+		logger.Error("Failed to count the Security Group's rules", zap.Error(err))
+		entry.Error = err.Error()
+		return entry
+	}
+	if last, ok, err := target.LastManagedChange(pair.SecurityGroupID, clients.EC2); err != nil {
+		logger.Error("Failed to get the last change of the Security Group", zap.Error(err))
+	} else if ok {
+		entry.LastChange = &last
+	}
+	return entry
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/version"
+)
+
+// SchemaVersion is the version of the Response's shape. New fields are always optional and don't bump it, the version
+// only changes when a field is removed or changes meaning. Version 1 is the shape from before the versioning, without
+// schema_version.
+const SchemaVersion = 2
+
+// Gets the Info of the running build, for the responses
+func build() *version.Info {
+	info := version.Get()
+	return &info
+}
+
+// ParseResponse decodes a Response of any schema version, e.g. one received through a Lambda Destination, converting
+// the older shapes to the current one
+func ParseResponse(data []byte) (response Response, err error) {
+	if err := json.Unmarshal(data, &response); err != nil {
+		return response, errs.Wrap(errs.Config, "parse response", err)
+	}
+	switch {
+	case response.SchemaVersion == 0:
+		upgradeV1(&response)
+	case response.SchemaVersion > SchemaVersion:
+		return response, errs.Errorf(errs.Config, "parse response", "unsupported schema version %d, expected at most %d", response.SchemaVersion, SchemaVersion)
+	}
+	response.SchemaVersion = SchemaVersion
+	return response, nil
+}
+
+// Converts a version 1 Response. Version 1 synced a single rule, reported as the default one, and had no per-rule
+// breakdown.
+func upgradeV1(response *Response) {
+	if len(response.Rules) == 0 && (len(response.AddedIPs) != 0 || len(response.RemovedIPs) != 0) {
+		response.Rules = []syncer.RuleResult{{Rule: target.DefaultRule, AddedIPs: response.AddedIPs, RemovedIPs: response.RemovedIPs}}
+	}
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/sentry"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/version"
+	"go.uber.org/zap"
+)
+
+// The Sentry DSNs read from Secrets Manager, by secret, cached for the lifetime of the container
+var sentryDSNs sync.Map
+
+// errorReport is the failure of an invocation reported to Sentry. eventID identifies the event that triggered it,
+// e.g. the lifecycle event or the SQS message.
+type errorReport struct {
+	region  string
+	eventID string
+	asgName string
+	sgID    string
+	err     error
+}
+
+// Reports the error or the panic to Sentry, with the event, the pair and the failure category, if enabled. Failures
+// are logged, they never fail the invocation.
+func reportError(newClients awsclient.Factory, cfg config.Config, report errorReport, logger *zap.Logger) {
+	if cfg.SentryDSNSecret == "" || report.err == nil {
+		return
+	}
+	clients, err := newClients(report.region)
+	if err != nil {
+		logger.Error("Failed to create session", zap.Error(err))
+		return
+	}
+	dsn, err := sentryDSN(clients.SecretsManager, cfg.SentryDSNSecret)
+	if err != nil {
+		logger.Error("Failed to read the Sentry DSN", zap.Error(err))
+		return
+	}
+
+	category := errs.CategoryOf(report.err)
+	var panicErr *PanicError
+	panicked := errors.As(report.err, &panicErr)
+	event := sentry.NewEvent(string(category), report.err, panicked)
+	event.Release = version.Version
+	event.Environment = cfg.SentryEnvironment
+	event.ServerName = cfg.FunctionName
+	// The messages carry IDs, the events are grouped by what failed instead
+	event.Fingerprint = []string{string(category), errs.OpOf(report.err)}
+	event.Tags = map[string]string{
+		"asg":          report.asgName,
+		"sg":           report.sgID,
+		"category":     string(category),
+		"region":       report.region,
+		"event":        report.eventID,
+		"handler_mode": cfg.HandlerMode,
+	}
+	event.Extra = map[string]string{"commit": version.Commit}
+	if panicked {
+		event.Fingerprint = []string{"panic", fmt.Sprint(panicErr.Value)}
+		event.Extra["stack"] = panicErr.Stack
+	}
+	if err := sentry.Capture(dsn, event, "sg-sync/"+version.Version); err != nil {
+		logger.Error("Failed to report the error to Sentry", zap.Error(err))
+		return
+	}
+	logger.Info("Error reported to Sentry", zap.String("sentryEventID", event.EventID))
+}
+
+// Gets the DSN of the secret, either the secret string itself or its "dsn" key when it is JSON
+func sentryDSN(smSvc secretsmanageriface.SecretsManagerAPI, secretID string) (sentry.DSN, error) {
+	if dsn, ok := sentryDSNs.Load(secretID); ok {
+		return dsn.(sentry.DSN), nil
+	}
+	out, err := smSvc.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: aws.String(secretID)})
+	if err != nil {
+		return sentry.DSN{}, errs.Wrap(errs.Config, "get secret value", err)
+	}
+	raw := aws.StringValue(out.SecretString)
+	if strings.HasPrefix(strings.TrimSpace(raw), "{") {
+		var secret struct {
+			DSN string `json:"dsn"`
+		}
+		if err := json.Unmarshal([]byte(raw), &secret); err != nil {
+			return sentry.DSN{}, errs.Wrap(errs.Config, "parse sentry secret", err)
+		}
+		raw = secret.DSN
+	}
+	dsn, err := sentry.ParseDSN(raw)
+	if err != nil {
+		return dsn, errs.Wrap(errs.Config, "parse sentry secret", err)
+	}
+	sentryDSNs.Store(secretID, dsn)
+	return dsn, nil
+}
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/version"
+	"go.uber.org/zap"
+)
+
+// TaskInput is the input of the Step Functions task
+type TaskInput struct {
+	AutoScalingGroupName string `json:"asgName"`
+	SecurityGroupID      string `json:"sgID"`
+	Port                 int64  `json:"port,omitempty"`
+	ExcludeInstanceID    string `json:"excludeInstanceID,omitempty"`
+	DryRun               bool   `json:"dryRun,omitempty"`
+	Region               string `json:"region,omitempty"`
+	// ApprovedRemovals, when set, restricts the removals to these IPs (e.g. after a manual approval state)
+	ApprovedRemovals []string `json:"approvedRemovals,omitempty"`
+	// Config is the task's layer of settings, e.g. {"dryRun":true}
+	Config json.RawMessage `json:"sgSyncConfig,omitempty"`
+}
+
+// TaskOutput is the output of the Step Functions task
+type TaskOutput struct {
+	AutoScalingGroupName string   `json:"asgName"`
+	SecurityGroupID      string   `json:"sgID"`
+	AddedIPs             []string `json:"addedIPs"`
+	RemovedIPs           []string `json:"removedIPs"`
+	DryRun               bool     `json:"dryRun"`
+	PendingRemovals      []string `json:"pendingRemovals,omitempty"`
+	BlockedRemovals      []string `json:"blockedRemovals,omitempty"`
+	CollectedOrphans     []string `json:"collectedOrphans,omitempty"`
+	ExpiredRules         []string `json:"expiredRules,omitempty"`
+	// Failures are the stage failures that the policy tolerated
+	Failures []syncer.Failure `json:"failures,omitempty"`
+	// Skipped are the IPs that were intentionally not added or removed, with the reason
+	Skipped []syncer.Skip `json:"skipped,omitempty"`
+	// Rules are the IPs added and removed by every rule
+	Rules []syncer.RuleResult `json:"rules,omitempty"`
+	// AddedByInstance and RemovedByInstance map the IDs of the instances to their CIDRs
+	AddedByInstance   map[string][]string `json:"addedByInstance,omitempty"`
+	RemovedByInstance map[string][]string `json:"removedByInstance,omitempty"`
+	// Drift are the changes other actors made to the rule sets since the last sync
+	Drift []syncer.Drift `json:"drift,omitempty"`
+	// DeferredUntil is when the maintenance window that deferred the changes closes, the changes are then the ones
+	// the sync would have made
+	DeferredUntil *time.Time `json:"deferredUntil,omitempty"`
+	// Build identifies the build that made the changes
+	Build *version.Info `json:"build,omitempty"`
+}
+
+// InvalidInputError is returned when the task input is incomplete. Retrying it is pointless.
+type InvalidInputError struct {
+	Message string
+}
+
+func (e *InvalidInputError) Error() string { return e.Message }
+
+// RetriableError wraps a throttling or transient AWS error. States can match it in a Retry block.
+type RetriableError struct {
+	Err error
+}
+
+func (e *RetriableError) Error() string { return e.Err.Error() }
+
+func (e *RetriableError) Unwrap() error { return e.Err }
+
+// TaskFailedError wraps any other error of the sync. States should route it to a Catch block.
+type TaskFailedError struct {
+	Err error
+}
+
+func (e *TaskFailedError) Error() string { return e.Err.Error() }
+
+func (e *TaskFailedError) Unwrap() error { return e.Err }
+
+// TaskHandler runs the sync as a Step Functions task. It never completes lifecycle actions,
+// that is left to the state machine.
+type TaskHandler struct {
+	newClients awsclient.Factory
+	cfg        config.Config
+	logger     *zap.Logger
+}
+
+// NewTask creates a TaskHandler that builds its AWS clients with newClients.
+// It is meant to be created once, at cold start, and reused across invocations.
+func NewTask(cfg config.Config, newClients awsclient.Factory) *TaskHandler {
+	configure(cfg)
+	return &TaskHandler{newClients: newClients, cfg: cfg, logger: logging.New()}
+}
+
+// Handle runs the sync described by the task input
+func (h *TaskHandler) Handle(input TaskInput) (output TaskOutput, err error) {
+	logger := h.logger
+	defer logger.Sync()
+	logger.Info("TaskInput", zap.Any("Input", input))
+
+	cfg, err := withEventLayer(h.cfg, input.Config, logger)
+	if err != nil {
+		return output, &InvalidInputError{Message: err.Error()}
+	}
+	h = &TaskHandler{newClients: h.newClients, cfg: cfg, logger: logger}
+	budget := retry.Start(h.cfg.RetryBudget)
+	defer retry.Stop(budget)
+	if input.AutoScalingGroupName == "" {
+		input.AutoScalingGroupName = h.cfg.DefaultAutoScalingGroup()
+	}
+	if input.AutoScalingGroupName == "" || input.SecurityGroupID == "" {
+		return output, &InvalidInputError{Message: "asgName and sgID are required"}
+	}
+	if input.Region == "" {
+		input.Region = awsclient.DefaultRegion()
+	}
+
+	clients, err := h.newClients(input.Region)
+	if err != nil {
+		logger.Error("Failed to create session", zap.Error(err))
+		return output, classify(errs.Wrap(errs.Config, "create session", err))
+	}
+	if input.AutoScalingGroupName, err = source.ResolveGroupName(input.AutoScalingGroupName, clients.ElasticBeanstalk); err != nil {
+		logger.Error("Failed to resolve the AutoScaling Group", zap.Error(err))
+		return output, classify(err)
+	}
+
+	// The state machine owns the approval flow, it gets the parked removals in the output
+	syncInput := newInput(h.cfg, input.AutoScalingGroupName, input.SecurityGroupID)
+	syncInput = withPort(syncInput, input.Port)
+	syncInput.ExcludeInstanceID = input.ExcludeInstanceID
+	syncInput.DryRun = syncInput.DryRun || input.DryRun
+	syncInput.ApprovedRemovals = input.ApprovedRemovals
+	syncInput.CollectOrphans = h.cfg.CollectOrphans
+
+	started := time.Now()
+	result, until, err := syncOrDefer(clients, h.cfg, input.Region, syncInput, logger)
+	recordOutcome(h.newClients, h.cfg, syncOutcome{input.Region, input.AutoScalingGroupName, input.SecurityGroupID, result, started, err}, logger)
+	trackFailures(h.newClients, h.cfg, input.Region, input.AutoScalingGroupName, input.SecurityGroupID, err, logger)
+	reportError(h.newClients, h.cfg, errorReport{input.Region, "", input.AutoScalingGroupName, input.SecurityGroupID, err}, logger)
+	if err != nil {
+		return output, classify(err)
+	}
+	followHealthChecks(clients, h.cfg, result, logger)
+
+	return TaskOutput{
+		Build:                build(),
+		AutoScalingGroupName: input.AutoScalingGroupName,
+		SecurityGroupID:      input.SecurityGroupID,
+		AddedIPs:             result.AddedIPs,
+		RemovedIPs:           result.RemovedIPs,
+		DryRun:               result.DryRun,
+		DeferredUntil:        deferredUntil(until),
+		PendingRemovals:      result.PendingRemovals,
+		BlockedRemovals:      result.BlockedRemovals,
+		CollectedOrphans:     result.CollectedOrphans,
+		ExpiredRules:         result.ExpiredRules,
+		Failures:             result.Failures,
+		Skipped:              result.Skipped,
+		Rules:                result.Rules,
+		AddedByInstance:      result.AddedByInstance,
+		RemovedByInstance:    result.RemovedByInstance,
+		Drift:                result.Drift,
+	}, nil
+}
+
+// Maps the error's category to the error names the state machine branches on
+func classify(err error) error {
+	switch errs.CategoryOf(err) {
+	case errs.Throttle:
+		return &RetriableError{Err: err}
+	case errs.Config:
+		return &InvalidInputError{Message: err.Error()}
+	default:
+		return &TaskFailedError{Err: err}
+	}
+}
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/targetgroup"
+	"go.uber.org/zap"
+)
+
+// Registers the launching instance with, or deregisters the terminating instance from, the configured target groups.
+// Returns the target groups that were updated.
+func (h *LifecycleHandler) updateTargetGroups(clients awsclient.Clients, request event.IncomingEvent) ([]string, error) {
+	var updated []string
+	instanceID := request.Detail.EC2InstanceID
+	for _, arn := range h.cfg.TargetGroupARNs {
+		var err error
+		if request.Detail.IsTerminating() {
+			err = targetgroup.Deregister(clients.ELBv2, arn, instanceID, h.cfg.TargetGroupPort)
+		} else {
+			err = targetgroup.Register(clients.ELBv2, arn, instanceID, h.cfg.TargetGroupPort)
+		}
+		if err != nil {
+			h.logger.Error("Failed to update the target group", zap.String("targetGroupARN", arn), zap.Error(err))
+			return updated, err
+		}
+		updated = append(updated, arn)
+	}
+	if len(updated) != 0 {
+		h.logger.Info("Target groups updated", zap.String("instanceID", instanceID), zap.Strings("targetGroupARNs", updated))
+	}
+	return updated, nil
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/policy"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"go.uber.org/zap"
+)
+
+// Gets the Security Groups the event syncs: the hook's own, else the configured list, else the configured one
+func (h *LifecycleHandler) securityGroups(request event.IncomingEvent, input syncer.Input) []string {
+	if settings, err := h.hookSettings(request); err == nil && settings.SecurityGroupID != "" {
+		return []string{input.SecurityGroupID}
+	}
+	if len(h.cfg.SecurityGroupIDs) != 0 {
+		return h.cfg.SecurityGroupIDs
+	}
+	return []string{input.SecurityGroupID}
+}
+
+// Reports the results of several Security Groups. Every Security Group that synced gets its approvals, alerts and
+// deferred removals, whatever happened to the others.
+func (h *LifecycleHandler) reportTargets(pc *pipelineContext) Response {
+	logger := h.logger
+	var deferred []string
+	for i := range pc.targets {
+		target := &pc.targets[i]
+		if target.Error != "" {
+			logger.Error("Failed to sync the Security Group", zap.String("sgID", target.SecurityGroupID), zap.String("error", target.Error), zap.String("category", string(target.Category)))
+			continue
+		}
+		if pc.tgErr != nil {
+			target.Failures = append(target.Failures, syncer.Failure{Stage: policy.StageTargets, Category: errs.CategoryOf(pc.tgErr), Error: pc.tgErr.Error()})
+		}
+		targetInput := pc.input
+		targetInput.SecurityGroupID = target.SecurityGroupID
+		targetLogger := logger.With(zap.String("sgID", target.SecurityGroupID))
+		requestApproval(pc.clients, h.cfg, targetInput, target.Result, targetLogger)
+		alertFailures(pc.clients, h.cfg, targetInput, target.Result, targetLogger)
+		target.Drift = reportDrift(pc.clients, h.cfg, targetInput, target.Result, targetLogger)
+		followHealthChecks(pc.clients, h.cfg, target.Result, targetLogger)
+		deferred = append(deferred, h.deferRemovals(pc.clients, pc.request, targetInput, target.Result)...)
+	}
+	return Response{Targets: pc.targets, DeferredRemovals: deferred, TargetGroups: pc.targetGroups}
+}
@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/config"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/logging"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/parameter"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/retry"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/state"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+// TeardownRequest asks for the removal of every rule the function owns, e.g. before it is decommissioned
+type TeardownRequest struct {
+	// Confirm must be the teardownConfirmationToken
+	Confirm string `json:"confirm"`
+	Region  string `json:"region,omitempty"`
+	// SecurityGroupIDs restricts the teardown to some of the configured Security Groups
+	SecurityGroupIDs []string `json:"sgIDs,omitempty"`
+	// DryRun lists the rules that would be removed
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// TeardownResponse holds the results of every Security Group of the teardown
+type TeardownResponse struct {
+	DryRun  bool             `json:"dry_run"`
+	Targets []TeardownResult `json:"targets"`
+}
+
+// TeardownResult is the result of the teardown of a Security Group
+type TeardownResult struct {
+	SecurityGroupID string `json:"sgID"`
+	// Revoked are the owned rules that were (or, on a dry run, would have been) removed
+	Revoked []target.OwnedRule `json:"revoked,omitempty"`
+	// ClearedRecords is how many records of the state table were dropped
+	ClearedRecords int `json:"cleared_records,omitempty"`
+	// Error is the error the Security Group failed with, if any
+	Error string `json:"error,omitempty"`
+}
+
+// TeardownHandler removes every rule the function owns on the configured Security Groups, and clears their state
+// records. It only acts on requests that carry the confirmation token.
+type TeardownHandler struct {
+	newClients awsclient.Factory
+	cfg        config.Config
+	cfgErr     error
+	logger     *zap.Logger
+}
+
+// NewTeardown creates a TeardownHandler that builds its AWS clients with newClients.
+// It is meant to be created once, at cold start, and reused across invocations.
+func NewTeardown(cfg config.Config, newClients awsclient.Factory) *TeardownHandler {
+	configure(cfg)
+	h := &TeardownHandler{newClients: newClients, cfg: cfg, cfgErr: cfg.Validate(), logger: logging.New()}
+	if h.cfgErr == nil && cfg.TeardownToken == "" {
+		h.cfgErr = errs.Errorf(errs.Config, "validate config", "the teardown needs teardownConfirmationToken")
+	}
+	if h.cfgErr != nil {
+		h.logger.Error("Invalid configuration", zap.Error(h.cfgErr))
+	}
+	return h
+}
+
+// Handle tears every Security Group down. A failing group doesn't stop the others, the first failure is returned once
+// they are all done so that the invocation counts as failed.
+func (h *TeardownHandler) Handle(request TeardownRequest) (response TeardownResponse, err error) {
+	defer h.logger.Sync()
+	budget := retry.Start(h.cfg.RetryBudget)
+	defer retry.Stop(budget)
+	response.DryRun = request.DryRun
+	if h.cfgErr != nil {
+		return response, h.cfgErr
+	}
+	if subtle.ConstantTimeCompare([]byte(request.Confirm), []byte(h.cfg.TeardownToken)) != 1 {
+		h.logger.Warn("Teardown request without the confirmation token, refusing it")
+		return response, errs.Errorf(errs.Config, "handle teardown request", "confirm doesn't match the confirmation token")
+	}
+	h.logger.Info("TeardownRequest", zap.Strings("sgIDs", request.SecurityGroupIDs), zap.Bool("dryRun", request.DryRun))
+
+	region := request.Region
+	if region == "" {
+		region = awsclient.DefaultRegion()
+	}
+	clients, err := h.newClients(region)
+	if err != nil {
+		h.logger.Error("Failed to create session", zap.Error(err))
+		return response, errs.Wrap(errs.Config, "create session", err)
+	}
+	sgIDs, err := h.targets(clients, request.SecurityGroupIDs)
+	if err != nil {
+		h.logger.Error("Failed to list the Security Groups", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		return response, err
+	}
+
+	var firstErr error
+	for _, sgID := range sgIDs {
+		result, err := h.teardown(clients, sgID, request.DryRun)
+		response.Targets = append(response.Targets, result)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return response, firstErr
+}
+
+// Gets the Security Groups of the teardown: the configured ones, those of the pairs, of the tenants and of the hook
+// targets, the instances' group of the return rules and the one the function adopted or swapped in. requested
+// restricts them.
+func (h *TeardownHandler) targets(clients awsclient.Clients, requested []string) ([]string, error) {
+	var sgIDs []string
+	seen := make(map[string]bool)
+	add := func(sgID string) {
+		if sgID != "" && !seen[sgID] {
+			seen[sgID] = true
+			sgIDs = append(sgIDs, sgID)
+		}
+	}
+	switch {
+	case h.cfg.BlueGreen:
+		sgID, err := activeGroup(clients, h.cfg)
+		if err != nil {
+			return nil, err
+		}
+		add(sgID)
+	case h.cfg.CreatesSecurityGroup():
+		sgID, _, err := parameter.Get(clients.SSM, h.cfg.SecurityGroupParameter)
+		if err != nil {
+			return nil, err
+		}
+		add(sgID)
+	default:
+		add(h.cfg.SecurityGroupID)
+	}
+	for _, sgID := range h.cfg.SecurityGroupIDs {
+		add(sgID)
+	}
+	for _, pair := range h.cfg.Pairs {
+		add(pair.SecurityGroupID)
+	}
+	for _, tenant := range h.cfg.Tenants {
+		add(tenant.SecurityGroupID)
+	}
+	hooks := make([]string, 0, len(h.cfg.HookTargets))
+	for name := range h.cfg.HookTargets {
+		hooks = append(hooks, name)
+	}
+	sort.Strings(hooks)
+	for _, name := range hooks {
+		add(h.cfg.HookTargets[name].SecurityGroupID)
+	}
+	add(h.cfg.ReturnSecurityGroupID)
+
+	if len(requested) == 0 {
+		return sgIDs, nil
+	}
+	for _, sgID := range requested {
+		if !seen[sgID] {
+			return nil, errs.Errorf(errs.Config, "handle teardown request", "%s is not one of the function's security groups", sgID)
+		}
+	}
+	return requested, nil
+}
+
+// Removes the owned rules of the Security Group: those of its descriptions and those of its state records, whose
+// descriptions may have been edited. The records of every removed rule set are dropped along with it, and the snapshots
+// and failure counts once they are all gone.
+func (h *TeardownHandler) teardown(clients awsclient.Clients, sgID string, dryRun bool) (TeardownResult, error) {
+	logger := h.logger.With(zap.String("sgID", sgID))
+	result := TeardownResult{SecurityGroupID: sgID}
+	fail := func(msg string, err error) (TeardownResult, error) {
+		logger.Error(msg, zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		result.Error = err.Error()
+		return result, err
+	}
+
+	owned, err := target.OwnedRules(sgID, clients.EC2)
+	if err != nil {
+		return fail("Failed to read the owned rules", err)
+	}
+	var store *state.Store
+	records := make(map[target.Rule][]string)
+	if h.cfg.StateTable != "" && clients.DynamoDB != nil {
+		store = &state.Store{Svc: clients.DynamoDB, Table: h.cfg.StateTable}
+		if records, err = store.Records(sgID); err != nil {
+			return fail("Failed to read the state records", err)
+		}
+		if owned, err = withRecords(sgID, owned, records, clients.EC2); err != nil {
+			return fail("Failed to read the recorded rules", err)
+		}
+	}
+	forget := func(rule target.Rule) error {
+		if dryRun || store == nil || len(records[rule]) == 0 {
+			return nil
+		}
+		if err := store.Forget(sgID, rule, records[rule]); err != nil {
+			return err
+		}
+		result.ClearedRecords += len(records[rule])
+		delete(records, rule)
+		return nil
+	}
+	for _, rule := range owned {
+		if !dryRun {
+			if err := target.RevokeOwned(sgID, rule, clients.EC2); err != nil {
+				return fail("Failed to revoke the owned rules", err)
+			}
+		}
+		result.Revoked = append(result.Revoked, rule)
+		if err := forget(rule.Rule); err != nil {
+			return fail("Failed to forget the revoked rules", err)
+		}
+	}
+	// The records left are those of rules that are already gone
+	for rule := range records {
+		if err := forget(rule); err != nil {
+			return fail("Failed to forget the removed rules", err)
+		}
+	}
+	if !dryRun && store != nil {
+		cleared, err := store.ClearHistory(sgID)
+		result.ClearedRecords += cleared
+		if err != nil {
+			return fail("Failed to clear the state records", err)
+		}
+	}
+	logger.Info("Security Group torn down", zap.Any("revoked", result.Revoked), zap.Int("clearedRecords", result.ClearedRecords), zap.Bool("dryRun", dryRun))
+	return result, nil
+}
+
+// Adds the recorded CIDRs that are still in the Security Group to its owned rules, whatever their descriptions
+func withRecords(sgID string, owned []target.OwnedRule, records map[target.Rule][]string, ec2Svc ec2iface.EC2API) ([]target.OwnedRule, error) {
+	index := make(map[target.Rule]int, len(owned))
+	for i, rule := range owned {
+		index[rule.Rule] = i
+	}
+	rules := make([]target.Rule, 0, len(records))
+	for rule := range records {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].String() < rules[j].String() })
+	for _, rule := range rules {
+		sgIPs, err := target.SecurityGroupIPs(sgID, rule, ec2Svc)
+		if err != nil {
+			return owned, err
+		}
+		seen := make(map[string]bool)
+		if i, ok := index[rule]; ok {
+			for _, c := range owned[i].CIDRs {
+				seen[c] = true
+			}
+		}
+		for _, c := range records[rule] {
+			if _, present := sgIPs[c]; !present || seen[c] {
+				continue
+			}
+			i, ok := index[rule]
+			if !ok {
+				i = len(owned)
+				index[rule] = i
+				owned = append(owned, target.OwnedRule{Rule: rule})
+			}
+			owned[i].CIDRs = append(owned[i].CIDRs, c)
+			seen[c] = true
+		}
+	}
+	return owned, nil
+}
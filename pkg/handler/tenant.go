@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"go.uber.org/zap"
+)
+
+// Gets the handler of the event's tenant: a copy of the handler with the tenant's block of configuration, named by the
+// AutoScaling Group's tenant tag. The groups without the tag are handled with the function's configuration, the ones
+// whose tenant has no block are refused rather than synced into the function's Security Group.
+func (h *LifecycleHandler) forTenant(clients awsclient.Clients, request event.IncomingEvent) (*LifecycleHandler, string, error) {
+	if h.cfgErr != nil || h.cfg.TenantTag == "" {
+		return h, "", nil
+	}
+	asgName := request.Detail.AutoScalingGroupName
+	tenant, found, err := source.GroupTag(asgName, h.cfg.TenantTag, clients.AutoScaling)
+	if err != nil {
+		h.logger.Error("Failed to read the tenant tag", zap.String("tag", h.cfg.TenantTag), zap.Error(err))
+		return h, "", err
+	}
+	if !found {
+		return h, "", nil
+	}
+	cfg, ok := h.cfg.ForTenant(tenant)
+	if !ok {
+		return h, tenant, errs.Errorf(errs.Config, "resolve tenant", "%s=%s of %s has no tenant configuration", h.cfg.TenantTag, tenant, asgName)
+	}
+	logger := h.logger.With(zap.String("tenant", tenant))
+	logger.Info("Tenant configuration", zap.String("sgID", cfg.SecurityGroupID))
+	return &LifecycleHandler{newClients: h.newClients, cfg: cfg, logger: logger}, tenant, nil
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// Checks that the Security Group, described again, holds the added IPs of every rule and none of the removed ones
+func verifyChanges(sgID string, result syncer.Result, clients awsclient.Clients) error {
+	if result.DryRun || sgID == "" {
+		return nil
+	}
+	var missing, lingering []string
+	for _, rule := range result.Rules {
+		if len(rule.AddedIPs)+len(rule.RemovedIPs) == 0 {
+			continue
+		}
+		sgIPs, err := target.FreshSecurityGroupIPs(sgID, rule.Rule, clients.EC2)
+		if err != nil {
+			return err
+		}
+		for _, c := range rule.AddedIPs {
+			if _, ok := sgIPs[c]; !ok {
+				missing = append(missing, rule.Rule.String()+" "+c)
+			}
+		}
+		for _, c := range rule.RemovedIPs {
+			if _, ok := sgIPs[c]; ok {
+				lingering = append(lingering, rule.Rule.String()+" "+c)
+			}
+		}
+	}
+	if len(missing)+len(lingering) != 0 {
+		return errs.Errorf(errs.Target, "verify security group", "%s misses the added %v and still holds the removed %v", sgID, missing, lingering)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/awsclient"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/lifecycle"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"go.uber.org/zap"
+)
+
+// Waits, up to the configured limit, until the launching instance is running with its public IP
+func (h *LifecycleHandler) waitForPublicIP(clients awsclient.Clients, detail event.Detail) {
+	if h.cfg.PublicIPWait <= 0 || detail.IsTerminating() {
+		return
+	}
+	logger := h.logger.With(zap.String("instanceID", detail.EC2InstanceID))
+	start := time.Now()
+	var ip string
+	err := h.waitWithHeartbeats(clients, detail, h.cfg.PublicIPWait, logger, func(ctx context.Context) (err error) {
+		ip, err = source.WaitForPublicIP(ctx, detail.EC2InstanceID, clients.EC2)
+		return err
+	})
+	if err != nil {
+		logger.Warn("The instance has no public IP yet, syncing without it", zap.Duration("waited", time.Since(start)), zap.Error(err))
+		return
+	}
+	logger.Info("The instance has its public IP", zap.String("publicIP", ip), zap.Duration("waited", time.Since(start)))
+}
+
+// Waits, up to the configured limit, until the launching instance passes both EC2 status checks. The error fails the
+// launch, so that a half-booted instance never gets its IP authorized.
+func (h *LifecycleHandler) waitForStatusChecks(clients awsclient.Clients, detail event.Detail) error {
+	if h.cfg.StatusCheckWait <= 0 || detail.IsTerminating() {
+		return nil
+	}
+	logger := h.logger.With(zap.String("instanceID", detail.EC2InstanceID))
+	start := time.Now()
+	err := h.waitWithHeartbeats(clients, detail, h.cfg.StatusCheckWait, logger, func(ctx context.Context) error {
+		return source.WaitForStatusChecks(ctx, detail.EC2InstanceID, clients.EC2)
+	})
+	if err != nil {
+		logger.Error("The instance didn't pass its status checks, not syncing it", zap.Duration("waited", time.Since(start)), zap.Error(err))
+		return err
+	}
+	logger.Info("The instance passed its status checks", zap.Duration("waited", time.Since(start)))
+	return nil
+}
+
+// Runs the wait with a deadline of limit. The hook's heartbeat timeout sizes the wait: heartbeats are recorded every
+// half timeout, so that the action never times out while waiting.
+func (h *LifecycleHandler) waitWithHeartbeats(clients awsclient.Clients, detail event.Detail, limit time.Duration, logger *zap.Logger, wait func(ctx context.Context) error) error {
+	timeout, err := lifecycle.HeartbeatTimeout(clients.AutoScaling, detail)
+	if err != nil {
+		logger.Warn("Failed to get the hook's heartbeat timeout, assuming the default", zap.Duration("heartbeatTimeout", timeout), zap.Error(err))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), limit)
+	defer cancel()
+	if heartbeatEvery := timeout / 2; heartbeatEvery > 0 {
+		go heartbeat(ctx, clients, detail, heartbeatEvery, logger)
+	}
+	return wait(ctx)
+}
+
+// Records the lifecycle action's heartbeat every interval, until ctx is done
+func heartbeat(ctx context.Context, clients awsclient.Clients, detail event.Detail, every time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := lifecycle.Heartbeat(clients.AutoScaling, detail); err != nil {
+				logger.Warn("Failed to record the lifecycle action heartbeat", zap.Error(err))
+			}
+		}
+	}
+}
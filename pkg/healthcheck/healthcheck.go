@@ -0,0 +1,99 @@
+package healthcheck
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// Tag keys of the health checks created by the sync
+const (
+	InstanceIDTag = "sg-sync:instance-id"
+	NameTag       = "Name"
+)
+
+// Settings describe the health check of every IP
+type Settings struct {
+	// Type is TCP, HTTP or HTTPS
+	Type string
+	Port int64
+	// Path is the resource path of the HTTP(S) checks
+	Path string
+}
+
+// Gets the IP out of a host CIDR
+func ipOf(hostCIDR string) string {
+	if ip, err := cidr.Addr(hostCIDR); err == nil {
+		return ip
+	}
+	return strings.SplitN(hostCIDR, "/", 2)[0]
+}
+
+// Create creates the health check of the CIDR's IP and tags it with the instance's ID. The caller reference holds the
+// instance and a nonce, since Route 53 doesn't take a reference again once its check is deleted, e.g. when the IP
+// comes back; the SDK's retries resend the same reference.
+func Create(r53Svc route53iface.Route53API, cidr string, instanceID string, settings Settings) error {
+	ip := ipOf(cidr)
+	config := &route53.HealthCheckConfig{
+		IPAddress: aws.String(ip),
+		Port:      aws.Int64(settings.Port),
+		Type:      aws.String(settings.Type),
+	}
+	if settings.Type != route53.HealthCheckTypeTcp && settings.Path != "" {
+		config.ResourcePath = aws.String(settings.Path)
+	}
+	out, err := r53Svc.CreateHealthCheck(&route53.CreateHealthCheckInput{
+		CallerReference:   aws.String("sg-sync-" + instanceID + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)),
+		HealthCheckConfig: config,
+	})
+	if err != nil {
+		return errs.Wrap(errs.Target, "create health check", err)
+	}
+	_, err = r53Svc.ChangeTagsForResource(&route53.ChangeTagsForResourceInput{
+		ResourceId:   out.HealthCheck.Id,
+		ResourceType: aws.String(route53.TagResourceTypeHealthcheck),
+		AddTags: []*route53.Tag{
+			{Key: aws.String(InstanceIDTag), Value: aws.String(instanceID)},
+			{Key: aws.String(NameTag), Value: aws.String("sg-sync " + instanceID + " " + ip)},
+		},
+	})
+	return errs.Wrap(errs.Target, "tag health check", err)
+}
+
+// Delete deletes the health checks of the CIDRs' IPs that were created with the settings' type and port. The health
+// checks are listed once for all of the CIDRs.
+func Delete(r53Svc route53iface.Route53API, cidrs []string, settings Settings) error {
+	ips := make(map[string]struct{}, len(cidrs))
+	for _, cidr := range cidrs {
+		ips[ipOf(cidr)] = struct{}{}
+	}
+	var ids []*string
+	err := r53Svc.ListHealthChecksPages(&route53.ListHealthChecksInput{}, func(page *route53.ListHealthChecksOutput, lastPage bool) bool {
+		for _, check := range page.HealthChecks {
+			config := check.HealthCheckConfig
+			if config == nil {
+				continue
+			}
+			if _, ok := ips[aws.StringValue(config.IPAddress)]; ok && aws.StringValue(config.Type) == settings.Type &&
+				aws.Int64Value(config.Port) == settings.Port && strings.HasPrefix(aws.StringValue(check.CallerReference), "sg-sync-") {
+				ids = append(ids, check.Id)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return errs.Wrap(errs.Target, "list health checks", err)
+	}
+	for _, id := range ids {
+		if _, err := r53Svc.DeleteHealthCheck(&route53.DeleteHealthCheckInput{HealthCheckId: id}); err != nil {
+			return errs.Wrap(errs.Target, "delete health check", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package incident
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssmincidents"
+	"github.com/aws/aws-sdk-go/service/ssmincidents/ssmincidentsiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+)
+
+// Source is the trigger source of the incidents the sync starts
+const Source = "sg-sync"
+
+// Details describe why the sync of a critical Security Group escalated, sent as the trigger's raw data
+type Details struct {
+	AutoScalingGroupName string           `json:"asgName"`
+	SecurityGroupID      string           `json:"sgID"`
+	InstanceID           string           `json:"instanceID"`
+	Transition           string           `json:"transition"`
+	Reason               string           `json:"reason"`
+	Category             errs.Category    `json:"category,omitempty"`
+	Error                string           `json:"error,omitempty"`
+	AddedIPs             []string         `json:"added_ips,omitempty"`
+	RemovedIPs           []string         `json:"removed_ips,omitempty"`
+	PendingRemovals      []string         `json:"pending_removals,omitempty"`
+	Failures             []syncer.Failure `json:"failures,omitempty"`
+	At                   time.Time        `json:"at"`
+}
+
+// Start starts an incident of the response plan. The response plan sets its impact. Returns the incident record's
+// ARN.
+func Start(incidentsSvc ssmincidentsiface.SSMIncidentsAPI, responsePlanARN string, details Details) (string, error) {
+	raw, err := json.Marshal(details)
+	if err != nil {
+		return "", errs.Wrap(errs.Unknown, "start incident", err)
+	}
+	out, err := incidentsSvc.StartIncident(&ssmincidents.StartIncidentInput{
+		ResponsePlanArn: aws.String(responsePlanARN),
+		Title:           aws.String(fmt.Sprintf("Security Group %s: %s", details.SecurityGroupID, details.Reason)),
+		TriggerDetails: &ssmincidents.TriggerDetails{
+			Source:    aws.String(Source),
+			Timestamp: aws.Time(details.At),
+			RawData:   aws.String(string(raw)),
+		},
+	})
+	if err != nil {
+		return "", errs.Wrap(errs.Target, "start incident", err)
+	}
+	return aws.StringValue(out.IncidentRecordArn), nil
+}
@@ -0,0 +1,75 @@
+package lifecycle
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/event"
+)
+
+// ResultContinue the continue action for the group to take
+const ResultContinue = "CONTINUE"
+
+// ResultAbandon the abandon action for the group to take
+const ResultAbandon = "ABANDON"
+
+// MaxActionLifetime is the global timeout of lifecycle actions. Past it, their tokens are certainly expired.
+const MaxActionLifetime = 48 * time.Hour
+
+// Complete completes the lifecycle action for the specified token or instance with the specified result.
+func Complete(autoscalingSvc autoscalingiface.AutoScalingAPI, detail event.Detail, result string) error {
+	_, err := autoscalingSvc.CompleteLifecycleAction(&autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName:  aws.String(detail.AutoScalingGroupName),
+		InstanceId:            aws.String(detail.EC2InstanceID),
+		LifecycleActionResult: aws.String(result),
+		LifecycleActionToken:  aws.String(detail.LifecycleActionToken),
+		LifecycleHookName:     aws.String(detail.LifecycleHookName),
+	})
+	return errs.Wrap(errs.Lifecycle, "complete lifecycle action", err)
+}
+
+// Heartbeat records a heartbeat for the lifecycle action, restarting its heartbeat timeout
+func Heartbeat(autoscalingSvc autoscalingiface.AutoScalingAPI, detail event.Detail) error {
+	_, err := autoscalingSvc.RecordLifecycleActionHeartbeat(&autoscaling.RecordLifecycleActionHeartbeatInput{
+		AutoScalingGroupName: aws.String(detail.AutoScalingGroupName),
+		InstanceId:           aws.String(detail.EC2InstanceID),
+		LifecycleActionToken: aws.String(detail.LifecycleActionToken),
+		LifecycleHookName:    aws.String(detail.LifecycleHookName),
+	})
+	return err
+}
+
+// DefaultHeartbeatTimeout is the heartbeat timeout of the hooks that don't set one
+const DefaultHeartbeatTimeout = time.Hour
+
+// HeartbeatTimeout gets the heartbeat timeout of the event's lifecycle hook
+func HeartbeatTimeout(autoscalingSvc autoscalingiface.AutoScalingAPI, detail event.Detail) (time.Duration, error) {
+	out, err := autoscalingSvc.DescribeLifecycleHooks(&autoscaling.DescribeLifecycleHooksInput{
+		AutoScalingGroupName: aws.String(detail.AutoScalingGroupName),
+		LifecycleHookNames:   []*string{aws.String(detail.LifecycleHookName)},
+	})
+	if err != nil {
+		return DefaultHeartbeatTimeout, errs.Wrap(errs.Lifecycle, "describe lifecycle hooks", err)
+	}
+	if len(out.LifecycleHooks) == 0 || out.LifecycleHooks[0].HeartbeatTimeout == nil {
+		return DefaultHeartbeatTimeout, nil
+	}
+	return time.Duration(aws.Int64Value(out.LifecycleHooks[0].HeartbeatTimeout)) * time.Second, nil
+}
+
+// Active returns whether the lifecycle action of the token is still waiting for a result. It records a heartbeat,
+// which AutoScaling rejects with a ValidationError once the action has been completed or has timed out.
+func Active(autoscalingSvc autoscalingiface.AutoScalingAPI, detail event.Detail) (bool, error) {
+	err := Heartbeat(autoscalingSvc, detail)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ValidationError" {
+		return false, nil
+	}
+	if err != nil {
+		return false, errs.Wrap(errs.Lifecycle, "record lifecycle action heartbeat", err)
+	}
+	return true, nil
+}
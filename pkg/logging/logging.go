@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"os"
+	"sync"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/version"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	once     sync.Once
+	logger   *zap.Logger
+	settings Settings
+)
+
+// Settings are the settings of the logger, as resolved by the function's configuration
+type Settings struct {
+	// Sampling is the per level sampling, e.g. "debug=0/0,info=20/100"
+	Sampling string
+	// RedactFields are the comma separated keys of the fields whose values are redacted
+	RedactFields string
+	// RedactAccountIDs also redacts the AWS account IDs
+	RedactAccountIDs bool
+}
+
+// Configure sets the settings of the loggers built afterwards. It is meant to be called once, at cold start, before
+// the process-wide logger is built.
+func Configure(s Settings) {
+	settings = s
+}
+
+// New returns the process-wide JSON logger, built on first use. Building it logs the build, so that every cold start
+// tells which build is running.
+func New() *zap.Logger {
+	once.Do(func() {
+		logger = Build()
+		build := version.Get()
+		logger.Info("Cold start", zap.String("version", build.Version), zap.String("commit", build.Commit), zap.String("buildDate", build.BuildDate))
+	})
+	return logger
+}
+
+// Build creates a JSON logger straight from a zapcore.Core, skipping the sink registry and
+// sampling setup of zap.NewProduction to keep cold starts short. The entries are sampled and their fields redacted as
+// the configured Settings say. Invalid settings are logged and ignored.
+func Build() *zap.Logger {
+	var core zapcore.Core = zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.Lock(os.Stderr),
+		zap.InfoLevel,
+	)
+	// The entries are sampled before they are redacted, so that the dropped ones are never redacted
+	core = newRedactingCore(core, newRedactor(settings.RedactFields, settings.RedactAccountIDs))
+	levels, err := parseSampling(settings.Sampling)
+	logger := zap.New(newLevelSampler(core, levels), zap.AddCaller())
+	if err != nil {
+		logger.Warn("Invalid log sampling entry, skipped", zap.Error(err))
+	}
+	return logger
+}
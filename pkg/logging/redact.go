@@ -0,0 +1,149 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Redacted replaces the values of the redacted fields
+const Redacted = "[REDACTED]"
+
+// accountID matches the 12 digits of an AWS account ID, e.g. in an ARN
+var accountID = regexp.MustCompile(`\b[0-9]{12}\b`)
+
+// redactor redacts the fields of the entries: the values of the fields with one of its keys, at any depth, and, when
+// accountIDs is set, the account IDs of the messages and values
+type redactor struct {
+	keys       map[string]bool
+	accountIDs bool
+}
+
+// Builds the redactor of the comma separated field keys. The keys are matched regardless of case.
+func newRedactor(fields string, accountIDs bool) redactor {
+	r := redactor{keys: make(map[string]bool), accountIDs: accountIDs}
+	for _, key := range strings.Split(fields, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			r.keys[strings.ToLower(key)] = true
+		}
+	}
+	return r
+}
+
+// Checks whether the redactor has anything to redact
+func (r redactor) enabled() bool {
+	return len(r.keys) > 0 || r.accountIDs
+}
+
+// Masks the account IDs of the text, when enabled
+func (r redactor) text(s string) string {
+	if !r.accountIDs {
+		return s
+	}
+	return accountID.ReplaceAllString(s, Redacted)
+}
+
+// Redacts the fields
+func (r redactor) fields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		redacted[i] = r.field(f)
+	}
+	return redacted
+}
+
+// Redacts the field. The objects and arrays, e.g. of zap.Any, are encoded to JSON to redact their nested fields.
+func (r redactor) field(f zapcore.Field) zapcore.Field {
+	if r.keys[strings.ToLower(f.Key)] {
+		return zap.String(f.Key, Redacted)
+	}
+	switch f.Type {
+	case zapcore.StringType:
+		f.String = r.text(f.String)
+	case zapcore.ErrorType:
+		if err, ok := f.Interface.(error); ok && r.accountIDs {
+			return zap.String(f.Key, r.text(err.Error()))
+		}
+	case zapcore.StringerType:
+		if s, ok := f.Interface.(fmt.Stringer); ok && r.accountIDs {
+			return zap.String(f.Key, r.text(s.String()))
+		}
+	case zapcore.ReflectType, zapcore.ObjectMarshalerType, zapcore.ArrayMarshalerType:
+		enc := zapcore.NewMapObjectEncoder()
+		f.AddTo(enc)
+		encoded, err := json.Marshal(enc.Fields[f.Key])
+		if err != nil {
+			return f
+		}
+		decoder := json.NewDecoder(bytes.NewReader(encoded))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return f
+		}
+		if encoded, err = json.Marshal(r.value(value)); err != nil {
+			return f
+		}
+		return zap.Reflect(f.Key, json.RawMessage(encoded))
+	}
+	return f
+}
+
+// Redacts the decoded JSON value
+func (r redactor) value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			if r.keys[strings.ToLower(key)] {
+				v[key] = Redacted
+			} else {
+				v[key] = r.value(nested)
+			}
+		}
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = r.value(nested)
+		}
+	case string:
+		return r.text(v)
+	}
+	return value
+}
+
+// redactingCore redacts the fields of the logger and of its entries before they are encoded
+type redactingCore struct {
+	zapcore.Core
+	r redactor
+}
+
+// Wraps the core with the redactor, if it has anything to redact
+func newRedactingCore(core zapcore.Core, r redactor) zapcore.Core {
+	if !r.enabled() {
+		return core
+	}
+	return redactingCore{Core: core, r: r}
+}
+
+// With redacts the fields once, when they are added to the logger
+func (c redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactingCore{Core: c.Core.With(c.r.fields(fields)), r: c.r}
+}
+
+// Check adds the redacting core to the entry, so that its Write gets the entry
+func (c redactingCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write redacts the entry's message and fields
+func (c redactingCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ent.Message = c.r.text(ent.Message)
+	return c.Core.Write(ent, c.r.fields(fields))
+}
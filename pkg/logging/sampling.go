@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// sampling is how the entries of a level are sampled: every second, the first First entries of each message are
+// logged, then every Thereafter-th one. A Thereafter of 0 drops the rest of the second's entries.
+type sampling struct {
+	First      int
+	Thereafter int
+}
+
+// Parses the sampling of the levels, a comma separated list of <level>=<first>/<thereafter>, e.g.
+// "debug=0/0,info=20/100". The levels that aren't listed are never sampled. The invalid entries are skipped, the
+// error is the first one's.
+func parseSampling(spec string) (map[zapcore.Level]sampling, error) {
+	levels := make(map[zapcore.Level]sampling)
+	var firstErr error
+	for _, entry := range strings.Split(spec, ",") {
+		if err := parseLevelSampling(entry, levels); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return levels, firstErr
+}
+
+// Parses an entry of the sampling into the levels
+func parseLevelSampling(entry string, levels map[zapcore.Level]sampling) error {
+	if entry = strings.TrimSpace(entry); entry == "" {
+		return nil
+	}
+	name, rate, ok := strings.Cut(entry, "=")
+	var level zapcore.Level
+	if !ok || level.UnmarshalText([]byte(strings.TrimSpace(name))) != nil || level > zapcore.ErrorLevel {
+		return fmt.Errorf("sampling %q: want \"<debug|info|warn|error>=<first>/<thereafter>\"", entry)
+	}
+	firstSpec, thereafterSpec, ok := strings.Cut(rate, "/")
+	first, err := strconv.Atoi(strings.TrimSpace(firstSpec))
+	if err != nil || first < 0 || !ok {
+		return fmt.Errorf("sampling %q: want \"<first>/<thereafter>\", both 0 or more", entry)
+	}
+	thereafter, err := strconv.Atoi(strings.TrimSpace(thereafterSpec))
+	if err != nil || thereafter < 0 {
+		return fmt.Errorf("sampling %q: want \"<first>/<thereafter>\", both 0 or more", entry)
+	}
+	levels[level] = sampling{First: first, Thereafter: thereafter}
+	return nil
+}
+
+// levelSampler samples the entries of the levels that have a sampler and passes the others through
+type levelSampler struct {
+	zapcore.Core
+	samplers map[zapcore.Level]zapcore.Core
+}
+
+// Wraps the core with a sampler for every level of the sampling
+func newLevelSampler(core zapcore.Core, levels map[zapcore.Level]sampling) zapcore.Core {
+	if len(levels) == 0 {
+		return core
+	}
+	samplers := make(map[zapcore.Level]zapcore.Core, len(levels))
+	for level, s := range levels {
+		samplers[level] = zapcore.NewSamplerWithOptions(core, time.Second, s.First, s.Thereafter)
+	}
+	return levelSampler{Core: core, samplers: samplers}
+}
+
+// With adds the fields to the core and to every sampler, which keep counting the entries together
+func (c levelSampler) With(fields []zapcore.Field) zapcore.Core {
+	samplers := make(map[zapcore.Level]zapcore.Core, len(c.samplers))
+	for level, sampler := range c.samplers {
+		samplers[level] = sampler.With(fields)
+	}
+	return levelSampler{Core: c.Core.With(fields), samplers: samplers}
+}
+
+// Check leaves the entry to its level's sampler, if it has one
+func (c levelSampler) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if sampler, ok := c.samplers[ent.Level]; ok {
+		return sampler.Check(ent, ce)
+	}
+	return c.Core.Check(ent, ce)
+}
@@ -0,0 +1,30 @@
+package maintenance
+
+import (
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/notify"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// Applied is published when the changes that a maintenance window deferred are applied, once the window closed. The
+// intended changes are the ones recorded when the sync was deferred, the added and removed IPs the ones applied.
+type Applied struct {
+	AutoScalingGroupName string        `json:"asgName"`
+	SecurityGroupID      string        `json:"sgID"`
+	Rules                []target.Rule `json:"rules"`
+	IntendedAdds         []string      `json:"intendedAdds"`
+	IntendedRemovals     []string      `json:"intendedRemovals"`
+	AddedIPs             []string      `json:"addedIPs"`
+	RemovedIPs           []string      `json:"removedIPs"`
+	DeferredAt           time.Time     `json:"deferredAt"`
+	AppliedAt            time.Time     `json:"appliedAt"`
+}
+
+// Kind is the kind of the messages of the applied deferred changes
+const Kind = "deferred"
+
+// Message builds the notification of the applied changes
+func (a Applied) Message() notify.Message {
+	return notify.Message{Kind: Kind, Subject: "Security Group changes deferred by a maintenance window applied", Details: a}
+}
@@ -0,0 +1,132 @@
+package maintenance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a five-field cron expression: minute, hour, day of month, month and day of week. Every field is a *, a
+// value, a range (1-5), a step (*/15, 8-18/2) or a comma separated list of them. Months and days of week also accept
+// their three-letter names (JAN, MON), and 7 is Sunday like 0.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny are true when the field is *. When both days are restricted, matching either one is enough,
+	// like in crontab.
+	domAny, dowAny bool
+}
+
+// field is the range of a cron field and the names of its values, if any
+type field struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: []string{"", "JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}}
+	dowField    = field{name: "day of week", min: 0, max: 7, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}}
+)
+
+// ParseCron parses a five-field cron expression, e.g. "0 18 * * FRI"
+func ParseCron(spec string) (Cron, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return Cron{}, fmt.Errorf("cron %q: want 5 fields, got %d", spec, len(fields))
+	}
+	var c Cron
+	var err error
+	if c.minute, err = minuteField.parse(fields[0]); err != nil {
+		return Cron{}, fmt.Errorf("cron %q: %w", spec, err)
+	}
+	if c.hour, err = hourField.parse(fields[1]); err != nil {
+		return Cron{}, fmt.Errorf("cron %q: %w", spec, err)
+	}
+	if c.dom, err = domField.parse(fields[2]); err != nil {
+		return Cron{}, fmt.Errorf("cron %q: %w", spec, err)
+	}
+	if c.month, err = monthField.parse(fields[3]); err != nil {
+		return Cron{}, fmt.Errorf("cron %q: %w", spec, err)
+	}
+	if c.dow, err = dowField.parse(fields[4]); err != nil {
+		return Cron{}, fmt.Errorf("cron %q: %w", spec, err)
+	}
+	// Sunday is both 0 and 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = fields[2] == "*", fields[4] == "*"
+	return c, nil
+}
+
+// Matches checks whether the minute of t, in its own location, is one of the expression's
+func (c Cron) Matches(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 || c.hour&(1<<uint(t.Hour())) == 0 || c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom, dow := c.dom&(1<<uint(t.Day())) != 0, c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domAny || c.dowAny:
+		return dom && dow
+	default:
+		return dom || dow
+	}
+}
+
+// Parses a field into the bitset of its values
+func (f field) parse(spec string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(spec, ",") {
+		expr, stepSpec, stepped := strings.Cut(part, "/")
+		step := 1
+		if stepped {
+			var err error
+			if step, err = strconv.Atoi(stepSpec); err != nil || step < 1 {
+				return 0, fmt.Errorf("%s: invalid step %q", f.name, stepSpec)
+			}
+		}
+
+		low, high := f.min, f.max
+		if expr != "*" {
+			lowSpec, highSpec, ranged := strings.Cut(expr, "-")
+			var err error
+			if low, err = f.value(lowSpec); err != nil {
+				return 0, err
+			}
+			high = low
+			if ranged {
+				if high, err = f.value(highSpec); err != nil {
+					return 0, err
+				}
+			} else if stepped {
+				// 5/15 is 5-59/15
+				high = f.max
+			}
+			if high < low {
+				return 0, fmt.Errorf("%s: invalid range %q", f.name, expr)
+			}
+		}
+		for v := low; v <= high; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// Parses a value of the field, a number or one of its names
+func (f field) value(spec string) (int, error) {
+	for i, name := range f.names {
+		if name != "" && strings.EqualFold(spec, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(spec)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s: invalid value %q", f.name, spec)
+	}
+	return v, nil
+}
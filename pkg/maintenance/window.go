@@ -0,0 +1,99 @@
+package maintenance
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	// The Lambda runtimes don't all ship the time zone database
+	_ "time/tzdata"
+)
+
+// MaxDuration is the longest a maintenance window can last
+const MaxDuration = 31 * 24 * time.Hour
+
+// Window is a maintenance window: it opens at every minute its Start matches and stays open for Duration
+type Window struct {
+	Start    Cron
+	Duration time.Duration
+	// Location is the time zone the Start is evaluated in
+	Location *time.Location
+	spec     string
+}
+
+// String returns the window as it was configured
+func (w Window) String() string { return w.spec }
+
+// End returns when the window that t falls in closes. ok is false when t falls in none. When the openings of the
+// window overlap, the latest closing wins.
+func (w Window) End(t time.Time) (end time.Time, ok bool) {
+	t = t.In(w.Location)
+	// The most recent opening that is still open closes last
+	for start := t.Truncate(time.Minute); start.Add(w.Duration).After(t); start = start.Add(-time.Minute) {
+		if w.Start.Matches(start) {
+			return start.Add(w.Duration), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Windows are all the maintenance windows of the function
+type Windows []Window
+
+// ParseWindows reads a semicolon separated list of windows, each one a cron expression and how long it lasts, e.g.
+// "0 18 * * FRI for 62h; 0 0 20 12 * for 336h". The expressions are evaluated in the location.
+func ParseWindows(spec string, location *time.Location) (Windows, error) {
+	if location == nil {
+		location = time.UTC
+	}
+	var windows Windows
+	for _, entry := range strings.Split(spec, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		cronSpec, durationSpec, ok := strings.Cut(entry, " for ")
+		if !ok {
+			return windows, fmt.Errorf("window %q: want \"<cron> for <duration>\"", entry)
+		}
+		start, err := ParseCron(cronSpec)
+		if err != nil {
+			return windows, fmt.Errorf("window %q: %w", entry, err)
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(durationSpec))
+		if err != nil || duration < time.Minute || duration > MaxDuration {
+			return windows, fmt.Errorf("window %q: the duration must be between 1m and %s", entry, MaxDuration)
+		}
+		windows = append(windows, Window{Start: start, Duration: duration, Location: location, spec: entry})
+	}
+	return windows, nil
+}
+
+// Active returns when the open windows close, the latest one when several are open. ok is false when none is.
+func (ws Windows) Active(t time.Time) (until time.Time, ok bool) {
+	for _, w := range ws {
+		if end, open := w.End(t); open && end.After(until) {
+			until, ok = end, true
+		}
+	}
+	return until.UTC(), ok
+}
+
+// ParseGroupWindows reads a semicolon separated list of windows of AutoScaling Groups, each one the AutoScaling Group's
+// name and a window as in ParseWindows, e.g. "batch-asg=0 22 * * * for 6h". A group can have several windows.
+func ParseGroupWindows(spec string, location *time.Location) (map[string]Windows, error) {
+	groups := make(map[string]Windows)
+	for _, entry := range strings.Split(spec, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		asgName, windowSpec, ok := strings.Cut(entry, "=")
+		if asgName = strings.TrimSpace(asgName); !ok || asgName == "" {
+			return groups, fmt.Errorf("window %q: want \"<asgName>=<cron> for <duration>\"", entry)
+		}
+		windows, err := ParseWindows(windowSpec, location)
+		if err != nil {
+			return groups, err
+		}
+		groups[asgName] = append(groups[asgName], windows...)
+	}
+	return groups, nil
+}
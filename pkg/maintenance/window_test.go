@@ -0,0 +1,71 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseGroupWindows(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    map[string][]string
+		wantErr bool
+	}{
+		{name: "empty", spec: "", want: map[string][]string{}},
+		{
+			name: "one window per group",
+			spec: "batch-asg=0 22 * * * for 6h; web-asg=0 18 * * FRI for 62h",
+			want: map[string][]string{"batch-asg": {"0 22 * * * for 6h"}, "web-asg": {"0 18 * * FRI for 62h"}},
+		},
+		{
+			name: "several windows of a group",
+			spec: "batch-asg=0 22 * * * for 6h;batch-asg=0 12 * * SAT for 2h;",
+			want: map[string][]string{"batch-asg": {"0 22 * * * for 6h", "0 12 * * SAT for 2h"}},
+		},
+		{name: "no group", spec: "0 22 * * * for 6h", wantErr: true},
+		{name: "empty group", spec: " =0 22 * * * for 6h", wantErr: true},
+		{name: "no duration", spec: "batch-asg=0 22 * * *", wantErr: true},
+		{name: "invalid cron", spec: "batch-asg=0 25 * * * for 6h", wantErr: true},
+		{name: "too long", spec: "batch-asg=0 22 * * * for 1000h", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups, err := ParseGroupWindows(tt.spec, time.UTC)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseGroupWindows(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(groups) != len(tt.want) {
+				t.Fatalf("got the groups %v, want %v", groups, tt.want)
+			}
+			for asgName, specs := range tt.want {
+				windows := groups[asgName]
+				if len(windows) != len(specs) {
+					t.Fatalf("%s has %d windows, want %d", asgName, len(windows), len(specs))
+				}
+				for i, spec := range specs {
+					if windows[i].String() != spec {
+						t.Errorf("window %d of %s is %q, want %q", i, asgName, windows[i], spec)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGroupWindowsActive(t *testing.T) {
+	groups, err := ParseGroupWindows("batch-asg=0 22 * * * for 6h", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := time.Date(2020, 10, 20, 23, 30, 0, 0, time.UTC)
+	if until, ok := groups["batch-asg"].Active(open); !ok || !until.Equal(time.Date(2020, 10, 21, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("Active(%s) = %s, %v, want the window open until 04:00", open, until, ok)
+	}
+	if _, ok := groups["batch-asg"].Active(open.Add(6 * time.Hour)); ok {
+		t.Errorf("Active(%s) is open, want it closed", open.Add(6*time.Hour))
+	}
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// Names of the Datadog metrics of the invocations
+const (
+	DDInvocations = "sg_sync.invocations"
+	DDAddedIPs    = "sg_sync.ips.added"
+	DDRemovedIPs  = "sg_sync.ips.removed"
+	// DDDuration is in milliseconds
+	DDDuration = "sg_sync.duration"
+)
+
+// DefaultDatadogSite is the Datadog site of the API, unless another one is configured
+const DefaultDatadogSite = "datadoghq.com"
+
+// Builds the tags of the invocation's metrics: asg, sg, region and outcome, success or failure
+func (i Invocation) tags() []string {
+	outcome := "success"
+	if i.Failed {
+		outcome = "failure"
+	}
+	return []string{
+		"asg:" + tagValue(i.AutoScalingGroupName),
+		"sg:" + tagValue(i.SecurityGroupID),
+		"region:" + tagValue(i.Region),
+		"outcome:" + outcome,
+	}
+}
+
+// Replaces the characters that would break the DogStatsD datagram, the commas and pipes
+func tagValue(value string) string {
+	return strings.NewReplacer(",", "_", "|", "_").Replace(value)
+}
+
+// DogStatsD sends the invocations to a DogStatsD server, e.g. the Datadog Lambda extension on localhost:8125
+type DogStatsD struct {
+	Addr string
+}
+
+// Name is dogstatsd
+func (d DogStatsD) Name() string { return "dogstatsd" }
+
+// Push sends the invocation's counts and its duration, as a distribution, in a single datagram
+func (d DogStatsD) Push(inv Invocation) error {
+	tags := "|#" + strings.Join(inv.tags(), ",")
+	lines := []string{
+		DDInvocations + ":1|c" + tags,
+		DDAddedIPs + ":" + strconv.Itoa(inv.Added) + "|c" + tags,
+		DDRemovedIPs + ":" + strconv.Itoa(inv.Removed) + "|c" + tags,
+		DDDuration + ":" + strconv.FormatInt(inv.Duration.Milliseconds(), 10) + "|d" + tags,
+	}
+	conn, err := net.DialTimeout("udp", d.Addr, time.Second)
+	if err != nil {
+		return errs.Wrap(errs.Target, "send dogstatsd metrics", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(strings.Join(lines, "\n")))
+	return errs.Wrap(errs.Target, "send dogstatsd metrics", err)
+}
+
+// DatadogAPI submits the invocations to the metrics API of a Datadog site, for functions without the extension
+type DatadogAPI struct {
+	APIKey string
+	// Site is the Datadog site, e.g. datadoghq.eu. Defaults to DefaultDatadogSite.
+	Site string
+}
+
+// Name is datadog
+func (d DatadogAPI) Name() string { return "datadog" }
+
+// datadogSeries is a series of the v2 series API. Type is 1 for a count and 3 for a gauge.
+type datadogSeries struct {
+	Metric string         `json:"metric"`
+	Type   int            `json:"type"`
+	Points []datadogPoint `json:"points"`
+	Tags   []string       `json:"tags"`
+	Unit   string         `json:"unit,omitempty"`
+}
+
+// datadogPoint is a point of a series, at a Unix timestamp in seconds
+type datadogPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// Push submits the invocation's counts and its duration, as a gauge, at the invocation's time
+func (d DatadogAPI) Push(inv Invocation) error {
+	site := d.Site
+	if site == "" {
+		site = DefaultDatadogSite
+	}
+	tags, at := inv.tags(), inv.Time.Unix()
+	point := func(value float64) []datadogPoint { return []datadogPoint{{Timestamp: at, Value: value}} }
+	body, err := json.Marshal(map[string][]datadogSeries{"series": {
+		{Metric: DDInvocations, Type: 1, Points: point(1), Tags: tags},
+		{Metric: DDAddedIPs, Type: 1, Points: point(float64(inv.Added)), Tags: tags},
+		{Metric: DDRemovedIPs, Type: 1, Points: point(float64(inv.Removed)), Tags: tags},
+		{Metric: DDDuration, Type: 3, Points: point(float64(inv.Duration.Milliseconds())), Tags: tags, Unit: "millisecond"},
+	}})
+	if err != nil {
+		return errs.Wrap(errs.Target, "submit datadog metrics", err)
+	}
+	err = send(http.MethodPost, fmt.Sprintf("https://api.%s/api/v2/series", site), body, map[string]string{
+		"Content-Type": "application/json",
+		"DD-API-KEY":   d.APIKey,
+	})
+	return errs.Wrap(errs.Target, "submit datadog metrics", err)
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Client sends the requests of the HTTP backends. Its timeout keeps a slow endpoint from eating the function's.
+var Client = &http.Client{Timeout: 5 * time.Second}
+
+// Invocation is the outcome of a sync, as pushed to the metrics backends
+type Invocation struct {
+	AutoScalingGroupName string
+	SecurityGroupID      string
+	Region               string
+	Added                int
+	Removed              int
+	Failed               bool
+	Duration             time.Duration
+	// Time is when the sync ended
+	Time time.Time
+}
+
+// Pusher pushes the invocations to a metrics backend, e.g. Prometheus or Datadog
+type Pusher interface {
+	// Name identifies the backend in the logs, e.g. pushgateway or dogstatsd
+	Name() string
+	Push(inv Invocation) error
+}
+
+// Sends the request. Credentials can be given in the URL's user info, so the URL is kept out of the errors.
+func send(method string, target string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("invalid URL")
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := Client.Do(req)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		reply, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(reply))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// Names of the outcome metrics. Both are published on every sync, one with 1 and the other with 0, so that alarms
+// can count consecutive failures.
+const (
+	SyncSucceeded = "SyncSucceeded"
+	SyncFailed    = "SyncFailed"
+)
+
+// ExternalChanges is the number of rules other actors added to or removed from the managed rule sets
+const ExternalChanges = "ExternalChanges"
+
+// Builds the dimensions of the AutoScaling Group and the Security Group
+func dimensions(asgName string, sgID string) []*cloudwatch.Dimension {
+	return []*cloudwatch.Dimension{
+		{Name: aws.String("AutoScalingGroupName"), Value: aws.String(asgName)},
+		{Name: aws.String("SecurityGroupID"), Value: aws.String(sgID)},
+	}
+}
+
+// PublishOutcome publishes the SyncSucceeded and SyncFailed metrics of the sync, failed when syncErr is not nil
+func PublishOutcome(cwSvc cloudwatchiface.CloudWatchAPI, namespace string, asgName string, sgID string, syncErr error) error {
+	succeeded, failed := 1.0, 0.0
+	if syncErr != nil {
+		succeeded, failed = 0, 1
+	}
+	_, err := cwSvc.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace: aws.String(namespace),
+		MetricData: []*cloudwatch.MetricDatum{
+			{MetricName: aws.String(SyncSucceeded), Dimensions: dimensions(asgName, sgID), Value: aws.Float64(succeeded), Unit: aws.String(cloudwatch.StandardUnitCount)},
+			{MetricName: aws.String(SyncFailed), Dimensions: dimensions(asgName, sgID), Value: aws.Float64(failed), Unit: aws.String(cloudwatch.StandardUnitCount)},
+		},
+	})
+	return errs.Wrap(errs.Target, "put metric data", err)
+}
+
+// PublishDrift publishes the ExternalChanges metric with the number of changes other actors made to the Security Group
+func PublishDrift(cwSvc cloudwatchiface.CloudWatchAPI, namespace string, asgName string, sgID string, changes int) error {
+	_, err := cwSvc.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace: aws.String(namespace),
+		MetricData: []*cloudwatch.MetricDatum{
+			{MetricName: aws.String(ExternalChanges), Dimensions: dimensions(asgName, sgID), Value: aws.Float64(float64(changes)), Unit: aws.String(cloudwatch.StandardUnitCount)},
+		},
+	})
+	return errs.Wrap(errs.Target, "put metric data", err)
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// Names of the Prometheus metrics of the invocations. They are gauges of the pair's last sync, since the Pushgateway
+// keeps the last push of every group rather than accumulating them.
+const (
+	PromAddedIPs         = "sg_sync_added_ips"
+	PromRemovedIPs       = "sg_sync_removed_ips"
+	PromFailed           = "sg_sync_failed"
+	PromDuration         = "sg_sync_duration_seconds"
+	PromLastRunTimestamp = "sg_sync_last_run_timestamp_seconds"
+)
+
+// sample is a metric of an invocation
+type sample struct {
+	name  string
+	help  string
+	value float64
+}
+
+// Builds the samples of the invocation
+func (i Invocation) samples() []sample {
+	failed := 0.0
+	if i.Failed {
+		failed = 1
+	}
+	return []sample{
+		{PromAddedIPs, "IPs added by the last sync", float64(i.Added)},
+		{PromRemovedIPs, "IPs removed by the last sync", float64(i.Removed)},
+		{PromFailed, "1 when the last sync failed", failed},
+		{PromDuration, "Duration of the last sync", i.Duration.Seconds()},
+		{PromLastRunTimestamp, "When the last sync ended", float64(i.Time.UnixNano()) / 1e9},
+	}
+}
+
+// Builds the labels of the invocation's series, sorted by name
+func (i Invocation) labels(job string) [][2]string {
+	return [][2]string{{"autoscaling_group", i.AutoScalingGroupName}, {"job", job}, {"security_group", i.SecurityGroupID}}
+}
+
+// Pushgateway pushes the invocations to a Prometheus Pushgateway, every pair in its own group of the job
+type Pushgateway struct {
+	URL string
+	Job string
+}
+
+// Name is pushgateway
+func (p Pushgateway) Name() string { return "pushgateway" }
+
+// Push replaces the metrics of the invocation's group with the invocation's
+func (p Pushgateway) Push(inv Invocation) error {
+	// The grouping key starts with the job. The label values are base64 encoded, AutoScaling Group names may contain
+	// slashes.
+	path := "/metrics"
+	for _, label := range [][2]string{{"job", p.Job}, {"autoscaling_group", inv.AutoScalingGroupName}, {"security_group", inv.SecurityGroupID}} {
+		value := base64.URLEncoding.EncodeToString([]byte(label[1]))
+		if value == "" {
+			value = "="
+		}
+		path += "/" + label[0] + "@base64/" + value
+	}
+	var body bytes.Buffer
+	for _, s := range inv.samples() {
+		fmt.Fprintf(&body, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", s.name, s.help, s.name, s.name, strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+	err := send(http.MethodPut, strings.TrimSuffix(p.URL, "/")+path, body.Bytes(), map[string]string{"Content-Type": "text/plain; version=0.0.4"})
+	return errs.Wrap(errs.Target, "push metrics", err)
+}
+
+// RemoteWrite sends the invocations to a Prometheus remote-write endpoint, e.g. Prometheus, Mimir or Thanos
+type RemoteWrite struct {
+	URL string
+	Job string
+}
+
+// Name is remote-write
+func (r RemoteWrite) Name() string { return "remote-write" }
+
+// Push writes a sample of every metric of the invocation, at the invocation's time
+func (r RemoteWrite) Push(inv Invocation) error {
+	labels := inv.labels(r.Job)
+	var series []timeSeries
+	for _, s := range inv.samples() {
+		series = append(series, timeSeries{
+			labels:    append([][2]string{{"__name__", s.name}}, labels...),
+			value:     s.value,
+			timestamp: inv.Time.UnixNano() / int64(time.Millisecond),
+		})
+	}
+	err := send(http.MethodPost, r.URL, snappyBlock(writeRequest(series)), map[string]string{
+		"Content-Type":                      "application/x-protobuf",
+		"Content-Encoding":                  "snappy",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	})
+	return errs.Wrap(errs.Target, "push metrics", err)
+}
+
+// timeSeries is a single sample of a series of the remote-write protocol
+type timeSeries struct {
+	labels    [][2]string
+	value     float64
+	timestamp int64
+}
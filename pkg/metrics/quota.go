@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+	"github.com/aws/aws-sdk-go/service/servicequotas/servicequotasiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// Names of the rule usage metrics
+const (
+	ManagedRuleCount     = "ManagedRuleCount"
+	RuleQuotaUtilization = "RuleQuotaUtilization"
+)
+
+// DefaultRulesQuota is the default quota of inbound rules per security group
+const DefaultRulesQuota = 60
+
+// The Service Quotas codes of the inbound or outbound rules per security group
+const (
+	vpcServiceCode = "vpc"
+	rulesQuotaCode = "L-0EA8095F"
+)
+
+var quotas sync.Map
+
+// RulesQuota gets the quota of inbound rules per security group of the account. It is cached for the lifetime of the
+// container.
+func RulesQuota(sqSvc servicequotasiface.ServiceQuotasAPI, region string) (int, error) {
+	if quota, ok := quotas.Load(region); ok {
+		return quota.(int), nil
+	}
+	out, err := sqSvc.GetServiceQuota(&servicequotas.GetServiceQuotaInput{
+		ServiceCode: aws.String(vpcServiceCode),
+		QuotaCode:   aws.String(rulesQuotaCode),
+	})
+	if err != nil {
+		return DefaultRulesQuota, errs.Wrap(errs.Target, "get service quota", err)
+	}
+	quota := int(aws.Float64Value(out.Quota.Value))
+	quotas.Store(region, quota)
+	return quota, nil
+}
+
+// PublishRuleUsage publishes the number of managed rules of the Security Group and the percentage of its rules quota
+// that all its inbound rules consume
+func PublishRuleUsage(cwSvc cloudwatchiface.CloudWatchAPI, namespace string, sgID string, managed int, total int, quota int) error {
+	dims := []*cloudwatch.Dimension{{Name: aws.String("SecurityGroupID"), Value: aws.String(sgID)}}
+	utilization := 0.0
+	if quota > 0 {
+		utilization = 100 * float64(total) / float64(quota)
+	}
+	_, err := cwSvc.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace: aws.String(namespace),
+		MetricData: []*cloudwatch.MetricDatum{
+			{MetricName: aws.String(ManagedRuleCount), Dimensions: dims, Value: aws.Float64(float64(managed)), Unit: aws.String(cloudwatch.StandardUnitCount)},
+			{MetricName: aws.String(RuleQuotaUtilization), Dimensions: dims, Value: aws.Float64(utilization), Unit: aws.String(cloudwatch.StandardUnitPercent)},
+		},
+	})
+	return errs.Wrap(errs.Target, "put metric data", err)
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// The remote-write protocol is a snappy compressed protobuf WriteRequest. Both are simple enough to be encoded here
+// rather than pulling in the Prometheus and snappy libraries for a handful of samples.
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// Encodes the WriteRequest of the series:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func writeRequest(series []timeSeries) []byte {
+	var request []byte
+	for _, ts := range series {
+		sortLabels(ts.labels)
+		var encoded []byte
+		for _, label := range ts.labels {
+			var l []byte
+			l = appendBytes(l, 1, []byte(label[0]))
+			l = appendBytes(l, 2, []byte(label[1]))
+			encoded = appendBytes(encoded, 1, l)
+		}
+		var s []byte
+		s = appendTag(s, 1, wireFixed64)
+		s = binary.LittleEndian.AppendUint64(s, math.Float64bits(ts.value))
+		s = appendTag(s, 2, wireVarint)
+		s = binary.AppendUvarint(s, uint64(ts.timestamp))
+		encoded = appendBytes(encoded, 2, s)
+		request = appendBytes(request, 1, encoded)
+	}
+	return request
+}
+
+// Appends the tag of the field
+func appendTag(b []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+// Appends a length-delimited field
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// Frames the data as a snappy block made of literals only. It isn't compressed, but any snappy decoder reads it, and
+// the requests are a few hundred bytes.
+func snappyBlock(data []byte) []byte {
+	block := binary.AppendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := len(data)
+		if n > 1<<16 {
+			n = 1 << 16
+		}
+		switch length := n - 1; {
+		case length < 60:
+			block = append(block, byte(length)<<2)
+		case length < 1<<8:
+			block = append(block, 60<<2, byte(length))
+		default:
+			block = append(block, 61<<2, byte(length), byte(length>>8))
+		}
+		block = append(block, data[:n]...)
+		data = data[n:]
+	}
+	return block
+}
+
+// Sorts the labels by name, as the remote-write protocol requires
+func sortLabels(labels [][2]string) {
+	sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+}
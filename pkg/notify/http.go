@@ -0,0 +1,118 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Client sends the requests of the HTTP channels. Its timeout keeps a slow endpoint from eating the function's.
+var Client = &http.Client{Timeout: 5 * time.Second}
+
+// PagerDutyEventsURL is the endpoint of the PagerDuty Events API v2
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// Webhook posts the messages, as JSON, to a URL
+type Webhook struct {
+	URL string
+}
+
+// Name is webhook
+func (w Webhook) Name() string { return "webhook" }
+
+// Notify posts the message to the URL
+func (w Webhook) Notify(msg Message) error {
+	return postJSON(w.URL, msg)
+}
+
+// Slack posts the messages to an incoming webhook of a Slack channel
+type Slack struct {
+	WebhookURL string
+}
+
+// Name is slack
+func (s Slack) Name() string { return "slack" }
+
+// Notify posts the message's text to the channel
+func (s Slack) Notify(msg Message) error {
+	text, err := Text(msg)
+	if err != nil {
+		return err
+	}
+	return postJSON(s.WebhookURL, map[string]string{"text": text})
+}
+
+// PagerDuty triggers the events of an integration of the PagerDuty Events API v2
+type PagerDuty struct {
+	RoutingKey string
+	// Severity is the severity of the events, defaults to warning
+	Severity string
+}
+
+// Name is pagerduty
+func (p PagerDuty) Name() string { return "pagerduty" }
+
+// pagerDutyEvent is the trigger event of the Events API v2
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key,omitempty"`
+	Payload     pagerDutyPayload `json:"payload"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string      `json:"summary"`
+	Source        string      `json:"source"`
+	Severity      string      `json:"severity"`
+	Component     string      `json:"component"`
+	CustomDetails interface{} `json:"custom_details"`
+}
+
+// Notify triggers an event with the message's subject as summary and its details as custom details. The message's key
+// is the event's dedup key, so that the repeats of an alert update the open incident rather than opening new ones.
+func (p PagerDuty) Notify(msg Message) error {
+	severity := p.Severity
+	if severity == "" {
+		severity = "warning"
+	}
+	return postJSON(PagerDutyEventsURL, pagerDutyEvent{
+		RoutingKey:  p.RoutingKey,
+		EventAction: "trigger",
+		DedupKey:    msg.Key,
+		Payload: pagerDutyPayload{
+			Summary:       msg.Subject,
+			Source:        "sg-sync",
+			Severity:      severity,
+			Component:     msg.Kind,
+			CustomDetails: msg.Details,
+		},
+	})
+}
+
+// Posts the body, as JSON, to the URL. Any status other than 2xx fails.
+func postJSON(target string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := Client.Post(target, "application/json", bytes.NewReader(payload))
+	// The URLs of the webhooks are secrets, they are kept out of the errors
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		reply, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(reply))
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Message is a notification of the sync, e.g. an alert or an approval request
+type Message struct {
+	// Kind is what the message is about, e.g. alert or approval
+	Kind    string `json:"kind"`
+	Subject string `json:"subject"`
+	// Details is the document of the message, rendered as JSON, e.g. an alert.Alert
+	Details interface{} `json:"details"`
+	// Key identifies the condition the message is about, e.g. the Security Group, AutoScaling Group and error category
+	// of an alert, so that the channels that can group the repeats of a message do
+	Key string `json:"key,omitempty"`
+}
+
+// Notifier sends messages to a channel
+type Notifier interface {
+	// Name identifies the channel in the errors and logs, e.g. sns or slack
+	Name() string
+	Notify(msg Message) error
+}
+
+// builders build the channels configured by a single target, by the channels' names
+var builders = map[string]func(target string) Notifier{
+	"slack":     func(target string) Notifier { return Slack{WebhookURL: target} },
+	"pagerduty": func(target string) Notifier { return PagerDuty{RoutingKey: target} },
+	"webhook":   func(target string) Notifier { return Webhook{URL: target} },
+}
+
+// Channel builds the channel of the name out of its target, e.g. the URL of a webhook. ok is false when there is no
+// channel of the name.
+func Channel(name string, target string) (n Notifier, ok bool) {
+	build, ok := builders[name]
+	if !ok {
+		return nil, false
+	}
+	return build(target), true
+}
+
+// FanOut sends the messages to all its channels. A failing channel doesn't keep the message from the others.
+type FanOut []Notifier
+
+// Name lists the names of the channels
+func (f FanOut) Name() string {
+	names := make([]string, 0, len(f))
+	for _, n := range f {
+		names = append(names, n.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// Notify sends the message to every channel. The failures of the channels are returned together as Errors.
+func (f FanOut) Notify(msg Message) error {
+	var failed Errors
+	for _, n := range f {
+		if err := n.Notify(msg); err != nil {
+			failed = append(failed, ChannelError{Channel: n.Name(), Err: err})
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return failed
+}
+
+// ChannelError is the failure of a channel to send a message
+type ChannelError struct {
+	Channel string
+	Err     error
+}
+
+func (e ChannelError) Error() string { return e.Channel + ": " + e.Err.Error() }
+
+func (e ChannelError) Unwrap() error { return e.Err }
+
+// Errors are the failures of the channels of a FanOut
+type Errors []ChannelError
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return "notify: " + strings.Join(msgs, "; ")
+}
+
+// Details renders the details of the message as JSON
+func Details(msg Message) ([]byte, error) {
+	details, err := json.Marshal(msg.Details)
+	if err != nil {
+		return nil, fmt.Errorf("render %s details: %w", msg.Kind, err)
+	}
+	return details, nil
+}
+
+// Text renders the message for the chat channels: the subject, then the details as an indented JSON block
+func Text(msg Message) (string, error) {
+	details, err := json.MarshalIndent(msg.Details, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("render %s details: %w", msg.Kind, err)
+	}
+	return fmt.Sprintf("*%s*\n```\n%s\n```", msg.Subject, details), nil
+}
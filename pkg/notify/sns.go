@@ -0,0 +1,30 @@
+package notify
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+// SNS publishes the messages to a topic, with the details as the JSON message
+type SNS struct {
+	Svc      snsiface.SNSAPI
+	TopicARN string
+}
+
+// Name is sns
+func (s SNS) Name() string { return "sns" }
+
+// Notify publishes the message to the topic
+func (s SNS) Notify(msg Message) error {
+	details, err := Details(msg)
+	if err != nil {
+		return err
+	}
+	_, err = s.Svc.Publish(&sns.PublishInput{
+		TopicArn: aws.String(s.TopicARN),
+		Subject:  aws.String(msg.Subject),
+		Message:  aws.String(string(details)),
+	})
+	return err
+}
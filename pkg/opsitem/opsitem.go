@@ -0,0 +1,83 @@
+package opsitem
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// Source is the source of the OpsItems the sync opens
+const Source = "sg-sync"
+
+// Failure describes the persistent failure of an AutoScaling Group and Security Group pair
+type Failure struct {
+	AutoScalingGroupName string
+	SecurityGroupID      string
+	// Count is the number of consecutive failed syncs
+	Count    int
+	Category errs.Category
+	Error    string
+	At       time.Time
+}
+
+// Builds the dedup string of the pair, so that its failures are tracked by a single open OpsItem
+func dedup(f Failure) string {
+	return fmt.Sprintf("sg-sync:%s:%s", f.AutoScalingGroupName, f.SecurityGroupID)
+}
+
+// Builds the operational data of the failure
+func operationalData(f Failure) map[string]*ssm.OpsItemDataValue {
+	searchable := func(v string) *ssm.OpsItemDataValue {
+		return &ssm.OpsItemDataValue{Value: aws.String(v), Type: aws.String(ssm.OpsItemDataTypeSearchableString)}
+	}
+	return map[string]*ssm.OpsItemDataValue{
+		"asgName":             searchable(f.AutoScalingGroupName),
+		"sgID":                searchable(f.SecurityGroupID),
+		"category":            searchable(string(f.Category)),
+		"consecutiveFailures": {Value: aws.String(strconv.Itoa(f.Count)), Type: aws.String(ssm.OpsItemDataTypeString)},
+		"lastFailureAt":       {Value: aws.String(f.At.Format(time.RFC3339)), Type: aws.String(ssm.OpsItemDataTypeString)},
+	}
+}
+
+// Open opens an OpsItem for the failure. When the pair already has an open one, it is updated instead. Returns the
+// OpsItem's ID.
+func Open(ssmSvc ssmiface.SSMAPI, f Failure) (string, error) {
+	title := fmt.Sprintf("Security Group %s fails to sync with %s", f.SecurityGroupID, f.AutoScalingGroupName)
+	description := fmt.Sprintf("The last %d syncs of AutoScaling Group %s with Security Group %s failed. Last error (%s): %s",
+		f.Count, f.AutoScalingGroupName, f.SecurityGroupID, f.Category, f.Error)
+
+	data := operationalData(f)
+	dedupValue, _ := json.Marshal(map[string]string{"dedupString": dedup(f)})
+	data["/aws/dedup"] = &ssm.OpsItemDataValue{Value: aws.String(string(dedupValue)), Type: aws.String(ssm.OpsItemDataTypeSearchableString)}
+	out, err := ssmSvc.CreateOpsItem(&ssm.CreateOpsItemInput{
+		Title:           aws.String(title),
+		Description:     aws.String(description),
+		Source:          aws.String(Source),
+		OperationalData: data,
+	})
+	if err == nil {
+		return aws.StringValue(out.OpsItemId), nil
+	}
+
+	// The dedup string matched an open OpsItem, which carries the failures of the pair
+	existing, ok := err.(*ssm.OpsItemAlreadyExistsException)
+	if !ok {
+		return "", errs.Wrap(errs.Target, "create ops item", err)
+	}
+	id := aws.StringValue(existing.OpsItemId)
+	_, err = ssmSvc.UpdateOpsItem(&ssm.UpdateOpsItemInput{
+		OpsItemId:       aws.String(id),
+		Description:     aws.String(description),
+		OperationalData: operationalData(f),
+	})
+	if err != nil {
+		return id, errs.Wrap(errs.Target, "update ops item", err)
+	}
+	return id, nil
+}
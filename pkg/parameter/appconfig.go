@@ -0,0 +1,33 @@
+package parameter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// appConfigTimeout bounds the call to the AppConfig extension, which serves its cached copy of the profile
+const appConfigTimeout = 2 * time.Second
+
+// AppConfig reads the configuration profile, e.g. "applications/sg-sync/environments/prod/configurations/flags",
+// from the AWS AppConfig Lambda extension
+func AppConfig(profile string) ([]byte, error) {
+	port := os.Getenv("AWS_APPCONFIG_EXTENSION_HTTP_PORT")
+	if port == "" {
+		port = "2772"
+	}
+	client := http.Client{Timeout: appConfigTimeout}
+	resp, err := client.Get(fmt.Sprintf("http://localhost:%s/%s", port, strings.TrimPrefix(profile, "/")))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return io.ReadAll(resp.Body)
+}
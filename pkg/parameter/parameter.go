@@ -0,0 +1,56 @@
+package parameter
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// Get reads the SSM parameter. It returns false when the parameter doesn't exist.
+func Get(ssmSvc ssmiface.SSMAPI, name string) (string, bool, error) {
+	out, err := ssmSvc.GetParameter(&ssm.GetParameterInput{Name: aws.String(name)})
+	var notFound *ssm.ParameterNotFound
+	if errors.As(err, &notFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errs.Wrap(errs.Config, "get parameter", err)
+	}
+	return aws.StringValue(out.Parameter.Value), true, nil
+}
+
+// Put creates or overwrites the SSM parameter
+func Put(ssmSvc ssmiface.SSMAPI, name string, value string, description string) error {
+	_, err := ssmSvc.PutParameter(&ssm.PutParameterInput{
+		Name:        aws.String(name),
+		Value:       aws.String(value),
+		Description: aws.String(description),
+		Type:        aws.String(ssm.ParameterTypeString),
+		Overwrite:   aws.Bool(true),
+	})
+	return errs.Wrap(errs.Config, "put parameter", err)
+}
+
+// PutOnce creates the SSM parameter unless it already exists. It returns the value the parameter ends up holding,
+// the existing one when another invocation created it first.
+func PutOnce(ssmSvc ssmiface.SSMAPI, name string, value string, description string) (string, error) {
+	_, err := ssmSvc.PutParameter(&ssm.PutParameterInput{
+		Name:        aws.String(name),
+		Value:       aws.String(value),
+		Description: aws.String(description),
+		Type:        aws.String(ssm.ParameterTypeString),
+		Overwrite:   aws.Bool(false),
+	})
+	var exists *ssm.ParameterAlreadyExists
+	if errors.As(err, &exists) {
+		existing, _, err := Get(ssmSvc, name)
+		return existing, err
+	}
+	if err != nil {
+		return "", errs.Wrap(errs.Config, "put parameter", err)
+	}
+	return value, nil
+}
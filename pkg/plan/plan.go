@@ -0,0 +1,175 @@
+package plan
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// ErrDiverged is returned when the Security Group's rules changed since the plan was made
+var ErrDiverged = errors.New("the security group changed since the plan was made")
+
+// ErrApplied is returned when the plan was already applied
+var ErrApplied = errors.New("the plan was already applied")
+
+// ErrExpired is returned when the plan is older than the max age of the plans
+var ErrExpired = errors.New("the plan expired")
+
+// validID matches the IDs of the plans, see newID
+var validID = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z-[0-9a-f]{8}$`)
+
+// Change is the changes of a rule of the plan
+type Change struct {
+	Rule      target.Rule `json:"rule"`
+	Authorize []string    `json:"authorize,omitempty"`
+	Revoke    []string    `json:"revoke,omitempty"`
+}
+
+// Plan is the diff of a sync, saved to be applied as it is by a later invocation
+type Plan struct {
+	ID                   string    `json:"plan_id"`
+	CreatedAt            time.Time `json:"created_at"`
+	Region               string    `json:"region"`
+	AutoScalingGroupName string    `json:"asg_name"`
+	SecurityGroupID      string    `json:"sg_id"`
+	// Fingerprint is the hash of the Security Group's ingress rules the diff was computed from
+	Fingerprint string   `json:"fingerprint"`
+	Changes     []Change `json:"changes"`
+	// Owners maps the authorized CIDRs to their instances
+	Owners map[string]string `json:"owners,omitempty"`
+	// AppliedAt is when the plan was applied, nil until it is
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// New builds the plan of the dry run's result, computed from the group's rules
+func New(region string, asgName string, group *ec2.SecurityGroup, result syncer.Result) Plan {
+	p := Plan{
+		ID:                   newID(),
+		CreatedAt:            time.Now().UTC(),
+		Region:               region,
+		AutoScalingGroupName: asgName,
+		SecurityGroupID:      aws.StringValue(group.GroupId),
+		Fingerprint:          Fingerprint(group),
+		Owners:               result.Owners,
+	}
+	for _, rr := range result.Rules {
+		if len(rr.AddedIPs) != 0 || len(rr.Revoked) != 0 {
+			p.Changes = append(p.Changes, Change{Rule: rr.Rule, Authorize: rr.AddedIPs, Revoke: rr.Revoked})
+		}
+	}
+	return p
+}
+
+// Result is the result of the sync once the plan is applied
+func (p Plan) Result() syncer.Result {
+	result := syncer.Result{Owners: p.Owners}
+	for _, change := range p.Changes {
+		result.AddedIPs = append(result.AddedIPs, change.Authorize...)
+		result.RemovedIPs = append(result.RemovedIPs, change.Revoke...)
+		result.Rules = append(result.Rules, syncer.RuleResult{Rule: change.Rule, AddedIPs: change.Authorize, RemovedIPs: change.Revoke, Revoked: change.Revoke})
+	}
+	return result
+}
+
+// Fingerprint hashes the ingress rules of the group, whatever their order and descriptions
+func Fingerprint(group *ec2.SecurityGroup) string {
+	var keys []string
+	for key := range target.PermissionKeys(group.IpPermissions) {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	for _, key := range keys {
+		io.WriteString(hash, key+"\n")
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Apply makes the plan's changes, unless the Security Group's rules changed since the plan was made or the plan is
+// older than maxAge. The rules are described again right before, whatever the cache.
+func Apply(p Plan, maxAge time.Duration, ec2Svc ec2iface.EC2API) error {
+	if p.AppliedAt != nil {
+		return errs.Wrap(errs.Config, "apply plan", ErrApplied)
+	}
+	if maxAge > 0 && time.Since(p.CreatedAt) > maxAge {
+		return errs.Wrap(errs.Config, "apply plan", ErrExpired)
+	}
+	group, err := target.Describe(p.SecurityGroupID, ec2Svc)
+	if err != nil {
+		return err
+	}
+	if Fingerprint(group) != p.Fingerprint {
+		return errs.Wrap(errs.Target, "apply plan", ErrDiverged)
+	}
+	for _, change := range p.Changes {
+		if err := target.Authorize(p.SecurityGroupID, change.Rule, change.Authorize, p.Owners, ec2Svc); err != nil {
+			return err
+		}
+	}
+	for _, change := range p.Changes {
+		if err := target.Revoke(p.SecurityGroupID, change.Rule, change.Revoke, ec2Svc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save writes the plan to the bucket, as <prefix><plan ID>.json
+func Save(p Plan, s3Svc s3iface.S3API, bucket string, prefix string) error {
+	body, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return errs.Wrap(errs.Config, "save plan", err)
+	}
+	_, err = s3Svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(prefix + p.ID + ".json"),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return errs.Wrap(errs.Target, "save plan", err)
+}
+
+// Load reads the plan from the bucket
+func Load(id string, s3Svc s3iface.S3API, bucket string, prefix string) (Plan, error) {
+	var p Plan
+	if !validID.MatchString(id) {
+		return p, errs.Errorf(errs.Config, "load plan", "%q is not a valid plan ID", id)
+	}
+	out, err := s3Svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(prefix + id + ".json")})
+	if err != nil {
+		return p, errs.Wrap(errs.Config, "load plan", err)
+	}
+	defer out.Body.Close()
+	if err := json.NewDecoder(out.Body).Decode(&p); err != nil {
+		return p, errs.Wrap(errs.Config, "load plan", err)
+	}
+	return p, nil
+}
+
+// Builds the ID of a plan: its creation time and a random suffix, so that the IDs sort by time
+func newID() string {
+	now := time.Now().UTC()
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		// The nanoseconds are random enough for a suffix
+		return now.Format("20060102T150405Z") + fmt.Sprintf("-%08x", uint32(now.UnixNano()))
+	}
+	return now.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
@@ -0,0 +1,28 @@
+package policy
+
+import (
+	"fmt"
+)
+
+// Order is the order in which the sync applies its additions and removals
+type Order string
+
+const (
+	// AddFirst authorizes the new IPs before revoking the stale ones, so that no instance ever loses access
+	AddFirst Order = "add-first"
+	// RemoveFirst revokes the stale IPs before authorizing the new ones, so that old access is closed before new
+	// access is opened and the rules quota is freed first
+	RemoveFirst Order = "remove-first"
+)
+
+// ParseOrder parses the order, defaulting to AddFirst when it is empty
+func ParseOrder(s string) (Order, error) {
+	switch order := Order(s); order {
+	case "":
+		return AddFirst, nil
+	case AddFirst, RemoveFirst:
+		return order, nil
+	default:
+		return AddFirst, fmt.Errorf("unknown order %q, expected %s or %s", s, AddFirst, RemoveFirst)
+	}
+}
@@ -0,0 +1,76 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Stage is a step of the sync that can fail
+type Stage string
+
+const (
+	// StageSource collects the AutoScaling Group's IPs
+	StageSource Stage = "source"
+	// StageRead reads the Security Group's rules
+	StageRead Stage = "read"
+	// StageGC looks for orphan rules
+	StageGC Stage = "gc"
+	// StageAdd authorizes the new IPs
+	StageAdd Stage = "add"
+	// StageRemove revokes the stale IPs
+	StageRemove Stage = "remove"
+	// StageState records the added and removed rules in the state store
+	StageState Stage = "state"
+	// StageTargets registers or deregisters the instance with the target groups
+	StageTargets Stage = "targets"
+	// StageVerify reads the Security Group back to check that the changes were applied
+	StageVerify Stage = "verify"
+)
+
+// Action is what happens when a stage fails
+type Action string
+
+const (
+	// Abandon stops the sync and sends the failure result to the AutoScaling Group
+	Abandon Action = "abandon"
+	// Continue records the failure, carries on with the stages that don't depend on it, sends CONTINUE to the
+	// AutoScaling Group and raises an alert
+	Continue Action = "continue"
+)
+
+// Policy maps the stages to the action taken when they fail. Stages that are not listed abandon.
+type Policy map[Stage]Action
+
+// Parse reads a policy like "add=abandon,remove=continue"
+func Parse(spec string) (Policy, error) {
+	p := make(Policy)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid policy entry %q, expected stage=action", entry)
+		}
+		stage, action := Stage(strings.TrimSpace(parts[0])), Action(strings.TrimSpace(parts[1]))
+		switch stage {
+		case StageSource, StageRead, StageGC, StageAdd, StageRemove, StageState, StageTargets, StageVerify:
+		default:
+			return nil, fmt.Errorf("unknown stage %q", stage)
+		}
+		if action != Abandon && action != Continue {
+			return nil, fmt.Errorf("unknown action %q for stage %q", action, stage)
+		}
+		p[stage] = action
+	}
+	return p, nil
+}
+
+// ActionFor returns the action of the stage, Abandon by default
+func (p Policy) ActionFor(stage Stage) Action {
+	if action, ok := p[stage]; ok {
+		return action
+	}
+	return Abandon
+}
@@ -0,0 +1,74 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    Policy
+		wantErr bool
+	}{
+		{name: "empty", spec: "", want: Policy{}},
+		{name: "one stage", spec: "remove=continue", want: Policy{StageRemove: Continue}},
+		{
+			name: "several stages, spaced",
+			spec: " add = abandon , remove=continue,verify=continue,",
+			want: Policy{StageAdd: Abandon, StageRemove: Continue, StageVerify: Continue},
+		},
+		{name: "the last entry of a stage wins", spec: "gc=continue,gc=abandon", want: Policy{StageGC: Abandon}},
+		{name: "no action", spec: "remove", wantErr: true},
+		{name: "unknown stage", spec: "apply=continue", wantErr: true},
+		{name: "unknown action", spec: "remove=retry", wantErr: true},
+		{name: "empty action", spec: "remove=", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActionFor(t *testing.T) {
+	p := Policy{StageRemove: Continue}
+	if action := p.ActionFor(StageRemove); action != Continue {
+		t.Errorf("ActionFor(%s) = %s, want %s", StageRemove, action, Continue)
+	}
+	if action := p.ActionFor(StageAdd); action != Abandon {
+		t.Errorf("ActionFor(%s) = %s, want the default %s", StageAdd, action, Abandon)
+	}
+}
+
+func TestParseOrder(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Order
+		wantErr bool
+	}{
+		{spec: "", want: AddFirst},
+		{spec: "add-first", want: AddFirst},
+		{spec: "remove-first", want: RemoveFirst},
+		{spec: "Remove-First", want: AddFirst, wantErr: true},
+		{spec: "remove", want: AddFirst, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseOrder(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseOrder(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseOrder(%q) = %s, want %s", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// MaxDelay is the longest delay SQS supports for a single message
+const MaxDelay = 15 * time.Minute
+
+// RemovalMessage asks a later invocation to remove the rules of a terminated instance once it is truly gone
+type RemovalMessage struct {
+	Region               string        `json:"region"`
+	AutoScalingGroupName string        `json:"asgName"`
+	SecurityGroupID      string        `json:"sgID"`
+	Rules                []target.Rule `json:"rules"`
+	InstanceID           string        `json:"instanceID"`
+	CIDRs                []string      `json:"cidrs"`
+	EnqueuedAt           time.Time     `json:"enqueuedAt"`
+}
+
+// KindDeferredSync is the kind of the DeferredSync messages. The RemovalMessages have no kind.
+const KindDeferredSync = "deferredSync"
+
+// DeferredSync asks a later invocation to apply the changes of a sync that a maintenance window deferred, once the
+// window closed
+type DeferredSync struct {
+	Kind                 string        `json:"kind"`
+	Region               string        `json:"region"`
+	AutoScalingGroupName string        `json:"asgName"`
+	SecurityGroupID      string        `json:"sgID"`
+	Rules                []target.Rule `json:"rules"`
+	// IntendedAdds and IntendedRemovals are the changes the sync would have made when it was deferred
+	IntendedAdds     []string  `json:"intendedAdds"`
+	IntendedRemovals []string  `json:"intendedRemovals"`
+	DeferredAt       time.Time `json:"deferredAt"`
+	// DeferredUntil is when the window closes
+	DeferredUntil time.Time `json:"deferredUntil"`
+}
+
+// EnqueueRemoval sends the message to the queue, to be delivered after delay (capped at MaxDelay)
+func EnqueueRemoval(sqsSvc sqsiface.SQSAPI, queueURL string, delay time.Duration, msg RemovalMessage) error {
+	return send(sqsSvc, queueURL, delay, msg, "enqueue removal")
+}
+
+// EnqueueDeferredSync sends the message to the queue, to be delivered when its window closes. Windows longer than
+// MaxDelay need the message to be enqueued again on every delivery until then.
+func EnqueueDeferredSync(sqsSvc sqsiface.SQSAPI, queueURL string, msg DeferredSync, now time.Time) error {
+	msg.Kind = KindDeferredSync
+	return send(sqsSvc, queueURL, msg.DeferredUntil.Sub(now), msg, "enqueue deferred sync")
+}
+
+// Sends the message to the queue, to be delivered after delay (capped at MaxDelay)
+func send(sqsSvc sqsiface.SQSAPI, queueURL string, delay time.Duration, msg interface{}, op string) error {
+	if delay > MaxDelay {
+		delay = MaxDelay
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return errs.Wrap(errs.Target, op, err)
+	}
+	_, err = sqsSvc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:     aws.String(queueURL),
+		MessageBody:  aws.String(string(body)),
+		DelaySeconds: aws.Int64(int64(delay / time.Second)),
+	})
+	return errs.Wrap(errs.Target, op, err)
+}
+
+// KindOf gets the kind of the message's body. It is empty for the RemovalMessages and the malformed bodies.
+func KindOf(body string) string {
+	var msg struct {
+		Kind string `json:"kind"`
+	}
+	_ = json.Unmarshal([]byte(body), &msg)
+	return msg.Kind
+}
+
+// ParseRemoval decodes the body of a RemovalMessage
+func ParseRemoval(body string) (msg RemovalMessage, err error) {
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		return msg, errs.Wrap(errs.Config, "parse removal message", err)
+	}
+	if msg.AutoScalingGroupName == "" || msg.SecurityGroupID == "" || msg.InstanceID == "" {
+		return msg, errs.Errorf(errs.Config, "parse removal message", "asgName, sgID and instanceID are required")
+	}
+	return msg, nil
+}
+
+// ParseDeferredSync decodes the body of a DeferredSync
+func ParseDeferredSync(body string) (msg DeferredSync, err error) {
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		return msg, errs.Wrap(errs.Config, "parse deferred sync message", err)
+	}
+	if msg.AutoScalingGroupName == "" || msg.SecurityGroupID == "" {
+		return msg, errs.Errorf(errs.Config, "parse deferred sync message", "asgName and sgID are required")
+	}
+	return msg, nil
+}
@@ -0,0 +1,114 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/syncer"
+)
+
+// Entry is the compliance of an AutoScaling Group and Security Group pair
+type Entry struct {
+	AutoScalingGroupName string `json:"asgName"`
+	SecurityGroupID      string `json:"sgID"`
+	// TotalRules and ManagedRules are the Security Group's inbound rules, all of them and the ones the sync created
+	TotalRules   int `json:"total_rules"`
+	ManagedRules int `json:"managed_rules"`
+	// PendingAdds and PendingRemovals are the IPs a sync would add and remove right now
+	PendingAdds     []string `json:"pending_adds,omitempty"`
+	PendingRemovals []string `json:"pending_removals,omitempty"`
+	// Drift are the changes other actors made since the last sync
+	Drift []syncer.Drift `json:"drift,omitempty"`
+	// LastChange is when the newest managed rule was created
+	LastChange *time.Time `json:"last_change,omitempty"`
+	// Failures are the stage failures of the check, Error the failure that stopped it
+	Failures []syncer.Failure `json:"failures,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// Compliant returns true when the Security Group matches the AutoScaling Group and nothing else changed it
+func (e Entry) Compliant() bool {
+	return e.Error == "" && len(e.Failures) == 0 && len(e.Drift) == 0 && len(e.PendingAdds) == 0 && len(e.PendingRemovals) == 0
+}
+
+// Report is the consolidated compliance report of all the pairs
+type Report struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Entries     []Entry   `json:"entries"`
+}
+
+var page = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Security Group sync report {{.GeneratedAt.Format "2006-01-02 15:04 MST"}}</title></head>
+<body>
+<h1>Security Group sync report</h1>
+<p>Generated at {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}</p>
+<table border="1" cellpadding="4">
+<tr><th>AutoScaling Group</th><th>Security Group</th><th>Compliant</th><th>Rules (managed/total)</th><th>Pending adds</th><th>Pending removals</th><th>Drift</th><th>Last change</th><th>Failures</th></tr>
+{{range .Entries}}<tr>
+<td>{{.AutoScalingGroupName}}</td>
+<td>{{.SecurityGroupID}}</td>
+<td>{{if .Compliant}}yes{{else}}<b>no</b>{{end}}</td>
+<td>{{.ManagedRules}}/{{.TotalRules}}</td>
+<td>{{range .PendingAdds}}{{.}}<br>{{end}}</td>
+<td>{{range .PendingRemovals}}{{.}}<br>{{end}}</td>
+<td>{{range .Drift}}{{.Change}} {{.CIDR}} {{.Rule}}{{if .Principal}} by {{.Principal}}{{end}}<br>{{end}}</td>
+<td>{{if .LastChange}}{{.LastChange.Format "2006-01-02 15:04:05 MST"}}{{end}}</td>
+<td>{{range .Failures}}{{.Stage}}: {{.Error}}<br>{{end}}{{.Error}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// HTML renders the report as a page for the security review
+func HTML(report Report) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, report); err != nil {
+		return nil, errs.Wrap(errs.Unknown, "render report", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Upload writes the report to the bucket as JSON and HTML, under prefix and named after its time. Returns the keys
+// of the objects.
+func Upload(s3Svc s3iface.S3API, bucket string, prefix string, report Report) ([]string, error) {
+	body, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, errs.Wrap(errs.Unknown, "marshal report", err)
+	}
+	html, err := HTML(report)
+	if err != nil {
+		return nil, err
+	}
+
+	name := prefix + "report-" + report.GeneratedAt.Format("20060102T150405Z")
+	objects := []struct {
+		key         string
+		body        []byte
+		contentType string
+	}{
+		{name + ".json", body, "application/json"},
+		{name + ".html", html, "text/html; charset=utf-8"},
+	}
+	var keys []string
+	for _, object := range objects {
+		_, err := s3Svc.PutObject(&s3.PutObjectInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(object.key),
+			Body:        bytes.NewReader(object.body),
+			ContentType: aws.String(object.contentType),
+		})
+		if err != nil {
+			return keys, errs.Wrap(errs.Target, "put report", err)
+		}
+		keys = append(keys, object.key)
+	}
+	return keys, nil
+}
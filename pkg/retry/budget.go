@@ -0,0 +1,123 @@
+package retry
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// Budget is the total number of retries the AWS calls of an invocation can make, whatever the calls. Once it is
+// spent, the failed calls are no longer retried.
+type Budget struct {
+	mu      sync.Mutex
+	limit   int
+	used    int
+	retries map[string]int
+	gaveUp  *GiveUp
+}
+
+// GiveUp is the failed call that found the budget spent
+type GiveUp struct {
+	// Operation is the call's service and operation, e.g. ec2:AuthorizeSecurityGroupIngress
+	Operation string `json:"operation"`
+	// Retries is how many times the operation had been retried during the invocation
+	Retries int    `json:"retries"`
+	Error   string `json:"error"`
+}
+
+// Report records the retries of an invocation: how many times every operation was retried and, when the budget was
+// spent, where the invocation gave up
+type Report struct {
+	Budget     int            `json:"budget"`
+	Used       int            `json:"used"`
+	Operations map[string]int `json:"operations,omitempty"`
+	GaveUp     *GiveUp        `json:"gave_up,omitempty"`
+}
+
+// current is the budget of the running invocation. A Lambda container runs one invocation at a time.
+var current atomic.Pointer[Budget]
+
+// Start gives the invocation a budget of limit retries and makes it the current one. A limit of 0 or less leaves the
+// invocation without a budget and returns nil.
+func Start(limit int) *Budget {
+	if limit <= 0 {
+		current.Store(nil)
+		return nil
+	}
+	b := &Budget{limit: limit, retries: make(map[string]int)}
+	current.Store(b)
+	return b
+}
+
+// Stop ends the budget of the invocation, when it is still the current one
+func Stop(b *Budget) {
+	current.CompareAndSwap(b, nil)
+}
+
+// Current returns the budget of the running invocation, nil when it has none
+func Current() *Budget {
+	return current.Load()
+}
+
+// Allow spends a retry of the operation that failed with err. Returns false when the budget is spent, recording the
+// first operation that was refused. A nil Budget allows every retry.
+func (b *Budget) Allow(operation string, err error) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used >= b.limit {
+		if b.gaveUp == nil {
+			b.gaveUp = &GiveUp{Operation: operation, Retries: b.retries[operation]}
+			if err != nil {
+				b.gaveUp.Error = err.Error()
+			}
+		}
+		return false
+	}
+	b.used++
+	b.retries[operation]++
+	return true
+}
+
+// Exhausted returns true when a retry was refused
+func (b *Budget) Exhausted() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.gaveUp != nil
+}
+
+// Err returns the error of the give up, nil when the budget wasn't exhausted
+func (b *Budget) Err() error {
+	report := b.Report()
+	if report == nil || report.GaveUp == nil {
+		return nil
+	}
+	return errs.Errorf(errs.Throttle, "retry budget", "spent %d retries, gave up on %s: %s", report.Used, report.GaveUp.Operation, report.GaveUp.Error)
+}
+
+// Report returns the retries of the invocation so far, nil when there were none or b is nil
+func (b *Budget) Report() *Report {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used == 0 && b.gaveUp == nil {
+		return nil
+	}
+	report := &Report{Budget: b.limit, Used: b.used, Operations: make(map[string]int, len(b.retries))}
+	for operation, n := range b.retries {
+		report.Operations[operation] = n
+	}
+	if b.gaveUp != nil {
+		gaveUp := *b.gaveUp
+		report.GaveUp = &gaveUp
+	}
+	return report
+}
@@ -0,0 +1,131 @@
+package sentry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client sends the events to Sentry. Its timeout keeps a slow Sentry from eating the function's.
+var Client = &http.Client{Timeout: 5 * time.Second}
+
+// DSN is a parsed Sentry DSN, e.g. https://<public key>@o0.ingest.sentry.io/<project ID>
+type DSN struct {
+	raw       string
+	publicKey string
+	// envelopeURL is the endpoint of the project's envelopes
+	envelopeURL string
+}
+
+// ParseDSN parses the DSN of a Sentry project
+func ParseDSN(raw string) (DSN, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		// The DSN is a secret, it is kept out of the error
+		return DSN{}, errors.New("invalid sentry DSN")
+	}
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	prefix, projectID := "", path
+	if i >= 0 {
+		prefix, projectID = "/"+path[:i], path[i+1:]
+	}
+	if projectID == "" {
+		return DSN{}, errors.New("invalid sentry DSN: no project ID")
+	}
+	return DSN{
+		raw:         strings.TrimSpace(raw),
+		publicKey:   u.User.Username(),
+		envelopeURL: fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, projectID),
+	}, nil
+}
+
+// Exception is an error of an event
+type Exception struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Event is the error or panic reported to Sentry
+type Event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Fingerprint []string          `json:"fingerprint,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+	Exception   struct {
+		Values []Exception `json:"values"`
+	} `json:"exception"`
+}
+
+// NewEvent builds the error event of err. Panics are fatal events, errors are error events.
+func NewEvent(errType string, err error, panicked bool) Event {
+	event := Event{EventID: newEventID(), Timestamp: time.Now().UTC(), Level: "error", Platform: "go", Logger: "sg-sync"}
+	if panicked {
+		event.Level = "fatal"
+	}
+	event.Exception.Values = []Exception{{Type: errType, Value: err.Error()}}
+	return event
+}
+
+// Capture sends the event to the DSN's project, in an envelope
+func Capture(dsn DSN, event Event, clientName string) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	header, err := json.Marshal(map[string]string{"event_id": event.EventID, "dsn": dsn.raw, "sent_at": time.Now().UTC().Format(time.RFC3339)})
+	if err != nil {
+		return err
+	}
+	var envelope bytes.Buffer
+	envelope.Write(header)
+	fmt.Fprintf(&envelope, "\n{\"type\":\"event\",\"length\":%d}\n", len(payload))
+	envelope.Write(payload)
+	envelope.WriteString("\n")
+
+	req, err := http.NewRequest(http.MethodPost, dsn.envelopeURL, &envelope)
+	if err != nil {
+		return errors.New("invalid sentry DSN")
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=%s", dsn.publicKey, clientName))
+	resp, err := Client.Do(req)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		reply, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(reply))
+	}
+	return nil
+}
+
+// Generates the ID of an event, 32 hex digits
+func newEventID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		// The time is unique enough for an error report
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,73 @@
+package source
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// addressBatchSize is the number of instances per DescribeAddresses call, the most values a filter takes
+const addressBatchSize = 200
+
+// Fills in, from their Elastic IPs, the addresses of the running instances that have none: an Elastic IP that was
+// just associated may not be reflected by DescribeInstances yet. The instances with neither keep an empty PublicIP.
+// With ENIDeviceIndex, the interface of the Elastic IP isn't known, and the addresses are left as they are.
+func withElasticIPs(instances []Instance, ec2Svc ec2iface.EC2API) error {
+	if ENIDeviceIndex >= 0 {
+		return nil
+	}
+	var ids []string
+	for _, instance := range instances {
+		if instance.Running() && instance.PublicIP == "" {
+			ids = append(ids, instance.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	eips, err := elasticIPs(ids, ec2Svc)
+	if err != nil {
+		return err
+	}
+	for i, instance := range instances {
+		if eip, ok := eips[instance.ID]; ok && instance.Running() && instance.PublicIP == "" {
+			instances[i].PublicIP = eip
+		}
+	}
+	return nil
+}
+
+// Describes the Elastic IPs (or carrier IPs) associated with the instances, by instance ID. The function's role without
+// ec2:DescribeAddresses finds none, so that the fallback doesn't fail the fleets without Elastic IPs.
+func elasticIPs(ids []string, ec2Svc ec2iface.EC2API) (map[string]string, error) {
+	eips := make(map[string]string)
+	for start := 0; start < len(ids); start += addressBatchSize {
+		end := start + addressBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		out, err := ec2Svc.DescribeAddresses(&ec2.DescribeAddressesInput{
+			Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: aws.StringSlice(ids[start:end])}},
+		})
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == "UnauthorizedOperation" {
+			return eips, nil
+		}
+		if err != nil {
+			return eips, errs.Wrap(errs.Source, "describe addresses", err)
+		}
+		for _, address := range out.Addresses {
+			id := aws.StringValue(address.InstanceId)
+			if ip := aws.StringValue(address.PublicIp); ip != "" {
+				eips[id] = ip
+			} else if ip := aws.StringValue(address.CarrierIp); ip != "" {
+				eips[id] = ip
+			}
+		}
+	}
+	return eips, nil
+}
@@ -0,0 +1,38 @@
+package source
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elasticbeanstalk"
+	"github.com/aws/aws-sdk-go/service/elasticbeanstalk/elasticbeanstalkiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// EnvironmentPrefix marks an AutoScaling Group name that is the alias of an Elastic Beanstalk environment, e.g.
+// eb:my-env
+const EnvironmentPrefix = "eb:"
+
+// ResolveGroupName gets the AutoScaling Group of the name. Aliases of Elastic Beanstalk environments are resolved to
+// the environment's underlying AutoScaling Group, any other name is returned as is.
+func ResolveGroupName(name string, ebSvc elasticbeanstalkiface.ElasticBeanstalkAPI) (string, error) {
+	if !strings.HasPrefix(name, EnvironmentPrefix) {
+		return name, nil
+	}
+	return EnvironmentGroup(strings.TrimPrefix(name, EnvironmentPrefix), ebSvc)
+}
+
+// EnvironmentGroup gets the name of the Elastic Beanstalk environment's AutoScaling Group. It isn't cached, a rebuilt
+// environment gets a new one.
+func EnvironmentGroup(envName string, ebSvc elasticbeanstalkiface.ElasticBeanstalkAPI) (string, error) {
+	out, err := ebSvc.DescribeEnvironmentResources(&elasticbeanstalk.DescribeEnvironmentResourcesInput{
+		EnvironmentName: aws.String(envName),
+	})
+	if err != nil {
+		return "", errs.Wrap(errs.Source, "describe environment resources", err)
+	}
+	if out.EnvironmentResources == nil || len(out.EnvironmentResources.AutoScalingGroups) == 0 {
+		return "", errs.Errorf(errs.Config, "describe environment resources", "environment %q has no AutoScaling Group", envName)
+	}
+	return aws.StringValue(out.EnvironmentResources.AutoScalingGroups[0].Name), nil
+}
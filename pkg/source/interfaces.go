@@ -0,0 +1,105 @@
+package source
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// Discoveries of the instances' addresses
+const (
+	// DiscoveryInstances describes the instances
+	DiscoveryInstances = "instances"
+	// DiscoveryInterfaces describes the network interfaces attached to the instances, a much smaller response for
+	// large fleets. The instances' tags aren't part of it.
+	DiscoveryInterfaces = "interfaces"
+)
+
+// Discovery is how the instances of the AutoScaling Groups are described, DiscoveryInstances by default
+var Discovery = DiscoveryInstances
+
+// interfaceBatchSize is the number of instances per DescribeNetworkInterfaces call, the most values of a filter
+const interfaceBatchSize = 200
+
+// Describes the instances through their network interfaces. The instances without any attached interface (e.g.
+// terminated ones) are left out, the ones whose interfaces are detaching are shutting down.
+func interfaceInstances(ids []*string, protected map[string]bool, excludeInstanceID string, ec2Svc ec2iface.EC2API) ([]Instance, error) {
+	enis := make(map[string][]*ec2.NetworkInterface, len(ids))
+	for start := 0; start < len(ids); start += interfaceBatchSize {
+		end := start + interfaceBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		err := ec2Svc.DescribeNetworkInterfacesPages(&ec2.DescribeNetworkInterfacesInput{
+			Filters: []*ec2.Filter{{Name: aws.String("attachment.instance-id"), Values: ids[start:end]}},
+		}, func(page *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+			for _, eni := range page.NetworkInterfaces {
+				if eni.Attachment == nil {
+					continue
+				}
+				id := aws.StringValue(eni.Attachment.InstanceId)
+				enis[id] = append(enis[id], eni)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, errs.Wrap(errs.Source, "describe network interfaces", err)
+		}
+	}
+
+	instances := make([]Instance, 0, len(enis))
+	for _, id := range ids {
+		attached, ok := enis[aws.StringValue(id)]
+		if !ok || (excludeInstanceID != "" && aws.StringValue(id) == excludeInstanceID) {
+			continue
+		}
+		instances = append(instances, interfacesInstance(aws.StringValue(id), attached, protected[aws.StringValue(id)]))
+	}
+	return instances, nil
+}
+
+// Builds the Instance of the network interfaces attached to the instance: the primary one gives its VPC and security
+// groups, and the address is picked as publicAddress picks it
+func interfacesInstance(id string, enis []*ec2.NetworkInterface, protectedFromScaleIn bool) Instance {
+	sort.SliceStable(enis, func(i, j int) bool {
+		return aws.Int64Value(enis[i].Attachment.DeviceIndex) < aws.Int64Value(enis[j].Attachment.DeviceIndex)
+	})
+	primary := enis[0]
+	instance := Instance{
+		ID:                   id,
+		State:                ec2.InstanceStateNameRunning,
+		VpcID:                aws.StringValue(primary.VpcId),
+		ProtectedFromScaleIn: protectedFromScaleIn,
+	}
+	if status := aws.StringValue(primary.Attachment.Status); status == ec2.AttachmentStatusDetaching || status == ec2.AttachmentStatusDetached {
+		instance.State = ec2.InstanceStateNameShuttingDown
+	}
+	for _, group := range primary.Groups {
+		instance.SecurityGroupIDs = append(instance.SecurityGroupIDs, aws.StringValue(group.GroupId))
+	}
+	instance.PublicIP = interfacesAddress(enis)
+	instance.Prefixes = interfacesPrefixes(enis)
+	return instance
+}
+
+// Gets the address of the instance among its network interfaces, sorted by device index: the public IP of its primary
+// interface, which is the instance's public IP, else the first carrier IP. With ENIDeviceIndex, the address of that
+// interface alone.
+func interfacesAddress(enis []*ec2.NetworkInterface) string {
+	for _, eni := range enis {
+		index := aws.Int64Value(eni.Attachment.DeviceIndex)
+		if eni.Association == nil || (ENIDeviceIndex >= 0 && index != ENIDeviceIndex) {
+			continue
+		}
+		if ip := aws.StringValue(eni.Association.PublicIp); ip != "" && (index == 0 || ENIDeviceIndex >= 0) {
+			return ip
+		}
+		if ip := aws.StringValue(eni.Association.CarrierIp); ip != "" {
+			return ip
+		}
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package source
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Modes of the IPv4 prefixes delegated to the instances' network interfaces
+const (
+	// PrefixesOff syncs the instances' addresses alone
+	PrefixesOff = "off"
+	// PrefixesAdd syncs the instances' delegated prefixes along with their addresses
+	PrefixesAdd = "add"
+	// PrefixesReplace syncs the delegated prefixes instead of the addresses of the instances that have any
+	PrefixesReplace = "replace"
+)
+
+// PrefixDelegation is how the IPv4 prefixes delegated to the instances' network interfaces are synced, PrefixesOff by
+// default
+var PrefixDelegation = PrefixesOff
+
+// SyncedPrefixes gets the delegated prefixes the sync manages for the instance, none unless PrefixDelegation is on
+func (i Instance) SyncedPrefixes() []string {
+	if PrefixDelegation == PrefixesOff {
+		return nil
+	}
+	return i.Prefixes
+}
+
+// Gets the IPv4 prefixes delegated to the instance's network interfaces. With ENIDeviceIndex, the prefixes of that
+// interface alone.
+func instancePrefixes(inst *ec2.Instance) []string {
+	var prefixes []string
+	for _, eni := range inst.NetworkInterfaces {
+		if ENIDeviceIndex >= 0 && (eni.Attachment == nil || aws.Int64Value(eni.Attachment.DeviceIndex) != ENIDeviceIndex) {
+			continue
+		}
+		for _, prefix := range eni.Ipv4Prefixes {
+			prefixes = append(prefixes, aws.StringValue(prefix.Ipv4Prefix))
+		}
+	}
+	return prefixes
+}
+
+// Gets the IPv4 prefixes delegated to the network interfaces, as instancePrefixes gets them
+func interfacesPrefixes(enis []*ec2.NetworkInterface) []string {
+	var prefixes []string
+	for _, eni := range enis {
+		if ENIDeviceIndex >= 0 && aws.Int64Value(eni.Attachment.DeviceIndex) != ENIDeviceIndex {
+			continue
+		}
+		for _, prefix := range eni.Ipv4Prefixes {
+			prefixes = append(prefixes, aws.StringValue(prefix.Ipv4Prefix))
+		}
+	}
+	return prefixes
+}
@@ -0,0 +1,323 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// ErrGroupNotFound is returned when the AutoScaling Group doesn't exist, e.g. it was deleted while its last instances
+// were terminating
+var ErrGroupNotFound = errors.New("autoscaling group not found")
+
+// Instance is an EC2 instance of the AutoScaling Group
+type Instance struct {
+	ID    string
+	State string
+	// PublicIP is the instance's public IP or, in a Wavelength zone, its carrier IP
+	PublicIP string
+	VpcID    string
+	// SecurityGroupIDs are the security groups attached to the instance
+	SecurityGroupIDs []string
+	// ProtectedFromScaleIn is the instance's scale-in protection in the AutoScaling Group
+	ProtectedFromScaleIn bool
+	// Tags are the instance's tags, nil when it has none
+	Tags map[string]string
+	// Prefixes are the IPv4 prefixes delegated to the instance's network interfaces, e.g. 10.0.1.16/28
+	Prefixes []string
+}
+
+// Running returns true when the instance is neither shutting down nor terminated
+func (i Instance) Running() bool {
+	return i.State != "shutting-down" && i.State != "terminated"
+}
+
+// ASGInstances describes all the instances of the Autoscaling Group.
+// The instance with ID excludeInstanceID, if not empty, is left out (e.g. the one being terminated).
+func ASGInstances(asgName string, excludeInstanceID string, autoscalingSvc autoscalingiface.AutoScalingAPI, ec2Svc ec2iface.EC2API) ([]Instance, error) {
+	var instances []Instance
+	asgResp, err := autoscalingSvc.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(asgName)},
+	})
+	if err != nil {
+		return instances, errs.Wrap(errs.Source, "describe autoscaling group", err)
+	}
+	if len(asgResp.AutoScalingGroups) == 0 {
+		return instances, errs.Wrap(errs.Source, "describe autoscaling group", ErrGroupNotFound)
+	}
+
+	asgInstances := asgResp.AutoScalingGroups[0].Instances
+	protected := make(map[string]bool, len(asgInstances))
+	ids := make([]*string, 0, len(asgInstances))
+	for _, instance := range asgInstances {
+		protected[aws.StringValue(instance.InstanceId)] = aws.BoolValue(instance.ProtectedFromScaleIn)
+		ids = append(ids, instance.InstanceId)
+	}
+	if Discovery == DiscoveryInterfaces {
+		return interfaceInstances(ids, protected, excludeInstanceID, ec2Svc)
+	}
+
+	// One call per describeBatchSize instances rather than one per instance, large fleets are described in a few pages
+	instances = make([]Instance, 0, len(ids))
+	for start := 0; start < len(ids); start += describeBatchSize {
+		end := start + describeBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		err := ec2Svc.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+			InstanceIds: ids[start:end],
+		}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, rsv := range page.Reservations {
+				for _, rsvInst := range rsv.Instances {
+					id := aws.StringValue(rsvInst.InstanceId)
+					if excludeInstanceID != "" && id == excludeInstanceID {
+						continue
+					}
+					instances = append(instances, instanceOf(rsvInst, protected[id]))
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return instances, errs.Wrap(errs.Source, "describe instances", err)
+		}
+	}
+	return instances, withElasticIPs(instances, ec2Svc)
+}
+
+// ENIDeviceIndex is the device index of the network interface whose address is synced, for the instances with several
+// of them. A negative index uses the instance's public IP.
+var ENIDeviceIndex int64 = -1
+
+// Gets the public IP of the instance. Instances in Wavelength zones have none, their carrier IP is on the association
+// of their network interfaces instead, the primary one first.
+// With ENIDeviceIndex, only the address of that network interface is used.
+func publicAddress(inst *ec2.Instance) string {
+	if ENIDeviceIndex >= 0 {
+		return interfaceAddress(inst, ENIDeviceIndex)
+	}
+	if ip := aws.StringValue(inst.PublicIpAddress); ip != "" {
+		return ip
+	}
+	enis := append([]*ec2.InstanceNetworkInterface{}, inst.NetworkInterfaces...)
+	sort.SliceStable(enis, func(i, j int) bool { return deviceIndex(enis[i]) < deviceIndex(enis[j]) })
+	for _, eni := range enis {
+		if eni.Association != nil {
+			if ip := aws.StringValue(eni.Association.CarrierIp); ip != "" {
+				return ip
+			}
+		}
+	}
+	return ""
+}
+
+// Gets the public or carrier IP of the instance's network interface at the device index. It is empty when the
+// instance has no such interface or the interface has no address.
+func interfaceAddress(inst *ec2.Instance, index int64) string {
+	for _, eni := range inst.NetworkInterfaces {
+		if eni.Attachment == nil || aws.Int64Value(eni.Attachment.DeviceIndex) != index || eni.Association == nil {
+			continue
+		}
+		if ip := aws.StringValue(eni.Association.PublicIp); ip != "" {
+			return ip
+		}
+		return aws.StringValue(eni.Association.CarrierIp)
+	}
+	return ""
+}
+
+// Gets the device index of the network interface
+func deviceIndex(eni *ec2.InstanceNetworkInterface) int64 {
+	if eni.Attachment == nil {
+		return 0
+	}
+	return aws.Int64Value(eni.Attachment.DeviceIndex)
+}
+
+// describeBatchSize is the number of instances described per DescribeInstances call
+const describeBatchSize = 1000
+
+// filterBatchSize is the maximum number of values of a DescribeInstances filter
+const filterBatchSize = 200
+
+// Builds the Instance of the EC2 instance
+func instanceOf(inst *ec2.Instance, protectedFromScaleIn bool) Instance {
+	var state string
+	if inst.State != nil {
+		state = aws.StringValue(inst.State.Name)
+	}
+	groupIDs := make([]string, 0, len(inst.SecurityGroups))
+	for _, group := range inst.SecurityGroups {
+		groupIDs = append(groupIDs, aws.StringValue(group.GroupId))
+	}
+	var tags map[string]string
+	if len(inst.Tags) != 0 {
+		tags = make(map[string]string, len(inst.Tags))
+		for _, tag := range inst.Tags {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	return Instance{
+		ID:                   aws.StringValue(inst.InstanceId),
+		State:                state,
+		PublicIP:             publicAddress(inst),
+		VpcID:                aws.StringValue(inst.VpcId),
+		SecurityGroupIDs:     groupIDs,
+		ProtectedFromScaleIn: protectedFromScaleIn,
+		Tags:                 tags,
+		Prefixes:             instancePrefixes(inst),
+	}
+}
+
+// PublicIPs gets a map of the normalized host CIDRs (/32 or /128) of the running instances' public IPs to the
+// instances' IDs, with the CIDRs of their delegated prefixes as PrefixDelegation syncs them. A malformed public IP
+// or prefix fails the whole set, rather than silently dropping the instance's rule.
+func PublicIPs(instances []Instance) (cidr.IPSet, error) {
+	ips := make(cidr.IPSet, len(instances))
+	for _, instance := range instances {
+		if !instance.Running() {
+			continue
+		}
+		prefixes := instance.SyncedPrefixes()
+		for _, prefix := range prefixes {
+			if err := ips.Add(prefix, instance.ID); err != nil {
+				return ips, errs.Wrap(errs.Source, "parse delegated prefix", fmt.Errorf("instance %s: %w", instance.ID, err))
+			}
+		}
+		if instance.PublicIP == "" || len(prefixes) != 0 && PrefixDelegation == PrefixesReplace {
+			continue
+		}
+		host, err := cidr.Host(instance.PublicIP)
+		if err == nil {
+			err = ips.Add(host, instance.ID)
+		}
+		if err != nil {
+			return ips, errs.Wrap(errs.Source, "parse public ip", fmt.Errorf("instance %s: %w", instance.ID, err))
+		}
+	}
+	return ips, nil
+}
+
+// GoneInstances returns which of the given instances are terminated or don't exist at all
+func GoneInstances(instanceIDs []string, ec2Svc ec2iface.EC2API) (map[string]struct{}, error) {
+	gone := make(map[string]struct{}, len(instanceIDs))
+	for _, id := range instanceIDs {
+		gone[id] = struct{}{}
+	}
+
+	// A filter, unlike InstanceIds, doesn't fail the whole call when one of the instances doesn't exist, but it takes
+	// at most filterBatchSize values
+	for start := 0; start < len(instanceIDs); start += filterBatchSize {
+		end := start + filterBatchSize
+		if end > len(instanceIDs) {
+			end = len(instanceIDs)
+		}
+		err := ec2Svc.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: aws.StringSlice(instanceIDs[start:end])}},
+		}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, rsv := range page.Reservations {
+				for _, inst := range rsv.Instances {
+					if inst.State != nil && aws.StringValue(inst.State.Name) == "terminated" {
+						continue
+					}
+					delete(gone, aws.StringValue(inst.InstanceId))
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, errs.Wrap(errs.Source, "describe instances", err)
+		}
+	}
+	return gone, nil
+}
+
+// ASGPublicIPs gets a map of running public IPs for all instances of the Autoscaling Group.
+// The instance with ID excludeInstanceID, if not empty, is left out (e.g. the one being terminated).
+func ASGPublicIPs(asgName string, excludeInstanceID string, autoscalingSvc autoscalingiface.AutoScalingAPI, ec2Svc ec2iface.EC2API) (cidr.IPSet, error) {
+	instances, err := ASGInstances(asgName, excludeInstanceID, autoscalingSvc, ec2Svc)
+	if err != nil {
+		return cidr.NewIPSet(), err
+	}
+	return PublicIPs(instances)
+}
+
+// CommonSecurityGroup gets the security group attached to all the running instances. When they share more than one,
+// the first in lexical order is picked.
+func CommonSecurityGroup(instances []Instance) (string, bool) {
+	counts := make(map[string]int)
+	running := 0
+	for _, instance := range instances {
+		if !instance.Running() {
+			continue
+		}
+		running++
+		for _, groupID := range instance.SecurityGroupIDs {
+			counts[groupID]++
+		}
+	}
+	var common []string
+	for groupID, count := range counts {
+		if count == running {
+			common = append(common, groupID)
+		}
+	}
+	if running == 0 || len(common) == 0 {
+		return "", false
+	}
+	sort.Strings(common)
+	return common[0], true
+}
+
+// PublicIP gets the public IP of the instance, or of its Elastic IP when DescribeInstances doesn't reflect it yet. It
+// is empty while the instance has none yet.
+func PublicIP(instanceID string, ec2Svc ec2iface.EC2API) (string, error) {
+	out, err := ec2Svc.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(instanceID)},
+	})
+	if err != nil {
+		return "", errs.Wrap(errs.Source, "describe instances", err)
+	}
+	var instances []Instance
+	for _, rsv := range out.Reservations {
+		for _, inst := range rsv.Instances {
+			instances = append(instances, instanceOf(inst, false))
+		}
+	}
+	if err := withElasticIPs(instances, ec2Svc); err != nil {
+		return "", err
+	}
+	for _, instance := range instances {
+		if instance.PublicIP != "" {
+			return instance.PublicIP, nil
+		}
+	}
+	return "", nil
+}
+
+// GroupTag reads the value of the AutoScaling Group's tag. found is false when the group doesn't have the tag.
+func GroupTag(asgName string, key string, autoscalingSvc autoscalingiface.AutoScalingAPI) (value string, found bool, err error) {
+	out, err := autoscalingSvc.DescribeTags(&autoscaling.DescribeTagsInput{
+		Filters: []*autoscaling.Filter{
+			{Name: aws.String("auto-scaling-group"), Values: []*string{aws.String(asgName)}},
+			{Name: aws.String("key"), Values: []*string{aws.String(key)}},
+		},
+	})
+	if err != nil {
+		return "", false, errs.Wrap(errs.Source, "describe autoscaling group tags", err)
+	}
+	for _, tag := range out.Tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value), true, nil
+		}
+	}
+	return "", false, nil
+}
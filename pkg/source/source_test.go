@@ -0,0 +1,115 @@
+package source_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/fakeaws"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+)
+
+// The sizes of the benchmarked fleets
+var fleets = []int{1000, 10000}
+
+func BenchmarkPublicIPs(b *testing.B) {
+	for _, n := range fleets {
+		instances := fakeInstances(n)
+		b.Run(fmt.Sprintf("instances=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				source.PublicIPs(instances)
+			}
+		})
+	}
+}
+
+// Benchmarks the IP collection of the fleets, with both discoveries. The calls made per collection are reported too.
+func BenchmarkASGInstances(b *testing.B) {
+	for _, discovery := range []string{source.DiscoveryInstances, source.DiscoveryInterfaces} {
+		for _, n := range fleets {
+			autoscalingSvc, ec2Svc := fakeClients(fakeInstances(n))
+			b.Run(fmt.Sprintf("discovery=%s/instances=%d", discovery, n), func(b *testing.B) {
+				source.Discovery = discovery
+				defer func() { source.Discovery = source.DiscoveryInstances }()
+				ec2Svc.calls = 0
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := source.ASGInstances("bench", "", autoscalingSvc, ec2Svc); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(ec2Svc.calls)/float64(b.N), "calls/op")
+			})
+		}
+	}
+}
+
+// Builds the running instances of the fleet
+func fakeInstances(n int) []source.Instance {
+	instances := make([]source.Instance, n)
+	for i := range instances {
+		instances[i] = source.Instance{ID: fakeaws.InstanceID(i), State: "running", PublicIP: fakeaws.IP(i)}
+	}
+	return instances
+}
+
+// In-memory AutoScaling client of a fleet
+type fakeAutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+	group *autoscaling.DescribeAutoScalingGroupsOutput
+}
+
+func (c *fakeAutoScaling) DescribeAutoScalingGroups(*autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	return c.group, nil
+}
+
+// In-memory EC2 client of a fleet. It counts the DescribeInstances and DescribeNetworkInterfaces calls.
+type fakeEC2 struct {
+	ec2iface.EC2API
+	byID  map[string]*ec2.Instance
+	calls int
+}
+
+func (c *fakeEC2) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	c.calls++
+	rsv := &ec2.Reservation{}
+	for _, id := range input.InstanceIds {
+		rsv.Instances = append(rsv.Instances, c.byID[aws.StringValue(id)])
+	}
+	fn(&ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{rsv}}, true)
+	return nil
+}
+
+func (c *fakeEC2) DescribeNetworkInterfacesPages(input *ec2.DescribeNetworkInterfacesInput, fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool) error {
+	c.calls++
+	out := &ec2.DescribeNetworkInterfacesOutput{}
+	for _, id := range input.Filters[0].Values {
+		inst := c.byID[aws.StringValue(id)]
+		out.NetworkInterfaces = append(out.NetworkInterfaces, &ec2.NetworkInterface{
+			Attachment:  &ec2.NetworkInterfaceAttachment{InstanceId: inst.InstanceId, DeviceIndex: aws.Int64(0), Status: aws.String(ec2.AttachmentStatusAttached)},
+			Association: &ec2.NetworkInterfaceAssociation{PublicIp: inst.PublicIpAddress},
+		})
+	}
+	fn(out, true)
+	return nil
+}
+
+// Builds the fake clients of the fleet
+func fakeClients(instances []source.Instance) (*fakeAutoScaling, *fakeEC2) {
+	group := &autoscaling.Group{}
+	ec2Svc := &fakeEC2{byID: make(map[string]*ec2.Instance, len(instances))}
+	for _, instance := range instances {
+		group.Instances = append(group.Instances, &autoscaling.Instance{InstanceId: aws.String(instance.ID), ProtectedFromScaleIn: aws.Bool(false)})
+		ec2Svc.byID[instance.ID] = &ec2.Instance{
+			InstanceId:      aws.String(instance.ID),
+			State:           &ec2.InstanceState{Name: aws.String(instance.State)},
+			PublicIpAddress: aws.String(instance.PublicIP),
+		}
+	}
+	return &fakeAutoScaling{group: &autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: []*autoscaling.Group{group}}}, ec2Svc
+}
@@ -0,0 +1,62 @@
+package source
+
+import (
+	"math"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// waiterDelay is how often the instance is described while waiting for it
+const waiterDelay = 5 * time.Second
+
+// WaitForPublicIP waits until the instance exists, is running and has its public IP, whichever of them it already
+// is. The SDK's InstanceExists and InstanceRunning waiters wait for the first two; the public IP, whose address
+// depends on the ENI settings, is then described until it is set. The deadline of ctx bounds the whole wait, and
+// the wait fails early when the instance stops or terminates instead.
+func WaitForPublicIP(ctx aws.Context, instanceID string, ec2Svc ec2iface.EC2API) (string, error) {
+	input := &ec2.DescribeInstancesInput{InstanceIds: []*string{aws.String(instanceID)}}
+	// The deadline, not the number of attempts, ends the waits
+	opts := []request.WaiterOption{
+		request.WithWaiterDelay(request.ConstantWaiterDelay(waiterDelay)),
+		request.WithWaiterMaxAttempts(math.MaxInt32),
+	}
+	if err := ec2Svc.WaitUntilInstanceExistsWithContext(ctx, input, opts...); err != nil {
+		return "", errs.Wrap(errs.Source, "wait until instance exists", err)
+	}
+	if err := ec2Svc.WaitUntilInstanceRunningWithContext(ctx, input, opts...); err != nil {
+		return "", errs.Wrap(errs.Source, "wait until instance running", err)
+	}
+	for {
+		ip, err := PublicIP(instanceID, ec2Svc)
+		if err != nil || ip != "" {
+			return ip, err
+		}
+		select {
+		case <-ctx.Done():
+			return "", errs.Wrap(errs.Source, "wait until instance has a public ip", ctx.Err())
+		case <-time.After(waiterDelay):
+		}
+	}
+}
+
+// WaitForStatusChecks waits, with the SDK's InstanceStatusOk and SystemStatusOk waiters, until the instance passes
+// both EC2 status checks. The deadline of ctx bounds the whole wait.
+func WaitForStatusChecks(ctx aws.Context, instanceID string, ec2Svc ec2iface.EC2API) error {
+	input := &ec2.DescribeInstanceStatusInput{InstanceIds: []*string{aws.String(instanceID)}}
+	opts := []request.WaiterOption{
+		request.WithWaiterDelay(request.ConstantWaiterDelay(waiterDelay)),
+		request.WithWaiterMaxAttempts(math.MaxInt32),
+	}
+	if err := ec2Svc.WaitUntilInstanceStatusOkWithContext(ctx, input, opts...); err != nil {
+		return errs.Wrap(errs.Source, "wait until instance status ok", err)
+	}
+	if err := ec2Svc.WaitUntilSystemStatusOkWithContext(ctx, input, opts...); err != nil {
+		return errs.Wrap(errs.Source, "wait until system status ok", err)
+	}
+	return nil
+}
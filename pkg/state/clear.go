@@ -0,0 +1,85 @@
+package state
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// Records gets the CIDRs that the sync owns in the Security Group, by rule set, in the deployment's namespace
+func (s *Store) Records(sgID string) (map[target.Rule][]string, error) {
+	records := make(map[target.Rule][]string)
+	err := s.queryKeys(sgID, func(key string) {
+		if !ownKey(key) || strings.HasPrefix(key, "failures#") || strings.HasPrefix(key, "snapshot#") {
+			return
+		}
+		key = strings.TrimPrefix(key, namespaced(""))
+		i := strings.LastIndex(key, "#")
+		if i < 0 {
+			return
+		}
+		rule, err := target.ParseRule(key[:i])
+		if err != nil {
+			return
+		}
+		records[rule] = append(records[rule], key[i+1:])
+	})
+	return records, err
+}
+
+// ClearHistory drops the snapshots of the Security Group, in the deployment's namespace, and its failure counts, which
+// have none. The owned CIDRs are dropped by Forget as their rules are removed. Returns how many records were dropped.
+func (s *Store) ClearHistory(sgID string) (int, error) {
+	var items []*dynamodb.TransactWriteItem
+	err := s.queryKeys(sgID, func(key string) {
+		if !ownKey(key) || !strings.HasPrefix(key, "failures#") && !strings.HasPrefix(key, "snapshot#") {
+			return
+		}
+		items = append(items, &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+			TableName: aws.String(s.Table),
+			Key: map[string]*dynamodb.AttributeValue{
+				sgIDKey: {S: aws.String(sgID)},
+				ruleKey: {S: aws.String(key)},
+			},
+		}})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(items), s.write(items, "clear state")
+}
+
+// Calls fn with the sort key of every record of the Security Group
+func (s *Store) queryKeys(sgID string, fn func(key string)) error {
+	err := s.Svc.QueryPages(&dynamodb.QueryInput{
+		TableName:                aws.String(s.Table),
+		KeyConditionExpression:   aws.String("#sg = :sg"),
+		ExpressionAttributeNames: map[string]*string{"#sg": aws.String(sgIDKey), "#rule": aws.String(ruleKey)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sg": {S: aws.String(sgID)},
+		},
+		ProjectionExpression: aws.String("#rule"),
+		ConsistentRead:       aws.Bool(true),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			fn(aws.StringValue(item[ruleKey].S))
+		}
+		return true
+	})
+	return errs.Wrap(errs.Target, "query state", err)
+}
+
+// Checks whether the sort key is one of the deployment's records, see sortKey, snapshotKey and failuresSortKey
+func ownKey(key string) bool {
+	if strings.HasPrefix(key, "failures#") {
+		return true
+	}
+	key = strings.TrimPrefix(key, "snapshot#")
+	if target.Namespace == "" {
+		return !strings.HasPrefix(key, "ns:")
+	}
+	return strings.HasPrefix(key, namespaced(""))
+}
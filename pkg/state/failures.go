@@ -0,0 +1,50 @@
+package state
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// failuresKey is the attribute of the consecutive failures count
+const failuresKey = "failures"
+
+// Builds the sort key of the AutoScaling Group's failures count. It doesn't look like a rule so that Owned never
+// reads it.
+func failuresSortKey(asgName string) string {
+	return "failures#" + asgName
+}
+
+// CountFailure counts one more consecutive failure of the AutoScaling Group and Security Group pair. Returns the count.
+func (s *Store) CountFailure(sgID string, asgName string) (int, error) {
+	out, err := s.Svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(s.Table),
+		Key: map[string]*dynamodb.AttributeValue{
+			sgIDKey: {S: aws.String(sgID)},
+			ruleKey: {S: aws.String(failuresSortKey(asgName))},
+		},
+		UpdateExpression:          aws.String("ADD #failures :one"),
+		ExpressionAttributeNames:  map[string]*string{"#failures": aws.String(failuresKey)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":one": {N: aws.String("1")}},
+		ReturnValues:              aws.String(dynamodb.ReturnValueUpdatedNew),
+	})
+	if err != nil {
+		return 0, errs.Wrap(errs.Target, "count failure", err)
+	}
+	count, _ := strconv.Atoi(aws.StringValue(out.Attributes[failuresKey].N))
+	return count, nil
+}
+
+// ResetFailures clears the consecutive failures count of the pair after a successful sync
+func (s *Store) ResetFailures(sgID string, asgName string) error {
+	_, err := s.Svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.Table),
+		Key: map[string]*dynamodb.AttributeValue{
+			sgIDKey: {S: aws.String(sgID)},
+			ruleKey: {S: aws.String(failuresSortKey(asgName))},
+		},
+	})
+	return errs.Wrap(errs.Target, "reset failures", err)
+}
@@ -0,0 +1,98 @@
+package state
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// cidrsKey is the attribute of a snapshot's CIDRs
+const cidrsKey = "cidrs"
+
+// versionKey is the attribute of a snapshot's version, incremented by every save
+const versionKey = "version"
+
+// Builds the sort key of the rule set's snapshot. It doesn't start with the rule so that Owned never reads it.
+func snapshotKey(rule target.Rule) string {
+	return "snapshot#" + namespaced(rule.String())
+}
+
+// Snapshot is the CIDRs of a rule set as a sync left them
+type Snapshot struct {
+	CIDRs   []string
+	TakenAt time.Time
+	// Version is the number of saves of the snapshot, 0 when none saved it yet or when it predates the versions
+	Version int64
+}
+
+// Snapshot gets the snapshot of the rule set that the last sync recorded. It is nil when no sync has recorded any yet.
+func (s *Store) Snapshot(sgID string, rule target.Rule) (*Snapshot, error) {
+	out, err := s.Svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.Table),
+		Key: map[string]*dynamodb.AttributeValue{
+			sgIDKey: {S: aws.String(sgID)},
+			ruleKey: {S: aws.String(snapshotKey(rule))},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, errs.Wrap(errs.Target, "get snapshot", err)
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	snapshot := &Snapshot{}
+	if v, ok := out.Item[cidrsKey]; ok {
+		for _, cidr := range v.L {
+			snapshot.CIDRs = append(snapshot.CIDRs, aws.StringValue(cidr.S))
+		}
+	}
+	if v, ok := out.Item[createdAtKey]; ok {
+		snapshot.TakenAt, _ = time.Parse(time.RFC3339, aws.StringValue(v.S))
+	}
+	if v, ok := out.Item[versionKey]; ok {
+		snapshot.Version, _ = strconv.ParseInt(aws.StringValue(v.N), 10, 64)
+	}
+	return snapshot, nil
+}
+
+// SaveSnapshot records the CIDRs of the rule set as the sync left them, over the snapshot of the version the sync read.
+// saved is false when another sync saved the snapshot since, which is left as it is: the Security Group the sync read
+// may have had that sync's changes half way.
+func (s *Store) SaveSnapshot(sgID string, rule target.Rule, cidrs []string, version int64) (saved bool, err error) {
+	list := make([]*dynamodb.AttributeValue, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		list = append(list, &dynamodb.AttributeValue{S: aws.String(cidr)})
+	}
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(s.Table),
+		Item: map[string]*dynamodb.AttributeValue{
+			sgIDKey:      {S: aws.String(sgID)},
+			ruleKey:      {S: aws.String(snapshotKey(rule))},
+			cidrsKey:     {L: list},
+			createdAtKey: {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+			versionKey:   {N: aws.String(strconv.FormatInt(version+1, 10))},
+		},
+		ConditionExpression:      aws.String("attribute_not_exists(#version)"),
+		ExpressionAttributeNames: map[string]*string{"#version": aws.String(versionKey)},
+	}
+	if version != 0 {
+		input.ConditionExpression = aws.String("#version = :version")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":version": {N: aws.String(strconv.FormatInt(version, 10))}}
+	}
+	_, err = s.Svc.PutItem(input)
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		return false, errs.Wrap(errs.Target, "save snapshot", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,127 @@
+package state
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// The attributes of the table. The partition key is the Security Group's ID and the sort key is the rule set and the
+// CIDR, e.g. "tcp/443#1.2.3.4/32", after the deployment's namespace, if any, e.g. "ns:team-a#tcp/443#1.2.3.4/32".
+const (
+	sgIDKey       = "sgID"
+	ruleKey       = "rule"
+	instanceIDKey = "instanceID"
+	createdAtKey  = "createdAt"
+)
+
+// maxTransactItems is the most items a single TransactWriteItems call accepts
+const maxTransactItems = 100
+
+// Store is the DynamoDB table that records which CIDRs the sync owns in every Security Group. It is the authoritative
+// record of the managed rules, along with the rules' descriptions which can be edited by anyone.
+type Store struct {
+	Svc   dynamodbiface.DynamoDBAPI
+	Table string
+}
+
+// Builds the sort key of the CIDR's rule
+func sortKey(rule target.Rule, cidr string) string {
+	return namespaced(rule.String()) + "#" + cidr
+}
+
+// Prefixes the key with the deployment's namespace, so that the deployments sharing a Security Group never read each
+// other's records. The keys of the deployments without one are left as they are.
+func namespaced(key string) string {
+	if target.Namespace == "" {
+		return key
+	}
+	return "ns:" + target.Namespace + "#" + key
+}
+
+// Owned gets the CIDRs of the rule set that the sync owns in the Security Group, with their metadata
+func (s *Store) Owned(sgID string, rule target.Rule) (map[string]target.RuleMeta, error) {
+	owned := make(map[string]target.RuleMeta)
+	prefix := namespaced(rule.String()) + "#"
+	err := s.Svc.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		KeyConditionExpression: aws.String("#sg = :sg AND begins_with(#rule, :prefix)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#sg":   aws.String(sgIDKey),
+			"#rule": aws.String(ruleKey),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sg":     {S: aws.String(sgID)},
+			":prefix": {S: aws.String(prefix)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			cidr := aws.StringValue(item[ruleKey].S)[len(prefix):]
+			meta := target.RuleMeta{Rule: rule.String(), Namespace: target.Namespace}
+			if v, ok := item[instanceIDKey]; ok {
+				meta.InstanceID = aws.StringValue(v.S)
+			}
+			if v, ok := item[createdAtKey]; ok {
+				meta.CreatedAt, _ = time.Parse(time.RFC3339, aws.StringValue(v.S))
+			}
+			owned[cidr] = meta
+		}
+		return true
+	})
+	if err != nil {
+		return owned, errs.Wrap(errs.Target, "query state", err)
+	}
+	return owned, nil
+}
+
+// Record records the CIDRs the sync added to the rule set. owners maps the CIDRs to their instances.
+func (s *Store) Record(sgID string, rule target.Rule, cidrs []string, owners map[string]string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	var items []*dynamodb.TransactWriteItem
+	for _, cidr := range cidrs {
+		item := map[string]*dynamodb.AttributeValue{
+			sgIDKey:      {S: aws.String(sgID)},
+			ruleKey:      {S: aws.String(sortKey(rule, cidr))},
+			createdAtKey: {S: aws.String(now)},
+		}
+		if instanceID := owners[cidr]; instanceID != "" {
+			item[instanceIDKey] = &dynamodb.AttributeValue{S: aws.String(instanceID)}
+		}
+		items = append(items, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{TableName: aws.String(s.Table), Item: item}})
+	}
+	return s.write(items, "record state")
+}
+
+// Forget drops the CIDRs the sync removed from the rule set
+func (s *Store) Forget(sgID string, rule target.Rule, cidrs []string) error {
+	var items []*dynamodb.TransactWriteItem
+	for _, cidr := range cidrs {
+		items = append(items, &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+			TableName: aws.String(s.Table),
+			Key: map[string]*dynamodb.AttributeValue{
+				sgIDKey: {S: aws.String(sgID)},
+				ruleKey: {S: aws.String(sortKey(rule, cidr))},
+			},
+		}})
+	}
+	return s.write(items, "forget state")
+}
+
+// Writes the items in transactions of at most maxTransactItems
+func (s *Store) write(items []*dynamodb.TransactWriteItem, op string) error {
+	for start := 0; start < len(items); start += maxTransactItems {
+		end := start + maxTransactItems
+		if end > len(items) {
+			end = len(items)
+		}
+		if _, err := s.Svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items[start:end]}); err != nil {
+			return errs.Wrap(errs.Target, op, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package syncer
+
+import (
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/policy"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+// Removes the managed rules of the AutoScaling Group's instances from every rule set while the group's access window
+// is closed. With InstancePorts, the rule sets of the instances' extra ports are removed too. The rules that weren't
+// added by the sync, and those of the instances of other groups sharing the Security Group, are left alone.
+func closeAccess(input Input, autoscalingSvc autoscalingiface.AutoScalingAPI, ec2Svc ec2iface.EC2API, logger *zap.Logger) (result Result, err error) {
+	logger.Info("The access window is closed, removing the managed rules")
+	instances, err := source.ASGInstances(input.AutoScalingGroupName, "", autoscalingSvc, ec2Svc)
+	if err != nil {
+		logger.Error("Failed to get the instances of the AutoScaling Group", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		return result, result.tolerate(input.Policy, policy.StageSource, err)
+	}
+	group := make(map[string]struct{}, len(instances))
+	for _, instance := range instances {
+		group[instance.ID] = struct{}{}
+	}
+	if input.InstancePorts {
+		managed, err := target.ManagedRuleSets(input.SecurityGroupID, ec2Svc)
+		if err != nil {
+			err = errs.Wrap(errs.Target, "get managed rule sets", err)
+			logger.Error("Failed to get the managed rule sets", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			if err := result.tolerate(input.Policy, policy.StageRead, err); err != nil {
+				return result, err
+			}
+		}
+		input.Rules = withRuleSets(input.Rules, managed)
+	}
+	removed, err := removeRules(input, ec2Svc, logger, func(meta target.RuleMeta) bool {
+		_, ok := group[meta.InstanceID]
+		return ok
+	})
+	removed.Failures = append(result.Failures, removed.Failures...)
+	removed.AccessClosed = true
+	return removed, err
+}
+
+// Adds the rule sets that aren't among the rules yet
+func withRuleSets(rules []target.Rule, sets []target.Rule) []target.Rule {
+	seen := make(map[target.Rule]struct{}, len(rules))
+	for _, rule := range rules {
+		seen[rule] = struct{}{}
+	}
+	for _, rule := range sets {
+		if _, ok := seen[rule]; !ok {
+			seen[rule] = struct{}{}
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
@@ -0,0 +1,112 @@
+package syncer
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/fakeaws"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+const sgID = "sg-0123456789abcdef0"
+
+// Builds the rule of the CIDR, managed for the instance unless instanceID is empty
+func rule(cidr string, instanceID string) *ec2.IpPermission {
+	perm := target.Permissions(target.DefaultRule, []string{cidr})[0]
+	perm.IpRanges[0].Description = aws.String(target.Description(target.RuleMeta{InstanceID: instanceID, Rule: target.DefaultRule.String()}))
+	return perm
+}
+
+// Gets the CIDRs the Security Group allows, sorted
+func cidrs(env *fakeaws.Env) []string {
+	var got []string
+	for _, perm := range env.EC2.Permissions(sgID) {
+		for _, r := range perm.IpRanges {
+			got = append(got, aws.StringValue(r.CidrIp))
+		}
+	}
+	sort.Strings(got)
+	return got
+}
+
+func TestCloseAccess(t *testing.T) {
+	fleet := fakeaws.Fleet{
+		AutoScalingGroupName: "web-asg",
+		SecurityGroupID:      sgID,
+		Instances: []fakeaws.Instance{
+			{ID: "i-0000000000000000a", PublicIP: "203.0.113.10"},
+			{ID: "i-0000000000000000b", PublicIP: "203.0.113.11"},
+		},
+		Permissions: []*ec2.IpPermission{
+			rule("203.0.113.10/32", "i-0000000000000000a"),
+			rule("203.0.113.11/32", "i-0000000000000000b"),
+			// An instance of another AutoScaling Group sharing the Security Group
+			rule("198.51.100.20/32", "i-0000000000000000z"),
+			// A rule the sync didn't add
+			rule("192.0.2.1/32", ""),
+		},
+	}
+	tests := []struct {
+		name          string
+		dryRun        bool
+		maxRemovals   int
+		wantRemoved   []string
+		wantThrottled []string
+		wantRules     []string
+	}{
+		{
+			name:        "removes the rules of the group's instances only",
+			wantRemoved: []string{"203.0.113.10/32", "203.0.113.11/32"},
+			wantRules:   []string{"192.0.2.1/32", "198.51.100.20/32"},
+		},
+		{
+			name:        "a dry run changes nothing",
+			dryRun:      true,
+			wantRemoved: []string{"203.0.113.10/32", "203.0.113.11/32"},
+			wantRules:   []string{"192.0.2.1/32", "198.51.100.20/32", "203.0.113.10/32", "203.0.113.11/32"},
+		},
+		{
+			name:          "the max removals leave the rest to the next syncs",
+			maxRemovals:   1,
+			wantRemoved:   []string{"203.0.113.10/32"},
+			wantThrottled: []string{"203.0.113.11/32"},
+			wantRules:     []string{"192.0.2.1/32", "198.51.100.20/32", "203.0.113.11/32"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target.Reset()
+			env := fakeaws.New(fleet)
+			input := Input{
+				AutoScalingGroupName: fleet.AutoScalingGroupName,
+				SecurityGroupID:      sgID,
+				Rules:                []target.Rule{target.DefaultRule},
+				AccessClosed:         true,
+				DryRun:               tt.dryRun,
+				MaxRemovals:          tt.maxRemovals,
+			}
+			result, err := closeAccess(input, env.AutoScaling, env.EC2, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			removed := append([]string(nil), result.RemovedIPs...)
+			sort.Strings(removed)
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("removed %v, want %v", removed, tt.wantRemoved)
+			}
+			if !reflect.DeepEqual(result.ThrottledRemovals, tt.wantThrottled) {
+				t.Errorf("throttled %v, want %v", result.ThrottledRemovals, tt.wantThrottled)
+			}
+			if !result.AccessClosed {
+				t.Error("the result isn't AccessClosed")
+			}
+			if got := cidrs(env); !reflect.DeepEqual(got, tt.wantRules) {
+				t.Errorf("the Security Group allows %v, want %v", got, tt.wantRules)
+			}
+		})
+	}
+}
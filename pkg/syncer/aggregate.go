@@ -0,0 +1,34 @@
+package syncer
+
+import (
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/diff"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// Aggregates the desired CIDRs into the smallest covering CIDRs. The CIDRs that were kept as they are keep their
+// instance, the aggregates of several instances are owned by target.AggregateOwner.
+func aggregate(asgIPs cidr.IPSet) cidr.IPSet {
+	aggregated := make(cidr.IPSet, len(asgIPs))
+	for _, c := range diff.Aggregate(asgIPs.CIDRs()) {
+		if instanceID, ok := asgIPs[c]; ok {
+			aggregated.Keep(c, instanceID)
+		} else {
+			aggregated.Keep(c, target.AggregateOwner)
+		}
+	}
+	return aggregated
+}
+
+// Lets the removal of the managed aggregates through the broad CIDRs guard, so that they are split again when the
+// membership changes. Returns the aggregates and the CIDRs that stay blocked.
+func managedAggregates(blocked []string, managed map[string]target.RuleMeta) (aggregates []string, stillBlocked []string) {
+	for _, cidr := range blocked {
+		if managed[cidr].InstanceID == target.AggregateOwner {
+			aggregates = append(aggregates, cidr)
+		} else {
+			stillBlocked = append(stillBlocked, cidr)
+		}
+	}
+	return aggregates, stillBlocked
+}
@@ -0,0 +1,142 @@
+package syncer
+
+import (
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+// ReturnChange is a change of a return rule on the instances' security group
+type ReturnChange struct {
+	Rule target.Rule `json:"rule"`
+	// Source is the CIDR or the security group the rule lets in
+	Source string `json:"source"`
+	// Removed is true when the rule was revoked, false when it was authorized
+	Removed bool `json:"removed,omitempty"`
+}
+
+// Syncs the return rules on the instances' own security group, so that the protected endpoint can reach them back, and
+// removes the managed return rules that are no longer configured. Returns the security group and the changes that
+// were (or, on a dry run, would have been) made.
+func syncReturn(input Input, ec2Svc ec2iface.EC2API, logger *zap.Logger) (string, []ReturnChange, error) {
+	groupID := input.ReturnGroupID
+	if groupID == "" {
+		return "", nil, errs.Errorf(errs.Config, "sync return rules", "the return rules need returnSecurityGroupID")
+	}
+	if groupID == input.SecurityGroupID {
+		logger.Warn("The instances' security group is the synced one, leaving the return rules alone", zap.String("returnGroupID", groupID))
+		return "", nil, nil
+	}
+
+	var changes []ReturnChange
+	for _, rule := range input.ReturnRules {
+		var ruleChanges []ReturnChange
+		var err error
+		if len(input.ReturnSourceCIDRs) == 0 {
+			ruleChanges, err = returnGroupRule(input, groupID, rule, ec2Svc)
+		} else {
+			ruleChanges, err = returnIPRules(input, groupID, rule, ec2Svc)
+		}
+		changes = append(changes, ruleChanges...)
+		if err != nil {
+			return groupID, changes, err
+		}
+	}
+	retired, err := retireReturnRules(input, groupID, ec2Svc)
+	changes = append(changes, retired...)
+	if err != nil {
+		return groupID, changes, err
+	}
+	logger.Info("Return rules synced", zap.String("returnGroupID", groupID), zap.Any("returnChanges", changes))
+	return groupID, changes, nil
+}
+
+// Makes sure that the rule of the instances' security group references the Security Group as its source
+func returnGroupRule(input Input, groupID string, rule target.Rule, ec2Svc ec2iface.EC2API) ([]ReturnChange, error) {
+	ok, err := target.ReferencesGroup(groupID, rule, input.SecurityGroupID, ec2Svc)
+	if err != nil || ok {
+		return nil, err
+	}
+	if !input.DryRun {
+		if err := target.AuthorizeGroup(groupID, rule, input.SecurityGroupID, ec2Svc); err != nil {
+			return nil, err
+		}
+	}
+	return []ReturnChange{{Rule: rule, Source: input.SecurityGroupID}}, nil
+}
+
+// Diffs the managed rules of the instances' security group that let the endpoint's IPs in with the ReturnSourceCIDRs,
+// and applies the diff
+func returnIPRules(input Input, groupID string, rule target.Rule, ec2Svc ec2iface.EC2API) ([]ReturnChange, error) {
+	desired := cidr.NewIPSet()
+	for _, c := range input.ReturnSourceCIDRs {
+		if err := desired.Add(c, target.EndpointOwner); err != nil {
+			return nil, errs.Wrap(errs.Config, "return source cidrs", err)
+		}
+	}
+	sgIPs, err := target.SecurityGroupIPs(groupID, rule, ec2Svc)
+	if err != nil {
+		return nil, err
+	}
+	endpointIPs := cidr.NewIPSet()
+	for c, meta := range managedRules(sgIPs, rule) {
+		if meta.InstanceID == target.EndpointOwner {
+			endpointIPs.Keep(c, target.EndpointOwner)
+		}
+	}
+	// Unmanaged rules that already let an endpoint's IP in are left as they are
+	toAdd, toRemove := desired.Diff(sgIPs), endpointIPs.Diff(desired)
+
+	var changes []ReturnChange
+	if !input.DryRun {
+		if err := target.Authorize(groupID, rule, toAdd, desired, ec2Svc); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range toAdd {
+		changes = append(changes, ReturnChange{Rule: rule, Source: c})
+	}
+	if !input.DryRun {
+		if err := target.Revoke(groupID, rule, toRemove, ec2Svc); err != nil {
+			return changes, err
+		}
+	}
+	for _, c := range toRemove {
+		changes = append(changes, ReturnChange{Rule: rule, Source: c, Removed: true})
+	}
+	return changes, nil
+}
+
+// Removes the managed return rules that are no longer desired: those of the rules removed from the ReturnRules, and the
+// sources of the other kind once the ReturnSourceCIDRs are set or unset
+func retireReturnRules(input Input, groupID string, ec2Svc ec2iface.EC2API) ([]ReturnChange, error) {
+	managed, err := target.ReturnRules(groupID, input.SecurityGroupID, ec2Svc)
+	if err != nil {
+		return nil, err
+	}
+	var changes []ReturnChange
+	for _, stale := range managed {
+		if hasRule(input.ReturnRules, stale.Rule) {
+			// The desired sources of the configured rules stay, the endpoint's CIDRs are diffed by returnIPRules
+			if len(input.ReturnSourceCIDRs) == 0 {
+				stale.Groups = nil
+			} else {
+				stale.CIDRs = nil
+			}
+		}
+		if len(stale.CIDRs)+len(stale.Groups) == 0 {
+			continue
+		}
+		if !input.DryRun {
+			if err := target.RevokeOwned(groupID, stale, ec2Svc); err != nil {
+				return changes, err
+			}
+		}
+		for _, source := range append(append([]string{}, stale.CIDRs...), stale.Groups...) {
+			changes = append(changes, ReturnChange{Rule: stale.Rule, Source: source, Removed: true})
+		}
+	}
+	return changes, nil
+}
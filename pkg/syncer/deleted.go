@@ -0,0 +1,113 @@
+package syncer
+
+import (
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/diff"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/policy"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+// Removes the managed rules of the excluded instances, found through the rules' descriptions or the state store. It
+// is what is left to sync once the AutoScaling Group is gone: there are no desired IPs to diff against, and the rules
+// of the other instances are left alone.
+func removeInstanceRules(input Input, ec2Svc ec2iface.EC2API, logger *zap.Logger) (result Result, err error) {
+	excluded := make(map[string]struct{})
+	for _, id := range excludedIDs(input) {
+		if id != "" {
+			excluded[id] = struct{}{}
+		}
+	}
+	result, err = removeRules(input, ec2Svc, logger, func(meta target.RuleMeta) bool {
+		_, ok := excluded[meta.InstanceID]
+		return ok
+	})
+	result.GroupDeleted = true
+	return result, err
+}
+
+// RemoveManaged removes all the managed rules of the Security Group, e.g. when the sync is torn down. The rules that
+// weren't added by the sync are left alone.
+func RemoveManaged(input Input, ec2Svc ec2iface.EC2API, logger *zap.Logger) (Result, error) {
+	if len(input.Rules) == 0 {
+		input.Rules = []target.Rule{target.DefaultRule}
+	}
+	input, err := withTaggedRules(input, ec2Svc, logger)
+	if err != nil {
+		return Result{}, err
+	}
+	return removeRules(input, ec2Svc, logger, func(target.RuleMeta) bool { return true })
+}
+
+// Removes the managed rules, found through the rules' descriptions or the state store, whose metadata is selected.
+// The removals are capped by MaxRemovals, the rest are left to the next syncs.
+func removeRules(input Input, ec2Svc ec2iface.EC2API, logger *zap.Logger, selected func(meta target.RuleMeta) bool) (result Result, err error) {
+	result.DryRun = input.DryRun
+	input.removals = newRemovalBudget(input.MaxRemovals)
+	for _, rule := range input.Rules {
+		var ruleResult Result
+		ruleLogger := logger.With(zap.Stringer("rule", rule))
+		sgIPs, err := target.SecurityGroupIPs(input.SecurityGroupID, rule, ec2Svc)
+		if err != nil {
+			ruleLogger.Error("Failed to get the IPs of the Security Groups", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			result.merge(rule, ruleResult)
+			return result, result.tolerate(input.Policy, policy.StageRead, err)
+		}
+		managed := managedRules(sgIPs, rule)
+		if input.StateStore != nil {
+			owned, err := input.StateStore.Owned(input.SecurityGroupID, rule)
+			if err != nil {
+				ruleLogger.Error("Failed to read the state of the managed rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+				if err := result.tolerate(input.Policy, policy.StageState, err); err != nil {
+					return result, err
+				}
+			}
+			for c, meta := range owned {
+				if _, ok := sgIPs[c]; ok {
+					managed[c] = meta
+				}
+			}
+		}
+
+		var cidrs []string
+		for _, c := range sgIPs.CIDRs() {
+			if meta, ok := managed[c]; ok && selected(meta) {
+				cidrs = append(cidrs, c)
+			}
+		}
+		cidrs, ruleResult.BlockedRemovals = diff.GuardRemovals(cidrs)
+		aggregates, blocked := managedAggregates(ruleResult.BlockedRemovals, managed)
+		prefixes, blocked := managedPrefixes(blocked, managed, input.RuleCIDRMask)
+		cidrs, ruleResult.BlockedRemovals = append(append(cidrs, aggregates...), prefixes...), blocked
+		ruleResult.ThrottledRemovals = input.removals.take(&cidrs)
+		if len(ruleResult.ThrottledRemovals) != 0 {
+			ruleLogger.Warn("Removals exceed the max removals of the sync, leaving the rest to the next syncs", zap.Int("maxRemovals", input.MaxRemovals), zap.Any("throttledRemovals", ruleResult.ThrottledRemovals))
+		}
+		ruleResult.Skipped = ruleSkips(nil, nil, ruleResult)
+		ruleResult.RemovedIPs = cidrs
+		ruleResult.RemovedByInstance = byInstance(cidrs, managedOwners(managed))
+		ruleLogger.Info("Managed rules to remove", zap.Any("ipsToRemove", cidrs))
+
+		if !input.DryRun {
+			if err := target.Revoke(input.SecurityGroupID, rule, cidrs, ec2Svc); err != nil {
+				ruleLogger.Error("Failed to remove IPs from security group", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+				ruleResult.RemovedIPs, ruleResult.RemovedByInstance = nil, nil
+				result.merge(rule, ruleResult)
+				if err := result.tolerate(input.Policy, policy.StageRemove, err); err != nil {
+					return result, err
+				}
+				continue
+			}
+			if err := forgetState(input, rule, cidrs); err != nil {
+				ruleLogger.Error("Failed to forget the removed rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+				if err := result.tolerate(input.Policy, policy.StageState, err); err != nil {
+					result.merge(rule, ruleResult)
+					return result, err
+				}
+			}
+		}
+		result.merge(rule, ruleResult)
+	}
+	return result, nil
+}
@@ -0,0 +1,27 @@
+package syncer
+
+import (
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+)
+
+// Drops the desired IPs within the denied CIDRs, which are never authorized. Returns the skips of the dropped IPs,
+// with their instances.
+func withoutDenied(asgIPs cidr.IPSet, deniedCIDRs []string) (cidr.IPSet, []Skip) {
+	if len(deniedCIDRs) == 0 {
+		return asgIPs, nil
+	}
+	denied := cidr.NewIPSet()
+	for _, c := range deniedCIDRs {
+		denied.Keep(c, "")
+	}
+	allowed := make(cidr.IPSet, len(asgIPs))
+	var dropped []string
+	for _, c := range asgIPs.CIDRs() {
+		if denied.Contains(c) || denied.Covers(c) {
+			dropped = append(dropped, c)
+			continue
+		}
+		allowed[c] = asgIPs[c]
+	}
+	return allowed, appendAddSkips(nil, dropped, asgIPs, ReasonDenylisted)
+}
@@ -0,0 +1,58 @@
+package syncer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+)
+
+func TestWithoutDenied(t *testing.T) {
+	asgIPs := cidr.IPSet{
+		"203.0.113.10/32": "i-0000000000000000a",
+		"203.0.113.11/32": "i-0000000000000000b",
+		"198.51.100.0/28": "i-0000000000000000c",
+	}
+	tests := []struct {
+		name        string
+		deniedCIDRs []string
+		wantAllowed []string
+		wantSkipped []Skip
+	}{
+		{
+			name:        "no denied CIDRs",
+			wantAllowed: []string{"198.51.100.0/28", "203.0.113.10/32", "203.0.113.11/32"},
+		},
+		{
+			name:        "a denied IP",
+			deniedCIDRs: []string{"203.0.113.10/32"},
+			wantAllowed: []string{"198.51.100.0/28", "203.0.113.11/32"},
+			wantSkipped: []Skip{{CIDR: "203.0.113.10/32", InstanceID: "i-0000000000000000a", Action: SkippedAdd, Reason: ReasonDenylisted}},
+		},
+		{
+			name:        "a denied range covers IPs and prefixes",
+			deniedCIDRs: []string{"198.51.100.0/24", "203.0.113.11/32"},
+			wantAllowed: []string{"203.0.113.10/32"},
+			wantSkipped: []Skip{
+				{CIDR: "198.51.100.0/28", InstanceID: "i-0000000000000000c", Action: SkippedAdd, Reason: ReasonDenylisted},
+				{CIDR: "203.0.113.11/32", InstanceID: "i-0000000000000000b", Action: SkippedAdd, Reason: ReasonDenylisted},
+			},
+		},
+		{
+			name:        "a narrower denied CIDR doesn't deny the prefix containing it",
+			deniedCIDRs: []string{"198.51.100.1/32"},
+			wantAllowed: []string{"198.51.100.0/28", "203.0.113.10/32", "203.0.113.11/32"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, skipped := withoutDenied(asgIPs, tt.deniedCIDRs)
+			if got := allowed.CIDRs(); !reflect.DeepEqual(got, tt.wantAllowed) {
+				t.Errorf("allowed %v, want %v", got, tt.wantAllowed)
+			}
+			if !reflect.DeepEqual(skipped, tt.wantSkipped) {
+				t.Errorf("skipped %+v, want %+v", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package syncer
+
+import (
+	"sort"
+	"time"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/state"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// DriftChange is how the Security Group changed outside of the sync
+type DriftChange string
+
+const (
+	// DriftAdded is a CIDR that another actor added to the rule set
+	DriftAdded DriftChange = "added"
+	// DriftRemoved is a CIDR that another actor removed from the rule set
+	DriftRemoved DriftChange = "removed"
+)
+
+// Drift is a change of the rule set that the sync didn't make, found by comparing the Security Group against the
+// snapshot the last sync recorded
+type Drift struct {
+	CIDR   string      `json:"cidr"`
+	Rule   string      `json:"rule,omitempty"`
+	Change DriftChange `json:"change"`
+	// Since is when the last sync recorded its snapshot. The change happened afterwards.
+	Since time.Time `json:"since"`
+	// Principal is the ARN of whoever made the change, EventName the API call and EventTime when it was made, as
+	// found in CloudTrail. They are empty when the change isn't attributed.
+	Principal string     `json:"principal,omitempty"`
+	EventName string     `json:"event_name,omitempty"`
+	EventTime *time.Time `json:"event_time,omitempty"`
+}
+
+// Gets the changes between the snapshot of the last sync and the CIDRs of the Security Group. The managed CIDRs that
+// appeared since are the sync's own, not drift.
+func detectDrift(sgIPs cidr.IPSet, snapshot state.Snapshot, managed map[string]target.RuleMeta) []Drift {
+	recorded := setOf(snapshot.CIDRs)
+	var drift []Drift
+	for _, c := range recorded.Diff(sgIPs) {
+		drift = append(drift, Drift{CIDR: c, Change: DriftRemoved, Since: snapshot.TakenAt})
+	}
+	for _, c := range sgIPs.Diff(recorded) {
+		if _, ok := managed[c]; ok {
+			// The sync's own rule, e.g. added by a concurrent sync that hasn't saved its snapshot yet
+			continue
+		}
+		drift = append(drift, Drift{CIDR: c, Change: DriftAdded, Since: snapshot.TakenAt})
+	}
+	sort.Slice(drift, func(i, j int) bool { return drift[i].CIDR < drift[j].CIDR })
+	return drift
+}
+
+// Gets the CIDRs of the rule set the deployment watches for drift: all of them, unless it has a namespace, then only
+// its managed ones
+func ownIPs(sgIPs cidr.IPSet, managed map[string]target.RuleMeta) cidr.IPSet {
+	if target.Namespace == "" {
+		return sgIPs
+	}
+	own := cidr.NewIPSet()
+	for c := range managed {
+		if description, ok := sgIPs[c]; ok {
+			own.Keep(c, description)
+		}
+	}
+	return own
+}
+
+// Gets the CIDRs of the rule set once the sync's changes are applied
+func syncedCIDRs(sgIPs cidr.IPSet, result Result) []string {
+	synced := sgIPs.Union(setOf(result.AddedIPs))
+	for _, c := range revocations(result.RemovedIPs, result) {
+		delete(synced, c)
+	}
+	return synced.CIDRs()
+}
+
+// Builds the IPSet of the CIDRs, without values
+func setOf(cidrs []string) cidr.IPSet {
+	set := make(cidr.IPSet, len(cidrs))
+	for _, c := range cidrs {
+		set.Keep(c, "")
+	}
+	return set
+}
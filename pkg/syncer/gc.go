@@ -0,0 +1,133 @@
+package syncer
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/diff"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// Gets the managed rules of the rule set out of their descriptions. sgIPs maps every CIDR of the Security Group to its
+// rule's description. Rules of other rule sets and namespaces are left out.
+func managedRules(sgIPs cidr.IPSet, rule target.Rule) map[string]target.RuleMeta {
+	managed := make(map[string]target.RuleMeta)
+	for cidr, description := range sgIPs {
+		if meta, ok := target.ParseDescription(description); ok && meta.InNamespace() && (meta.Rule == "" || meta.Rule == rule.String()) {
+			managed[cidr] = meta
+		}
+	}
+	return managed
+}
+
+// Gets the managed rules that are neither desired nor already being removed, e.g. because their removal is parked
+func staleManagedRules(managed map[string]target.RuleMeta, asgIPs cidr.IPSet, ipsToRemove []string) map[string]target.RuleMeta {
+	removing := make(map[string]struct{}, len(ipsToRemove))
+	for _, cidr := range ipsToRemove {
+		removing[cidr] = struct{}{}
+	}
+
+	stale := make(map[string]target.RuleMeta)
+	for cidr, meta := range managed {
+		if _, ok := asgIPs[cidr]; ok {
+			continue
+		}
+		if _, ok := removing[cidr]; ok {
+			continue
+		}
+		stale[cidr] = meta
+	}
+	return stale
+}
+
+// Finds the stale managed rules whose instances no longer exist anywhere. mask is the mask of the created rules.
+func findOrphans(stale map[string]target.RuleMeta, mask int, ec2Svc ec2iface.EC2API) ([]string, error) {
+	if len(stale) == 0 {
+		return nil, nil
+	}
+	var instanceIDs []string
+	for _, meta := range stale {
+		if meta.InstanceID != target.AggregateOwner {
+			instanceIDs = append(instanceIDs, meta.InstanceID)
+		}
+	}
+	if len(instanceIDs) == 0 {
+		return nil, nil
+	}
+
+	gone, err := source.GoneInstances(instanceIDs, ec2Svc)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []string
+	for cidr, meta := range stale {
+		if _, ok := gone[meta.InstanceID]; ok {
+			orphans = append(orphans, cidr)
+		}
+	}
+	sort.Strings(orphans)
+	orphans, blocked := diff.GuardRemovals(orphans)
+	prefixes, _ := managedPrefixes(blocked, stale, mask)
+	return append(orphans, prefixes...), nil
+}
+
+// Finds the stale managed rules created more than maxAge ago. Rules without a recorded creation time never expire.
+func findExpired(stale map[string]target.RuleMeta, mask int, maxAge time.Duration, now time.Time) []string {
+	var expired []string
+	for cidr, meta := range stale {
+		if !meta.CreatedAt.IsZero() && now.Sub(meta.CreatedAt) > maxAge {
+			expired = append(expired, cidr)
+		}
+	}
+	sort.Strings(expired)
+	expired, blocked := diff.GuardRemovals(expired)
+	prefixes, _ := managedPrefixes(blocked, stale, mask)
+	return append(expired, prefixes...)
+}
+
+// Drops the CIDRs whose managed rules belong to another rule set, e.g. when two rule sets share a port, or to another
+// namespace. Rules created before the rule set was recorded are kept, and so are unmanaged rules unless the deployment
+// has a namespace: it only ever removes the rules of its own.
+func ownedByRule(cidrs []string, sgIPs cidr.IPSet, rule target.Rule) (owned []string, foreign []string) {
+	for _, cidr := range cidrs {
+		meta, ok := target.ParseDescription(sgIPs[cidr])
+		if ok && (!meta.InNamespace() || meta.Rule != "" && meta.Rule != rule.String()) || !ok && target.Namespace != "" {
+			foreign = append(foreign, cidr)
+			continue
+		}
+		owned = append(owned, cidr)
+	}
+	return owned, foreign
+}
+
+// Splits the CIDRs into the ones of managed rules and the others
+func onlyManaged(cidrs []string, managed map[string]target.RuleMeta) (kept []string, unmanaged []string) {
+	for _, c := range cidrs {
+		if _, ok := managed[c]; ok {
+			kept = append(kept, c)
+		} else {
+			unmanaged = append(unmanaged, c)
+		}
+	}
+	return kept, unmanaged
+}
+
+// Records the added CIDRs in the state store, if any
+func recordState(input Input, rule target.Rule, cidrs []string, owners cidr.IPSet) error {
+	if input.StateStore == nil || len(cidrs) == 0 {
+		return nil
+	}
+	return input.StateStore.Record(input.SecurityGroupID, rule, cidrs, owners)
+}
+
+// Drops the removed CIDRs from the state store, if any
+func forgetState(input Input, rule target.Rule, cidrs []string) error {
+	if input.StateStore == nil || len(cidrs) == 0 {
+		return nil
+	}
+	return input.StateStore.Forget(input.SecurityGroupID, rule, cidrs)
+}
@@ -0,0 +1,101 @@
+package syncer
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+// Gets the rule sets to sync and the IPs of each one. The configured rules get the IPs of all the instances. With
+// InstancePorts, the ports that instances declare with their target.ExtraPortsTag get the IPs of those instances only,
+// and the rule sets that were added for declared ports but aren't declared anymore get no IPs, so that their rules are
+// removed. On failure, only the configured rules are returned.
+func instanceRules(input Input, instances []source.Instance, asgIPs cidr.IPSet, ec2Svc ec2iface.EC2API, logger *zap.Logger) ([]target.Rule, map[target.Rule]cidr.IPSet, error) {
+	rules := append([]target.Rule{}, input.Rules...)
+	ips := make(map[target.Rule]cidr.IPSet, len(rules))
+	for _, rule := range rules {
+		ips[rule] = asgIPs
+	}
+	if !input.InstancePorts {
+		return rules, ips, nil
+	}
+
+	extra := make(map[target.Rule]cidr.IPSet)
+	for _, instance := range instances {
+		spec, ok := instance.Tags[target.ExtraPortsTag]
+		if !ok || !instance.Running() {
+			continue
+		}
+		instanceRules, err := target.ParsePorts(spec)
+		if err != nil {
+			// A mistagged instance keeps the configured rules, it doesn't fail the sync of the others
+			logger.Warn("Ignoring the malformed extra ports of the instance", zap.String("instanceID", instance.ID), zap.String("tag", spec), zap.Error(err))
+			continue
+		}
+		hostIPs, err := source.PublicIPs([]source.Instance{instance})
+		if err != nil {
+			return rules, ips, err
+		}
+		// The denied IPs were already reported with the configured rules
+		hostIPs, _ = withoutDenied(hostIPs, input.DeniedCIDRs)
+		hostIPs = masked(hostIPs, input.RuleCIDRMask)
+		for _, rule := range instanceRules {
+			if _, configured := ips[rule]; configured {
+				continue
+			}
+			extra[rule] = extra[rule].Union(hostIPs)
+		}
+	}
+
+	declared, err := target.DeclaredRuleSets(input.SecurityGroupID, ec2Svc)
+	if err != nil {
+		return rules, ips, errs.Wrap(errs.Target, "get declared rule sets", err)
+	}
+	for _, rule := range declared {
+		if _, ok := extra[rule]; !ok {
+			if _, configured := ips[rule]; !configured {
+				extra[rule] = cidr.NewIPSet()
+			}
+		}
+	}
+	for _, rule := range sortedRules(extra) {
+		rules = append(rules, rule)
+		ips[rule] = extra[rule]
+		if input.AggregateCIDRs {
+			ips[rule] = aggregate(extra[rule])
+		}
+	}
+	if len(extra) != 0 {
+		extraIPs := make(map[string][]string, len(extra))
+		for rule, set := range extra {
+			extraIPs[rule.String()] = set.CIDRs()
+		}
+		logger.Info("Instances' extra ports", zap.Any("extraRules", extraIPs))
+	}
+	return rules, ips, nil
+}
+
+// Checks whether the rule set is one of the rules, e.g. a configured one rather than one declared by instances
+func hasRule(rules []target.Rule, rule target.Rule) bool {
+	for _, configured := range rules {
+		if configured == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// Sorts the rule sets by their names, so that they are synced in a stable order
+func sortedRules(sets map[target.Rule]cidr.IPSet) []target.Rule {
+	rules := make([]target.Rule, 0, len(sets))
+	for rule := range sets {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].String() < rules[j].String() })
+	return rules
+}
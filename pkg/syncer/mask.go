@@ -0,0 +1,22 @@
+package syncer
+
+import "github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+
+// Widens the instances' IPv4 CIDRs to the mask of the created rules, e.g. 203.0.113.12/32 to 203.0.113.0/24. The
+// instances sharing a widened CIDR are recorded by the lowest of their IDs, so that every sync owns it the same way.
+// A mask of 0 or 32 leaves the CIDRs as they are.
+func masked(asgIPs cidr.IPSet, bits int) cidr.IPSet {
+	if bits == 0 || bits == 32 {
+		return asgIPs
+	}
+	widened := make(cidr.IPSet, len(asgIPs))
+	for c, instanceID := range asgIPs {
+		if m, err := cidr.Mask(c, bits); err == nil {
+			c = m
+		}
+		if owner, ok := widened[c]; !ok || instanceID < owner {
+			widened.Keep(c, instanceID)
+		}
+	}
+	return widened
+}
@@ -0,0 +1,37 @@
+package syncer
+
+import (
+	"net/netip"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// Lets the removal of the instances' managed delegated prefixes, and of their CIDRs widened to the mask of the created
+// rules or to a previous, wider, one, through the broad CIDRs guard, so that they follow their instances as their
+// addresses do. The prefixes are let through whether or not they are still synced. Returns the CIDRs let through and
+// the ones that stay blocked.
+func managedPrefixes(blocked []string, managed map[string]target.RuleMeta, mask int) (prefixes []string, stillBlocked []string) {
+	for _, c := range blocked {
+		meta, ok := managed[c]
+		if ok && meta.InstanceID != "" && meta.InstanceID != target.AggregateOwner && (cidr.IsDelegatedPrefix(c) || widened(c, mask)) {
+			prefixes = append(prefixes, c)
+		} else {
+			stillBlocked = append(stillBlocked, c)
+		}
+	}
+	return prefixes, stillBlocked
+}
+
+// minRuleMask is the widest mask the instances' CIDRs can be widened to, see Input.RuleCIDRMask
+const minRuleMask = 16
+
+// Checks whether the CIDR is an IPv4 CIDR of the mask, or of a wider mask the rules may have been created with before
+// the mask changed, e.g. a /24 once the mask is back to 32
+func widened(c string, mask int) bool {
+	if mask == 0 {
+		mask = 32
+	}
+	prefix, err := netip.ParsePrefix(c)
+	return err == nil && prefix.Addr().Is4() && prefix.Bits() >= minRuleMask && prefix.Bits() <= mask
+}
@@ -0,0 +1,23 @@
+package syncer
+
+import (
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// Builds the room the rules quota leaves the sync, nil when the additions aren't capped. The rules the sync removes
+// only make room for the next syncs.
+func newQuotaRoom(input Input, ec2Svc ec2iface.EC2API) (*ruleBudget, error) {
+	if input.RulesQuota <= 0 {
+		return nil, nil
+	}
+	total, _, err := target.RuleCounts(input.SecurityGroupID, ec2Svc)
+	if err != nil {
+		return nil, err
+	}
+	room := input.RulesQuota - total
+	if room < 0 {
+		room = 0
+	}
+	return &ruleBudget{left: room}, nil
+}
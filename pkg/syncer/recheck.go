@@ -0,0 +1,37 @@
+package syncer
+
+import (
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+)
+
+// ReasonAlreadyPresent and ReasonAlreadyAbsent are the changes that another actor made between the read of the rule
+// and its update: the IPs already added and the ones already removed
+const (
+	ReasonAlreadyPresent SkipReason = "already_present"
+	ReasonAlreadyAbsent  SkipReason = "already_absent"
+)
+
+// Drops from the lists the CIDRs whose change the instant state of the rule already reflects: the additions that are
+// present, or the removals that are absent. Returns the dropped CIDRs.
+func dropRedundant(current cidr.IPSet, add bool, lists ...*[]string) (redundant []string) {
+	for _, list := range lists {
+		var needed []string
+		for _, c := range *list {
+			if _, present := current[c]; present != add {
+				needed = append(needed, c)
+			} else {
+				redundant = append(redundant, c)
+			}
+		}
+		*list = needed
+	}
+	return redundant
+}
+
+// Lists the skips of the redundant additions and removals
+func redundantSkips(adds []string, removals []string) (skips []Skip) {
+	for _, cidr := range adds {
+		skips = append(skips, Skip{CIDR: cidr, Action: SkippedAdd, Reason: ReasonAlreadyPresent})
+	}
+	return appendSkips(skips, removals, ReasonAlreadyAbsent)
+}
@@ -0,0 +1,94 @@
+package syncer
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+// Makes sure that every rule of the Security Group has the source group as its source, and removes the managed
+// references of any other group. Returns the rules that were (or, on a dry run, would have been) added and those
+// whose stale references were removed.
+func referenceGroup(input Input, ec2Svc ec2iface.EC2API, logger *zap.Logger) (added []target.Rule, unreferenced []target.Rule, err error) {
+	groupID := input.SourceGroupID
+	for _, rule := range input.Rules {
+		ok, err := target.ReferencesGroup(input.SecurityGroupID, rule, groupID, ec2Svc)
+		if err != nil {
+			return added, unreferenced, err
+		}
+		if ok {
+			continue
+		}
+		if !input.DryRun {
+			if err := target.AuthorizeGroup(input.SecurityGroupID, rule, groupID, ec2Svc); err != nil {
+				return added, unreferenced, err
+			}
+		}
+		logger.Info("Source group referenced", zap.Stringer("rule", rule), zap.String("sourceGroupID", groupID))
+		added = append(added, rule)
+	}
+	unreferenced, err = unreference(input, groupID, ec2Svc, logger)
+	return added, unreferenced, err
+}
+
+// Removes the managed source group references of the rules, but the one of keepGroupID, e.g. when the IP rules are used
+// again. Returns the rules that had any.
+func unreference(input Input, keepGroupID string, ec2Svc ec2iface.EC2API, logger *zap.Logger) ([]target.Rule, error) {
+	var unreferenced []target.Rule
+	for _, rule := range input.Rules {
+		groupIDs, err := target.ManagedReferences(input.SecurityGroupID, rule, ec2Svc)
+		if err != nil {
+			return unreferenced, err
+		}
+		stale := make([]string, 0, len(groupIDs))
+		for _, groupID := range groupIDs {
+			if groupID != keepGroupID {
+				stale = append(stale, groupID)
+			}
+		}
+		if len(stale) == 0 {
+			continue
+		}
+		if !input.DryRun {
+			if err := target.RevokeOwned(input.SecurityGroupID, target.OwnedRule{Rule: rule, Groups: stale}, ec2Svc); err != nil {
+				return unreferenced, err
+			}
+		}
+		logger.Info("Source group references removed", zap.Stringer("rule", rule), zap.Strings("sourceGroupIDs", stale))
+		unreferenced = append(unreferenced, rule)
+	}
+	return unreferenced, nil
+}
+
+// Removes the managed IP rules of the rules, which the source group reference replaces. Returns the CIDRs that were
+// (or, on a dry run, would have been) removed.
+func retireIPRules(input Input, ec2Svc ec2iface.EC2API, logger *zap.Logger) ([]string, error) {
+	var removed []string
+	for _, rule := range input.Rules {
+		sgIPs, err := target.SecurityGroupIPs(input.SecurityGroupID, rule, ec2Svc)
+		if err != nil {
+			return removed, err
+		}
+		cidrs := make([]string, 0)
+		for c := range managedRules(sgIPs, rule) {
+			cidrs = append(cidrs, c)
+		}
+		if len(cidrs) == 0 {
+			continue
+		}
+		sort.Strings(cidrs)
+		if !input.DryRun {
+			if err := target.Revoke(input.SecurityGroupID, rule, cidrs, ec2Svc); err != nil {
+				return removed, err
+			}
+			if err := forgetState(input, rule, cidrs); err != nil {
+				return removed, err
+			}
+		}
+		logger.Info("IP rules replaced by the source group reference", zap.Stringer("rule", rule), zap.Strings("cidrs", cidrs))
+		removed = append(removed, cidrs...)
+	}
+	return removed, nil
+}
@@ -0,0 +1,49 @@
+package syncer
+
+import (
+	"sort"
+
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// Replacement is an instance whose public IP changed, e.g. after a stop/start, and whose managed rule is replaced
+type Replacement struct {
+	InstanceID string `json:"instance_id"`
+	OldCIDR    string `json:"old_cidr"`
+	NewCIDR    string `json:"new_cidr"`
+	Rule       string `json:"rule,omitempty"`
+}
+
+// Pairs the CIDRs to add with the managed CIDRs to remove that belong to the same instance. asgIPs maps the CIDRs to
+// add to their instances, managed the managed rules to their metadata.
+func findReplacements(ipsToAdd []string, ipsToRemove []string, asgIPs cidr.IPSet, managed map[string]target.RuleMeta) []Replacement {
+	newCIDRs := make(map[string]string, len(ipsToAdd))
+	for _, cidr := range ipsToAdd {
+		if instanceID := asgIPs[cidr]; instanceID != "" && instanceID != target.AggregateOwner {
+			newCIDRs[instanceID] = cidr
+		}
+	}
+	var replaced []Replacement
+	for _, cidr := range ipsToRemove {
+		meta, ok := managed[cidr]
+		if !ok || meta.InstanceID == "" {
+			continue
+		}
+		if newCIDR, ok := newCIDRs[meta.InstanceID]; ok {
+			replaced = append(replaced, Replacement{InstanceID: meta.InstanceID, OldCIDR: cidr, NewCIDR: newCIDR})
+			delete(newCIDRs, meta.InstanceID)
+		}
+	}
+	sort.Slice(replaced, func(i, j int) bool { return replaced[i].InstanceID < replaced[j].InstanceID })
+	return replaced
+}
+
+// Gets the new and old CIDRs of the replacements
+func replacementCIDRs(replaced []Replacement) (newCIDRs []string, oldCIDRs []string) {
+	for _, r := range replaced {
+		newCIDRs = append(newCIDRs, r.NewCIDR)
+		oldCIDRs = append(oldCIDRs, r.OldCIDR)
+	}
+	return newCIDRs, oldCIDRs
+}
@@ -0,0 +1,98 @@
+package syncer
+
+import (
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+)
+
+// RuleResult holds the IPs that were added and removed for a single rule
+type RuleResult struct {
+	target.Rule
+	AddedIPs   []string `json:"added_ips"`
+	RemovedIPs []string `json:"removed_ips"`
+	// Revoked are all the CIDRs whose rules were revoked: the removed IPs, the collected orphans and the expired rules
+	Revoked []string `json:"-"`
+}
+
+// Merges the result of a rule into the result of the sync. A CIDR is listed once, whatever the number of its rules.
+func (r *Result) merge(rule target.Rule, ruleResult Result) {
+	r.AddedIPs = appendUnique(r.AddedIPs, ruleResult.AddedIPs)
+	if ruleResult.Owners != nil {
+		r.Owners = ruleResult.Owners
+	}
+	r.RemovedIPs = appendUnique(r.RemovedIPs, ruleResult.RemovedIPs)
+	r.PendingRemovals = appendUnique(r.PendingRemovals, ruleResult.PendingRemovals)
+	r.BlockedRemovals = appendUnique(r.BlockedRemovals, ruleResult.BlockedRemovals)
+	r.ThrottledRemovals = appendUnique(r.ThrottledRemovals, ruleResult.ThrottledRemovals)
+	r.CollectedOrphans = appendUnique(r.CollectedOrphans, ruleResult.CollectedOrphans)
+	r.ExpiredRules = appendUnique(r.ExpiredRules, ruleResult.ExpiredRules)
+	for _, failure := range ruleResult.Failures {
+		failure.Rule = rule.String()
+		r.Failures = append(r.Failures, failure)
+	}
+	r.CIDRFailures = append(r.CIDRFailures, ruleResult.CIDRFailures...)
+	for _, skip := range ruleResult.Skipped {
+		skip.Rule = rule.String()
+		r.Skipped = append(r.Skipped, skip)
+	}
+	for _, replacement := range ruleResult.Replaced {
+		replacement.Rule = rule.String()
+		r.Replaced = append(r.Replaced, replacement)
+	}
+	for _, drift := range ruleResult.Drift {
+		drift.Rule = rule.String()
+		r.Drift = append(r.Drift, drift)
+	}
+	r.AddedByInstance = mergeMaps(r.AddedByInstance, ruleResult.AddedByInstance)
+	r.RemovedByInstance = mergeMaps(r.RemovedByInstance, ruleResult.RemovedByInstance)
+	r.Rules = append(r.Rules, RuleResult{Rule: rule, AddedIPs: ruleResult.AddedIPs, RemovedIPs: ruleResult.RemovedIPs, Revoked: revocations(ruleResult.RemovedIPs, ruleResult)})
+}
+
+// Appends the CIDRs that are not in cidrs yet
+func appendUnique(cidrs []string, more []string) []string {
+	seen := make(map[string]struct{}, len(cidrs))
+	for _, cidr := range cidrs {
+		seen[cidr] = struct{}{}
+	}
+	for _, cidr := range more {
+		if _, ok := seen[cidr]; !ok {
+			seen[cidr] = struct{}{}
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return cidrs
+}
+
+// Merges the CIDRs of the instances of more into m, allocating it when needed
+func mergeMaps(m map[string][]string, more map[string][]string) map[string][]string {
+	for k, v := range more {
+		if m == nil {
+			m = make(map[string][]string, len(more))
+		}
+		m[k] = appendUnique(m[k], v)
+	}
+	return m
+}
+
+// Maps the IDs of the instances to their CIDRs. owners maps the CIDRs to their instances, CIDRs without one and
+// aggregates are left out.
+func byInstance(cidrs []string, owners map[string]string) map[string][]string {
+	var m map[string][]string
+	for _, cidr := range cidrs {
+		if instanceID := owners[cidr]; instanceID != "" && instanceID != target.AggregateOwner {
+			if m == nil {
+				m = make(map[string][]string)
+			}
+			m[instanceID] = append(m[instanceID], cidr)
+		}
+	}
+	return m
+}
+
+// Maps the CIDRs of the managed rules to the IDs of their instances
+func managedOwners(managed map[string]target.RuleMeta) map[string]string {
+	owners := make(map[string]string, len(managed))
+	for cidr, meta := range managed {
+		owners[cidr] = meta.InstanceID
+	}
+	return owners
+}
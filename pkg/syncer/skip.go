@@ -0,0 +1,84 @@
+package syncer
+
+import (
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+)
+
+// SkipReason is the machine-readable reason an IP was intentionally not added or removed
+type SkipReason string
+
+const (
+	// ReasonNoPublicIP is an instance of the AutoScaling Group that has no public IP yet
+	ReasonNoPublicIP SkipReason = "no_public_ip"
+	// ReasonBroadCIDR is a rule wider than a single host, kept by the removal guard
+	ReasonBroadCIDR SkipReason = "broad_cidr"
+	// ReasonPendingApproval is a removal parked until it gets approved
+	ReasonPendingApproval SkipReason = "pending_approval"
+	// ReasonNotApproved is a removal left out of the approved removals
+	ReasonNotApproved SkipReason = "not_approved"
+	// ReasonRemovalCooldown is the IP of a terminating instance kept during the removal cooldown
+	ReasonRemovalCooldown SkipReason = "removal_cooldown"
+	// ReasonScaleInProtected is the IP of a terminating instance that is protected from scale in
+	ReasonScaleInProtected SkipReason = "scale_in_protected"
+	// ReasonRemovalDeferred is the IP of a terminating instance whose removal was left to a delayed sync
+	ReasonRemovalDeferred SkipReason = "removal_deferred"
+	// ReasonUnmanaged is a rule the sync didn't create, kept by the strict removal
+	ReasonUnmanaged SkipReason = "unmanaged"
+	// ReasonRemovalThrottled is a removal beyond the max removals of the sync, left to the next syncs
+	ReasonRemovalThrottled SkipReason = "removal_throttled"
+	// ReasonOverQuota is an addition beyond the room the Security Group's rules quota leaves, left to the next syncs
+	ReasonOverQuota SkipReason = "over_quota"
+	// ReasonDenylisted is the IP of an instance within the denied CIDRs, which are never authorized
+	ReasonDenylisted SkipReason = "denylisted"
+)
+
+// SkippedAdd and SkippedRemove are the actions that were skipped
+const (
+	SkippedAdd    = "add"
+	SkippedRemove = "remove"
+)
+
+// Skip is an IP that was intentionally not added or removed. Instances without a public IP have no CIDR.
+type Skip struct {
+	CIDR       string     `json:"cidr,omitempty"`
+	Rule       string     `json:"rule,omitempty"`
+	InstanceID string     `json:"instance_id,omitempty"`
+	Action     string     `json:"action"`
+	Reason     SkipReason `json:"reason"`
+}
+
+// Lists the skips that concern the instances, whatever the rule
+func instanceSkips(instances []source.Instance, suppressed []string, suppressedReason SkipReason) (skips []Skip) {
+	for _, instance := range instances {
+		if instance.Running() && instance.PublicIP == "" && len(instance.SyncedPrefixes()) == 0 {
+			skips = append(skips, Skip{InstanceID: instance.ID, Action: SkippedAdd, Reason: ReasonNoPublicIP})
+		}
+	}
+	return appendSkips(skips, suppressed, suppressedReason)
+}
+
+// Lists the skipped removals of a rule
+func ruleSkips(notApproved []string, unmanaged []string, result Result) (skips []Skip) {
+	skips = appendSkips(skips, result.BlockedRemovals, ReasonBroadCIDR)
+	skips = appendSkips(skips, result.PendingRemovals, ReasonPendingApproval)
+	skips = appendSkips(skips, unmanaged, ReasonUnmanaged)
+	skips = appendSkips(skips, result.ThrottledRemovals, ReasonRemovalThrottled)
+	return appendSkips(skips, notApproved, ReasonNotApproved)
+}
+
+// Appends the skipped additions of the CIDRs, with the instances they belong to
+func appendAddSkips(skips []Skip, cidrs []string, owners cidr.IPSet, reason SkipReason) []Skip {
+	for _, c := range cidrs {
+		skips = append(skips, Skip{CIDR: c, InstanceID: owners[c], Action: SkippedAdd, Reason: reason})
+	}
+	return skips
+}
+
+// Appends the skipped removals of the CIDRs
+func appendSkips(skips []Skip, cidrs []string, reason SkipReason) []Skip {
+	for _, cidr := range cidrs {
+		skips = append(skips, Skip{CIDR: cidr, Action: SkippedRemove, Reason: reason})
+	}
+	return skips
+}
@@ -0,0 +1,706 @@
+package syncer
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/approval"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/diff"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/policy"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/source"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/state"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/target"
+	"go.uber.org/zap"
+)
+
+// Input describes which AutoScaling Group has to be synced to which Security Group
+type Input struct {
+	AutoScalingGroupName string
+	SecurityGroupID      string
+	// Rules are the protocols and ports of the managed rules. Defaults to target.DefaultRule
+	Rules []target.Rule
+	// ExcludeInstanceID is an instance whose IP must not be part of the desired set (e.g. the one being terminated)
+	ExcludeInstanceID string
+	// ExcludeInstanceIDs are more instances excluded the same way, e.g. the ones of coalesced termination events
+	ExcludeInstanceIDs []string
+	// ExcludedSince is when the excluded instance started terminating
+	ExcludedSince time.Time
+	// RemovalCooldown keeps the excluded instance's IP until its termination is older than this, so that long-draining
+	// connections are not cut off. Later syncs remove it.
+	RemovalCooldown time.Duration
+	// RespectScaleInProtection keeps the excluded instance's IP while the instance is protected from scale in
+	RespectScaleInProtection bool
+	// Policy decides which stage failures stop the sync. By default they all do.
+	Policy policy.Policy
+	// DeferRemoval keeps the excluded instance's IP, its removal is left to a later, delayed, sync
+	DeferRemoval bool
+	// RemovalApprovalThreshold parks the removals instead of applying them when there are more than this many. 0 disables it.
+	RemovalApprovalThreshold int
+	// MaxRemovals caps the CIDRs a sync revokes across all its rules, the others are left to the next syncs. 0
+	// disables it.
+	MaxRemovals int
+	// removals is the removal budget of MaxRemovals, shared by the rules of the sync
+	removals *ruleBudget
+	// RulesQuota caps the CIDRs a sync authorizes to the room the Security Group's quota of inbound rules leaves, the
+	// others are left to the next syncs. 0 disables it.
+	RulesQuota int
+	// quotaRoom is the room RulesQuota leaves, shared by the rules of the sync
+	quotaRoom *ruleBudget
+	// DeniedCIDRs are never authorized: the instances whose IPs they contain are skipped
+	DeniedCIDRs []string
+	// ApprovedRemovals, when not nil, restricts the removals to these IPs and bypasses the approval threshold
+	ApprovedRemovals []string
+	// ExpectedVpcID, when set, refuses to sync unless the Security Group belongs to this VPC
+	ExpectedVpcID string
+	// RequireSameVPC refuses to sync unless the Security Group belongs to the VPC of the AutoScaling Group's instances
+	RequireSameVPC bool
+	// AllowBroadRemovals disables the guard that only lets /32 and /128 rules be removed
+	AllowBroadRemovals bool
+	// RuleCIDRMask widens the instances' IPv4 CIDRs to this mask, e.g. 24 authorizes the /24 containing each instance's
+	// IP. 0 or 32 keeps the /32s.
+	RuleCIDRMask int
+	// AggregateCIDRs merges the contiguous IPs into the smallest covering CIDRs, so that large fleets need fewer rules.
+	// The aggregates are split again when the membership changes.
+	AggregateCIDRs bool
+	// StrictRemoval only removes the managed rules, the ones the sync created. The others are skipped.
+	StrictRemoval bool
+	// CollectOrphans also removes managed rules whose instances no longer exist, even if their removal is parked
+	CollectOrphans bool
+	// MaxRuleAge, when set, also removes the managed rules that are not desired and were created longer ago than this,
+	// even if their removal is parked
+	MaxRuleAge time.Duration
+	// ReferenceSourceGroup authorizes the instances' security group as the source of the rules, instead of their IPs.
+	// The managed IP rules are removed, and the managed references are removed when it is off.
+	ReferenceSourceGroup bool
+	// SourceGroupID is the security group referenced by ReferenceSourceGroup, e.g. across a peering. Required by it.
+	SourceGroupID string
+	// ReturnRules are the rules also managed on the instances' own security group, so that the protected endpoint can
+	// reach them back. Empty disables them.
+	ReturnRules []target.Rule
+	// ReturnGroupID is the instances' security group of the ReturnRules. Required by them, its managed return rules are
+	// removed once they are all unset.
+	ReturnGroupID string
+	// ReturnSourceCIDRs are the protected endpoint's IPs, the sources of the ReturnRules. When empty, the Security
+	// Group itself is referenced as their source.
+	ReturnSourceCIDRs []string
+	// StateStore, when set, records which CIDRs the sync owns, so that the ownership doesn't depend on the rules'
+	// descriptions alone
+	StateStore *state.Store
+	// Order is whether the new rules are authorized before or after the stale ones are revoked. Defaults to
+	// policy.AddFirst. The rules of instances whose IP changed are always replaced add first.
+	Order policy.Order
+	// Recreate, when set, recreates the Security Group after this spec when it was deleted, instead of failing
+	Recreate *target.GroupSpec
+	// InstancePorts adds the rules of the extra ports that instances declare with their target.ExtraPortsTag, for
+	// their IPs only
+	InstancePorts bool
+	// RulesFromTags reads the rules from the Security Group's target.PortsTag, when it has one, instead of Rules
+	RulesFromTags bool
+	// AccessClosed is true outside of the AutoScaling Group's access windows: its managed rules are removed instead of
+	// synced, and no rule is added until a window opens
+	AccessClosed bool
+	// DryRun calculates the IPs to add and remove without changing the Security Group
+	DryRun bool
+}
+
+// Result holds the IPs that were added and removed. On a dry run, the IPs that would have been added and removed.
+type Result struct {
+	AddedIPs   []string `json:"added_ips"`
+	RemovedIPs []string `json:"removed_ips"`
+	DryRun     bool     `json:"dry_run,omitempty"`
+	// PendingRemovals are the IPs whose removal awaits approval
+	PendingRemovals []string `json:"pending_removals,omitempty"`
+	// BlockedRemovals are the broad CIDRs that were not removed by the safety guard
+	BlockedRemovals []string `json:"blocked_removals,omitempty"`
+	// ThrottledRemovals are the CIDRs beyond the max removals of the sync, left to the next syncs
+	ThrottledRemovals []string `json:"throttled_removals,omitempty"`
+	// CollectedOrphans are the managed rules removed because their instances no longer exist
+	CollectedOrphans []string `json:"collected_orphans,omitempty"`
+	// ExpiredRules are the managed rules removed because they are older than the max rule age
+	ExpiredRules []string `json:"expired_rules,omitempty"`
+	// SuppressedRemovals are the IPs of the terminating instance that were kept because of the cooldown, the
+	// scale-in protection or a deferred removal
+	SuppressedRemovals []string `json:"suppressed_removals,omitempty"`
+	// Failures are the stage failures that the policy tolerated
+	Failures []Failure `json:"failures,omitempty"`
+	// CIDRFailures are the CIDRs whose authorization or revocation failed, with the AWS error code of each
+	CIDRFailures []target.CIDRFailure `json:"cidr_failures,omitempty"`
+	// Skipped are the IPs that were intentionally not added or removed, with the reason
+	Skipped []Skip `json:"skipped,omitempty"`
+	// Rules are the IPs added and removed by every rule
+	Rules []RuleResult `json:"rules,omitempty"`
+	// SourceGroupID is the security group referenced as the source of the rules, instead of the IPs
+	SourceGroupID string `json:"source_group_id,omitempty"`
+	// ReferencedRules are the rules whose source group reference was added
+	ReferencedRules []target.Rule `json:"referenced_rules,omitempty"`
+	// UnreferencedRules are the rules whose managed source group references were removed, when the source group
+	// changed or the IP rules are used again
+	UnreferencedRules []target.Rule `json:"unreferenced_rules,omitempty"`
+	// ReturnGroupID is the instances' security group the return rules were synced on
+	ReturnGroupID string `json:"return_group_id,omitempty"`
+	// ReturnChanges are the changes of the return rules
+	ReturnChanges []ReturnChange `json:"return_changes,omitempty"`
+	// Owners maps the added CIDRs to the IDs of their instances
+	Owners map[string]string `json:"-"`
+	// AddedByInstance maps the IDs of the instances to their added CIDRs, e.g. their IP and their delegated prefixes
+	AddedByInstance map[string][]string `json:"added_by_instance,omitempty"`
+	// RemovedByInstance maps the IDs of the instances to their removed CIDRs. Only the managed rules record their
+	// instance.
+	RemovedByInstance map[string][]string `json:"removed_by_instance,omitempty"`
+	// Replaced are the instances whose public IP changed and whose rule was replaced
+	Replaced []Replacement `json:"replaced,omitempty"`
+	// RecreatedSecurityGroupID is the Security Group recreated in place of the deleted one, whose rules were synced
+	RecreatedSecurityGroupID string `json:"recreated_security_group_id,omitempty"`
+	// AccessClosed is true when the access window of the AutoScaling Group was closed and its managed rules were
+	// removed
+	AccessClosed bool `json:"access_closed,omitempty"`
+	// GroupDeleted is true when the AutoScaling Group no longer existed and only the terminating instances' rules were
+	// removed
+	GroupDeleted bool `json:"group_deleted,omitempty"`
+	// Drift are the changes other actors made to the rule sets since the last sync. Only detected with a state store.
+	Drift []Drift `json:"drift,omitempty"`
+}
+
+// Failure is a stage failure that the policy tolerated
+type Failure struct {
+	Stage    policy.Stage  `json:"stage"`
+	Rule     string        `json:"rule,omitempty"`
+	Category errs.Category `json:"category"`
+	Error    string        `json:"error"`
+}
+
+// Records the failed CIDRs of the Authorize or Revoke error, and gets the CIDRs that were applied all the same
+func (r *Result) cidrFailures(err error) (applied []string) {
+	failures, applied, _ := target.FailuresOf(err)
+	r.CIDRFailures = append(r.CIDRFailures, failures...)
+	return applied
+}
+
+// Consults the policy about the failed stage. A fatal failure is returned, a tolerated one is recorded.
+func (r *Result) tolerate(p policy.Policy, stage policy.Stage, err error) error {
+	if p.ActionFor(stage) != policy.Continue {
+		return err
+	}
+	r.Failures = append(r.Failures, Failure{Stage: stage, Category: errs.CategoryOf(err), Error: err.Error()})
+	return nil
+}
+
+// Sync updates (adds/removes) the Security Group's rules based on the public IPs of the AutoScaling Group's instances.
+// Every rule of the input is diffed and applied on its own.
+func Sync(input Input, autoscalingSvc autoscalingiface.AutoScalingAPI, ec2Svc ec2iface.EC2API, logger *zap.Logger) (result Result, err error) {
+	if len(input.Rules) == 0 {
+		input.Rules = []target.Rule{target.DefaultRule}
+	}
+	if input.AutoScalingGroupName == "" || input.SecurityGroupID == "" {
+		err = errs.Errorf(errs.Config, "validate input", "the AutoScaling Group name and the Security Group ID are required")
+		logger.Error("Invalid sync input", zap.Error(err))
+		return result, err
+	}
+	err = target.Verify(input.SecurityGroupID, ec2Svc)
+	if target.IsNotFound(err) && input.Recreate != nil && !input.DryRun {
+		recreatedID, recreateErr := target.Recreate(input.SecurityGroupID, *input.Recreate, ec2Svc)
+		if recreateErr != nil {
+			logger.Error("Failed to recreate the deleted Security Group", zap.Error(recreateErr), zap.String("category", string(errs.CategoryOf(recreateErr))))
+			return result, recreateErr
+		}
+		logger.Warn("The Security Group was deleted, syncing its recreation", zap.String("deletedID", input.SecurityGroupID), zap.String("sgID", recreatedID))
+		input.SecurityGroupID, result.RecreatedSecurityGroupID = recreatedID, recreatedID
+		err = nil
+	}
+	if err != nil {
+		logger.Error("Failed to verify the Security Group", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		return result, err
+	}
+	if input, err = withTaggedRules(input, ec2Svc, logger); err != nil {
+		return result, err
+	}
+	if input.AccessClosed {
+		closed, err := closeAccess(input, autoscalingSvc, ec2Svc, logger)
+		closed.RecreatedSecurityGroupID = result.RecreatedSecurityGroupID
+		return closed, err
+	}
+
+	instances, err := source.ASGInstances(input.AutoScalingGroupName, "", autoscalingSvc, ec2Svc)
+	if errors.Is(err, source.ErrGroupNotFound) && len(excludedIDs(input)) != 0 {
+		logger.Warn("The AutoScaling Group no longer exists, removing the terminating instances' rules", zap.Strings("instanceIDs", excludedIDs(input)))
+		return removeInstanceRules(input, ec2Svc, logger)
+	}
+	if err != nil {
+		logger.Error("Failed to get ASG Public IPs", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		return result, result.tolerate(input.Policy, policy.StageSource, err)
+	}
+	var suppressedReason SkipReason
+	instances, result.SuppressedRemovals, suppressedReason = excludeInstance(input, instances, time.Now())
+	if len(result.SuppressedRemovals) != 0 {
+		logger.Info("Keeping the terminating instances' IPs for now", zap.Strings("instanceIDs", excludedIDs(input)), zap.Any("suppressedRemovals", result.SuppressedRemovals))
+	}
+	if err := checkVPC(input, instances, ec2Svc); err != nil {
+		logger.Error("VPC ownership check failed, refusing to update the Security Group", zap.Error(err))
+		return result, err
+	}
+	result.DryRun = input.DryRun
+
+	if len(input.ReturnRules) != 0 || input.ReturnGroupID != "" {
+		result.ReturnGroupID, result.ReturnChanges, err = syncReturn(input, ec2Svc, logger)
+		if err != nil {
+			logger.Error("Failed to sync the return rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			if err := result.tolerate(input.Policy, policy.StageAdd, err); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	if input.ReferenceSourceGroup {
+		if input.SourceGroupID == "" {
+			err := errs.Errorf(errs.Config, "reference source group", "the source group reference needs sourceSecurityGroupID")
+			logger.Error("No source group to reference", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			return result, err
+		}
+		result.SourceGroupID = input.SourceGroupID
+		result.ReferencedRules, result.UnreferencedRules, err = referenceGroup(input, ec2Svc, logger)
+		if err != nil {
+			logger.Error("Failed to reference the source group", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			return result, result.tolerate(input.Policy, policy.StageAdd, err)
+		}
+		if result.RemovedIPs, err = retireIPRules(input, ec2Svc, logger); err != nil {
+			logger.Error("Failed to remove the IP rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			return result, result.tolerate(input.Policy, policy.StageRemove, err)
+		}
+		return result, nil
+	}
+	if result.UnreferencedRules, err = unreference(input, "", ec2Svc, logger); err != nil {
+		logger.Error("Failed to remove the source group references", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		if err := result.tolerate(input.Policy, policy.StageRemove, err); err != nil {
+			return result, err
+		}
+	}
+
+	asgIPs, err := source.PublicIPs(instances)
+	if err != nil {
+		logger.Error("Malformed public IP", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		return result, result.tolerate(input.Policy, policy.StageSource, err)
+	}
+	logger.Info("AutoScaling Group's IPs", zap.Any("asgIPs", asgIPs))
+	asgIPs, denied := withoutDenied(asgIPs, input.DeniedCIDRs)
+	if len(denied) != 0 {
+		logger.Warn("Skipping the IPs within the denied CIDRs", zap.Strings("deniedCIDRs", input.DeniedCIDRs), zap.Any("denied", denied))
+	}
+	if input.RuleCIDRMask != 0 && input.RuleCIDRMask != 32 {
+		asgIPs = masked(asgIPs, input.RuleCIDRMask)
+		logger.Info("Widened the AutoScaling Group's IPs to the rules' mask", zap.Int("mask", input.RuleCIDRMask), zap.Any("asgIPs", asgIPs))
+	}
+	if input.AggregateCIDRs {
+		asgIPs = aggregate(asgIPs)
+		logger.Info("Aggregated the AutoScaling Group's IPs", zap.Any("asgIPs", asgIPs))
+	}
+
+	result.Skipped = append(instanceSkips(instances, result.SuppressedRemovals, suppressedReason), denied...)
+	rules, ruleIPs, err := instanceRules(input, instances, asgIPs, ec2Svc, logger)
+	if err != nil {
+		logger.Error("Failed to get the instances' extra ports", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		return result, result.tolerate(input.Policy, policy.StageRead, err)
+	}
+	input.removals = newRemovalBudget(input.MaxRemovals)
+	if input.quotaRoom, err = newQuotaRoom(input, ec2Svc); err != nil {
+		logger.Error("Failed to count the rules of the Security Group", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		return result, result.tolerate(input.Policy, policy.StageRead, err)
+	}
+	for _, rule := range rules {
+		ruleResult, err := syncRule(input, rule, ruleIPs[rule], ec2Svc, logger.With(zap.Stringer("rule", rule)))
+		result.merge(rule, ruleResult)
+		if err != nil {
+			return result, err
+		}
+	}
+	if input.DryRun {
+		logger.Info("Dry run, the Security Group is left untouched")
+	}
+	return result, nil
+}
+
+// Replaces the rules of the input with the ones listed by the Security Group's tag, when enabled and tagged
+func withTaggedRules(input Input, ec2Svc ec2iface.EC2API, logger *zap.Logger) (Input, error) {
+	if !input.RulesFromTags {
+		return input, nil
+	}
+	rules, ok, err := target.TaggedRules(input.SecurityGroupID, ec2Svc)
+	if err != nil {
+		logger.Error("Failed to read the rules of the Security Group's tag", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		return input, err
+	}
+	if ok {
+		logger.Info("Rules read from the Security Group's tag", zap.String("tag", target.PortsTag), zap.Any("rules", rules))
+		input.Rules = rules
+	}
+	return input, nil
+}
+
+// Diffs and applies a single rule of the Security Group
+func syncRule(input Input, rule target.Rule, asgIPs cidr.IPSet, ec2Svc ec2iface.EC2API, logger *zap.Logger) (result Result, err error) {
+	sgIPs, err := target.SecurityGroupIPs(input.SecurityGroupID, rule, ec2Svc)
+	if err != nil {
+		logger.Error("Failed to get the IPs of the Security Groups", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+		return result, result.tolerate(input.Policy, policy.StageRead, err)
+	}
+	logger.Info("Security Group's IPs", zap.Any("sgIPs", sgIPs))
+	authorize := target.Authorize
+	if !hasRule(input.Rules, rule) {
+		// The rules of the instances' declared ports are marked, so that only they are retired once undeclared
+		authorize = target.AuthorizeDeclared
+	}
+
+	managed := managedRules(sgIPs, rule)
+	var snapshotVersion int64
+	if input.StateStore != nil {
+		owned, err := input.StateStore.Owned(input.SecurityGroupID, rule)
+		if err != nil {
+			logger.Error("Failed to read the state of the managed rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			return result, result.tolerate(input.Policy, policy.StageRead, err)
+		}
+		for cidr, meta := range owned {
+			// The state outlives the rules' descriptions but not the rules themselves
+			if _, ok := sgIPs[cidr]; ok {
+				managed[cidr] = meta
+			}
+		}
+
+		snapshot, err := input.StateStore.Snapshot(input.SecurityGroupID, rule)
+		if err != nil {
+			logger.Error("Failed to read the snapshot of the last sync", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			if err := result.tolerate(input.Policy, policy.StageState, err); err != nil {
+				return result, err
+			}
+		} else if snapshot != nil {
+			snapshotVersion = snapshot.Version
+			// The drift is read past the cache, whose groups may predate the changes of the last sync
+			fresh, err := target.FreshSecurityGroupIPs(input.SecurityGroupID, rule, ec2Svc)
+			if err != nil {
+				logger.Error("Failed to get the IPs of the Security Groups", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+				return result, result.tolerate(input.Policy, policy.StageRead, err)
+			}
+			freshManaged := managedRules(fresh, rule)
+			for c, meta := range owned {
+				freshManaged[c] = meta
+			}
+			result.Drift = detectDrift(ownIPs(fresh, freshManaged), *snapshot, freshManaged)
+		}
+	}
+
+	ipsToAdd := diff.IPsToAdd(asgIPs, sgIPs)
+	logger.Info("IPs to add", zap.Any("ipsToAdd", ipsToAdd))
+
+	ipsToRemove, foreign := ownedByRule(diff.IPsToRemove(sgIPs, asgIPs), sgIPs, rule)
+	logger.Info("IPs to remove", zap.Any("ipsToRemove", ipsToRemove))
+	if len(foreign) != 0 {
+		logger.Info("Keeping the rules of other rule sets and namespaces", zap.Any("foreign", foreign))
+	}
+	var unmanaged []string
+	if input.StrictRemoval {
+		ipsToRemove, unmanaged = onlyManaged(ipsToRemove, managed)
+		if len(unmanaged) != 0 {
+			logger.Info("Strict removal, keeping the rules the sync didn't create", zap.Any("unmanaged", unmanaged))
+		}
+	}
+
+	// The instances whose IP changed get their rule replaced on its own, add then remove, whatever the approvals
+	replaced := findReplacements(ipsToAdd, ipsToRemove, asgIPs, managed)
+	newCIDRs, oldCIDRs := replacementCIDRs(replaced)
+	if len(replaced) != 0 {
+		logger.Info("Instances whose public IP changed", zap.Any("replaced", replaced))
+		ipsToAdd = approval.Exclude(ipsToAdd, newCIDRs)
+		ipsToRemove = approval.Exclude(ipsToRemove, oldCIDRs)
+	}
+
+	if !input.AllowBroadRemovals {
+		ipsToRemove, result.BlockedRemovals = diff.GuardRemovals(ipsToRemove)
+		if input.AggregateCIDRs {
+			var aggregates []string
+			aggregates, result.BlockedRemovals = managedAggregates(result.BlockedRemovals, managed)
+			ipsToRemove = append(ipsToRemove, aggregates...)
+		}
+		var prefixes []string
+		prefixes, result.BlockedRemovals = managedPrefixes(result.BlockedRemovals, managed, input.RuleCIDRMask)
+		ipsToRemove = append(ipsToRemove, prefixes...)
+		if len(result.BlockedRemovals) != 0 {
+			logger.Warn("Refusing to remove broad CIDRs", zap.Any("blockedRemovals", result.BlockedRemovals))
+		}
+	}
+
+	var notApproved []string
+	if input.ApprovedRemovals != nil {
+		approved := approval.Filter(ipsToRemove, input.ApprovedRemovals)
+		notApproved, ipsToRemove = approval.Exclude(ipsToRemove, approved), approved
+		logger.Info("Approved IPs to remove", zap.Any("ipsToRemove", ipsToRemove))
+	} else if input.RemovalApprovalThreshold > 0 && len(ipsToRemove) > input.RemovalApprovalThreshold {
+		logger.Warn("Removals exceed the approval threshold, parking them", zap.Int("threshold", input.RemovalApprovalThreshold), zap.Any("pendingRemovals", ipsToRemove))
+		result.PendingRemovals, ipsToRemove = ipsToRemove, nil
+	}
+
+	stale := staleManagedRules(managed, asgIPs, append(append([]string{}, ipsToRemove...), oldCIDRs...))
+	if input.CollectOrphans {
+		orphans, err := findOrphans(stale, input.RuleCIDRMask, ec2Svc)
+		if err != nil {
+			logger.Error("Failed to look for orphan rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			if err := result.tolerate(input.Policy, policy.StageGC, err); err != nil {
+				return result, err
+			}
+		}
+		logger.Info("Orphan rules of instances that no longer exist", zap.Any("orphans", orphans))
+		result.CollectedOrphans = orphans
+		result.PendingRemovals = approval.Exclude(result.PendingRemovals, orphans)
+		for _, cidr := range orphans {
+			delete(stale, cidr)
+		}
+	}
+	if input.MaxRuleAge > 0 {
+		result.ExpiredRules = findExpired(stale, input.RuleCIDRMask, input.MaxRuleAge, time.Now())
+		logger.Info("Stale rules older than the max rule age", zap.Any("expiredRules", result.ExpiredRules))
+		result.PendingRemovals = approval.Exclude(result.PendingRemovals, result.ExpiredRules)
+	}
+	// The replaced rules are not capped, their instances' new rules are added first
+	result.ThrottledRemovals = input.removals.take(&ipsToRemove, &result.CollectedOrphans, &result.ExpiredRules)
+	if len(result.ThrottledRemovals) != 0 {
+		logger.Warn("Removals exceed the max removals of the sync, leaving the rest to the next syncs", zap.Int("maxRemovals", input.MaxRemovals), zap.Any("throttledRemovals", result.ThrottledRemovals))
+	}
+
+	// The replaced rules are not capped either, their old rules are removed right after
+	overQuota := input.quotaRoom.take(&ipsToAdd)
+	if len(overQuota) != 0 {
+		logger.Warn("Additions exceed the room of the rules quota, leaving the rest to the next syncs", zap.Int("rulesQuota", input.RulesQuota), zap.Any("overQuota", overQuota))
+	}
+
+	result.AddedIPs = append(append([]string{}, ipsToAdd...), newCIDRs...)
+	result.RemovedIPs = append(append([]string{}, ipsToRemove...), oldCIDRs...)
+	result.Replaced = replaced
+	result.Owners = asgIPs
+	byInstances := func() {
+		result.AddedByInstance = byInstance(result.AddedIPs, asgIPs)
+		result.RemovedByInstance = byInstance(revocations(result.RemovedIPs, result), managedOwners(managed))
+	}
+	byInstances()
+	result.Skipped = appendAddSkips(ruleSkips(notApproved, unmanaged, result), overQuota, asgIPs, ReasonOverQuota)
+	if input.DryRun {
+		return result, nil
+	}
+
+	// Another actor may have changed the rule since it was read. It is described again right before the update and the
+	// changes are recomputed against its instant state, so that the redundant ones are skipped instead of failing.
+	if len(ipsToAdd)+len(newCIDRs)+len(oldCIDRs)+len(revocations(ipsToRemove, result)) != 0 {
+		current, err := target.FreshSecurityGroupIPs(input.SecurityGroupID, rule, ec2Svc)
+		if err != nil {
+			logger.Error("Failed to describe the Security Group again before updating it", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			return result, result.tolerate(input.Policy, policy.StageRead, err)
+		}
+		redundantAdds := dropRedundant(current, true, &ipsToAdd, &newCIDRs)
+		redundantRemovals := dropRedundant(current, false, &ipsToRemove, &oldCIDRs, &result.CollectedOrphans, &result.ExpiredRules)
+		if len(redundantAdds)+len(redundantRemovals) != 0 {
+			logger.Info("The rule changed since it was read, skipping the redundant changes", zap.Strings("alreadyPresent", redundantAdds), zap.Strings("alreadyAbsent", redundantRemovals))
+			result.AddedIPs = approval.Exclude(result.AddedIPs, redundantAdds)
+			result.RemovedIPs = approval.Exclude(result.RemovedIPs, redundantRemovals)
+			result.Skipped = append(result.Skipped, redundantSkips(redundantAdds, redundantRemovals)...)
+			byInstances()
+		}
+		sgIPs = current
+	}
+
+	replace := func() error {
+		if len(replaced) == 0 {
+			return nil
+		}
+		if err := authorize(input.SecurityGroupID, rule, newCIDRs, asgIPs, ec2Svc); err != nil {
+			logger.Error("Failed to add the changed IPs to security group", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			applied := result.cidrFailures(err)
+			if err := result.tolerate(input.Policy, policy.StageAdd, err); err != nil {
+				return err
+			}
+			// The old rules stay until all the new ones are in place
+			result.AddedIPs = approval.Exclude(result.AddedIPs, approval.Exclude(newCIDRs, applied))
+			result.RemovedIPs = approval.Exclude(result.RemovedIPs, oldCIDRs)
+			result.Replaced = nil
+			byInstances()
+			if err := recordState(input, rule, applied, asgIPs); err != nil {
+				logger.Error("Failed to record the added rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+				return result.tolerate(input.Policy, policy.StageState, err)
+			}
+			return nil
+		}
+		if err := recordState(input, rule, newCIDRs, asgIPs); err != nil {
+			logger.Error("Failed to record the added rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			if err := result.tolerate(input.Policy, policy.StageState, err); err != nil {
+				return err
+			}
+		}
+		if err := target.Revoke(input.SecurityGroupID, rule, oldCIDRs, ec2Svc); err != nil {
+			logger.Error("Failed to remove the changed IPs from security group", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			applied := result.cidrFailures(err)
+			if err := result.tolerate(input.Policy, policy.StageRemove, err); err != nil {
+				return err
+			}
+			result.RemovedIPs = approval.Exclude(result.RemovedIPs, approval.Exclude(oldCIDRs, applied))
+			result.Replaced = nil
+			byInstances()
+			if err := forgetState(input, rule, applied); err != nil {
+				logger.Error("Failed to forget the removed rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+				return result.tolerate(input.Policy, policy.StageState, err)
+			}
+			return nil
+		}
+		if err := forgetState(input, rule, oldCIDRs); err != nil {
+			logger.Error("Failed to forget the removed rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			return result.tolerate(input.Policy, policy.StageState, err)
+		}
+		return nil
+	}
+	add := func() error {
+		if err := authorize(input.SecurityGroupID, rule, ipsToAdd, asgIPs, ec2Svc); err != nil {
+			logger.Error("Failed to add IPs to security group", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			applied := result.cidrFailures(err)
+			if err := result.tolerate(input.Policy, policy.StageAdd, err); err != nil {
+				return err
+			}
+			result.AddedIPs = approval.Exclude(result.AddedIPs, approval.Exclude(ipsToAdd, applied))
+			byInstances()
+			if err := recordState(input, rule, applied, asgIPs); err != nil {
+				logger.Error("Failed to record the added rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+				return result.tolerate(input.Policy, policy.StageState, err)
+			}
+		} else if err := recordState(input, rule, ipsToAdd, asgIPs); err != nil {
+			logger.Error("Failed to record the added rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			return result.tolerate(input.Policy, policy.StageState, err)
+		}
+		return nil
+	}
+	revoked := revocations(ipsToRemove, result)
+	remove := func() error {
+		if err := target.Revoke(input.SecurityGroupID, rule, revoked, ec2Svc); err != nil {
+			logger.Error("Failed to remove IPs from security group", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			applied := result.cidrFailures(err)
+			if err := result.tolerate(input.Policy, policy.StageRemove, err); err != nil {
+				return err
+			}
+			failed := approval.Exclude(revoked, applied)
+			result.RemovedIPs = approval.Exclude(result.RemovedIPs, failed)
+			result.CollectedOrphans = approval.Exclude(result.CollectedOrphans, failed)
+			result.ExpiredRules = approval.Exclude(result.ExpiredRules, failed)
+			byInstances()
+			if err := forgetState(input, rule, applied); err != nil {
+				logger.Error("Failed to forget the removed rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+				return result.tolerate(input.Policy, policy.StageState, err)
+			}
+		} else if err := forgetState(input, rule, revoked); err != nil {
+			logger.Error("Failed to forget the removed rules", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			return result.tolerate(input.Policy, policy.StageState, err)
+		}
+		return nil
+	}
+
+	steps := []func() error{replace, add, remove}
+	if input.Order == policy.RemoveFirst {
+		steps = []func() error{replace, remove, add}
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return result, err
+		}
+	}
+	if len(result.AddedByInstance) != 0 || len(result.RemovedByInstance) != 0 {
+		logger.Info("Instances' IPs", zap.Any("addedByInstance", result.AddedByInstance), zap.Any("removedByInstance", result.RemovedByInstance))
+	}
+
+	if input.StateStore != nil {
+		saved, err := input.StateStore.SaveSnapshot(input.SecurityGroupID, rule, syncedCIDRs(ownIPs(sgIPs, managedRules(sgIPs, rule)), result), snapshotVersion)
+		if err != nil {
+			logger.Error("Failed to save the snapshot of the sync", zap.Error(err), zap.String("category", string(errs.CategoryOf(err))))
+			if err := result.tolerate(input.Policy, policy.StageState, err); err != nil {
+				return result, err
+			}
+		} else if !saved && len(result.Drift) != 0 {
+			// The drift may be the changes of the sync that saved meanwhile, the next sync compares against its snapshot
+			logger.Info("Another sync saved the snapshot meanwhile, not reporting the drift", zap.Any("drift", result.Drift))
+			result.Drift = nil
+		}
+		if len(result.Drift) != 0 {
+			logger.Warn("Security Group changed outside of the sync", zap.Any("drift", result.Drift))
+		}
+	}
+
+	return result, nil
+}
+
+// Drops the excluded instances from the desired instances, unless their removal is deferred or suppressed by the
+// cooldown window or their scale-in protection. Returns the CIDRs whose removal was suppressed and why.
+func excludeInstance(input Input, instances []source.Instance, now time.Time) ([]source.Instance, []string, SkipReason) {
+	excluded := make(map[string]struct{})
+	for _, id := range excludedIDs(input) {
+		excluded[id] = struct{}{}
+	}
+	if len(excluded) == 0 {
+		return instances, nil, ""
+	}
+	var kept []source.Instance
+	var suppressed []string
+	var reason SkipReason
+	for _, instance := range instances {
+		if _, ok := excluded[instance.ID]; !ok {
+			kept = append(kept, instance)
+			continue
+		}
+		inCooldown := input.RemovalCooldown > 0 && now.Sub(input.ExcludedSince) < input.RemovalCooldown
+		protected := input.RespectScaleInProtection && instance.ProtectedFromScaleIn
+		cidrs, err := source.PublicIPs([]source.Instance{instance})
+		if (inCooldown || protected || input.DeferRemoval) && instance.Running() && err == nil && len(cidrs) != 0 {
+			kept = append(kept, instance)
+			suppressed = append(suppressed, masked(cidrs, input.RuleCIDRMask).CIDRs()...)
+			switch {
+			case protected:
+				reason = ReasonScaleInProtected
+			case inCooldown:
+				reason = ReasonRemovalCooldown
+			default:
+				reason = ReasonRemovalDeferred
+			}
+		}
+	}
+	return kept, suppressed, reason
+}
+
+// Gets the IDs of all the excluded instances
+func excludedIDs(input Input) []string {
+	if input.ExcludeInstanceID == "" {
+		return input.ExcludeInstanceIDs
+	}
+	return append([]string{input.ExcludeInstanceID}, input.ExcludeInstanceIDs...)
+}
+
+// Gets all the CIDRs to revoke: the diff's removals, the collected orphans and the expired rules
+func revocations(ipsToRemove []string, result Result) []string {
+	all := append([]string{}, ipsToRemove...)
+	all = append(all, result.CollectedOrphans...)
+	return append(all, result.ExpiredRules...)
+}
+
+// Verifies that the Security Group belongs to the expected VPC and/or the VPC of the instances
+func checkVPC(input Input, instances []source.Instance, ec2Svc ec2iface.EC2API) error {
+	if input.ExpectedVpcID == "" && !input.RequireSameVPC {
+		return nil
+	}
+	sgVpcID, err := target.VpcID(input.SecurityGroupID, ec2Svc)
+	if err != nil {
+		return err
+	}
+	if input.ExpectedVpcID != "" && sgVpcID != input.ExpectedVpcID {
+		return errs.Errorf(errs.Config, "check vpc", "security group %s belongs to %s, expected %s", input.SecurityGroupID, sgVpcID, input.ExpectedVpcID)
+	}
+	if input.RequireSameVPC {
+		for _, instance := range instances {
+			if instance.VpcID != "" && instance.VpcID != sgVpcID {
+				return errs.Errorf(errs.Config, "check vpc", "security group %s belongs to %s but instance %s runs in %s", input.SecurityGroupID, sgVpcID, instance.ID, instance.VpcID)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultConcurrency is how many Security Groups are synced at once by default
+const DefaultConcurrency = 4
+
+// TargetResult is the result of syncing one of several Security Groups
+type TargetResult struct {
+	SecurityGroupID string `json:"sg_id"`
+	Result
+	// Error is the failure that stopped the sync of this Security Group, with its Category
+	Error    string        `json:"error,omitempty"`
+	Category errs.Category `json:"category,omitempty"`
+}
+
+// SyncTargets syncs the AutoScaling Group with every one of the Security Groups, concurrency of them at once. A
+// failure only stops the sync of its own Security Group. The returned error joins the failures of all of them.
+func SyncTargets(input Input, sgIDs []string, concurrency int, autoscalingSvc autoscalingiface.AutoScalingAPI, ec2Svc ec2iface.EC2API, logger *zap.Logger) ([]TargetResult, error) {
+	if concurrency < 1 {
+		concurrency = DefaultConcurrency
+	}
+	results := make([]TargetResult, len(sgIDs))
+	failures := make([]error, len(sgIDs))
+
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for i, sgID := range sgIDs {
+		i, sgID := i, sgID
+		g.Go(func() error {
+			targetInput := input
+			targetInput.SecurityGroupID = sgID
+			result, err := Sync(targetInput, autoscalingSvc, ec2Svc, logger.With(zap.String("sgID", sgID)))
+			results[i] = TargetResult{SecurityGroupID: sgID, Result: result}
+			if err != nil {
+				results[i].Error, results[i].Category = err.Error(), errs.CategoryOf(err)
+				failures[i] = fmt.Errorf("%s: %w", sgID, err)
+			}
+			// Every Security Group is synced whatever happens to the others
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return results, errors.Join(failures...)
+}
@@ -0,0 +1,32 @@
+package syncer
+
+// ruleBudget is how many more CIDRs the sync can revoke, or authorize, across all its rules
+type ruleBudget struct {
+	left int
+}
+
+// Builds the removal budget of a sync, nil when the removals aren't capped
+func newRemovalBudget(max int) *ruleBudget {
+	if max <= 0 {
+		return nil
+	}
+	return &ruleBudget{left: max}
+}
+
+// Takes the CIDRs of the lists, in order, while the budget lasts. The lists are cut down to the CIDRs that fit and the
+// ones that didn't are returned, for a later sync to remove. A nil budget takes them all.
+func (b *ruleBudget) take(lists ...*[]string) (throttled []string) {
+	if b == nil {
+		return nil
+	}
+	for _, list := range lists {
+		if len(*list) <= b.left {
+			b.left -= len(*list)
+			continue
+		}
+		throttled = append(throttled, (*list)[b.left:]...)
+		*list = append([]string(nil), (*list)[:b.left]...)
+		b.left = 0
+	}
+	return throttled
+}
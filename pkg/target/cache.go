@@ -0,0 +1,69 @@
+package target
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// CacheTTL is how long a described Security Group is reused, across the invocations of a warm container, before it
+// is described again. The sync's own changes invalidate it right away. 0 disables the cache.
+var CacheTTL = 10 * time.Second
+
+// cachedGroup is a described Security Group and when it was described
+type cachedGroup struct {
+	group *ec2.SecurityGroup
+	at    time.Time
+}
+
+var groups sync.Map
+
+// Describes the Security Group, reusing the cached description while it is fresh
+func describeGroup(sgID string, ec2Svc ec2iface.EC2API) (*ec2.SecurityGroup, error) {
+	if cached, ok := groups.Load(sgID); ok && time.Since(cached.(cachedGroup).at) < CacheTTL {
+		return cached.(cachedGroup).group, nil
+	}
+	sgResp, err := ec2Svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		GroupIds: []*string{aws.String(sgID)},
+	})
+	if err != nil {
+		if IsNotFound(err) {
+			// Verify checks the group again, e.g. to recreate it
+			verified.Delete(sgID)
+		}
+		return nil, errs.Wrap(errs.Target, "describe security group", err)
+	}
+	if len(sgResp.SecurityGroups) == 0 {
+		return nil, errs.Errorf(errs.Target, "describe security group", "security group %s not found", sgID)
+	}
+	if CacheTTL > 0 {
+		groups.Store(sgID, cachedGroup{group: sgResp.SecurityGroups[0], at: time.Now()})
+	}
+	return sgResp.SecurityGroups[0], nil
+}
+
+// Describe describes the Security Group again, bypassing the cache, e.g. to copy it
+func Describe(sgID string, ec2Svc ec2iface.EC2API) (*ec2.SecurityGroup, error) {
+	Invalidate(sgID)
+	return describeGroup(sgID, ec2Svc)
+}
+
+// Invalidate drops the cached description of the Security Group, e.g. after changing its rules
+func Invalidate(sgID string) {
+	groups.Delete(sgID)
+}
+
+// Reset forgets the cached descriptions and verifications of every Security Group, e.g. between the cases of a harness
+// that reuses the groups' IDs with new fake clients
+func Reset() {
+	for _, m := range []*sync.Map{&groups, &verified} {
+		m.Range(func(key, _ interface{}) bool {
+			m.Delete(key)
+			return true
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package target
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// Actions of the CIDR failures
+const (
+	ActionAuthorize = "authorize"
+	ActionRevoke    = "revoke"
+)
+
+// itemCodes are the error codes about some of the CIDRs of a call rather than the whole call. The CIDRs of a call
+// that fails with one of them are retried one at a time, to tell the failing ones from the rest.
+var itemCodes = map[string]bool{
+	"InvalidParameterValue":              true,
+	"InvalidPermission.Duplicate":        true,
+	"InvalidPermission.Malformed":        true,
+	"InvalidPermission.NotFound":         true,
+	"RulesPerSecurityGroupLimitExceeded": true,
+}
+
+// CIDRFailure is a CIDR whose authorization or revocation failed, with the AWS error code
+type CIDRFailure struct {
+	CIDR    string `json:"cidr"`
+	Rule    string `json:"rule,omitempty"`
+	Action  string `json:"action"`
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// CIDRErrors is the error of an authorization or revocation some CIDRs of which failed. Applied are the others, they
+// are in place. It unwraps to the error of the first failure.
+type CIDRErrors struct {
+	Failures []CIDRFailure
+	Applied  []string
+	Err      error
+}
+
+func (e *CIDRErrors) Error() string {
+	failed := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		failed = append(failed, failure.CIDR+" ("+failure.Code+")")
+	}
+	return fmt.Sprintf("%v, %d of %d CIDRs failed: %s", e.Err, len(e.Failures), len(e.Failures)+len(e.Applied), strings.Join(failed, ", "))
+}
+
+func (e *CIDRErrors) Unwrap() error { return e.Err }
+
+// FailuresOf gets the failed CIDRs of an Authorize or Revoke error, and the CIDRs that were applied all the same.
+// ok is false when the error carries no CIDRs.
+func FailuresOf(err error) (failures []CIDRFailure, applied []string, ok bool) {
+	var e *CIDRErrors
+	if !errors.As(err, &e) {
+		return nil, nil, false
+	}
+	return e.Failures, e.Applied, true
+}
+
+// Applies the change to the CIDRs in a single call. When it fails with an itemCodes error, the CIDRs are applied again
+// one at a time, so that the error lists the failing ones and the others are in place. Otherwise every CIDR fails
+// with the call's error.
+func applyEach(op string, action string, rule Rule, cidrs []string, apply func(cidrs []string) error) error {
+	err := apply(cidrs)
+	if err == nil {
+		return nil
+	}
+	e := &CIDRErrors{Err: err}
+	if len(cidrs) > 1 && itemCodes[codeOf(err)] {
+		e.Err = nil
+		for _, c := range cidrs {
+			if err := apply([]string{c}); err != nil {
+				e.Failures = append(e.Failures, failure(action, rule, c, err))
+				if e.Err == nil {
+					e.Err = err
+				}
+				continue
+			}
+			e.Applied = append(e.Applied, c)
+		}
+		if e.Err == nil {
+			// The CIDRs went through one at a time, whatever failed the call
+			return nil
+		}
+	} else {
+		for _, c := range cidrs {
+			e.Failures = append(e.Failures, failure(action, rule, c, err))
+		}
+	}
+	// The category is the one of the AWS error, e.g. Throttle
+	return &errs.Error{Category: errs.CategoryOf(errs.Wrap(errs.Target, op, e.Err)), Op: op, Err: e}
+}
+
+// Builds the failure of the CIDR
+func failure(action string, rule Rule, c string, err error) CIDRFailure {
+	f := CIDRFailure{CIDR: c, Rule: rule.String(), Action: action, Code: codeOf(err), Message: err.Error()}
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		f.Message = aerr.Message()
+	}
+	return f
+}
+
+// Gets the AWS error code of the error, empty when it isn't an AWS error
+func codeOf(err error) string {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return aerr.Code()
+	}
+	return ""
+}
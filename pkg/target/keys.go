@@ -0,0 +1,49 @@
+package target
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+)
+
+// PermissionKeys builds a key of every source of the permissions: the protocol, the port range and the CIDR, prefix
+// list or group, whatever the descriptions
+func PermissionKeys(perms []*ec2.IpPermission) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for _, perm := range perms {
+		prefix := permissionPrefix(perm)
+		for _, r := range perm.IpRanges {
+			keys[RangeKey(perm, aws.StringValue(r.CidrIp))] = struct{}{}
+		}
+		for _, r := range perm.Ipv6Ranges {
+			keys[prefix+"ipv6:"+normalize(aws.StringValue(r.CidrIpv6))] = struct{}{}
+		}
+		for _, p := range perm.PrefixListIds {
+			keys[prefix+"prefix-list:"+aws.StringValue(p.PrefixListId)] = struct{}{}
+		}
+		for _, pair := range perm.UserIdGroupPairs {
+			keys[prefix+"group:"+aws.StringValue(pair.GroupId)] = struct{}{}
+		}
+	}
+	return keys
+}
+
+// Builds the protocol and port range part of the permission's keys, e.g. tcp/443-443/
+func permissionPrefix(perm *ec2.IpPermission) string {
+	return fmt.Sprintf("%s/%d-%d/", aws.StringValue(perm.IpProtocol), aws.Int64Value(perm.FromPort), aws.Int64Value(perm.ToPort))
+}
+
+// RangeKey builds the key of the permission's IPv4 range
+func RangeKey(perm *ec2.IpPermission, c string) string {
+	return permissionPrefix(perm) + "ip:" + normalize(c)
+}
+
+// Normalizes the CIDR, keeping the malformed ones as they are
+func normalize(c string) string {
+	if normalized, err := cidr.Normalize(c); err == nil {
+		return normalized
+	}
+	return c
+}
@@ -0,0 +1,23 @@
+package target
+
+import "regexp"
+
+// Namespace is the ownership namespace of the deployment. It is recorded in the descriptions of the rules the sync
+// creates, and the sync only ever manages the rules of its own namespace, so that several deployments can share a
+// Security Group. Empty is the namespace of the deployments without one.
+var Namespace string
+
+// namespacePrefix precedes the namespace of the rule in its description
+const namespacePrefix = "ns:"
+
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+// ValidNamespace checks whether the namespace is at most 32 letters, digits, dots, dashes and underscores
+func ValidNamespace(namespace string) bool {
+	return namespacePattern.MatchString(namespace)
+}
+
+// InNamespace returns true when the managed rule belongs to the deployment's Namespace
+func (m RuleMeta) InNamespace() bool {
+	return m.Namespace == Namespace
+}
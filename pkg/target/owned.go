@@ -0,0 +1,88 @@
+package target
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// OwnedRule is a rule set of a Security Group and the sources of it that the deployment owns
+type OwnedRule struct {
+	Rule  Rule     `json:"rule"`
+	CIDRs []string `json:"cidrs,omitempty"`
+	// Groups are the referenced source groups, see AuthorizeGroup
+	Groups []string `json:"groups,omitempty"`
+}
+
+// OwnedRules gets the rules of the Security Group that the sync created in the deployment's namespace, out of their
+// descriptions
+func OwnedRules(sgID string, ec2Svc ec2iface.EC2API) ([]OwnedRule, error) {
+	return ownedRules(sgID, func(RuleMeta, string) bool { return true }, ec2Svc)
+}
+
+// ReturnRules gets the managed return rules of the instances' security group, in the deployment's namespace: those that
+// let the endpoint's IPs in, see EndpointOwner, and the references of the synced sourceGroupID
+func ReturnRules(sgID string, sourceGroupID string, ec2Svc ec2iface.EC2API) ([]OwnedRule, error) {
+	return ownedRules(sgID, func(meta RuleMeta, source string) bool {
+		return meta.InstanceID == EndpointOwner || meta.InstanceID == groupOwner && source == sourceGroupID
+	}, ec2Svc)
+}
+
+// Gets the owned rules whose metadata and source, a CIDR or a group, match
+func ownedRules(sgID string, match func(meta RuleMeta, source string) bool, ec2Svc ec2iface.EC2API) ([]OwnedRule, error) {
+	group, err := describeGroup(sgID, ec2Svc)
+	if err != nil {
+		return nil, err
+	}
+	var owned []OwnedRule
+	index := make(map[Rule]int)
+	add := func(rule Rule) *OwnedRule {
+		i, ok := index[rule]
+		if !ok {
+			i = len(owned)
+			index[rule] = i
+			owned = append(owned, OwnedRule{Rule: rule})
+		}
+		return &owned[i]
+	}
+	for _, perm := range group.IpPermissions {
+		rule := PermissionRule(aws.StringValue(perm.IpProtocol), aws.Int64Value(perm.FromPort), aws.Int64Value(perm.ToPort))
+		for _, ipRange := range perm.IpRanges {
+			if meta, ok := ParseDescription(aws.StringValue(ipRange.Description)); ok && meta.InNamespace() && match(meta, aws.StringValue(ipRange.CidrIp)) {
+				o := add(rule)
+				o.CIDRs = append(o.CIDRs, aws.StringValue(ipRange.CidrIp))
+			}
+		}
+		for _, pair := range perm.UserIdGroupPairs {
+			if meta, ok := ParseDescription(aws.StringValue(pair.Description)); ok && meta.InNamespace() && match(meta, aws.StringValue(pair.GroupId)) {
+				o := add(rule)
+				o.Groups = append(o.Groups, aws.StringValue(pair.GroupId))
+			}
+		}
+	}
+	return owned, nil
+}
+
+// RevokeOwned removes the owned rule's sources from the Security Group
+func RevokeOwned(sgID string, owned OwnedRule, ec2Svc ec2iface.EC2API) error {
+	perms := Permissions(owned.Rule, owned.CIDRs)
+	if len(owned.Groups) != 0 {
+		from, to := owned.Rule.portRange()
+		perm := &ec2.IpPermission{FromPort: from, ToPort: to, IpProtocol: aws.String(owned.Rule.Protocol)}
+		for _, groupID := range owned.Groups {
+			perm.UserIdGroupPairs = append(perm.UserIdGroupPairs, &ec2.UserIdGroupPair{GroupId: aws.String(groupID)})
+		}
+		perms = append(perms, perm)
+	}
+	if len(perms) == 0 {
+		return nil
+	}
+	throttle(sgID)
+	defer Invalidate(sgID)
+	_, err := ec2Svc.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{
+		GroupId:       aws.String(sgID),
+		IpPermissions: perms,
+	})
+	return errs.Wrap(errs.Target, "revoke security group ingress", err)
+}
@@ -0,0 +1,50 @@
+package target
+
+import (
+	"sync"
+	"time"
+)
+
+// MutationRate is how many authorize and revoke calls per second the container makes on every Security Group, so
+// that mass scale events don't flood the EC2 API. MutationBurst calls can be made at once. 0 disables the limit.
+var (
+	MutationRate  float64
+	MutationBurst = 1
+)
+
+// bucket is the token bucket of a Security Group's mutations
+type bucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+var buckets sync.Map
+
+// Waits until the Security Group's bucket has a token for one more mutation and takes it
+func throttle(sgID string) {
+	if MutationRate <= 0 {
+		return
+	}
+	burst := float64(MutationBurst)
+	if burst < 1 {
+		burst = 1
+	}
+	v, _ := buckets.LoadOrStore(sgID, &bucket{tokens: burst, last: time.Now()})
+	b := v.(*bucket)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * MutationRate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / MutationRate * float64(time.Second))
+		time.Sleep(wait)
+		b.tokens, b.last = 1, time.Now()
+	}
+	b.tokens--
+}
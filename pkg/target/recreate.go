@@ -0,0 +1,148 @@
+package target
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// CreatedByTag tags the Security Group the sync created on its first run with the name of the function
+const CreatedByTag = "sg-sync:created-by"
+
+// RecreatedFromTag tags a recreated Security Group with the ID of the deleted one, so that later syncs of the deleted
+// ID find it instead of creating another one
+const RecreatedFromTag = "sg-sync:recreated-from"
+
+// GroupSpec describes the Security Group the sync creates
+type GroupSpec struct {
+	Name        string
+	Description string
+	VpcID       string
+	Tags        map[string]string
+}
+
+// IsNotFound checks whether the error is about a Security Group that doesn't exist
+func IsNotFound(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == "InvalidGroup.NotFound"
+}
+
+// Recreate gets the Security Group that replaces the deleted one: the one already recreated, found through its
+// RecreatedFromTag, or a new one created after spec. The new group has no ingress rules.
+func Recreate(deletedID string, spec GroupSpec, ec2Svc ec2iface.EC2API) (string, error) {
+	existing, err := FindTagged(RecreatedFromTag, deletedID, spec.VpcID, ec2Svc)
+	if err != nil || existing != "" {
+		return existing, err
+	}
+	recreated, _, err := CreateOrFind(spec, map[string]string{RecreatedFromTag: deletedID}, ec2Svc)
+	return recreated, err
+}
+
+// IsDuplicate checks whether the error is about a Security Group name the VPC already has
+func IsDuplicate(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == "InvalidGroup.Duplicate"
+}
+
+// FindTagged gets the Security Group of the VPC that has the tag, empty when there is none
+func FindTagged(key string, value string, vpcID string, ec2Svc ec2iface.EC2API) (string, error) {
+	out, err := ec2Svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:" + key), Values: []*string{aws.String(value)}},
+			{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpcID)}},
+		},
+	})
+	if err != nil {
+		return "", errs.Wrap(errs.Target, "describe security groups", err)
+	}
+	if len(out.SecurityGroups) == 0 {
+		return "", nil
+	}
+	return aws.StringValue(out.SecurityGroups[0].GroupId), nil
+}
+
+// FindNamed gets the Security Group of the VPC that has the name, empty when there is none
+func FindNamed(name string, vpcID string, ec2Svc ec2iface.EC2API) (string, error) {
+	out, err := ec2Svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("group-name"), Values: []*string{aws.String(name)}},
+			{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpcID)}},
+		},
+	})
+	if err != nil {
+		return "", errs.Wrap(errs.Target, "describe security groups", err)
+	}
+	if len(out.SecurityGroups) == 0 {
+		return "", nil
+	}
+	return aws.StringValue(out.SecurityGroups[0].GroupId), nil
+}
+
+// CreateOrFind creates the Security Group of the spec like Create. When another invocation created the group of the
+// same name first, that group is used instead and created is false.
+func CreateOrFind(spec GroupSpec, tags map[string]string, ec2Svc ec2iface.EC2API) (sgID string, created bool, err error) {
+	sgID, err = Create(spec, tags, ec2Svc)
+	if err == nil {
+		return sgID, true, nil
+	}
+	if !IsDuplicate(err) {
+		return "", false, err
+	}
+	existing, findErr := FindNamed(spec.Name, spec.VpcID, ec2Svc)
+	if findErr != nil {
+		return "", false, findErr
+	}
+	if existing == "" {
+		return "", false, err
+	}
+	return existing, false, nil
+}
+
+// Delete deletes the Security Group
+func Delete(sgID string, ec2Svc ec2iface.EC2API) error {
+	_, err := ec2Svc.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: aws.String(sgID)})
+	if err != nil {
+		return errs.Wrap(errs.Target, "delete security group", err)
+	}
+	Invalidate(sgID)
+	return nil
+}
+
+// Create creates the Security Group of the spec, with the given tags along with the spec's
+func Create(spec GroupSpec, tags map[string]string, ec2Svc ec2iface.EC2API) (string, error) {
+	all := make(map[string]string, len(spec.Tags)+len(tags))
+	for k, v := range spec.Tags {
+		all[k] = v
+	}
+	for k, v := range tags {
+		all[k] = v
+	}
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var ec2Tags []*ec2.Tag
+	for _, k := range keys {
+		ec2Tags = append(ec2Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(all[k])})
+	}
+
+	input := &ec2.CreateSecurityGroupInput{
+		GroupName:   aws.String(spec.Name),
+		Description: aws.String(spec.Description),
+		VpcId:       aws.String(spec.VpcID),
+	}
+	if len(ec2Tags) != 0 {
+		input.TagSpecifications = []*ec2.TagSpecification{{ResourceType: aws.String(ec2.ResourceTypeSecurityGroup), Tags: ec2Tags}}
+	}
+	created, err := ec2Svc.CreateSecurityGroup(input)
+	if err != nil {
+		return "", errs.Wrap(errs.Target, "create security group", err)
+	}
+	return aws.StringValue(created.GroupId), nil
+}
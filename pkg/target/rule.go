@@ -0,0 +1,201 @@
+package target
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// UDPProtocol specifies the udp protocol
+const UDPProtocol = "udp"
+
+// ICMPProtocol specifies the icmp protocol. Its rules carry the ICMP type and code in place of the port range.
+const ICMPProtocol = "icmp"
+
+// AnyICMP is the ICMP type or code that matches all of them
+const AnyICMP = -1
+
+// AllProtocol specifies all the traffic, on every protocol and port. Its rules have no ports.
+const AllProtocol = "-1"
+
+// Rule is the protocol and port of a set of managed rules. ICMP rules carry the ICMP type in Port and its code in Code.
+type Rule struct {
+	Protocol string `json:"proto"`
+	Port     int64  `json:"port"`
+	Code     int64  `json:"code,omitempty"`
+}
+
+// DefaultRule is the tcp rule of HTTPSPort
+var DefaultRule = Rule{Protocol: TCPProtocol, Port: HTTPSPort}
+
+func (r Rule) String() string {
+	if r.AllTraffic() {
+		return "all"
+	}
+	if r.ICMP() && r.Code != AnyICMP {
+		return fmt.Sprintf("%s/%d:%d", r.Protocol, r.Port, r.Code)
+	}
+	return fmt.Sprintf("%s/%d", r.Protocol, r.Port)
+}
+
+// AllTraffic returns true for the rule of all the traffic
+func (r Rule) AllTraffic() bool { return r.Protocol == AllProtocol }
+
+// ICMP returns true for the rules of an ICMP type and code
+func (r Rule) ICMP() bool { return r.Protocol == ICMPProtocol }
+
+// PermissionRule gets the rule of an ingress permission's protocol and port range
+func PermissionRule(protocol string, from int64, to int64) Rule {
+	switch protocol {
+	case AllProtocol:
+		return Rule{Protocol: AllProtocol}
+	case ICMPProtocol:
+		return Rule{Protocol: ICMPProtocol, Port: from, Code: to}
+	}
+	return Rule{Protocol: protocol, Port: from}
+}
+
+// ParseRule reads the name of a rule set back into its Rule, see String
+func ParseRule(name string) (Rule, error) {
+	if name == "all" {
+		return Rule{Protocol: AllProtocol}, nil
+	}
+	i := strings.Index(name, "/")
+	if i <= 0 {
+		return Rule{}, fmt.Errorf("invalid rule %q", name)
+	}
+	rule := Rule{Protocol: name[:i]}
+	port, code := name[i+1:], ""
+	if j := strings.Index(port, ":"); j >= 0 && rule.ICMP() {
+		port, code = port[:j], port[j+1:]
+	}
+	var err error
+	if rule.Port, err = strconv.ParseInt(port, 10, 64); err != nil {
+		return Rule{}, fmt.Errorf("invalid rule %q", name)
+	}
+	if rule.ICMP() {
+		rule.Code = AnyICMP
+		if code != "" {
+			if rule.Code, err = strconv.ParseInt(code, 10, 64); err != nil {
+				return Rule{}, fmt.Errorf("invalid rule %q", name)
+			}
+		}
+	}
+	return rule, nil
+}
+
+// Gets the port range of the rule's permissions. The all-traffic rule has none and ICMP rules map the type and the code
+// onto it, as EC2 does.
+func (r Rule) portRange() (from *int64, to *int64) {
+	if r.AllTraffic() {
+		return nil, nil
+	}
+	if r.ICMP() {
+		return aws.Int64(r.Port), aws.Int64(r.Code)
+	}
+	return aws.Int64(r.Port), aws.Int64(r.Port)
+}
+
+// RuleSet is an entry of the rule matrix: a protocol and its ports, or the ICMP types and code of the icmp protocol
+type RuleSet struct {
+	Protocol string  `json:"proto"`
+	Ports    []int64 `json:"ports,omitempty"`
+	// Types are the ICMP types of the icmp protocol, -1 being all of them
+	Types []int64 `json:"types,omitempty"`
+	// Code is the ICMP code of the types. Defaults to -1, all the codes.
+	Code *int64 `json:"code,omitempty"`
+}
+
+// ParseRules reads a rule matrix like [{"proto":"tcp","ports":[443,8443]},{"proto":"udp","ports":[51820]}] into one
+// Rule per protocol and port. {"proto":"-1"} is the rule of all the traffic and {"proto":"icmp","types":[8]} the one
+// of the ICMP echo requests.
+func ParseRules(spec string) ([]Rule, error) {
+	var sets []RuleSet
+	if err := json.Unmarshal([]byte(spec), &sets); err != nil {
+		return nil, err
+	}
+	return ExpandRules(sets)
+}
+
+// ExpandRules validates the rule matrix and expands it into one Rule per protocol and port
+func ExpandRules(sets []RuleSet) ([]Rule, error) {
+	var rules []Rule
+	seen := make(map[Rule]struct{})
+	for _, set := range sets {
+		if set.Protocol == AllProtocol {
+			if len(set.Ports) != 0 {
+				return nil, fmt.Errorf("protocol %q takes no ports", set.Protocol)
+			}
+			rule := Rule{Protocol: AllProtocol}
+			if _, ok := seen[rule]; !ok {
+				seen[rule] = struct{}{}
+				rules = append(rules, rule)
+			}
+			continue
+		}
+		if set.Protocol == ICMPProtocol {
+			icmpRules, err := expandICMP(set)
+			if err != nil {
+				return nil, err
+			}
+			for _, rule := range icmpRules {
+				if _, ok := seen[rule]; !ok {
+					seen[rule] = struct{}{}
+					rules = append(rules, rule)
+				}
+			}
+			continue
+		}
+		if set.Protocol != TCPProtocol && set.Protocol != UDPProtocol {
+			return nil, fmt.Errorf("unsupported protocol %q, expected tcp, udp, icmp or -1", set.Protocol)
+		}
+		if len(set.Ports) == 0 {
+			return nil, fmt.Errorf("no ports for protocol %q", set.Protocol)
+		}
+		for _, port := range set.Ports {
+			if port < 1 || port > 65535 {
+				return nil, fmt.Errorf("invalid port %d", port)
+			}
+			rule := Rule{Protocol: set.Protocol, Port: port}
+			if _, ok := seen[rule]; !ok {
+				seen[rule] = struct{}{}
+				rules = append(rules, rule)
+			}
+		}
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("the rule matrix is empty")
+	}
+	return rules, nil
+}
+
+// Validates the ICMP types and code of the rule set and expands them into one Rule per type
+func expandICMP(set RuleSet) ([]Rule, error) {
+	if len(set.Ports) != 0 {
+		return nil, fmt.Errorf("protocol %q takes types and a code, not ports", set.Protocol)
+	}
+	if len(set.Types) == 0 {
+		return nil, fmt.Errorf("no types for protocol %q", set.Protocol)
+	}
+	code := int64(AnyICMP)
+	if set.Code != nil {
+		code = *set.Code
+	}
+	if code < AnyICMP || code > 255 {
+		return nil, fmt.Errorf("invalid ICMP code %d", code)
+	}
+	var rules []Rule
+	for _, icmpType := range set.Types {
+		if icmpType < AnyICMP || icmpType > 255 {
+			return nil, fmt.Errorf("invalid ICMP type %d", icmpType)
+		}
+		if icmpType == AnyICMP && code != AnyICMP {
+			return nil, fmt.Errorf("ICMP code %d needs a type", code)
+		}
+		rules = append(rules, Rule{Protocol: ICMPProtocol, Port: icmpType, Code: code})
+	}
+	return rules, nil
+}
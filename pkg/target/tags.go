@@ -0,0 +1,66 @@
+package target
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// PortsTag is the tag of the Security Group that lists the ports of its managed rules, e.g. 443,8443,udp/51820
+const PortsTag = "sg-sync:ports"
+
+// ExtraPortsTag is the tag of an instance that lists the ports it gets rules for on top of the configured ones, e.g.
+// 9100 for the nodes that run an exporter. It takes the same entries as PortsTag.
+const ExtraPortsTag = "sg-sync:extra-ports"
+
+// ParsePorts reads a comma separated list of [protocol/]port entries, tcp by default, e.g. 443,8443,udp/51820, into
+// one Rule per protocol and port
+func ParsePorts(spec string) ([]Rule, error) {
+	var sets []RuleSet
+	byProtocol := make(map[string]int)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		protocol, port := TCPProtocol, entry
+		if i := strings.Index(entry, "/"); i >= 0 {
+			protocol, port = strings.ToLower(entry[:i]), entry[i+1:]
+		}
+		n, err := strconv.ParseInt(port, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", entry)
+		}
+		i, ok := byProtocol[protocol]
+		if !ok {
+			i = len(sets)
+			byProtocol[protocol] = i
+			sets = append(sets, RuleSet{Protocol: protocol})
+		}
+		sets[i].Ports = append(sets[i].Ports, n)
+	}
+	return ExpandRules(sets)
+}
+
+// TaggedRules gets the rules listed by the Security Group's PortsTag. ok is false when the group has no such tag.
+func TaggedRules(sgID string, ec2Svc ec2iface.EC2API) (rules []Rule, ok bool, err error) {
+	group, err := describeGroup(sgID, ec2Svc)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, tag := range group.Tags {
+		if aws.StringValue(tag.Key) != PortsTag {
+			continue
+		}
+		rules, err := ParsePorts(aws.StringValue(tag.Value))
+		if err != nil {
+			return nil, true, errs.Errorf(errs.Config, "read ports tag", "%s tag of %s: %v", PortsTag, sgID, err)
+		}
+		return rules, true, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,392 @@
+package target
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/cidr"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// HTTPSPort is the port 443
+const HTTPSPort = 443
+
+// TCPProtocol specifies the tcp protocol
+const TCPProtocol = "tcp"
+
+var (
+	idPattern = regexp.MustCompile(`^sg-([0-9a-f]{8}|[0-9a-f]{17})$`)
+	verified  sync.Map
+)
+
+// ValidID checks whether sgID has the sg-xxxxxxxx (or sg-xxxxxxxxxxxxxxxxx) format
+func ValidID(sgID string) bool {
+	return idPattern.MatchString(sgID)
+}
+
+// Verify checks that sgID is well formed and that the Security Group exists and is accessible.
+// Successful checks are cached for the lifetime of the container. A malformed or missing group is a Config error.
+func Verify(sgID string, ec2Svc ec2iface.EC2API) error {
+	if !ValidID(sgID) {
+		return errs.Errorf(errs.Config, "verify security group", "%q is not a valid security group ID", sgID)
+	}
+	if _, ok := verified.Load(sgID); ok {
+		return nil
+	}
+
+	_, err := ec2Svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		GroupIds: []*string{aws.String(sgID)},
+	})
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "InvalidGroup.NotFound" || aerr.Code() == "InvalidGroupId.Malformed") {
+		return errs.Wrap(errs.Config, "verify security group", err)
+	}
+	if err != nil {
+		return errs.Wrap(errs.Target, "verify security group", err)
+	}
+	verified.Store(sgID, struct{}{})
+	return nil
+}
+
+// VpcID gets the ID of the VPC the Security Group belongs to
+func VpcID(sgID string, ec2Svc ec2iface.EC2API) (string, error) {
+	group, err := describeGroup(sgID, ec2Svc)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(group.VpcId), nil
+}
+
+// descriptionPrefix marks the rules created by the sync. It is followed by the ID of the rule's instance.
+const descriptionPrefix = "sg-sync:"
+
+// AggregateOwner is recorded as the instance of the rules that aggregate the IPs of several instances
+const AggregateOwner = "aggregate"
+
+// EndpointOwner is recorded as the instance of the return rules that let the protected endpoint's IPs reach the
+// instances
+const EndpointOwner = "endpoint"
+
+// createdPrefix precedes the creation time of the rule in its description
+const createdPrefix = "created:"
+
+// rulePrefix precedes the rule set (e.g. tcp/443) the rule belongs to in its description
+const rulePrefix = "rule:"
+
+// originPrefix precedes where the rule set of the rule came from in its description, when not from the configuration
+const originPrefix = "origin:"
+
+// OriginInstance marks the rules of the ports that instances declare with their ExtraPortsTag
+const OriginInstance = "instance"
+
+// RuleMeta is the metadata the sync records in the description of a managed rule
+type RuleMeta struct {
+	InstanceID string
+	// Rule is the rule set that owns the rule, e.g. tcp/443. Empty for rules created before it was recorded.
+	Rule string
+	// Namespace is the ownership namespace of the deployment that created the rule, empty when it had none
+	Namespace string
+	// Origin is where the rule set came from, OriginInstance for the instances' declared ports, empty when configured
+	Origin    string
+	CreatedAt time.Time
+}
+
+// Description builds the description of the managed rule of the instance, e.g.
+// "sg-sync:i-0123456789abcdef0 ns:team-a rule:tcp/443 created:2020-10-20T05:47:36Z"
+func Description(meta RuleMeta) string {
+	if meta.InstanceID == "" {
+		return ""
+	}
+	description := descriptionPrefix + meta.InstanceID
+	if meta.Namespace != "" {
+		description += " " + namespacePrefix + meta.Namespace
+	}
+	if meta.Rule != "" {
+		description += " " + rulePrefix + meta.Rule
+	}
+	if meta.Origin != "" {
+		description += " " + originPrefix + meta.Origin
+	}
+	if !meta.CreatedAt.IsZero() {
+		description += " " + createdPrefix + meta.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	return description
+}
+
+// ParseDescription parses the metadata out of a managed rule's description. The rule set and the creation time are
+// zero for rules created before they were recorded, the namespace for rules created without one.
+func ParseDescription(description string) (RuleMeta, bool) {
+	fields := strings.Fields(description)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], descriptionPrefix) || len(fields[0]) == len(descriptionPrefix) {
+		return RuleMeta{}, false
+	}
+	meta := RuleMeta{InstanceID: strings.TrimPrefix(fields[0], descriptionPrefix)}
+	for _, field := range fields[1:] {
+		switch {
+		case strings.HasPrefix(field, createdPrefix):
+			meta.CreatedAt, _ = time.Parse(time.RFC3339, strings.TrimPrefix(field, createdPrefix))
+		case strings.HasPrefix(field, rulePrefix):
+			meta.Rule = strings.TrimPrefix(field, rulePrefix)
+		case strings.HasPrefix(field, namespacePrefix):
+			meta.Namespace = strings.TrimPrefix(field, namespacePrefix)
+		case strings.HasPrefix(field, originPrefix):
+			meta.Origin = strings.TrimPrefix(field, originPrefix)
+		}
+	}
+	return meta, true
+}
+
+// SecurityGroupIPs gets a map of the normalized CIDRs that are already present in the Security Group for the given rule
+// to their rules' descriptions. Malformed CIDRs are kept as they are, the removal guard never lets them through.
+func SecurityGroupIPs(sgID string, rule Rule, ec2Svc ec2iface.EC2API) (cidr.IPSet, error) {
+	sgIPs := cidr.NewIPSet()
+	group, err := describeGroup(sgID, ec2Svc)
+	if err != nil {
+		return sgIPs, err
+	}
+
+	for _, perm := range group.IpPermissions {
+		if !matches(perm, rule) {
+			continue
+		}
+		for _, ipRange := range perm.IpRanges {
+			sgIPs.Keep(aws.StringValue(ipRange.CidrIp), aws.StringValue(ipRange.Description))
+		}
+	}
+	return sgIPs, err
+}
+
+// FreshSecurityGroupIPs is SecurityGroupIPs without the cache: the Security Group is described again, e.g. right
+// before it is changed
+func FreshSecurityGroupIPs(sgID string, rule Rule, ec2Svc ec2iface.EC2API) (cidr.IPSet, error) {
+	Invalidate(sgID)
+	return SecurityGroupIPs(sgID, rule, ec2Svc)
+}
+
+// Authorize adds an ingress rule of the given protocol and port to the Security Group for every one of the given CIDRs.
+// owners maps the CIDRs to the IDs of their instances, which are recorded in the rules' descriptions. The error lists
+// the failed CIDRs, see FailuresOf.
+func Authorize(sgID string, rule Rule, cidrs []string, owners cidr.IPSet, ec2Svc ec2iface.EC2API) error {
+	return authorize(sgID, rule, "", cidrs, owners, ec2Svc)
+}
+
+// AuthorizeDeclared adds the rules like Authorize, marking them as the ones of a port that instances declare, so that
+// DeclaredRuleSets finds them once no instance declares it anymore
+func AuthorizeDeclared(sgID string, rule Rule, cidrs []string, owners cidr.IPSet, ec2Svc ec2iface.EC2API) error {
+	return authorize(sgID, rule, OriginInstance, cidrs, owners, ec2Svc)
+}
+
+func authorize(sgID string, rule Rule, origin string, cidrs []string, owners cidr.IPSet, ec2Svc ec2iface.EC2API) error {
+	if len(cidrs) == 0 {
+		return nil
+	}
+	now := time.Now()
+	perms := Permissions(rule, cidrs)
+	for _, perm := range perms {
+		for _, ipRange := range perm.IpRanges {
+			meta := RuleMeta{InstanceID: owners[aws.StringValue(ipRange.CidrIp)], Rule: rule.String(), Namespace: Namespace, Origin: origin, CreatedAt: now}
+			if description := Description(meta); description != "" {
+				ipRange.Description = aws.String(description)
+			}
+		}
+	}
+	byCIDR := make(map[string]*ec2.IpPermission, len(perms))
+	for _, perm := range perms {
+		byCIDR[aws.StringValue(perm.IpRanges[0].CidrIp)] = perm
+	}
+	defer Invalidate(sgID)
+	return applyEach("authorize security group ingress", ActionAuthorize, rule, cidrs, func(cidrs []string) error {
+		batch := make([]*ec2.IpPermission, 0, len(cidrs))
+		for _, c := range cidrs {
+			batch = append(batch, byCIDR[c])
+		}
+		throttle(sgID)
+		_, err := ec2Svc.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       aws.String(sgID),
+			IpPermissions: batch,
+		})
+		return err
+	})
+}
+
+// Revoke removes the ingress rule of the given protocol and port of every one of the given CIDRs from the Security Group
+func Revoke(sgID string, rule Rule, cidrs []string, ec2Svc ec2iface.EC2API) error {
+	if len(cidrs) == 0 {
+		return nil
+	}
+	defer Invalidate(sgID)
+	return applyEach("revoke security group ingress", ActionRevoke, rule, cidrs, func(cidrs []string) error {
+		throttle(sgID)
+		_, err := ec2Svc.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       aws.String(sgID),
+			IpPermissions: Permissions(rule, cidrs),
+		})
+		return err
+	})
+}
+
+// Checks whether the permission is the given rule
+func matches(perm *ec2.IpPermission, rule Rule) bool {
+	if rule.AllTraffic() {
+		// All-traffic permissions carry no ports
+		return aws.StringValue(perm.IpProtocol) == AllProtocol
+	}
+	from, to := rule.portRange()
+	return aws.StringValue(perm.IpProtocol) == rule.Protocol &&
+		aws.Int64Value(perm.FromPort) == *from &&
+		aws.Int64Value(perm.ToPort) == *to
+}
+
+// Permissions builds one ingress permission of the given rule per CIDR, without descriptions
+func Permissions(rule Rule, cidrs []string) []*ec2.IpPermission {
+	var perms []*ec2.IpPermission
+	from, to := rule.portRange()
+	for _, cidr := range cidrs {
+		perms = append(perms, &ec2.IpPermission{
+			FromPort:   from,
+			ToPort:     to,
+			IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(cidr)}},
+			IpProtocol: aws.String(rule.Protocol),
+		})
+	}
+	return perms
+}
+
+// ReferencesGroup checks whether the Security Group has the given rule with sourceGroupID as its source
+func ReferencesGroup(sgID string, rule Rule, sourceGroupID string, ec2Svc ec2iface.EC2API) (bool, error) {
+	group, err := describeGroup(sgID, ec2Svc)
+	if err != nil {
+		return false, err
+	}
+	for _, perm := range group.IpPermissions {
+		if !matches(perm, rule) {
+			continue
+		}
+		for _, pair := range perm.UserIdGroupPairs {
+			if aws.StringValue(pair.GroupId) == sourceGroupID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// groupOwner is the owner recorded in the descriptions of the managed source group references
+const groupOwner = "group"
+
+// AuthorizeGroup adds an ingress rule of the given protocol and port to the Security Group with sourceGroupID as its
+// source
+func AuthorizeGroup(sgID string, rule Rule, sourceGroupID string, ec2Svc ec2iface.EC2API) error {
+	throttle(sgID)
+	defer Invalidate(sgID)
+	from, to := rule.portRange()
+	_, err := ec2Svc.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId: aws.String(sgID),
+		IpPermissions: []*ec2.IpPermission{{
+			FromPort:   from,
+			ToPort:     to,
+			IpProtocol: aws.String(rule.Protocol),
+			UserIdGroupPairs: []*ec2.UserIdGroupPair{{
+				GroupId:     aws.String(sourceGroupID),
+				Description: aws.String(Description(RuleMeta{InstanceID: groupOwner, Rule: rule.String(), Namespace: Namespace, CreatedAt: time.Now()})),
+			}},
+		}},
+	})
+	return errs.Wrap(errs.Target, "authorize security group ingress", err)
+}
+
+// ManagedReferences gets the source groups of the rule's managed references, see AuthorizeGroup, in the deployment's
+// namespace
+func ManagedReferences(sgID string, rule Rule, ec2Svc ec2iface.EC2API) ([]string, error) {
+	group, err := describeGroup(sgID, ec2Svc)
+	if err != nil {
+		return nil, err
+	}
+	var groupIDs []string
+	for _, perm := range group.IpPermissions {
+		if !matches(perm, rule) {
+			continue
+		}
+		for _, pair := range perm.UserIdGroupPairs {
+			meta, ok := ParseDescription(aws.StringValue(pair.Description))
+			if ok && meta.InstanceID == groupOwner && meta.InNamespace() && (meta.Rule == "" || meta.Rule == rule.String()) {
+				groupIDs = append(groupIDs, aws.StringValue(pair.GroupId))
+			}
+		}
+	}
+	return groupIDs, nil
+}
+
+// RuleCounts counts the inbound rules of the Security Group, as its quota counts them (one per CIDR, group or prefix
+// list), and how many of them are managed by the sync, in the deployment's namespace
+func RuleCounts(sgID string, ec2Svc ec2iface.EC2API) (total int, managed int, err error) {
+	group, err := describeGroup(sgID, ec2Svc)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, perm := range group.IpPermissions {
+		total += len(perm.IpRanges) + len(perm.Ipv6Ranges) + len(perm.UserIdGroupPairs) + len(perm.PrefixListIds)
+		for _, ipRange := range perm.IpRanges {
+			if meta, ok := ParseDescription(aws.StringValue(ipRange.Description)); ok && meta.InNamespace() {
+				managed++
+			}
+		}
+	}
+	return total, managed, nil
+}
+
+// ManagedRuleSets gets the rule sets of the Security Group's managed rules, out of the rule markers of their
+// descriptions. The rules created before the markers were recorded and those of other namespaces are left out.
+func ManagedRuleSets(sgID string, ec2Svc ec2iface.EC2API) ([]Rule, error) {
+	return ruleSets(sgID, func(RuleMeta) bool { return true }, ec2Svc)
+}
+
+// DeclaredRuleSets gets the rule sets of the Security Group's managed rules that were added for the ports instances
+// declare, in the deployment's namespace. The configured rule sets, of this deployment or of others, are left out.
+func DeclaredRuleSets(sgID string, ec2Svc ec2iface.EC2API) ([]Rule, error) {
+	return ruleSets(sgID, func(meta RuleMeta) bool { return meta.Origin == OriginInstance }, ec2Svc)
+}
+
+// Gets the rule sets of the managed rules of the namespace whose metadata matches
+func ruleSets(sgID string, match func(RuleMeta) bool, ec2Svc ec2iface.EC2API) ([]Rule, error) {
+	group, err := describeGroup(sgID, ec2Svc)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	seen := make(map[Rule]struct{})
+	for _, perm := range group.IpPermissions {
+		rule := PermissionRule(aws.StringValue(perm.IpProtocol), aws.Int64Value(perm.FromPort), aws.Int64Value(perm.ToPort))
+		for _, ipRange := range perm.IpRanges {
+			meta, ok := ParseDescription(aws.StringValue(ipRange.Description))
+			if _, dup := seen[rule]; ok && !dup && meta.Rule == rule.String() && meta.InNamespace() && match(meta) {
+				seen[rule] = struct{}{}
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// LastManagedChange gets when the newest managed rule of the Security Group was created, in the deployment's
+// namespace. ok is false when it has none.
+func LastManagedChange(sgID string, ec2Svc ec2iface.EC2API) (last time.Time, ok bool, err error) {
+	group, err := describeGroup(sgID, ec2Svc)
+	if err != nil {
+		return last, false, err
+	}
+	for _, perm := range group.IpPermissions {
+		for _, ipRange := range perm.IpRanges {
+			meta, managed := ParseDescription(aws.StringValue(ipRange.Description))
+			if managed && meta.InNamespace() && meta.CreatedAt.After(last) {
+				last, ok = meta.CreatedAt, true
+			}
+		}
+	}
+	return last, ok, nil
+}
@@ -0,0 +1,35 @@
+package targetgroup
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/errs"
+)
+
+// Builds the target of the instance. A zero port uses the target group's port.
+func targets(instanceID string, port int64) []*elbv2.TargetDescription {
+	target := &elbv2.TargetDescription{Id: aws.String(instanceID)}
+	if port != 0 {
+		target.Port = aws.Int64(port)
+	}
+	return []*elbv2.TargetDescription{target}
+}
+
+// Register registers the instance with the ALB/NLB target group. Registering a registered instance is a no-op.
+func Register(elbSvc elbv2iface.ELBV2API, targetGroupARN string, instanceID string, port int64) error {
+	_, err := elbSvc.RegisterTargets(&elbv2.RegisterTargetsInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+		Targets:        targets(instanceID, port),
+	})
+	return errs.Wrap(errs.Target, "register targets", err)
+}
+
+// Deregister deregisters the instance from the ALB/NLB target group. Deregistering an unknown instance is a no-op.
+func Deregister(elbSvc elbv2iface.ELBV2API, targetGroupARN string, instanceID string, port int64) error {
+	_, err := elbSvc.DeregisterTargets(&elbv2.DeregisterTargetsInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+		Targets:        targets(instanceID, port),
+	})
+	return errs.Wrap(errs.Target, "deregister targets", err)
+}
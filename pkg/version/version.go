@@ -0,0 +1,24 @@
+package version
+
+// Set at build time with -ldflags, e.g.
+// -X github.com/karvounis/aws-lambda-auto-update-security-group-ips/pkg/version.Version=v1.4.0
+var (
+	// Version is the release of the build
+	Version = "dev"
+	// Commit is the git commit the build was made from
+	Commit = "unknown"
+	// BuildDate is when the build was made, in RFC 3339
+	BuildDate = "unknown"
+)
+
+// Info identifies the build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+}
+
+// Get returns the Info of the running build
+func Get() Info {
+	return Info{Version: Version, Commit: Commit, BuildDate: BuildDate}
+}